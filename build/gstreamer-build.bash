@@ -46,14 +46,20 @@ meson setup \
 	-Dgst-plugins-base:app=enabled \
 	-Dgst-plugins-base:audioconvert=enabled \
 	-Dgst-plugins-base:audioresample=enabled \
+	-Dgst-plugins-base:audiotestsrc=enabled \
 	-Dgst-plugins-base:opus=enabled \
 	-Dgst-plugins-base:videoconvertscale=enabled \
 	-Dgst-plugins-base:videorate=enabled \
+	-Dgst-plugins-base:videotestsrc=enabled \
 	-Dgood=enabled \
+	-Dgst-plugins-good:audioparsers=enabled \
 	-Dgst-plugins-good:deinterlace=enabled \
+	-Dgst-plugins-good:multifile=enabled \
 	-Dbad=enabled \
 	-Dgst-plugins-bad:dvb=enabled \
 	-Dgst-plugins-bad:mpegtsdemux=enabled \
+	-Dgst-plugins-bad:mpegtsmux=enabled \
+	-Dgst-plugins-bad:videoparsers=enabled \
 	-Dugly=enabled \
 	-Dgst-plugins-ugly:a52dec=enabled \
 	-Dgst-plugins-ugly:x264=enabled \
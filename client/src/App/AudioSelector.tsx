@@ -0,0 +1,57 @@
+import React from "react";
+
+import { useTuner } from "../Tuner";
+import { useTunerStatus, AudioTrack } from "../TunerStatus";
+import rpc from "../rpc";
+
+export default function AudioSelector() {
+  const tuner = useTuner();
+  const tunerStatus = useTunerStatus();
+
+  if (
+    tunerStatus.Connection !== "Connected" ||
+    tunerStatus.State !== "Playing" ||
+    tunerStatus.AudioTracks === undefined ||
+    tunerStatus.AudioTracks.length < 2
+  ) {
+    return null;
+  }
+
+  const handleChange = (evt: React.ChangeEvent<HTMLSelectElement>) => {
+    rpc("audio", { TunerID: tuner.ID, PID: Number(evt.target.value) }).catch(
+      console.error,
+    );
+  };
+
+  return (
+    <select
+      className="AudioSelector"
+      aria-label="Audio track"
+      value={tunerStatus.AudioPID ?? ""}
+      onChange={handleChange}
+    >
+      {tunerStatus.AudioTracks.map((track, i) => (
+        <option key={track.PID} value={track.PID}>
+          {trackLabel(track, i)}
+        </option>
+      ))}
+    </select>
+  );
+}
+
+const audioTypeLabels = {
+  CleanEffects: "no dialogue",
+  HearingImpaired: "hearing impaired",
+  VisualImpaired: "described",
+};
+
+function trackLabel(track: AudioTrack, index: number): string {
+  const name = track.Language?.toUpperCase() ?? `Track ${index + 1}`;
+  const details = [
+    track.Type !== undefined ? audioTypeLabels[track.Type] : undefined,
+    track.Codec,
+  ]
+    .filter((d) => d !== undefined)
+    .join(", ");
+  return `${name} (${details})`;
+}
@@ -0,0 +1,46 @@
+import React from "react";
+
+import { useWebRTC, CaptionService } from "../WebRTC";
+
+export default function CaptionSelector() {
+  const { CaptionServices, CaptionSelection, selectCaptions } = useWebRTC();
+
+  // A selection for a service that hasn't appeared yet stays in the list, so
+  // that it remains selected when the captions start.
+  const services = [...CaptionServices.Services];
+  const selected = CaptionServices.Selected || CaptionSelection.Service;
+  if (selected && !services.some((svc) => svc.Service === selected)) {
+    services.push({ Service: selected, Language: CaptionSelection.Language });
+  }
+
+  const handleChange = (evt: React.ChangeEvent<HTMLSelectElement>) => {
+    const svc = services.find((svc) => svc.Service === evt.target.value);
+    selectCaptions(
+      svc === undefined ? {} : { Service: svc.Service, Language: svc.Language },
+    );
+  };
+
+  return (
+    <select
+      className="CaptionSelector"
+      aria-label="Closed captions"
+      value={selected ?? ""}
+      onChange={handleChange}
+    >
+      <option value="">CC Off</option>
+      {services.map((svc) => (
+        <option key={svc.Service} value={svc.Service}>
+          {serviceLabel(svc)}
+        </option>
+      ))}
+    </select>
+  );
+}
+
+function serviceLabel(svc: CaptionService): string {
+  const name = svc.Service.replace(/^SERVICE/, "Service ");
+  const details = [svc.Language, svc.EasyReader ? "easy reader" : undefined]
+    .filter((d) => d !== undefined)
+    .join(", ");
+  return details !== "" ? `${name} (${details})` : name;
+}
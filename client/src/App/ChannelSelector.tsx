@@ -2,6 +2,12 @@ import React from "react";
 
 import useConfig from "../useConfig";
 
+export interface ConfigChannel {
+  Name: string;
+  Number?: string;
+  ShortName?: string;
+}
+
 export default function ChannelSelector({
   selected,
   onTune,
@@ -9,16 +15,16 @@ export default function ChannelSelector({
   selected?: string;
   onTune: (ch: string) => void;
 }) {
-  const channelNames = useConfig<string[]>("channels");
+  const channels = useConfig<ConfigChannel[]>("channels");
 
-  return channelNames instanceof Array ? (
+  return channels instanceof Array ? (
     <aside className="ChannelSelector">
-      {channelNames.map((ch) => (
+      {channels.map((ch) => (
         <Channel
-          key={ch}
-          name={ch}
-          active={ch === selected}
-          onClick={() => onTune(ch)}
+          key={ch.Name}
+          channel={ch}
+          active={ch.Name === selected}
+          onClick={() => onTune(ch.Name)}
         />
       ))}
     </aside>
@@ -26,11 +32,11 @@ export default function ChannelSelector({
 }
 
 function Channel({
-  name,
+  channel,
   active,
   onClick,
 }: {
-  name: string;
+  channel: ConfigChannel;
   active?: boolean;
   onClick: () => void;
 }) {
@@ -41,7 +47,10 @@ function Channel({
       }`}
       onClick={onClick}
     >
-      {name}
+      {channel.Number !== undefined ? (
+        <span className="ChannelSelector__Number">{channel.Number}</span>
+      ) : null}
+      {channel.Name}
     </button>
   );
 }
@@ -1,16 +1,24 @@
 import React from "react";
 
 import { useWebRTC, State as WebRTCState } from "../WebRTC";
-import { useTunerStatus, Status as TunerStatus } from "../TunerStatus";
+import {
+  useTunerStatus,
+  Status as TunerStatus,
+  Signal,
+} from "../TunerStatus";
+import { useTuner, tune } from "../Tuner";
 import rpc from "../rpc";
 import useConfig from "../useConfig";
 
+import { ConfigChannel } from "./ChannelSelector";
+
 export default function Header() {
   return (
     <header className="Header">
       <Title />
       <PowerButton />
       <StatusIndicator />
+      <SignalIndicator />
     </header>
   );
 }
@@ -20,17 +28,18 @@ function Title() {
 }
 
 function PowerButton() {
+  const tuner = useTuner();
   const tunerStatus = useTunerStatus();
-  const channelNames = useConfig<string[]>("channels");
+  const channels = useConfig<ConfigChannel[]>("channels");
 
   const poweredOn =
     tunerStatus.Connection === "Connected" && tunerStatus.State !== "Stopped";
 
   const handleClick = () => {
     if (poweredOn) {
-      rpc("stop").catch(console.error);
-    } else if (channelNames instanceof Array) {
-      rpc("tune", { ChannelName: channelNames[0] }).catch(console.error);
+      rpc("stop", { TunerID: tuner.ID }).catch(console.error);
+    } else if (channels instanceof Array && channels.length > 0) {
+      tune(tuner, channels[0].Name).catch(console.error);
     }
   };
 
@@ -69,13 +78,58 @@ function StatusIndicator() {
           indicatorActive ? "StatusIndicator__Dot--Active" : ""
         }`}
       ></div>
-      <span className="StatusIndicator__Description">
+      <span
+        className="StatusIndicator__Description"
+        title={upNextString(tunerStatus)}
+      >
         {statusString(webRTC, tunerStatus)}
       </span>
     </div>
   );
 }
 
+// SignalIndicator shows the live statistics of the tuner's signal, which
+// change quickly enough to help with aiming an antenna.
+function SignalIndicator() {
+  const tunerStatus = useTunerStatus();
+  if (
+    tunerStatus.Connection !== "Connected" ||
+    (tunerStatus.State !== "Starting" && tunerStatus.State !== "Playing") ||
+    tunerStatus.Signal === undefined
+  ) {
+    return null;
+  }
+
+  const signal = tunerStatus.Signal;
+  return (
+    <div className="SignalIndicator" title={signalDetails(signal)}>
+      <meter
+        className="SignalIndicator__Meter"
+        min={0}
+        max={maxSignalStrength}
+        value={signal.Strength}
+      />
+      <span className="SignalIndicator__Description">
+        {signal.Locked ? "Locked" : "No Lock"}, SNR {signal.SNR}
+      </span>
+    </div>
+  );
+}
+
+// maxSignalStrength is the top of the strength scale that most DVB drivers
+// report on.
+const maxSignalStrength = 0xffff;
+
+function signalDetails(signal: Signal): string {
+  const percent = Math.round((100 * signal.Strength) / maxSignalStrength);
+  return [
+    `Strength: ${signal.Strength} (${percent}%)`,
+    `SNR: ${signal.SNR}`,
+    `Bit error rate: ${signal.BER}`,
+    `Uncorrected blocks: ${signal.UNC}`,
+  ].join("\n");
+}
+
 function statusString(webRTC: WebRTCState, tunerStatus: TunerStatus): string {
   if (webRTC.Connection.Status !== "Connected") {
     return webRTC.Connection.Status;
@@ -97,8 +151,31 @@ function statusString(webRTC: WebRTCState, tunerStatus: TunerStatus): string {
   }
 
   if (tunerStatus.State === "Playing") {
+    if (tunerStatus.Now !== undefined) {
+      return `Watching ${tunerStatus.ChannelName}: ${tunerStatus.Now.Title}`;
+    }
     return `Watching ${tunerStatus.ChannelName}`;
   }
 
+  if (tunerStatus.State === "Scanning") {
+    return "Scanning for Channels";
+  }
+
   return tunerStatus.State;
 }
+
+function upNextString(tunerStatus: TunerStatus): string | undefined {
+  if (
+    tunerStatus.Connection !== "Connected" ||
+    tunerStatus.State !== "Playing" ||
+    tunerStatus.Next === undefined
+  ) {
+    return undefined;
+  }
+
+  const start = new Date(tunerStatus.Next.Start).toLocaleTimeString([], {
+    hour: "numeric",
+    minute: "2-digit",
+  });
+  return `Up next at ${start}: ${tunerStatus.Next.Title}`;
+}
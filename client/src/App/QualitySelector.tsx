@@ -0,0 +1,35 @@
+import React from "react";
+
+import { useWebRTC } from "../WebRTC";
+
+export default function QualitySelector() {
+  const { VideoLayers, VideoQuality, selectVideoQuality } = useWebRTC();
+
+  if (VideoLayers.Layers === null || VideoLayers.Layers.length < 2) {
+    return null;
+  }
+
+  const handleChange = (evt: React.ChangeEvent<HTMLSelectElement>) => {
+    selectVideoQuality(evt.target.value);
+  };
+
+  return (
+    <select
+      className="QualitySelector"
+      aria-label="Video quality"
+      value={VideoLayers.Layers.includes(VideoQuality) ? VideoQuality : ""}
+      onChange={handleChange}
+    >
+      <option value="">Auto ({layerLabel(VideoLayers.Layer)})</option>
+      {VideoLayers.Layers.map((layer) => (
+        <option key={layer} value={layer}>
+          {layerLabel(layer)}
+        </option>
+      ))}
+    </select>
+  );
+}
+
+function layerLabel(layer: string): string {
+  return layer.charAt(0).toUpperCase() + layer.slice(1);
+}
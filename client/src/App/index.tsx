@@ -1,20 +1,24 @@
 import React from "react";
 
-import { useWebRTC } from "../WebRTC";
-import rpc from "../rpc";
+import { useWebRTC, CaptionCue } from "../WebRTC";
 
 import "./index.scss";
 
 import Header from "./Header";
 import ChannelSelector from "./ChannelSelector";
+import CaptionSelector from "./CaptionSelector";
+import AudioSelector from "./AudioSelector";
+import QualitySelector from "./QualitySelector";
 import { useTunerStatus } from "../TunerStatus";
+import { useTuner, tune } from "../Tuner";
 
 export default function App() {
   const webRTC = useWebRTC();
+  const tuner = useTuner();
   const tunerStatus = useTunerStatus();
 
   const selectedChannel =
-    tunerStatus.Connection === "Connected" && tunerStatus.State !== "Stopped"
+    tunerStatus.Connection === "Connected" && "ChannelName" in tunerStatus
       ? tunerStatus.ChannelName
       : undefined;
 
@@ -24,9 +28,9 @@ export default function App() {
       <Header />
       <ChannelSelector
         selected={selectedChannel}
-        onTune={(ch) => rpc("tune", { ChannelName: ch }).catch(console.error)}
+        onTune={(ch) => tune(tuner, ch).catch(console.error)}
       />
-      <VideoPlayer stream={webRTC.MediaStream} />
+      <VideoPlayer stream={webRTC.MediaStream} cue={webRTC.CaptionCue} />
     </div>
   );
 }
@@ -34,15 +38,22 @@ export default function App() {
 function PageTitle() {
   const tunerStatus = useTunerStatus();
   const titleText =
-    tunerStatus.Connection === "Connected" && tunerStatus.State !== "Stopped"
+    tunerStatus.Connection === "Connected" && "ChannelName" in tunerStatus
       ? `${tunerStatus.ChannelName} | Hypcast`
       : "Hypcast";
 
   return <title>{titleText}</title>;
 }
 
-function VideoPlayer({ stream }: { stream: undefined | MediaStream }) {
+function VideoPlayer({
+  stream,
+  cue,
+}: {
+  stream: undefined | MediaStream;
+  cue: CaptionCue;
+}) {
   const videoElement = React.useRef<null | HTMLVideoElement>(null);
+  const captionTrack = React.useRef<null | TextTrack>(null);
 
   React.useEffect(() => {
     if (videoElement.current !== null) {
@@ -50,14 +61,21 @@ function VideoPlayer({ stream }: { stream: undefined | MediaStream }) {
     }
   }, [stream]);
 
+  React.useEffect(() => {
+    const video = videoElement.current;
+    if (video === null) {
+      return;
+    }
+    if (captionTrack.current === null) {
+      captionTrack.current = video.addTextTrack("captions");
+      captionTrack.current.mode = "showing";
+    }
+    showCue(captionTrack.current, video.currentTime, cue);
+  }, [cue]);
+
   // eslint-disable jsx-a11y/media-has-caption
-  // Lack of closed caption support is a longstanding deficiency in Hypcast.
-  // After experimenting with several approaches in GStreamer, I'm ashamed to
-  // say that I have yet to identify one that works reliably and consistently.
-  // Also, it is not clear that the eventual implementation of closed captions
-  // will involve WebVTT, which is what this rule actually looks for (through
-  // the presence of a <track> element), so it may need to remain disabled even
-  // after closed caption support is in place.
+  // Captions arrive live over a WebRTC data channel rather than from a WebVTT
+  // file, so there is no <track> element for this rule to find.
   return (
     <main className="VideoPlayer">
       <video
@@ -66,6 +84,31 @@ function VideoPlayer({ stream }: { stream: undefined | MediaStream }) {
         autoPlay
         controls
       />
+      <div className="VideoPlayer__Controls">
+        <AudioSelector />
+        <CaptionSelector />
+        <QualitySelector />
+      </div>
     </main>
   );
 }
+
+// showCue replaces the caption on screen with the one that the server is
+// displaying now. The server sends each cue as it should appear, so a cue
+// lasts until the next one replaces it.
+function showCue(track: TextTrack, now: number, cue: CaptionCue) {
+  for (const old of Array.from(track.cues ?? [])) {
+    track.removeCue(old);
+  }
+  if (cue.Text === "") {
+    return;
+  }
+
+  const vttCue = new VTTCue(now, Number.MAX_VALUE, cue.Text);
+  const line = /line:(\d+)%/.exec(cue.Settings ?? "");
+  if (line !== null) {
+    vttCue.snapToLines = false;
+    vttCue.line = Number(line[1]);
+  }
+  track.addCue(vttCue);
+}
@@ -0,0 +1,42 @@
+import React from "react";
+
+import rpc from "./rpc";
+
+export interface Tuner {
+  // ID is undefined until the server allocates a tuner to this client. Until
+  // then, the server connects the client to its default tuner.
+  ID: undefined | string;
+  setID: (id: string) => void;
+}
+
+const Context = React.createContext<Tuner | null>(null);
+
+export const useTuner = (): Tuner => {
+  const tuner = React.useContext(Context);
+  if (tuner === null) {
+    throw new Error("useTuner must be used within <TunerProvider>");
+  }
+  return tuner;
+};
+
+export const TunerProvider = ({ children }: { children: React.ReactNode }) => {
+  const [id, setID] = React.useState<undefined | string>();
+  const tuner = React.useMemo(() => ({ ID: id, setID }), [id]);
+  return <Context value={tuner}>{children}</Context>;
+};
+
+// tunerQuery returns the query string that selects a tuner in a socket URL.
+export function tunerQuery(id: undefined | string): string {
+  return id === undefined ? "" : `?tuner=${encodeURIComponent(id)}`;
+}
+
+// tune asks the server to tune to a channel, either on the client's current
+// tuner or on one that the server allocates, and switches the client to the
+// tuner that the server used.
+export async function tune(tuner: Tuner, channelName: string) {
+  const result: { TunerID: string } = await rpc("tune", {
+    ChannelName: channelName,
+    TunerID: tuner.ID,
+  });
+  tuner.setID(result.TunerID);
+}
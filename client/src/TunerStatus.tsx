@@ -1,8 +1,41 @@
 import React from "react";
 
+import { useTuner, tunerQuery } from "./Tuner";
+
+export interface GuideEvent {
+  Title: string;
+  Start: string;
+  End: string;
+}
+
+export interface AudioTrack {
+  PID: number;
+  Codec: string;
+  Language?: string;
+  Type?: "CleanEffects" | "HearingImpaired" | "VisualImpaired";
+}
+
+export interface Signal {
+  Time: string;
+  Locked: boolean;
+  Strength: number;
+  SNR: number;
+  BER: number;
+  UNC: number;
+}
+
 type TunerStatus =
-  | { State: "Starting" | "Playing"; ChannelName: string }
-  | { State: "Stopped"; Error: undefined | string };
+  | {
+      State: "Starting" | "Playing";
+      ChannelName: string;
+      Now?: GuideEvent;
+      Next?: GuideEvent;
+      AudioTracks?: AudioTrack[];
+      AudioPID?: number;
+      Signal?: Signal;
+    }
+  | { State: "Stopped"; Error: undefined | string }
+  | { State: "Scanning" };
 
 export type Status =
   | { Connection: "Disconnected" | "Connecting" }
@@ -23,13 +56,15 @@ export const TunerStatusProvider = ({
 }: {
   children: React.ReactNode;
 }) => {
+  const { ID: tunerID } = useTuner();
   const [status, setStatus] = React.useState<Status>({
     Connection: "Connecting",
   });
 
   React.useEffect(() => {
+    setStatus({ Connection: "Connecting" });
     const ws = new WebSocket(
-      `ws://${window.location.host}/api/socket/tuner-status`,
+      `ws://${window.location.host}/api/socket/tuner-status${tunerQuery(tunerID)}`,
     );
 
     let closed = false;
@@ -62,7 +97,7 @@ export const TunerStatusProvider = ({
     };
 
     return close;
-  }, []);
+  }, [tunerID]);
 
   return <Context value={status}>{children}</Context>;
 };
@@ -1,10 +1,57 @@
 import { EventEmitter } from "events";
 
+import { tunerQuery } from "../Tuner";
+
 export type ConnectionState =
   | { Status: "Disconnected" | "Connecting" | "Connected" }
   | { Status: "Error"; Error: Error };
 
-type Message = { SDP: RTCSessionDescriptionInit };
+// VideoLayers lists the quality layers of the video that the server encodes,
+// from highest to lowest, along with the layer that the client receives.
+export interface VideoLayers {
+  Layers: string[] | null;
+  Layer: string;
+}
+
+type Message =
+  | { SDP: RTCSessionDescriptionInit }
+  | { Candidate: RTCIceCandidateInit }
+  | { EndOfCandidates: true }
+  | { Video: VideoLayers };
+
+// socketProtocol is the version of the WebRTC socket protocol in which both
+// sides trickle their ICE candidates. Servers that predate it accept the
+// socket without choosing a protocol, and send every candidate in each offer.
+const socketProtocol = "hypcast.webrtc.v2";
+
+export interface CaptionService {
+  Service: string;
+  Language?: string;
+  EasyReader?: boolean;
+}
+
+export interface CaptionServices {
+  Services: CaptionService[];
+  Selected: string;
+}
+
+export interface CaptionCue {
+  Text: string;
+  Settings?: string;
+}
+
+// CaptionSelection selects a caption service by ID, like "CC1" or "SERVICE1",
+// or by ISO 639-2 language code when the broadcaster lists one. An empty
+// selection turns captions off.
+export interface CaptionSelection {
+  Service?: string;
+  Language?: string;
+}
+
+type CaptionMessage = CaptionServices | { Cue: CaptionCue };
+
+const captionSelectionKey = "hypcast.captions";
+const videoQualityKey = "hypcast.quality";
 
 // eslint-disable @typescript-eslint/no-unsafe-declaration-merging
 // TODO: I need to figure out what's up with this one.
@@ -21,20 +68,37 @@ declare interface Backend {
 
   emit(event: "streamremoved"): boolean;
   on(event: "streamremoved", listener: () => void): this;
+
+  emit(event: "captionservices", services: CaptionServices): boolean;
+  on(
+    event: "captionservices",
+    listener: (services: CaptionServices) => void,
+  ): this;
+
+  emit(event: "captioncue", cue: CaptionCue): boolean;
+  on(event: "captioncue", listener: (cue: CaptionCue) => void): this;
+
+  emit(event: "videolayers", layers: VideoLayers): boolean;
+  on(event: "videolayers", listener: (layers: VideoLayers) => void): this;
 }
 
 class Backend extends EventEmitter {
   private pc: RTCPeerConnection;
   private ws: WebSocket;
+  private captionChannel: undefined | RTCDataChannel;
+  private iceConfigured: Promise<void>;
+  private signaling: Promise<void> = Promise.resolve();
 
   private _connectionState: ConnectionState = { Status: "Connecting" };
   private _mediaStream: undefined | MediaStream;
 
-  constructor() {
+  constructor(tunerID?: string) {
     super();
     this.pc = new RTCPeerConnection();
+    this.iceConfigured = this.configureICEServers();
     this.ws = new WebSocket(
-      `ws://${window.location.host}/api/socket/webrtc-peer`,
+      `ws://${window.location.host}/api/socket/webrtc-peer${tunerQuery(tunerID)}`,
+      [socketProtocol],
     );
     this.setup();
   }
@@ -48,9 +112,18 @@ class Backend extends EventEmitter {
     this.pc.addEventListener("track", (evt) =>
       this.handlePeerConnectionTrack(evt),
     );
+    this.pc.addEventListener("icecandidate", (evt) =>
+      this.handlePeerConnectionICECandidate(evt),
+    );
+    this.pc.addEventListener("datachannel", (evt) =>
+      this.handlePeerConnectionDataChannel(evt),
+    );
     this.pc.addEventListener("connectionstatechange", (evt) =>
       console.log("Connection state", this.pc.connectionState, evt),
     );
+    this.pc.addEventListener("iceconnectionstatechange", () =>
+      this.handlePeerConnectionICEStateChange(),
+    );
     this.pc.addEventListener("signalingstatechange", (evt) =>
       console.log("Signaling state", this.pc.signalingState, evt),
     );
@@ -68,13 +141,90 @@ class Backend extends EventEmitter {
     this.ws.close();
   }
 
+  get captionSelection(): CaptionSelection {
+    try {
+      return JSON.parse(localStorage.getItem(captionSelectionKey) ?? "{}");
+    } catch {
+      return {};
+    }
+  }
+
+  selectCaptions(selection: CaptionSelection) {
+    localStorage.setItem(captionSelectionKey, JSON.stringify(selection));
+    this.sendCaptionSelection();
+  }
+
+  // videoQuality is the video layer that the user asked for, or the empty
+  // string to let the server pick a layer to suit the connection.
+  get videoQuality(): string {
+    return localStorage.getItem(videoQualityKey) ?? "";
+  }
+
+  selectVideoQuality(quality: string) {
+    localStorage.setItem(videoQualityKey, quality);
+    this.sendVideoQuality();
+  }
+
+  private sendVideoQuality() {
+    if (this.ws.readyState === WebSocket.OPEN) {
+      this.ws.send(JSON.stringify({ Quality: this.videoQuality }));
+    }
+  }
+
+  private sendCaptionSelection() {
+    if (this.captionChannel?.readyState === "open") {
+      this.captionChannel.send(JSON.stringify(this.captionSelection));
+    }
+  }
+
   private handleSocketMessage(evt: MessageEvent) {
     const message: Message = JSON.parse(evt.data);
+    if ("Video" in message) {
+      this.emit("videolayers", message.Video);
+      return;
+    }
+    if ("Candidate" in message) {
+      this.signal(() => this.pc.addIceCandidate(message.Candidate));
+      return;
+    }
+    if ("EndOfCandidates" in message) {
+      this.signal(() => this.pc.addIceCandidate());
+      return;
+    }
     console.log("Received WebRTC offer", message);
-    this.handleRTCOffer(message.SDP).catch(() => {});
+    this.signal(() => this.handleRTCOffer(message.SDP));
+  }
+
+  // signal runs a step of the session negotiation after every earlier step, so
+  // that each candidate from the server follows the offer that it belongs to.
+  private signal(step: () => Promise<void>) {
+    this.signaling = this.signaling
+      .then(step)
+      .catch((e: unknown) => console.log("WebRTC signaling failed", e));
+  }
+
+  // configureICEServers sets up the STUN and TURN servers that the server
+  // provides, which have to be in place before an answer starts ICE gathering.
+  // Without them, the connection can still work on a network with a direct
+  // route to the server. The server's own TURN credentials only last a few
+  // minutes, so every ICE restart fetches new ones.
+  private async configureICEServers() {
+    try {
+      const response = await fetch("/api/config/ice-servers");
+      if (!response.ok) {
+        throw new Error(
+          `failed to retrieve ICE servers: ${response.statusText}`,
+        );
+      }
+      const iceServers: RTCIceServer[] = await response.json();
+      this.pc.setConfiguration({ iceServers });
+    } catch (e: unknown) {
+      console.log("Failed to configure ICE servers", e);
+    }
   }
 
   private async handleRTCOffer(sdp: RTCSessionDescriptionInit) {
+    await this.iceConfigured;
     console.log("Received remote description", sdp);
     this.pc.setRemoteDescription(sdp).catch(() => {});
 
@@ -88,6 +238,7 @@ class Backend extends EventEmitter {
   private handleSocketOpen() {
     this._connectionState = { Status: "Connected" };
     this.emit("connectionchange", this._connectionState);
+    this.sendVideoQuality();
   }
 
   private handleSocketClose() {
@@ -124,6 +275,60 @@ class Backend extends EventEmitter {
     this.emit("streamreceived", stream);
   }
 
+  private handlePeerConnectionICECandidate(evt: RTCPeerConnectionIceEvent) {
+    if (
+      this.ws.protocol !== socketProtocol ||
+      this.ws.readyState !== WebSocket.OPEN
+    ) {
+      return;
+    }
+    if (evt.candidate) {
+      this.ws.send(JSON.stringify({ Candidate: evt.candidate.toJSON() }));
+    } else {
+      this.ws.send(JSON.stringify({ EndOfCandidates: true }));
+    }
+  }
+
+  // handlePeerConnectionICEStateChange asks the server to restart ICE when the
+  // connection fails, as a relayed connection does once its TURN credentials
+  // expire. The server's offer waits for new ICE servers to answer with.
+  private handlePeerConnectionICEStateChange() {
+    console.log("ICE connection state", this.pc.iceConnectionState);
+    if (
+      this.pc.iceConnectionState !== "failed" ||
+      this.ws.readyState !== WebSocket.OPEN
+    ) {
+      return;
+    }
+    this.iceConfigured = this.configureICEServers();
+    this.ws.send(JSON.stringify({ RestartICE: true }));
+  }
+
+  private handlePeerConnectionDataChannel(evt: RTCDataChannelEvent) {
+    if (evt.channel.label !== "captions") {
+      return;
+    }
+
+    this.captionChannel = evt.channel;
+    this.captionChannel.addEventListener("open", () =>
+      this.sendCaptionSelection(),
+    );
+    this.captionChannel.addEventListener("message", (msgEvt) =>
+      this.handleCaptionMessage(msgEvt),
+    );
+    // Some browsers announce channels that are already open.
+    this.sendCaptionSelection();
+  }
+
+  private handleCaptionMessage(evt: MessageEvent) {
+    const message: CaptionMessage = JSON.parse(evt.data);
+    if ("Cue" in message) {
+      this.emit("captioncue", message.Cue);
+    } else {
+      this.emit("captionservices", message);
+    }
+  }
+
   private handleMediaStreamRemoveTrack(stream: MediaStream) {
     console.log("Track removed from stream", stream);
 
@@ -1,10 +1,33 @@
 import React from "react";
 
-import { default as Backend, ConnectionState } from "./Backend";
+import {
+  default as Backend,
+  ConnectionState,
+  CaptionCue,
+  CaptionSelection,
+  CaptionServices,
+  VideoLayers,
+} from "./Backend";
+import { useTuner } from "../Tuner";
+
+export type {
+  CaptionCue,
+  CaptionSelection,
+  CaptionService,
+  CaptionServices,
+  VideoLayers,
+} from "./Backend";
 
 export interface State {
   Connection: ConnectionState;
   MediaStream: undefined | MediaStream;
+  CaptionServices: CaptionServices;
+  CaptionCue: CaptionCue;
+  CaptionSelection: CaptionSelection;
+  selectCaptions: (selection: CaptionSelection) => void;
+  VideoLayers: VideoLayers;
+  VideoQuality: string;
+  selectVideoQuality: (quality: string) => void;
 }
 
 const Context = React.createContext<State | null>(null);
@@ -18,13 +41,34 @@ export const useWebRTC = (): State => {
 };
 
 export const WebRTCProvider = ({ children }: { children: React.ReactNode }) => {
+  const { ID: tunerID } = useTuner();
   const [state, dispatch] = React.useReducer(reduce, null, () =>
     defaultState(),
   );
 
   React.useEffect(() => {
-    const backend = new Backend();
+    const backend = new Backend(tunerID);
     dispatch({ kind: "connectionchange", state: backend.connectionState });
+    dispatch({
+      kind: "captionselectionchange",
+      selection: backend.captionSelection,
+      selectCaptions: (selection: CaptionSelection) => {
+        backend.selectCaptions(selection);
+        dispatch({
+          kind: "captionselectionchange",
+          selection: backend.captionSelection,
+        });
+      },
+    });
+
+    dispatch({
+      kind: "videoqualitychange",
+      quality: backend.videoQuality,
+      selectVideoQuality: (quality: string) => {
+        backend.selectVideoQuality(quality);
+        dispatch({ kind: "videoqualitychange", quality });
+      },
+    });
 
     backend.on("connectionchange", (state: ConnectionState) =>
       dispatch({ kind: "connectionchange", state }),
@@ -33,11 +77,21 @@ export const WebRTCProvider = ({ children }: { children: React.ReactNode }) => {
       dispatch({ kind: "streamreceived", stream }),
     );
     backend.on("streamremoved", () => dispatch({ kind: "streamremoved" }));
+    backend.on("captionservices", (services: CaptionServices) =>
+      dispatch({ kind: "captionservices", services }),
+    );
+    backend.on("captioncue", (cue: CaptionCue) =>
+      dispatch({ kind: "captioncue", cue }),
+    );
+    backend.on("videolayers", (layers: VideoLayers) =>
+      dispatch({ kind: "videolayers", layers }),
+    );
 
     return () => {
       backend.close();
+      dispatch({ kind: "streamremoved" });
     };
-  }, []);
+  }, [tunerID]);
 
   return <Context value={state}>{children}</Context>;
 };
@@ -45,12 +99,32 @@ export const WebRTCProvider = ({ children }: { children: React.ReactNode }) => {
 const defaultState = (): State => ({
   Connection: { Status: "Connecting" },
   MediaStream: undefined,
+  CaptionServices: { Services: [], Selected: "" },
+  CaptionCue: { Text: "" },
+  CaptionSelection: {},
+  selectCaptions: () => {},
+  VideoLayers: { Layers: null, Layer: "" },
+  VideoQuality: "",
+  selectVideoQuality: () => {},
 });
 
 type Action =
   | { kind: "connectionchange"; state: ConnectionState }
   | { kind: "streamreceived"; stream: MediaStream }
-  | { kind: "streamremoved" };
+  | { kind: "streamremoved" }
+  | { kind: "captionservices"; services: CaptionServices }
+  | { kind: "captioncue"; cue: CaptionCue }
+  | {
+      kind: "captionselectionchange";
+      selection: CaptionSelection;
+      selectCaptions?: State["selectCaptions"];
+    }
+  | { kind: "videolayers"; layers: VideoLayers }
+  | {
+      kind: "videoqualitychange";
+      quality: string;
+      selectVideoQuality?: State["selectVideoQuality"];
+    };
 
 const reduce = (state: State, action: Action): State => {
   switch (action.kind) {
@@ -61,6 +135,30 @@ const reduce = (state: State, action: Action): State => {
       return { ...state, MediaStream: action.stream };
 
     case "streamremoved":
-      return { ...state, MediaStream: undefined };
+      return { ...state, MediaStream: undefined, CaptionCue: { Text: "" } };
+
+    case "captionservices":
+      return { ...state, CaptionServices: action.services };
+
+    case "captioncue":
+      return { ...state, CaptionCue: action.cue };
+
+    case "captionselectionchange":
+      return {
+        ...state,
+        CaptionSelection: action.selection,
+        selectCaptions: action.selectCaptions ?? state.selectCaptions,
+      };
+
+    case "videolayers":
+      return { ...state, VideoLayers: action.layers };
+
+    case "videoqualitychange":
+      return {
+        ...state,
+        VideoQuality: action.quality,
+        selectVideoQuality:
+          action.selectVideoQuality ?? state.selectVideoQuality,
+      };
   }
 };
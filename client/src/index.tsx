@@ -4,6 +4,7 @@ import { createRoot } from "react-dom/client";
 import App from "./App";
 import { WebRTCProvider } from "./WebRTC";
 import { TunerStatusProvider } from "./TunerStatus";
+import { TunerProvider } from "./Tuner";
 
 import "./index.scss";
 
@@ -15,10 +16,12 @@ if (!container) {
 const root = createRoot(container);
 root.render(
   <React.StrictMode>
-    <WebRTCProvider>
-      <TunerStatusProvider>
-        <App />
-      </TunerStatusProvider>
-    </WebRTCProvider>
+    <TunerProvider>
+      <WebRTCProvider>
+        <TunerStatusProvider>
+          <App />
+        </TunerStatusProvider>
+      </WebRTCProvider>
+    </TunerProvider>
   </React.StrictMode>,
 );
@@ -2,15 +2,23 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/pion/webrtc/v4"
+
 	"github.com/featherbread/hypcast/client"
 	"github.com/featherbread/hypcast/internal/api"
 	"github.com/featherbread/hypcast/internal/assets"
@@ -23,6 +31,19 @@ var (
 	flagChannels      string
 	flagAssets        string
 	flagVideoPipeline string
+	flagVideoLayers   string
+	flagTuners        string
+	flagGuideSweep    time.Duration
+
+	flagICEServers    string
+	flagICEUsername   string
+	flagICECredential string
+	flagNAT1To1IPs    string
+	flagUDPPorts      string
+	flagUDPMuxPort    int
+	flagICEInterfaces string
+	flagTURNPort      int
+	flagTURNRelayIP   string
 )
 
 func init() {
@@ -42,9 +63,62 @@ func init() {
 		&flagVideoPipeline, "video-pipeline", "default",
 		`Video pipeline implementation (default, lowpower, vaapi)`,
 	)
+	flag.StringVar(
+		&flagVideoLayers, "video-layers", "high",
+		"Comma-separated list of video quality layers to encode for clients with different bandwidth (high, medium, low)",
+	)
+	flag.StringVar(
+		&flagTuners, "tuners", "0",
+		"Comma-separated list of DVB devices to use as tuners, each given as ADAPTER or ADAPTER.FRONTEND",
+	)
+	flag.DurationVar(
+		&flagGuideSweep, "guide-sweep", 0,
+		"Interval between sweeps of every multiplex for program guide data while a tuner is idle (0 to disable)",
+	)
+	flag.StringVar(
+		&flagICEServers, "ice-servers", "",
+		"Comma-separated list of STUN and TURN server URLs for WebRTC connections",
+	)
+	flag.StringVar(
+		&flagICEUsername, "ice-username", "",
+		"Username for the TURN servers in -ice-servers",
+	)
+	flag.StringVar(
+		&flagICECredential, "ice-credential", "",
+		"Credential for the TURN servers in -ice-servers",
+	)
+	flag.StringVar(
+		&flagNAT1To1IPs, "nat-1to1-ips", "",
+		"Comma-separated list of public IP addresses that map one to one onto the server's own, to advertise to WebRTC clients",
+	)
+	flag.StringVar(
+		&flagUDPPorts, "udp-ports", "",
+		"Range of UDP ports for WebRTC connections, given as MIN-MAX (default any ephemeral port)",
+	)
+	flag.IntVar(
+		&flagUDPMuxPort, "udp-mux-port", 0,
+		"Single UDP port to serve every WebRTC connection on; overrides -udp-ports",
+	)
+	flag.StringVar(
+		&flagICEInterfaces, "ice-interfaces", "",
+		"Comma-separated list of network interfaces to offer WebRTC connections on (default all)",
+	)
+	flag.IntVar(
+		&flagTURNPort, "turn-port", 0,
+		"UDP and TCP port for an embedded TURN server that relays WebRTC connections (0 to disable)",
+	)
+	flag.StringVar(
+		&flagTURNRelayIP, "turn-relay-ip", "",
+		"IP address of the server for the embedded TURN server to relay connections to (default the first non-loopback address)",
+	)
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "scan" {
+		runScan(os.Args[2:])
+		return
+	}
+
 	flag.Parse()
 
 	channels, err := readChannelsConf(flagChannels)
@@ -53,9 +127,43 @@ func main() {
 		os.Exit(1)
 	}
 
+	devices, err := parseTunerDevices(flagTuners)
+	if err != nil {
+		slog.Error("Invalid tuner list", "tuners", flagTuners, "error", err)
+		os.Exit(1)
+	}
+
+	layers, err := tuner.ParseVideoLayers(flagVideoLayers)
+	if err != nil {
+		slog.Error("Invalid video layer list", "video-layers", flagVideoLayers, "error", err)
+		os.Exit(1)
+	}
+
 	vp := tuner.ParseVideoPipeline(flagVideoPipeline)
-	tuner := tuner.NewTuner(channels, vp)
-	http.Handle("/api/", api.NewHandler(tuner))
+	if vp == tuner.VideoPipelineLowPower && len(layers) > 1 {
+		slog.Warn("The lowpower video pipeline encodes a single video layer", "video-layers", flagVideoLayers)
+	}
+	pool, err := tuner.NewPool(devices, channels, vp, layers)
+	if err != nil {
+		slog.Error("Failed to create tuners", "error", err)
+		os.Exit(1)
+	}
+
+	ice, err := parseICEConfig()
+	if err != nil {
+		slog.Error("Invalid WebRTC connection settings", "error", err)
+		os.Exit(1)
+	}
+	handler, err := api.NewHandler(pool, ice)
+	if err != nil {
+		slog.Error("Failed to create API handler", "error", err)
+		os.Exit(1)
+	}
+	http.Handle("/api/", handler)
+
+	if flagGuideSweep > 0 {
+		go sweepGuide(pool, flagGuideSweep)
+	}
 
 	var assetLogAttr slog.Attr
 	if flagAssets != "" {
@@ -76,6 +184,17 @@ func main() {
 		slog.String("addr", flagAddr),
 		slog.String("channels", flagChannels),
 		slog.String("pipeline", string(vp)),
+		slog.String("video-layers", flagVideoLayers),
+		slog.String("tuners", flagTuners),
+		slog.Duration("guide-sweep", flagGuideSweep),
+		slog.Group("ice",
+			slog.String("servers", flagICEServers),
+			slog.String("nat-1to1-ips", flagNAT1To1IPs),
+			slog.String("udp-ports", flagUDPPorts),
+			slog.Int("udp-mux-port", flagUDPMuxPort),
+			slog.String("interfaces", flagICEInterfaces),
+			slog.Int("turn-port", flagTURNPort),
+		),
 		assetLogAttr,
 	)
 	server := http.Server{Addr: flagAddr}
@@ -95,6 +214,24 @@ func main() {
 		stopCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		server.Shutdown(stopCtx)
+		if err := handler.Close(); err != nil {
+			slog.Error("Failed to close API handler", "error", err)
+		}
+	}
+}
+
+// sweepGuide collects the program guide from every multiplex at the provided
+// interval, using whichever tuner in the pool is idle.
+func sweepGuide(pool *tuner.Pool, interval time.Duration) {
+	for {
+		err := pool.SweepGuide(context.Background())
+		switch {
+		case errors.Is(err, tuner.ErrTunerBusy):
+			slog.Debug("Skipped program guide sweep", "error", err)
+		case err != nil:
+			slog.Error("Failed to sweep program guide", "error", err)
+		}
+		time.Sleep(interval)
 	}
 }
 
@@ -107,3 +244,124 @@ func readChannelsConf(path string) ([]atsc.Channel, error) {
 
 	return atsc.ParseChannelsConf(f)
 }
+
+func parseTunerDevices(list string) ([]tuner.Device, error) {
+	var devices []tuner.Device
+	for field := range strings.SplitSeq(list, ",") {
+		device, err := tuner.ParseDevice(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// parseICEConfig builds the settings for WebRTC connections from the flags.
+func parseICEConfig() (api.ICEConfig, error) {
+	var ice api.ICEConfig
+
+	if urls := splitList(flagICEServers); len(urls) > 0 {
+		// Only TURN servers take credentials, so the STUN servers go in an
+		// entry of their own without them.
+		var stun, turn []string
+		for _, url := range urls {
+			if strings.HasPrefix(url, "turn:") || strings.HasPrefix(url, "turns:") {
+				turn = append(turn, url)
+			} else {
+				stun = append(stun, url)
+			}
+		}
+		if len(stun) > 0 {
+			ice.Servers = append(ice.Servers, webrtc.ICEServer{URLs: stun})
+		}
+		if len(turn) > 0 {
+			ice.Servers = append(ice.Servers, webrtc.ICEServer{
+				URLs:       turn,
+				Username:   flagICEUsername,
+				Credential: flagICECredential,
+			})
+		}
+	}
+
+	for _, ip := range splitList(flagNAT1To1IPs) {
+		if net.ParseIP(ip) == nil {
+			return ice, fmt.Errorf("invalid NAT 1:1 IP address %q", ip)
+		}
+		ice.NAT1To1IPs = append(ice.NAT1To1IPs, ip)
+	}
+
+	if flagUDPPorts != "" {
+		lo, hi, ok := strings.Cut(flagUDPPorts, "-")
+		portMin, minErr := strconv.ParseUint(strings.TrimSpace(lo), 10, 16)
+		portMax, maxErr := strconv.ParseUint(strings.TrimSpace(hi), 10, 16)
+		if !ok || minErr != nil || maxErr != nil || portMin == 0 || portMin > portMax {
+			return ice, fmt.Errorf("invalid UDP port range %q", flagUDPPorts)
+		}
+		ice.UDPPortMin, ice.UDPPortMax = uint16(portMin), uint16(portMax)
+	}
+
+	if flagUDPMuxPort < 0 || flagUDPMuxPort > 65535 {
+		return ice, fmt.Errorf("invalid UDP mux port %d", flagUDPMuxPort)
+	}
+	ice.UDPMuxPort = flagUDPMuxPort
+
+	ice.Interfaces = splitList(flagICEInterfaces)
+
+	if flagTURNPort < 0 || flagTURNPort > 65535 {
+		return ice, fmt.Errorf("invalid TURN port %d", flagTURNPort)
+	}
+	ice.TURNPort = flagTURNPort
+	if ice.TURNPort != 0 {
+		ip, err := turnRelayIP(ice.Interfaces)
+		if err != nil {
+			return ice, err
+		}
+		ice.TURNRelayIP = ip
+	}
+	return ice, nil
+}
+
+// turnRelayIP returns the address for the embedded TURN server to allocate
+// relays on: the one in the flag, or the first non-loopback IPv4 address of
+// the provided interfaces (or of any interface).
+func turnRelayIP(interfaces []string) (net.IP, error) {
+	if flagTURNRelayIP != "" {
+		ip := net.ParseIP(flagTURNRelayIP)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid TURN relay IP address %q", flagTURNRelayIP)
+		}
+		return ip, nil
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range ifaces {
+		if len(interfaces) > 0 && !slices.Contains(interfaces, iface.Name) {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+				return ipNet.IP, nil
+			}
+		}
+	}
+	return nil, errors.New("no address for the TURN server to relay to; set -turn-relay-ip")
+}
+
+// splitList splits a comma-separated flag value, ignoring empty items.
+func splitList(list string) []string {
+	var items []string
+	for item := range strings.SplitSeq(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/featherbread/hypcast/internal/api"
+)
+
+// iceFlags holds the values of the flags that parseICEConfig reads.
+type iceFlags struct {
+	servers, username, credential string
+	nat1To1IPs                    string
+	udpPorts                      string
+	udpMuxPort                    int
+	interfaces                    string
+	turnPort                      int
+	turnRelayIP                   string
+}
+
+func (f iceFlags) set(t *testing.T) {
+	saved := iceFlags{
+		flagICEServers, flagICEUsername, flagICECredential, flagNAT1To1IPs, flagUDPPorts,
+		flagUDPMuxPort, flagICEInterfaces, flagTURNPort, flagTURNRelayIP,
+	}
+	t.Cleanup(func() { saved.assign() })
+	f.assign()
+}
+
+func (f iceFlags) assign() {
+	flagICEServers, flagICEUsername, flagICECredential = f.servers, f.username, f.credential
+	flagNAT1To1IPs, flagUDPPorts, flagUDPMuxPort = f.nat1To1IPs, f.udpPorts, f.udpMuxPort
+	flagICEInterfaces, flagTURNPort, flagTURNRelayIP = f.interfaces, f.turnPort, f.turnRelayIP
+}
+
+func TestParseICEConfig(t *testing.T) {
+	testCases := []struct {
+		name    string
+		flags   iceFlags
+		want    api.ICEConfig
+		wantErr bool
+	}{
+		{name: "defaults"},
+		{
+			name: "servers",
+			flags: iceFlags{
+				servers:  "stun:stun.example.com:3478, turn:turn.example.com:3478,turns:turn.example.com:5349",
+				username: "user", credential: "secret",
+			},
+			want: api.ICEConfig{Servers: []webrtc.ICEServer{
+				{URLs: []string{"stun:stun.example.com:3478"}},
+				{URLs: []string{"turn:turn.example.com:3478", "turns:turn.example.com:5349"}, Username: "user", Credential: "secret"},
+			}},
+		},
+		{
+			name:  "NAT 1:1 IPs",
+			flags: iceFlags{nat1To1IPs: "203.0.113.5,2001:db8::5"},
+			want:  api.ICEConfig{NAT1To1IPs: []string{"203.0.113.5", "2001:db8::5"}},
+		},
+		{name: "bad NAT 1:1 IP", flags: iceFlags{nat1To1IPs: "203.0.113"}, wantErr: true},
+		{name: "NAT 1:1 hostname", flags: iceFlags{nat1To1IPs: "example.com"}, wantErr: true},
+		{
+			name:  "UDP ports",
+			flags: iceFlags{udpPorts: "50000-50100"},
+			want:  api.ICEConfig{UDPPortMin: 50000, UDPPortMax: 50100},
+		},
+		{
+			name:  "UDP ports with spaces",
+			flags: iceFlags{udpPorts: "50000 - 50000"},
+			want:  api.ICEConfig{UDPPortMin: 50000, UDPPortMax: 50000},
+		},
+		{name: "single UDP port", flags: iceFlags{udpPorts: "50000"}, wantErr: true},
+		{name: "open UDP port range", flags: iceFlags{udpPorts: "50000-"}, wantErr: true},
+		{name: "UDP port zero", flags: iceFlags{udpPorts: "0-100"}, wantErr: true},
+		{name: "reversed UDP ports", flags: iceFlags{udpPorts: "50100-50000"}, wantErr: true},
+		{name: "UDP port too large", flags: iceFlags{udpPorts: "50000-65536"}, wantErr: true},
+		{name: "negative UDP port", flags: iceFlags{udpPorts: "-1-100"}, wantErr: true},
+		{name: "UDP port names", flags: iceFlags{udpPorts: "low-high"}, wantErr: true},
+		{
+			name:  "UDP mux port",
+			flags: iceFlags{udpMuxPort: 9201},
+			want:  api.ICEConfig{UDPMuxPort: 9201},
+		},
+		{name: "negative UDP mux port", flags: iceFlags{udpMuxPort: -1}, wantErr: true},
+		{name: "UDP mux port too large", flags: iceFlags{udpMuxPort: 65536}, wantErr: true},
+		{
+			name:  "interfaces",
+			flags: iceFlags{interfaces: "wg0, eth0"},
+			want:  api.ICEConfig{Interfaces: []string{"wg0", "eth0"}},
+		},
+		{
+			name:  "TURN relay",
+			flags: iceFlags{turnPort: 3478, turnRelayIP: "192.0.2.10"},
+			want:  api.ICEConfig{TURNPort: 3478, TURNRelayIP: net.ParseIP("192.0.2.10")},
+		},
+		{name: "bad TURN relay IP", flags: iceFlags{turnPort: 3478, turnRelayIP: "192.0.2"}, wantErr: true},
+		{name: "negative TURN port", flags: iceFlags{turnPort: -1}, wantErr: true},
+		{name: "TURN port too large", flags: iceFlags{turnPort: 65536}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.flags.set(t)
+			got, err := parseICEConfig()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseICEConfig() error = %v, want error %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected result (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/featherbread/hypcast/internal/atsc"
+	"github.com/featherbread/hypcast/internal/atsc/scan"
+	"github.com/featherbread/hypcast/internal/atsc/tuner"
+)
+
+// runScan implements the scan subcommand, which searches for channels with a
+// single tuner and writes them to standard output in channels.conf format.
+func runScan(args []string) {
+	flags := flag.NewFlagSet("scan", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s scan [flags] > channels.conf\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	var (
+		flagTuner = flags.String(
+			"tuner", "0",
+			"DVB device to scan with, given as ADAPTER or ADAPTER.FRONTEND",
+		)
+		flagPlan = flags.String(
+			"plan", "atsc",
+			"Band plan to scan (atsc for broadcast, qam for cable)",
+		)
+	)
+	flags.Parse(args)
+
+	device, err := tuner.ParseDevice(*flagTuner)
+	if err != nil {
+		slog.Error("Invalid tuner", "tuner", *flagTuner, "error", err)
+		os.Exit(2)
+	}
+	plan, ok := scan.BandPlanByName(*flagPlan)
+	if !ok {
+		slog.Error("Unknown band plan", "plan", *flagPlan)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	t := tuner.NewTuner(device, nil, tuner.VideoPipelineDefault, nil)
+	channels, scanErr := t.Scan(ctx, plan, func(p scan.Progress) {
+		slog.Info("Scanning", "done", p.Done, "total", p.Total, "channels", p.Channels)
+	})
+
+	// Even a canceled scan may have found channels worth keeping.
+	if err := atsc.WriteChannelsConf(os.Stdout, channels); err != nil {
+		slog.Error("Failed to write channels", "error", err)
+		os.Exit(1)
+	}
+	if scanErr != nil {
+		slog.Error("Channel scan did not complete", "error", scanErr)
+		os.Exit(1)
+	}
+}
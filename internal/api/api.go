@@ -7,27 +7,69 @@ import (
 	"log/slog"
 	"net/http"
 	"slices"
+	"time"
+
+	"github.com/pion/webrtc/v4"
 
 	"github.com/featherbread/hypcast/internal/api/rpc"
+	"github.com/featherbread/hypcast/internal/atsc"
+	"github.com/featherbread/hypcast/internal/atsc/scan"
 	"github.com/featherbread/hypcast/internal/atsc/tuner"
 )
 
 var csrf = http.NewCrossOriginProtection()
 
-// Handler serves the Hypcast API for a single tuner.
+// Handler serves the Hypcast API for a pool of tuners.
+//
+// Clients select a tuner by providing its ID, either in the "tuner" query
+// parameter of a socket URL or in the TunerID parameter of an RPC. Clients
+// that don't provide a tuner ID are served by the pool's default tuner.
 type Handler struct {
 	mux   *http.ServeMux
-	tuner *tuner.Tuner
+	pool  *tuner.Pool
+	peers peerRegistry
+	whep  whepSessions
+	scans scanJobs
+
+	ice         ICEConfig
+	iceSettings webrtc.SettingEngine
+	turn        *turnServer
 }
 
-// NewHandler creates a Handler serving the Hypcast API for tuner.
-func NewHandler(tuner *tuner.Tuner) *Handler {
+// NewHandler creates a Handler serving the Hypcast API for pool, whose WebRTC
+// clients connect as ice configures.
+func NewHandler(pool *tuner.Pool, ice ICEConfig) (*Handler, error) {
+	settings, err := ice.newSettingEngine()
+	if err != nil {
+		return nil, err
+	}
+
 	h := &Handler{
-		mux:   http.NewServeMux(),
-		tuner: tuner,
+		mux:         http.NewServeMux(),
+		pool:        pool,
+		ice:         ice,
+		iceSettings: settings,
+	}
+	if ice.TURNPort != 0 {
+		if h.turn, err = startTURNServer(ice); err != nil {
+			return nil, err
+		}
 	}
 
 	h.mux.HandleFunc("GET /api/config/channels", h.handleConfigChannels)
+	h.mux.HandleFunc("GET /api/config/ice-servers", h.handleConfigICEServers)
+	h.mux.HandleFunc("GET /api/config/tuners", h.handleConfigTuners)
+	h.mux.HandleFunc("GET /api/guide", h.handleGuide)
+	h.mux.HandleFunc("GET /api/signal", h.handleSignal)
+	h.mux.HandleFunc("GET /api/peers", h.handlePeers)
+	h.mux.HandleFunc("GET /api/stream/{file}", h.handleStream)
+
+	// WHEP players often run on other origins, and can't send CSRF headers.
+	h.mux.HandleFunc("POST /api/whep", allowCORS(h.handleWHEPOffer))
+	h.mux.HandleFunc("OPTIONS /api/whep", allowCORS(handleWHEPOptions))
+	h.mux.HandleFunc("PATCH /api/whep/{id}", allowCORS(h.handleWHEPPatch))
+	h.mux.HandleFunc("DELETE /api/whep/{id}", allowCORS(h.handleWHEPDelete))
+	h.mux.HandleFunc("OPTIONS /api/whep/{id}", allowCORS(handleWHEPOptions))
 
 	// The RPC framework is expected to enforce its own method checks.
 	rpcMux := http.NewServeMux()
@@ -35,46 +77,313 @@ func NewHandler(tuner *tuner.Tuner) *Handler {
 		csrf.Handler(
 			rpc.WithLimitedBodyBuffer(1024,
 				rpcMux)))
+	rpcMux.Handle("/api/rpc/audio", rpc.Handle(h.rpcAudio))
+	rpcMux.Handle("/api/rpc/scan", rpc.Handle(h.rpcScan))
 	rpcMux.Handle("/api/rpc/stop", rpc.Handle(h.rpcStop))
 	rpcMux.Handle("/api/rpc/tune", rpc.Handle(h.rpcTune))
 
 	// The websocket library is expected to enforce its own method checks.
 	h.mux.HandleFunc("/api/socket/webrtc-peer", h.handleSocketWebRTCPeer)
 	h.mux.HandleFunc("/api/socket/tuner-status", h.handleSocketTunerStatus)
+	h.mux.HandleFunc("/api/socket/scan", h.handleSocketScan)
 
-	return h
+	return h, nil
+}
+
+// Close releases the resources that the handler holds outside of its requests,
+// like the listeners of the embedded TURN server.
+func (h *Handler) Close() error {
+	if h.turn != nil {
+		return h.turn.Close()
+	}
+	return nil
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.mux.ServeHTTP(w, r)
 }
 
+// configChannel is the representation of a channel in the channel list, which
+// clients may tune to by Name.
+type configChannel struct {
+	Name      string
+	Number    string `json:",omitempty"`
+	ShortName string `json:",omitempty"`
+}
+
 func (h *Handler) handleConfigChannels(w http.ResponseWriter, r *http.Request) {
+	channels := slices.SortedStableFunc(h.pool.Channels(), atsc.CompareNumbers)
+	list := make([]configChannel, len(channels))
+	for i, ch := range channels {
+		list[i] = newConfigChannel(ch)
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func newConfigChannel(ch atsc.Channel) configChannel {
+	return configChannel{Name: ch.Name, Number: ch.Number(), ShortName: ch.ShortName}
+}
+
+func (h *Handler) handleConfigTuners(w http.ResponseWriter, r *http.Request) {
+	var ids []string
+	for t := range h.pool.All() {
+		ids = append(ids, t.ID())
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ids)
+}
+
+const (
+	// defaultGuideWindow is the length of the guide window that clients
+	// receive when they don't provide an end time.
+	defaultGuideWindow = 3 * time.Hour
+	// maxGuideWindow limits the length of the guide window that clients may
+	// request, and exceeds the schedule that broadcasters are likely to send.
+	maxGuideWindow = 7 * 24 * time.Hour
+)
+
+// guideChannel is the representation of a channel in the program guide.
+type guideChannel struct {
+	configChannel
+	Events []guideEvent
+}
+
+type guideEvent struct {
+	Title       string
+	Start       time.Time
+	End         time.Time
+	Description string `json:",omitempty"`
+}
+
+// handleGuide serves the program guide for every channel, or for the channel
+// named by the "channel" query parameter. The "from" and "to" query parameters
+// optionally give the window of the guide in RFC 3339 format, which defaults to
+// the next few hours.
+func (h *Handler) handleGuide(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to, err := parseGuideWindow(query.Get("from"), query.Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var channels []atsc.Channel
+	if name := query.Get("channel"); name != "" {
+		ch, ok := h.pool.Default().Channel(name)
+		if !ok {
+			http.Error(w, tuner.ErrChannelNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		channels = []atsc.Channel{ch}
+	} else {
+		channels = slices.SortedStableFunc(h.pool.Channels(), atsc.CompareNumbers)
+	}
+
+	g := h.pool.Guide()
+	list := make([]guideChannel, len(channels))
+	for i, ch := range channels {
+		list[i] = guideChannel{configChannel: newConfigChannel(ch), Events: []guideEvent{}}
+		for _, e := range g.Events(ch, from, to) {
+			list[i].Events = append(list[i].Events, guideEvent{
+				Title:       e.Title,
+				Start:       e.Start,
+				End:         e.End(),
+				Description: e.Description,
+			})
+		}
+	}
 	w.Header().Add("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(slices.Collect(h.tuner.ChannelNames()))
+	json.NewEncoder(w).Encode(list)
 }
 
-func (h *Handler) rpcStop(r *http.Request, _ struct{}) (code int, body any) {
-	slog.Info("Stopping tuner", "client", r.RemoteAddr)
-	if err := h.tuner.Stop(); err != nil {
+func parseGuideWindow(fromParam, toParam string) (from, to time.Time, err error) {
+	from = time.Now()
+	if fromParam != "" {
+		if from, err = time.Parse(time.RFC3339, fromParam); err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid start time")
+		}
+	}
+	to = from.Add(defaultGuideWindow)
+	if toParam != "" {
+		if to, err = time.Parse(time.RFC3339, toParam); err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid end time")
+		}
+	}
+
+	switch window := to.Sub(from); {
+	case window <= 0:
+		return time.Time{}, time.Time{}, errors.New("end time must follow start time")
+	case window > maxGuideWindow:
+		return time.Time{}, time.Time{}, errors.New("guide window too long")
+	}
+	return from, to, nil
+}
+
+// handleSignal serves the recent signal statistics of the tuner identified by
+// the "tuner" query parameter, from oldest to newest.
+func (h *Handler) handleSignal(w http.ResponseWriter, r *http.Request) {
+	t, err := h.lookupTuner(r.URL.Query().Get("tuner"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	history := t.SignalHistory()
+	list := make([]signalMsg, len(history))
+	for i, s := range history {
+		list[i] = signalMsg(s)
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+var errTunerNotFound = errors.New("tuner not found")
+
+// lookupTuner returns the tuner identified by id, or the pool's default tuner
+// if id is empty.
+func (h *Handler) lookupTuner(id string) (*tuner.Tuner, error) {
+	if id == "" {
+		return h.pool.Default(), nil
+	}
+	if t, ok := h.pool.Get(id); ok {
+		return t, nil
+	}
+	return nil, errTunerNotFound
+}
+
+// lookupSocketTuner returns the tuner identified by the "tuner" query parameter
+// of r, or responds with an HTTP error if there is no such tuner.
+func (h *Handler) lookupSocketTuner(w http.ResponseWriter, r *http.Request) (*tuner.Tuner, bool) {
+	t, err := h.lookupTuner(r.URL.Query().Get("tuner"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	return t, true
+}
+
+func (h *Handler) rpcStop(r *http.Request, params struct{ TunerID string }) (code int, body any) {
+	t, err := h.lookupTuner(params.TunerID)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	slog.Info("Stopping tuner", "client", r.RemoteAddr, "tuner", t.ID())
+	if err := t.Stop(); err != nil {
 		return http.StatusInternalServerError, err
 	}
 	return http.StatusNoContent, nil
 }
 
-func (h *Handler) rpcTune(r *http.Request, params struct{ ChannelName string }) (code int, body any) {
+type rpcTuneParams struct {
+	ChannelName string
+	// TunerID optionally selects the tuner to tune, which keeps playing until
+	// it's stopped. When it is empty, the pool allocates a tuner for the
+	// channel, which stops once no client has watched it for a minute.
+	TunerID string
+}
+
+type rpcTuneResult struct {
+	TunerID string
+}
+
+func (h *Handler) rpcTune(r *http.Request, params rpcTuneParams) (code int, body any) {
 	if params.ChannelName == "" {
 		return http.StatusBadRequest, errors.New("channel name required")
 	}
 
-	slog.Info("Tuning to channel", "client", r.RemoteAddr, "channel", params.ChannelName)
-	err := h.tuner.Tune(params.ChannelName)
+	var (
+		t   *tuner.Tuner
+		err error
+	)
+	if params.TunerID == "" {
+		slog.Info("Allocating tuner for channel", "client", r.RemoteAddr, "channel", params.ChannelName)
+		t, err = h.pool.Tune(params.ChannelName)
+	} else if t, err = h.lookupTuner(params.TunerID); err == nil {
+		slog.Info("Tuning to channel", "client", r.RemoteAddr, "tuner", t.ID(), "channel", params.ChannelName)
+		err = t.Tune(params.ChannelName)
+	}
+
 	switch {
-	case errors.Is(err, tuner.ErrChannelNotFound):
+	case errors.Is(err, tuner.ErrChannelNotFound), errors.Is(err, errTunerNotFound):
 		return http.StatusBadRequest, err // Not 404; avoid confusion with nonexistent RPC route.
+	case errors.Is(err, tuner.ErrNoTunerAvailable):
+		return http.StatusServiceUnavailable, err
+	case errors.Is(err, tuner.ErrTunerBusy):
+		return http.StatusConflict, err
 	case err != nil:
 		return http.StatusInternalServerError, err
 	}
 
+	return http.StatusOK, rpcTuneResult{TunerID: t.ID()}
+}
+
+type rpcAudioParams struct {
+	TunerID string
+	// PID selects the audio track to stream, from the AudioTracks of the
+	// tuner's status.
+	PID uint
+}
+
+// rpcAudio switches the audio track of a tuner's current channel without
+// interrupting its video.
+func (h *Handler) rpcAudio(r *http.Request, params rpcAudioParams) (code int, body any) {
+	t, err := h.lookupTuner(params.TunerID)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	slog.Info("Selecting audio track", "client", r.RemoteAddr, "tuner", t.ID(), "pid", params.PID)
+	err = t.SelectAudio(params.PID)
+	switch {
+	case errors.Is(err, tuner.ErrAudioTrackNotFound), errors.Is(err, tuner.ErrAudioTrackUnsupported):
+		return http.StatusBadRequest, err
+	case errors.Is(err, tuner.ErrTunerNotPlaying):
+		return http.StatusConflict, err
+	case err != nil:
+		return http.StatusInternalServerError, err
+	}
 	return http.StatusNoContent, nil
 }
+
+type rpcScanParams struct {
+	// TunerID selects the tuner to scan with, which must be stopped. When it is
+	// empty, the scan uses the default tuner.
+	TunerID string
+	// BandPlan names the band plan to scan, as accepted by
+	// [scan.BandPlanByName].
+	BandPlan string
+}
+
+type rpcScanResult struct {
+	// TunerID identifies the scanning tuner, whose progress and result are
+	// available from the scan socket.
+	TunerID string
+}
+
+// rpcScan starts a search for channels with a tuner, without changing the
+// channels available to tune. The scan takes several minutes for broadcast
+// channels, and over an hour for cable channels, so it continues in the
+// background after the RPC returns. Clients follow it on the scan socket, and
+// can stop it with the stop RPC.
+func (h *Handler) rpcScan(r *http.Request, params rpcScanParams) (code int, body any) {
+	t, err := h.lookupTuner(params.TunerID)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	plan, ok := scan.BandPlanByName(params.BandPlan)
+	if !ok {
+		return http.StatusBadRequest, errors.New("unknown band plan")
+	}
+
+	slog.Info("Scanning for channels", "client", r.RemoteAddr, "tuner", t.ID(), "plan", params.BandPlan)
+	err = h.scans.Start(t, plan)
+	switch {
+	case errors.Is(err, tuner.ErrTunerBusy):
+		return http.StatusConflict, err
+	case err != nil:
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusAccepted, rpcScanResult{TunerID: t.ID()}
+}
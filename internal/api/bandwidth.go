@@ -0,0 +1,222 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/featherbread/hypcast/internal/atsc/tuner"
+	"github.com/featherbread/hypcast/internal/watch"
+)
+
+// The bounds of the bandwidth estimates for each WebRTC client, in bits per
+// second. The initial estimate covers the video and audio of the default
+// pipeline, so that clients on a fast network start at full quality.
+const (
+	initialBandwidthEstimate = 10_000_000
+	minBandwidthEstimate     = 300_000
+	maxBandwidthEstimate     = 20_000_000
+)
+
+// nackBufferPackets is the number of recent packets that each stream keeps to
+// answer NACKs with, which covers a couple of seconds of the default pipeline's
+// video at its top bitrate. It must be a power of two.
+const nackBufferPackets = 2048
+
+// newWebRTCAPI creates the WebRTC API for a single peer connection, with the
+// ICE settings that every connection shares. When the connection is created,
+// the API passes its bandwidth estimator to onEstimator and its stream
+// statistics to onStats.
+//
+// The estimator implements Google Congestion Control, from the transport-wide
+// congestion control feedback that the client sends for each packet. Clients
+// that only support REMB report their own estimates through RTCP.
+//
+// The API also retransmits the packets that the client reports lost through
+// NACKs, on a separate RTX stream when the client supports one, and exchanges
+// RTCP reports with the client, from which the statistics derive the client's
+// packet loss, jitter, and round trip time.
+func newWebRTCAPI(settings webrtc.SettingEngine, onEstimator func(cc.BandwidthEstimator), onStats func(stats.Getter)) (*webrtc.API, error) {
+	var (
+		me       webrtc.MediaEngine
+		registry interceptor.Registry
+	)
+	if err := registerCodecs(&me); err != nil {
+		return nil, err
+	}
+	if err := webrtc.ConfigureTWCCHeaderExtensionSender(&me, &registry); err != nil {
+		return nil, err
+	}
+
+	congestionController, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+		return gcc.NewSendSideBWE(
+			gcc.SendSideBWEInitialBitrate(initialBandwidthEstimate),
+			gcc.SendSideBWEMinBitrate(minBandwidthEstimate),
+			gcc.SendSideBWEMaxBitrate(maxBandwidthEstimate),
+			// The encoder adapts to the estimate instead, so pacing packets
+			// would only delay them.
+			gcc.SendSideBWEPacer(gcc.NewNoOpPacer()),
+		)
+	})
+	if err != nil {
+		return nil, err
+	}
+	congestionController.OnNewPeerConnection(func(_ string, estimator cc.BandwidthEstimator) {
+		onEstimator(estimator)
+	})
+	registry.Add(congestionController)
+
+	responder, err := nack.NewResponderInterceptor(nack.ResponderSize(nackBufferPackets))
+	if err != nil {
+		return nil, err
+	}
+	registry.Add(responder)
+
+	if err := webrtc.ConfigureRTCPReports(&registry); err != nil {
+		return nil, err
+	}
+
+	statsRecorder, err := stats.NewInterceptor()
+	if err != nil {
+		return nil, err
+	}
+	statsRecorder.OnNewPeerConnection(func(_ string, getter stats.Getter) {
+		onStats(getter)
+	})
+	registry.Add(statsRecorder)
+
+	return webrtc.NewAPI(
+		webrtc.WithSettingEngine(settings),
+		webrtc.WithMediaEngine(&me),
+		webrtc.WithInterceptorRegistry(&registry),
+	), nil
+}
+
+// registerCodecs registers the tuner's codecs with me, along with the feedback
+// that clients send for congestion control and loss recovery. The video codec
+// has an RTX codec alongside it for retransmissions.
+func registerCodecs(me *webrtc.MediaEngine) error {
+	// https://tools.ietf.org/html/rfc3551#section-3
+	//
+	// "This profile reserves payload type numbers in the range 96-127 exclusively
+	// for dynamic assignment."
+	const firstDynamicPayloadType = 96
+
+	var (
+		errs        []error
+		payloadType = webrtc.PayloadType(firstDynamicPayloadType)
+	)
+	register := func(capability webrtc.RTPCodecCapability, typ webrtc.RTPCodecType, feedback ...webrtc.RTCPFeedback) webrtc.PayloadType {
+		capability.RTCPFeedback = feedback
+		errs = append(errs, me.RegisterCodec(
+			webrtc.RTPCodecParameters{PayloadType: payloadType, RTPCodecCapability: capability}, typ))
+		payloadType++
+		return payloadType - 1
+	}
+
+	var (
+		transportCC = webrtc.RTCPFeedback{Type: webrtc.TypeRTCPFBTransportCC}
+		remb        = webrtc.RTCPFeedback{Type: webrtc.TypeRTCPFBGoogREMB}
+		genericNACK = webrtc.RTCPFeedback{Type: webrtc.TypeRTCPFBNACK}
+		pli         = webrtc.RTCPFeedback{Type: webrtc.TypeRTCPFBNACK, Parameter: "pli"}
+		fir         = webrtc.RTCPFeedback{Type: webrtc.TypeRTCPFBCCM, Parameter: "fir"}
+	)
+	videoPT := register(tuner.VideoCodecCapability, webrtc.RTPCodecTypeVideo, transportCC, remb, genericNACK, pli, fir)
+	register(webrtc.RTPCodecCapability{
+		MimeType:    webrtc.MimeTypeRTX,
+		ClockRate:   tuner.VideoCodecCapability.ClockRate,
+		SDPFmtpLine: fmt.Sprintf("apt=%d", videoPT),
+	}, webrtc.RTPCodecTypeVideo)
+	register(tuner.AudioCodecCapability, webrtc.RTPCodecTypeAudio, transportCC)
+	return errors.Join(errs...)
+}
+
+// bandwidthEstimate tracks the bandwidth available to a single WebRTC client,
+// and reports it to the bitrate control of the video layer that the client
+// receives. When the client provides both transport-wide congestion control
+// feedback and REMB, the lower of the two estimates wins.
+type bandwidthEstimate struct {
+	value watch.Value[int]
+
+	mu     sync.Mutex
+	closed bool
+	gcc    int
+	remb   int
+	client *tuner.BitrateClient
+}
+
+// Get returns the latest estimate in bits per second, or 0 if the client
+// hasn't provided any feedback.
+func (be *bandwidthEstimate) Get() int {
+	return be.value.Get()
+}
+
+// Watch sets up a handler function to continuously receive the estimate. See
+// the watch package documentation for details.
+func (be *bandwidthEstimate) Watch(handler func(int)) watch.Watch {
+	return be.value.Watch(handler)
+}
+
+// SetControl starts reporting the estimate to bc, which may be nil for video
+// that the tuner doesn't encode.
+func (be *bandwidthEstimate) SetControl(bc *tuner.BitrateControl) {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	be.closeClientLocked()
+	if bc != nil && !be.closed {
+		be.client = bc.NewClient()
+		be.reportLocked()
+	}
+}
+
+// SetGCC updates the estimate from the congestion controller.
+func (be *bandwidthEstimate) SetGCC(bitrate int) {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	be.gcc = bitrate
+	be.reportLocked()
+}
+
+// SetREMB updates the estimate from a REMB packet that the client sent.
+func (be *bandwidthEstimate) SetREMB(bitrate int) {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	be.remb = bitrate
+	be.reportLocked()
+}
+
+// Close stops reporting the estimate.
+func (be *bandwidthEstimate) Close() {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	be.closed = true
+	be.closeClientLocked()
+}
+
+func (be *bandwidthEstimate) closeClientLocked() {
+	if be.client != nil {
+		be.client.Close()
+		be.client = nil
+	}
+}
+
+func (be *bandwidthEstimate) reportLocked() {
+	estimate := be.gcc
+	if be.remb > 0 && (estimate == 0 || be.remb < estimate) {
+		estimate = be.remb
+	}
+	if estimate == 0 {
+		return
+	}
+	be.value.Set(estimate)
+	if be.client != nil {
+		be.client.SetEstimate(estimate)
+	}
+}
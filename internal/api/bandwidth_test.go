@@ -0,0 +1,37 @@
+package api
+
+import "testing"
+
+func TestBandwidthEstimate(t *testing.T) {
+	testCases := []struct {
+		name      string
+		gcc, remb int
+		want      int
+	}{
+		{name: "no feedback", want: 0},
+		{name: "gcc only", gcc: 2_000_000, want: 2_000_000},
+		{name: "remb only", remb: 3_000_000, want: 3_000_000},
+		{name: "gcc lower", gcc: 2_000_000, remb: 3_000_000, want: 2_000_000},
+		{name: "remb lower", gcc: 2_000_000, remb: 1_000_000, want: 1_000_000},
+	}
+	for _, tc := range testCases {
+		var be bandwidthEstimate
+		if tc.gcc > 0 {
+			be.SetGCC(tc.gcc)
+		}
+		if tc.remb > 0 {
+			be.SetREMB(tc.remb)
+		}
+		if got := be.Get(); got != tc.want {
+			t.Errorf("%s: Get() = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+
+	// An estimate that drops to zero keeps the last one.
+	var be bandwidthEstimate
+	be.SetGCC(2_000_000)
+	be.SetGCC(0)
+	if got := be.Get(); got != 2_000_000 {
+		t.Errorf("Get() after zero estimate = %d, want %d", got, 2_000_000)
+	}
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/featherbread/hypcast/internal/atsc/caption"
+	"github.com/featherbread/hypcast/internal/watch"
+)
+
+// captionDataChannelLabel is the label of the WebRTC data channel that carries
+// closed captions to the client.
+const captionDataChannelLabel = "captions"
+
+// captionChannel sends the cues of a single caption service to a WebRTC client
+// over a data channel, and lets the client choose the service.
+//
+// The client selects a service by sending a captionSelectMsg, either by its ID
+// or by its language. The captions are off until the client selects a service.
+type captionChannel struct {
+	log *slog.Logger
+	dc  *webrtc.DataChannel
+
+	mu        sync.Mutex
+	closed    bool
+	stream    *caption.Stream
+	selection captionSelectMsg
+	service   caption.Service // As resolved from selection.
+
+	servicesWatch watch.Watch
+	cueWatch      watch.Watch
+}
+
+// captionSelectMsg is sent by the client to select a caption service. An empty
+// selection turns the captions off.
+type captionSelectMsg struct {
+	Service caption.Service
+	// Language selects the service for an ISO 639-2 language code, like "spa",
+	// when the broadcaster lists the languages of its services. Service is used
+	// when no service matches the language.
+	Language string
+}
+
+type captionServicesMsg struct {
+	Services []captionServiceMsg
+	Selected caption.Service
+}
+
+type captionServiceMsg struct {
+	Service    caption.Service
+	Language   string `json:",omitempty"`
+	EasyReader bool   `json:",omitempty"`
+}
+
+type captionCueMsg struct {
+	Cue struct {
+		Text     string
+		Settings string `json:",omitempty"`
+	}
+}
+
+func newCaptionChannel(log *slog.Logger, pc *webrtc.PeerConnection) (*captionChannel, error) {
+	dc, err := pc.CreateDataChannel(captionDataChannelLabel, nil)
+	if err != nil {
+		return nil, err
+	}
+	cc := &captionChannel{log: log, dc: dc}
+	dc.OnOpen(cc.restart)
+	dc.OnMessage(cc.handleMessage)
+	return cc, nil
+}
+
+// SetStream starts sending captions from s, or stops sending captions if s is
+// nil.
+func (cc *captionChannel) SetStream(s *caption.Stream) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.stream = s
+	cc.restartLocked()
+}
+
+// Close stops sending captions, and waits for any in-flight sends to finish.
+func (cc *captionChannel) Close() {
+	cc.mu.Lock()
+	cc.closed = true
+	watches := cc.cancelWatchesLocked()
+	cc.mu.Unlock()
+
+	for _, w := range watches {
+		w.Wait()
+	}
+}
+
+func (cc *captionChannel) restart() {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.restartLocked()
+}
+
+// restartLocked sends the current state of the captions from scratch, as when
+// the stream changes or the data channel opens.
+func (cc *captionChannel) restartLocked() {
+	cc.cancelWatchesLocked()
+	if cc.closed {
+		return
+	}
+	if cc.stream == nil {
+		cc.sendLocked(captionServicesMsg{Services: []captionServiceMsg{}, Selected: cc.selection.Service})
+		cc.sendLocked(captionCueMsg{})
+		return
+	}
+
+	stream := cc.stream
+	cc.servicesWatch = stream.WatchServices(func(services []caption.ServiceInfo) {
+		cc.handleServices(stream, services)
+	})
+}
+
+func (cc *captionChannel) cancelWatchesLocked() []watch.Watch {
+	var watches []watch.Watch
+	for _, w := range []*watch.Watch{&cc.servicesWatch, &cc.cueWatch} {
+		if *w != nil {
+			(*w).Cancel()
+			watches = append(watches, *w)
+			*w = nil
+		}
+	}
+	cc.service = ""
+	return watches
+}
+
+func (cc *captionChannel) handleServices(stream *caption.Stream, services []caption.ServiceInfo) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.stream != stream || cc.closed {
+		return
+	}
+
+	// A service for the selected language may have just appeared.
+	cc.selectServiceLocked()
+
+	msg := captionServicesMsg{
+		Services: make([]captionServiceMsg, len(services)),
+		Selected: cc.service,
+	}
+	for i, info := range services {
+		msg.Services[i] = captionServiceMsg(info)
+	}
+	cc.sendLocked(msg)
+}
+
+// selectServiceLocked starts sending the cues of the selected service, unless
+// they are already being sent.
+func (cc *captionChannel) selectServiceLocked() {
+	svc := cc.selection.Service
+	if cc.selection.Language != "" {
+		if match, ok := cc.stream.ServiceForLanguage(cc.selection.Language); ok {
+			svc = match
+		}
+	}
+	if svc == cc.service && (svc == "" || cc.cueWatch != nil) {
+		return
+	}
+
+	if cc.cueWatch != nil {
+		cc.cueWatch.Cancel()
+		cc.cueWatch = nil
+	}
+	cc.service = svc
+	if svc == "" {
+		cc.sendLocked(captionCueMsg{})
+		return
+	}
+
+	stream := cc.stream
+	cc.cueWatch = stream.WatchCues(svc, func(cue caption.Cue) {
+		cc.handleCue(stream, svc, cue)
+	})
+}
+
+func (cc *captionChannel) handleCue(stream *caption.Stream, svc caption.Service, cue caption.Cue) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.stream != stream || cc.service != svc || cc.closed {
+		return
+	}
+
+	var msg captionCueMsg
+	msg.Cue.Text, msg.Cue.Settings = cue.Text, cue.Settings
+	cc.sendLocked(msg)
+}
+
+func (cc *captionChannel) handleMessage(dcm webrtc.DataChannelMessage) {
+	var msg captionSelectMsg
+	if err := json.Unmarshal(dcm.Data, &msg); err != nil {
+		cc.log.Warn("Invalid caption selection", "error", err)
+		return
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.log.Info("Selecting captions", "service", msg.Service, "language", msg.Language)
+	cc.selection = msg
+	if cc.stream != nil && !cc.closed {
+		// Restarting resends the service list with the new selection.
+		cc.restartLocked()
+	}
+}
+
+func (cc *captionChannel) sendLocked(msg any) {
+	if cc.dc.ReadyState() != webrtc.DataChannelStateOpen {
+		return // The channel resends everything when it opens.
+	}
+	data, err := json.Marshal(msg)
+	if err == nil {
+		err = cc.dc.SendText(string(data))
+	}
+	if err != nil {
+		cc.log.Debug("Failed to send captions", "error", err)
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// ICEConfig controls how WebRTC clients reach the server, for deployments where
+// the server's own addresses aren't reachable from the clients, like bridged
+// container networks or VPNs. The zero value gathers candidates on every
+// interface with ephemeral UDP ports, and uses no STUN or TURN servers.
+type ICEConfig struct {
+	// Servers lists the STUN and TURN servers that both the server and its
+	// clients gather candidates from.
+	Servers []webrtc.ICEServer
+	// NAT1To1IPs lists public addresses that map one to one onto the server's
+	// own, which the server advertises in place of its host candidates.
+	NAT1To1IPs []string
+	// UDPPortMin and UDPPortMax limit the ephemeral UDP ports of each
+	// connection, when both are set.
+	UDPPortMin, UDPPortMax uint16
+	// UDPMuxPort serves every connection from a single UDP port, when set,
+	// in place of ephemeral ports.
+	UDPMuxPort int
+	// Interfaces limits candidate gathering to the named network interfaces,
+	// when set.
+	Interfaces []string
+	// TURNPort runs an embedded TURN server on both UDP and TCP, when set,
+	// which allocates relays on TURNRelayIP. The relays only need to be
+	// reachable from the server itself. Clients receive credentials for the
+	// TURN server along with Servers.
+	TURNPort    int
+	TURNRelayIP net.IP
+}
+
+// newSettingEngine creates the settings that every WebRTC connection shares,
+// including the UDP socket that a UDP mux listens on.
+func (c ICEConfig) newSettingEngine() (webrtc.SettingEngine, error) {
+	var se webrtc.SettingEngine
+
+	if len(c.NAT1To1IPs) > 0 {
+		err := se.SetICEAddressRewriteRules(webrtc.ICEAddressRewriteRule{
+			External:        c.NAT1To1IPs,
+			AsCandidateType: webrtc.ICECandidateTypeHost,
+		})
+		if err != nil {
+			return se, err
+		}
+	}
+
+	if len(c.Interfaces) > 0 {
+		se.SetInterfaceFilter(func(name string) bool {
+			return slices.Contains(c.Interfaces, name)
+		})
+	}
+
+	switch {
+	case c.UDPMuxPort != 0:
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: c.UDPMuxPort})
+		if err != nil {
+			return se, fmt.Errorf("listening for WebRTC on UDP port %d: %w", c.UDPMuxPort, err)
+		}
+		se.SetICEUDPMux(webrtc.NewICEUDPMux(nil, conn))
+		slog.Info("Listening for WebRTC connections", "udp", conn.LocalAddr())
+
+	case c.UDPPortMin != 0 || c.UDPPortMax != 0:
+		if err := se.SetEphemeralUDPPortRange(c.UDPPortMin, c.UDPPortMax); err != nil {
+			return se, fmt.Errorf("WebRTC UDP port range %d-%d: %w", c.UDPPortMin, c.UDPPortMax, err)
+		}
+	}
+
+	return se, nil
+}
+
+// configuration returns the configuration of a new WebRTC connection.
+func (c ICEConfig) configuration() webrtc.Configuration {
+	return webrtc.Configuration{ICEServers: c.Servers}
+}
+
+// handleConfigICEServers serves the STUN and TURN servers that clients should
+// configure their peer connections with, in the form of RTCIceServer
+// dictionaries. The embedded TURN server, if any, comes with fresh credentials
+// on every request.
+func (h *Handler) handleConfigICEServers(w http.ResponseWriter, r *http.Request) {
+	servers := append([]webrtc.ICEServer{}, h.ice.Servers...)
+	if h.turn != nil {
+		host, err := requestHost(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		server, err := h.turn.iceServer(host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		servers = append(servers, server)
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Add("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(servers)
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/featherbread/hypcast/internal/atsc/tuner"
+)
+
+// peerStatsInterval is how often each WebRTC client's statistics refresh. The
+// client's RTCP receiver reports arrive about once a second, so each refresh
+// sums up several of them.
+const peerStatsInterval = 10 * time.Second
+
+// peerStats is the representation of a WebRTC client's connection quality in
+// the peer list.
+type peerStats struct {
+	Client    string
+	Tuner     string
+	Channel   string           `json:",omitempty"`
+	Layer     tuner.VideoLayer `json:",omitempty"`
+	Bandwidth int              `json:",omitempty"`
+	Video     *streamStats     `json:",omitempty"`
+	Audio     *streamStats     `json:",omitempty"`
+}
+
+// streamStats describes the delivery of a single track to a WebRTC client,
+// from the client's RTCP reports.
+type streamStats struct {
+	PacketsSent uint64
+	PacketsLost int64
+	// FractionLost is the fraction of packets lost since the client's last
+	// report, from 0 to 1.
+	FractionLost float64
+	JitterMs     float64
+	RoundTripMs  float64
+	NACKs        uint32
+	PLIs         uint32 `json:",omitempty"`
+	FIRs         uint32 `json:",omitempty"`
+}
+
+func newStreamStats(s *stats.Stats) *streamStats {
+	return &streamStats{
+		PacketsSent:  s.OutboundRTPStreamStats.PacketsSent,
+		PacketsLost:  s.RemoteInboundRTPStreamStats.PacketsLost,
+		FractionLost: s.RemoteInboundRTPStreamStats.FractionLost,
+		JitterMs:     s.RemoteInboundRTPStreamStats.Jitter * 1000,
+		RoundTripMs:  float64(s.RemoteInboundRTPStreamStats.RoundTripTime) / float64(time.Millisecond),
+		NACKs:        s.OutboundRTPStreamStats.NACKCount,
+		PLIs:         s.OutboundRTPStreamStats.PLICount,
+		FIRs:         s.OutboundRTPStreamStats.FIRCount,
+	}
+}
+
+// peerRegistry tracks the WebRTC clients connected to the API.
+type peerRegistry struct {
+	mu    sync.Mutex
+	peers map[*WebRTCHandler]struct{}
+}
+
+func (pr *peerRegistry) Add(wh *WebRTCHandler) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if pr.peers == nil {
+		pr.peers = make(map[*WebRTCHandler]struct{})
+	}
+	pr.peers[wh] = struct{}{}
+}
+
+func (pr *peerRegistry) Remove(wh *WebRTCHandler) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	delete(pr.peers, wh)
+}
+
+// Stats returns the latest statistics of every client, ordered by tuner and
+// client address.
+func (pr *peerRegistry) Stats() []peerStats {
+	pr.mu.Lock()
+	list := make([]peerStats, 0, len(pr.peers))
+	for wh := range pr.peers {
+		list = append(list, wh.stats.Get())
+	}
+	pr.mu.Unlock()
+
+	slices.SortFunc(list, func(a, b peerStats) int {
+		return cmp.Or(cmp.Compare(a.Tuner, b.Tuner), cmp.Compare(a.Client, b.Client))
+	})
+	return list
+}
+
+// handlePeers serves the latest statistics of every connected WebRTC client,
+// for diagnosing their connections.
+func (h *Handler) handlePeers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.peers.Stats())
+}
+
+// reportStats refreshes the client's statistics until the handler shuts down.
+func (wh *WebRTCHandler) reportStats() {
+	ticker := time.NewTicker(peerStatsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-wh.ctx.Done():
+			return
+		case <-ticker.C:
+			wh.updateStats()
+		}
+	}
+}
+
+// updateStats collects the client's latest statistics, logs them, and reports
+// the client's packet loss to the tuner's audio encoder.
+func (wh *WebRTCHandler) updateStats() {
+	wh.mu.Lock()
+	ps := wh.stats.Get()
+	ps.Layer = wh.layer
+	ps.Bandwidth = wh.bandwidth.Get()
+	ps.Video = wh.senderStats(wh.videoSender)
+	ps.Audio = wh.senderStats(wh.audioSender)
+	loss := wh.lossClient
+	wh.mu.Unlock()
+
+	wh.stats.Set(ps)
+	if ps.Video == nil && ps.Audio == nil {
+		return
+	}
+
+	args := []any{"layer", ps.Layer, "bandwidth", ps.Bandwidth}
+	if ps.Video != nil {
+		args = append(args, "video", *ps.Video)
+	}
+	if ps.Audio != nil {
+		args = append(args, "audio", *ps.Audio)
+	}
+	wh.log.Info("WebRTC stats", args...)
+
+	// The video and audio share the client's network path, so the worse of
+	// their reports stands for both. The audio's few packets alone would make
+	// a noisy measure.
+	if loss != nil {
+		var fraction float64
+		for _, s := range []*streamStats{ps.Video, ps.Audio} {
+			if s != nil {
+				fraction = max(fraction, s.FractionLost)
+			}
+		}
+		loss.SetLoss(fraction)
+	}
+}
+
+// senderStats returns the statistics of the track that sender carries, or nil
+// if there are none.
+func (wh *WebRTCHandler) senderStats(sender *webrtc.RTPSender) *streamStats {
+	if sender == nil || wh.streamStats == nil {
+		return nil
+	}
+	encodings := sender.GetParameters().Encodings
+	if len(encodings) == 0 {
+		return nil
+	}
+	s := wh.streamStats.Get(uint32(encodings[0].SSRC))
+	if s == nil {
+		return nil
+	}
+	return newStreamStats(s)
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+// readRTCP reads RTCP packets from sender until the sender stops, and handles
+// the client's feedback about the sender's track. Reading the packets also
+// feeds the interceptors, like the congestion controller.
+func (wh *WebRTCHandler) readRTCP(sender *webrtc.RTPSender) {
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := sender.Read(buf)
+		if err != nil {
+			return
+		}
+		packets, err := rtcp.Unmarshal(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, p := range packets {
+			switch p := p.(type) {
+			case *rtcp.ReceiverEstimatedMaximumBitrate:
+				wh.bandwidth.SetREMB(int(p.Bitrate))
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				wh.requestKeyframe(sender)
+			}
+		}
+	}
+}
+
+// requestKeyframe forces a keyframe from the encoder of the video layer that
+// sender carries, so that the client can recover from a lost picture.
+func (wh *WebRTCHandler) requestKeyframe(sender *webrtc.RTPSender) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	if sender != wh.videoSender {
+		return
+	}
+	wh.videoTrack().RequestKeyframe()
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+
+	"github.com/featherbread/hypcast/internal/atsc"
+	"github.com/featherbread/hypcast/internal/atsc/scan"
+	"github.com/featherbread/hypcast/internal/atsc/tuner"
+	"github.com/featherbread/hypcast/internal/watch"
+)
+
+// scanMsg reports the progress of a channel scan to clients of the scan
+// socket.
+type scanMsg struct {
+	// Finished is set in the last message for a scan, which carries its result
+	// in ChannelsConf and Error.
+	Finished bool
+	// Done counts the frequencies of the band plan that the scan has searched,
+	// out of Total, and Channels counts the channels that it has found.
+	Done     int
+	Total    int
+	Channels int
+	// ChannelsConf lists the channels that the scan found in channels.conf
+	// format. A scan that ends with an error may still find some channels.
+	ChannelsConf string `json:",omitempty"`
+	Error        string `json:",omitempty"`
+}
+
+// scanJobs tracks the progress of the latest channel scan on each tuner, which
+// runs in the background after the scan RPC returns.
+type scanJobs struct {
+	mu   sync.Mutex
+	jobs map[*tuner.Tuner]*watch.Value[scanMsg]
+}
+
+// Start starts scanning plan with t, unless t is busy.
+func (sj *scanJobs) Start(t *tuner.Tuner, plan scan.BandPlan) error {
+	sj.mu.Lock()
+	defer sj.mu.Unlock()
+
+	// Nobody can cancel the scan along with a request. It runs until it
+	// finishes or the tuner stops.
+	progress := watch.NewValue(scanMsg{Total: len(plan)})
+	err := t.StartScan(context.Background(), plan,
+		func(p scan.Progress) {
+			progress.Set(scanMsg{Done: p.Done, Total: p.Total, Channels: p.Channels})
+		},
+		func(channels []atsc.Channel, err error) {
+			progress.Set(newFinishedScanMsg(progress.Get(), channels, err))
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if sj.jobs == nil {
+		sj.jobs = make(map[*tuner.Tuner]*watch.Value[scanMsg])
+	}
+	sj.jobs[t] = progress
+	return nil
+}
+
+// Get returns the progress of the latest scan on t, if t has ever scanned.
+func (sj *scanJobs) Get(t *tuner.Tuner) (*watch.Value[scanMsg], bool) {
+	sj.mu.Lock()
+	defer sj.mu.Unlock()
+	progress, ok := sj.jobs[t]
+	return progress, ok
+}
+
+func newFinishedScanMsg(last scanMsg, channels []atsc.Channel, err error) scanMsg {
+	msg := scanMsg{
+		Finished: true,
+		Done:     last.Done,
+		Total:    last.Total,
+		Channels: len(channels),
+	}
+	var conf strings.Builder
+	if writeErr := atsc.WriteChannelsConf(&conf, channels); writeErr != nil {
+		err = errors.Join(err, writeErr)
+	} else {
+		msg.ChannelsConf = conf.String()
+	}
+	if err != nil {
+		msg.Error = err.Error()
+	}
+	return msg
+}
+
+// errScanFinished ends a scan socket after it sends the result of the scan.
+var errScanFinished = errors.New("scan finished")
+
+// handleSocketScan reports the progress of the latest scan on the tuner
+// selected by the "tuner" query parameter, and closes the socket after
+// sending the result of the scan. Clients that connect after the scan
+// finishes receive its result immediately.
+func (h *Handler) handleSocketScan(w http.ResponseWriter, r *http.Request) {
+	t, ok := h.lookupSocketTuner(w, r)
+	if !ok {
+		return
+	}
+	progress, ok := h.scans.Get(t)
+	if !ok {
+		http.Error(w, "tuner has not scanned for channels", http.StatusNotFound)
+		return
+	}
+
+	log := slog.With("client", r.RemoteAddr, "tuner", t.ID())
+	socket, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	log.Info("Connected scan socket")
+
+	ctx, shutdown := context.WithCancelCause(socket.CloseRead(r.Context()))
+	defer shutdown(nil)
+
+	// The watch runs one handler at a time, and always delivers the latest
+	// message, so the client can't miss the result.
+	progressWatch := progress.Watch(func(msg scanMsg) {
+		if err := wsjson.Write(ctx, socket, msg); err != nil {
+			shutdown(err)
+			return
+		}
+		if msg.Finished {
+			shutdown(errScanFinished)
+		}
+	})
+	<-ctx.Done()
+	progressWatch.Cancel()
+	progressWatch.Wait()
+
+	if cause := context.Cause(ctx); errors.Is(cause, errScanFinished) {
+		socket.Close(websocket.StatusNormalClosure, cause.Error())
+	} else {
+		socket.Close(websocket.StatusGoingAway, "server is shutting down")
+	}
+	log.Info("Disconnected scan socket", "error", context.Cause(ctx))
+}
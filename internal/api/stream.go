@@ -0,0 +1,98 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/featherbread/hypcast/internal/atsc/tuner"
+)
+
+// handleStream serves a channel as an MPEG transport stream, for players like
+// VLC and media servers that open a URL rather than speak WebRTC. The path
+// names the channel as "CHANNEL.ts", with the channel's name or virtual
+// channel number.
+//
+// The "format" query parameter selects a tuner.StreamFormat, and defaults to
+// the raw program. The "tuner" query parameter optionally selects the tuner to
+// stream from. Without it, the pool allocates a tuner for the channel. Every
+// client of a channel on the same tuner shares the tuning, and a tuner that
+// was stopped before its first client arrived stops again after its last
+// client leaves.
+func (h *Handler) handleStream(w http.ResponseWriter, r *http.Request) {
+	channelName, ok := strings.CutSuffix(r.PathValue("file"), ".ts")
+	if !ok || channelName == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	format := tuner.StreamFormat(cmp.Or(query.Get("format"), string(tuner.StreamFormatRaw)))
+
+	var (
+		stream *tuner.Stream
+		err    error
+	)
+	if id := query.Get("tuner"); id == "" {
+		slog.Info("Allocating tuner for stream", "client", r.RemoteAddr, "channel", channelName, "format", format)
+		stream, err = h.pool.OpenStream(channelName, format)
+	} else if t, ok := h.pool.Get(id); !ok {
+		err = errTunerNotFound
+	} else {
+		slog.Info("Opening stream", "client", r.RemoteAddr, "tuner", t.ID(), "channel", channelName, "format", format)
+		stream, err = t.OpenStream(channelName, format)
+	}
+
+	switch {
+	case errors.Is(err, tuner.ErrChannelNotFound), errors.Is(err, errTunerNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, tuner.ErrStreamFormatUnknown):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, tuner.ErrNoTunerAvailable):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, tuner.ErrChannelNotOnMultiplex), errors.Is(err, tuner.ErrTunerBusy):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log := slog.With("client", r.RemoteAddr, "tuner", stream.Tuner().ID(), "channel", channelName, "format", format)
+	log.Info("Starting stream")
+
+	// Closing the stream unblocks any read waiting on the tuner once the client
+	// goes away.
+	stop := context.AfterFunc(r.Context(), func() { stream.Close() })
+	defer stop()
+	defer stream.Close()
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	http.NewResponseController(w).Flush()
+
+	_, err = io.Copy(flushWriter{w}, stream)
+	log.Info("Ended stream", "error", err)
+}
+
+// flushWriter flushes each write through to the client, so that a live
+// stream doesn't sit in the response buffer.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, http.NewResponseController(fw.w).Flush()
+}
@@ -4,10 +4,15 @@ import (
 	"context"
 	"log/slog"
 	"net/http"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/coder/websocket"
 	"github.com/coder/websocket/wsjson"
 
+	"github.com/featherbread/hypcast/internal/atsc/guide"
+	"github.com/featherbread/hypcast/internal/atsc/mpegts"
 	"github.com/featherbread/hypcast/internal/atsc/tuner"
 	"github.com/featherbread/hypcast/internal/watch"
 )
@@ -21,13 +26,28 @@ type TunerStatusHandler struct {
 	socket *websocket.Conn
 
 	statusWatch watch.Watch
+	guideWatch  watch.Watch
+	signalWatch watch.Watch
+
+	// mu serializes the sending of status messages, which may be triggered by
+	// changes to the tuner's status, the program guide, or the signal, or by
+	// the end of the program that is airing.
+	mu           sync.Mutex
+	lastMsg      tunerStatusMsg
+	sentAny      bool
+	guideRefresh *time.Timer
 }
 
 func (h *Handler) handleSocketTunerStatus(w http.ResponseWriter, r *http.Request) {
+	t, ok := h.lookupSocketTuner(w, r)
+	if !ok {
+		return
+	}
+
 	ctx, shutdown := context.WithCancelCause(r.Context())
 	tsh := &TunerStatusHandler{
-		log:      slog.With("client", r.RemoteAddr),
-		tuner:    h.tuner,
+		log:      slog.With("client", r.RemoteAddr, "tuner", t.ID()),
+		tuner:    t,
 		ctx:      ctx,
 		shutdown: shutdown,
 	}
@@ -40,6 +60,17 @@ func (tsh *TunerStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		if tsh.statusWatch != nil {
 			tsh.statusWatch.Wait()
 		}
+		if tsh.guideWatch != nil {
+			tsh.guideWatch.Wait()
+		}
+		if tsh.signalWatch != nil {
+			tsh.signalWatch.Wait()
+		}
+		tsh.mu.Lock()
+		if tsh.guideRefresh != nil {
+			tsh.guideRefresh.Stop()
+		}
+		tsh.mu.Unlock()
 		tsh.log.Info("Disconnected tuner status socket", "error", context.Cause(tsh.ctx))
 	}()
 
@@ -53,18 +84,64 @@ func (tsh *TunerStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 
 	tsh.ctx = tsh.socket.CloseRead(tsh.ctx)
 
-	tsh.statusWatch = tsh.tuner.WatchStatus(tsh.sendNewTunerStatus)
+	tsh.statusWatch = tsh.tuner.WatchStatus(func(tuner.Status) { tsh.sendTunerStatus() })
 	defer tsh.statusWatch.Cancel()
 
+	tsh.guideWatch = tsh.tuner.Guide().Watch(tsh.sendTunerStatus)
+	defer tsh.guideWatch.Cancel()
+
+	tsh.signalWatch = tsh.tuner.WatchSignal(func(tuner.Signal) { tsh.sendTunerStatus() })
+	defer tsh.signalWatch.Cancel()
+
 	<-tsh.ctx.Done()
 }
 
-func (tsh *TunerStatusHandler) sendNewTunerStatus(s tuner.Status) {
-	tsh.logTunerStatus(s)
+// sendTunerStatus sends the tuner's current status to the client, unless it
+// matches the last status that the client received. Only changes to more than
+// the signal statistics are logged, as the statistics change constantly.
+func (tsh *TunerStatusHandler) sendTunerStatus() {
+	tsh.mu.Lock()
+	defer tsh.mu.Unlock()
+
+	if tsh.ctx.Err() != nil {
+		return
+	}
+
+	s := tsh.tuner.Status()
 	msg := tsh.mapTunerStatusToMessage(s)
+	tsh.scheduleGuideRefresh(msg)
+	if tsh.sentAny && msg.equal(tsh.lastMsg) {
+		return
+	}
+
+	if !tsh.sentAny || !msg.withoutSignal().equal(tsh.lastMsg.withoutSignal()) {
+		tsh.logTunerStatus(s)
+	}
 	if err := wsjson.Write(tsh.ctx, tsh.socket, msg); err != nil {
 		tsh.shutdown(err)
+		return
+	}
+	tsh.lastMsg, tsh.sentAny = msg, true
+}
+
+// scheduleGuideRefresh arranges to send the tuner's status again when the
+// program guide information in msg goes out of date.
+func (tsh *TunerStatusHandler) scheduleGuideRefresh(msg tunerStatusMsg) {
+	if tsh.guideRefresh != nil {
+		tsh.guideRefresh.Stop()
+		tsh.guideRefresh = nil
+	}
+
+	var at time.Time
+	switch {
+	case !msg.Now.End.IsZero():
+		at = msg.Now.End
+	case !msg.Next.Start.IsZero():
+		at = msg.Next.Start
+	default:
+		return
 	}
+	tsh.guideRefresh = time.AfterFunc(time.Until(at), tsh.sendTunerStatus)
 }
 
 func (tsh *TunerStatusHandler) logTunerStatus(s tuner.Status) {
@@ -82,12 +159,95 @@ type tunerStatusMsg struct {
 	State       string
 	ChannelName string `json:",omitempty"`
 	Error       string `json:",omitempty"`
+	// Now and Next describe the programs airing on the channel, if the program
+	// guide has them.
+	Now  statusEventMsg `json:",omitzero"`
+	Next statusEventMsg `json:",omitzero"`
+	// AudioTracks lists the audio tracks that clients may select with the
+	// audio RPC, and AudioPID identifies the track that the tuner streams.
+	AudioTracks []statusAudioTrackMsg `json:",omitempty"`
+	AudioPID    uint                  `json:",omitempty"`
+	// Signal holds the latest statistics from the tuner's DVB frontend, while
+	// it receives a live signal.
+	Signal *signalMsg `json:",omitempty"`
+}
+
+func (m tunerStatusMsg) equal(other tunerStatusMsg) bool {
+	return m.State == other.State &&
+		m.ChannelName == other.ChannelName &&
+		m.Error == other.Error &&
+		m.Now == other.Now &&
+		m.Next == other.Next &&
+		slices.Equal(m.AudioTracks, other.AudioTracks) &&
+		m.AudioPID == other.AudioPID &&
+		(m.Signal == nil) == (other.Signal == nil) &&
+		(m.Signal == nil || *m.Signal == *other.Signal)
+}
+
+func (m tunerStatusMsg) withoutSignal() tunerStatusMsg {
+	m.Signal = nil
+	return m
+}
+
+type signalMsg struct {
+	Time     time.Time
+	Locked   bool
+	Strength int
+	SNR      int
+	BER      int
+	UNC      int
+}
+
+func newSignalMsg(s tuner.Signal) *signalMsg {
+	if s.Time.IsZero() {
+		return nil
+	}
+	msg := signalMsg(s)
+	return &msg
+}
+
+type statusAudioTrackMsg struct {
+	PID      uint
+	Codec    string
+	Language string `json:",omitempty"`
+	// Type is set for special purpose tracks, as with "VisualImpaired" for
+	// descriptive audio.
+	Type string `json:",omitempty"`
+}
+
+var audioTypeStrings = map[mpegts.AudioType]string{
+	mpegts.AudioTypeCleanEffects:    "CleanEffects",
+	mpegts.AudioTypeHearingImpaired: "HearingImpaired",
+	mpegts.AudioTypeVisualImpaired:  "VisualImpaired",
+}
+
+func newStatusAudioTrackMsg(at tuner.AudioTrack) statusAudioTrackMsg {
+	return statusAudioTrackMsg{
+		PID:      at.PID,
+		Codec:    at.StreamType.String(),
+		Language: at.Language,
+		Type:     audioTypeStrings[at.Type],
+	}
+}
+
+type statusEventMsg struct {
+	Title string
+	Start time.Time
+	End   time.Time
+}
+
+func newStatusEventMsg(e *guide.Event) statusEventMsg {
+	if e == nil {
+		return statusEventMsg{}
+	}
+	return statusEventMsg{Title: e.Title, Start: e.Start, End: e.End()}
 }
 
 var tunerStateStrings = map[tuner.State]string{
 	tuner.StateStopped:  "Stopped",
 	tuner.StateStarting: "Starting",
 	tuner.StatePlaying:  "Playing",
+	tuner.StateScanning: "Scanning",
 }
 
 func (tsh *TunerStatusHandler) mapTunerStatusToMessage(s tuner.Status) tunerStatusMsg {
@@ -98,5 +258,14 @@ func (tsh *TunerStatusHandler) mapTunerStatusToMessage(s tuner.Status) tunerStat
 	if s.Error != nil {
 		msg.Error = s.Error.Error()
 	}
+	for _, at := range s.AudioTracks {
+		msg.AudioTracks = append(msg.AudioTracks, newStatusAudioTrackMsg(at))
+	}
+	msg.AudioPID = s.AudioPID
+	msg.Signal = newSignalMsg(tsh.tuner.Signal())
+	if channel, ok := tsh.tuner.Channel(s.ChannelName); ok && s.State != tuner.StateStopped {
+		now, next := tsh.tuner.Guide().NowNext(channel, time.Now())
+		msg.Now, msg.Next = newStatusEventMsg(now), newStatusEventMsg(next)
+	}
 	return msg
 }
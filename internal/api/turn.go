@@ -0,0 +1,161 @@
+package api
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/turn/v5"
+	"github.com/pion/webrtc/v4"
+)
+
+// turnCredentialLifetime is how long the TURN credentials that a client
+// receives remain valid. Anyone who copies a client's credentials, which any
+// visitor to the UI can fetch, can relay through Hypcast until they expire. A
+// browser refreshes its relay allocation with the credentials it started with,
+// so a relayed connection that outlives them fails, and the client restarts
+// ICE with new ones.
+const turnCredentialLifetime = 10 * time.Minute
+
+// turnRealm is the realm that the embedded TURN server authenticates clients
+// in.
+const turnRealm = "hypcast"
+
+// turnServer is a TURN relay that runs inside Hypcast, for clients whose
+// networks block the UDP ports that WebRTC connections otherwise use. Each
+// client receives time-limited credentials derived from a secret that only the
+// server knows, following the TURN REST API scheme.
+//
+// The relay only forwards traffic to the addresses that Hypcast offers to
+// clients, so that the credentials it hands out can't open a path to anything
+// else on its network, including services that only listen on loopback.
+type turnServer struct {
+	server *turn.Server
+	port   int
+	secret string
+}
+
+// startTURNServer starts the TURN server that c configures.
+func startTURNServer(c ICEConfig) (ts *turnServer, err error) {
+	port, relayIP := c.TURNPort, c.TURNRelayIP
+	ts = &turnServer{port: port, secret: rand.Text()}
+	permit := permitOwnAddresses(c)
+
+	addr := net.JoinHostPort("", strconv.Itoa(port))
+	udpConn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listening for TURN on UDP port %d: %w", port, err)
+	}
+	tcpListener, err := net.Listen("tcp", addr)
+	if err != nil {
+		udpConn.Close()
+		return nil, fmt.Errorf("listening for TURN on TCP port %d: %w", port, err)
+	}
+
+	relay := func() turn.RelayAddressGenerator {
+		return &turn.RelayAddressGeneratorStatic{RelayAddress: relayIP, Address: "0.0.0.0"}
+	}
+	ts.server, err = turn.NewServer(turn.ServerConfig{
+		Realm:       turnRealm,
+		AuthHandler: turn.LongTermTURNRESTAuthHandler(ts.secret, nil),
+		PacketConnConfigs: []turn.PacketConnConfig{{
+			PacketConn:            udpConn,
+			RelayAddressGenerator: relay(),
+			PermissionHandler:     permit,
+		}},
+		ListenerConfigs: []turn.ListenerConfig{{
+			Listener:              tcpListener,
+			RelayAddressGenerator: relay(),
+			PermissionHandler:     permit,
+		}},
+	})
+	if err != nil {
+		udpConn.Close()
+		tcpListener.Close()
+		return nil, err
+	}
+
+	slog.Info("Started TURN server", "port", port, "relay", relayIP)
+	return ts, nil
+}
+
+// permitOwnAddresses returns a TURN permission handler that lets a relay reach
+// the NAT 1:1 addresses in c, and the addresses of the interfaces that c
+// gathers candidates on. It never permits loopback, link-local, or unspecified
+// addresses, even when an interface or a NAT 1:1 address would.
+func permitOwnAddresses(c ICEConfig) turn.PermissionHandler {
+	return func(_ net.Addr, peerIP net.IP) bool {
+		if peerIP.IsLoopback() || peerIP.IsLinkLocalUnicast() || peerIP.IsUnspecified() {
+			return false
+		}
+		for _, ip := range c.NAT1To1IPs {
+			if net.ParseIP(ip).Equal(peerIP) {
+				return true
+			}
+		}
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return false
+		}
+		for _, iface := range ifaces {
+			if len(c.Interfaces) > 0 && !slices.Contains(c.Interfaces, iface.Name) {
+				continue
+			}
+			addrs, err := iface.Addrs()
+			if err != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(peerIP) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// Close stops the TURN server by closing its listeners, which ends every relay
+// allocation.
+func (ts *turnServer) Close() error {
+	return ts.server.Close()
+}
+
+// iceServer returns the entry for the TURN server in a client's ICE
+// configuration, with new credentials. host is the name or address that the
+// client reached Hypcast at, which should reach the TURN server too.
+func (ts *turnServer) iceServer(host string) (webrtc.ICEServer, error) {
+	username, password, err := turn.GenerateLongTermTURNRESTCredentials(ts.secret, "hypcast", turnCredentialLifetime)
+	if err != nil {
+		return webrtc.ICEServer{}, err
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(ts.port))
+	return webrtc.ICEServer{
+		URLs: []string{
+			"turn:" + addr + "?transport=udp",
+			"turn:" + addr + "?transport=tcp",
+		},
+		Username:   username,
+		Credential: password,
+	}, nil
+}
+
+// requestHost returns the host name or address that r was sent to, without
+// any port.
+func requestHost(r *http.Request) (string, error) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(r.Host, "["), "]")
+	}
+	if host == "" {
+		return "", errors.New("request has no host")
+	}
+	return host, nil
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPermitOwnAddresses(t *testing.T) {
+	permit := permitOwnAddresses(ICEConfig{
+		NAT1To1IPs: []string{"203.0.113.7", "127.0.0.1", "169.254.1.1", "0.0.0.0"},
+		Interfaces: []string{"lo"},
+	})
+	testCases := []struct {
+		ip   string
+		want bool
+	}{
+		{"203.0.113.7", true},
+		{"198.51.100.1", false},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"169.254.1.1", false},
+		{"fe80::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+	}
+	for _, tc := range testCases {
+		if got := permit(nil, net.ParseIP(tc.ip)); got != tc.want {
+			t.Errorf("permit(%s) = %v, want %v", tc.ip, got, tc.want)
+		}
+	}
+}
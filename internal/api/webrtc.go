@@ -9,62 +9,142 @@ import (
 
 	"github.com/coder/websocket"
 	"github.com/coder/websocket/wsjson"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/stats"
 	"github.com/pion/webrtc/v4"
 
 	"github.com/featherbread/hypcast/internal/atsc/tuner"
 	"github.com/featherbread/hypcast/internal/watch"
 )
 
-var webrtcAPI *webrtc.API
-
-func init() {
-	// https://tools.ietf.org/html/rfc3551#section-3
-	//
-	// "This profile reserves payload type numbers in the range 96-127 exclusively
-	// for dynamic assignment."
-	const (
-		videoPayloadType = 96 + iota
-		audioPayloadType
-	)
-
-	var me webrtc.MediaEngine
-	err := errors.Join(
-		me.RegisterCodec(
-			webrtc.RTPCodecParameters{PayloadType: videoPayloadType, RTPCodecCapability: tuner.VideoCodecCapability},
-			webrtc.RTPCodecTypeVideo),
-		me.RegisterCodec(
-			webrtc.RTPCodecParameters{PayloadType: audioPayloadType, RTPCodecCapability: tuner.AudioCodecCapability},
-			webrtc.RTPCodecTypeAudio),
-	)
-	if err != nil {
-		panic(err)
-	}
-
-	webrtcAPI = webrtc.NewAPI(webrtc.WithMediaEngine(&me))
+// trackSource provides the tracks that a WebRTCHandler streams to its client.
+// It is implemented by tuners, and by viewers of channels that a tuner streams
+// alongside its current channel.
+type trackSource interface {
+	WatchTracks(handler func(tuner.Tracks)) watch.Watch
 }
 
+// Clients choose a version of the WebRTC socket protocol by websocket
+// subprotocol. In version 1, which clients get by requesting no subprotocol,
+// each offer that the server sends carries every one of its ICE candidates. In
+// version 2, both sides trickle their candidates in separate Candidate messages
+// as they gather them, and send an EndOfCandidates message once they're done.
+const webrtcSocketProtocolV2 = "hypcast.webrtc.v2"
+
 type WebRTCHandler struct {
 	log      *slog.Logger
-	tuner    *tuner.Tuner
+	tracks   trackSource
 	ctx      context.Context
 	shutdown context.CancelCauseFunc
 
-	socket  *websocket.Conn
-	rtcPeer *webrtc.PeerConnection
+	iceSettings webrtc.SettingEngine
+	iceConfig   webrtc.Configuration
+
+	socket   *websocket.Conn
+	rtcPeer  *webrtc.PeerConnection
+	captions *captionChannel
+
+	// signalMu orders the candidates that the server trickles to the client
+	// after the offer that starts their gathering.
+	signalMu sync.Mutex
+	trickle  bool
+
+	bandwidth      bandwidthEstimate
+	bandwidthWatch watch.Watch
+	rtcpReaders    sync.WaitGroup
+
+	// stats holds the latest statistics of the connection, which streamStats
+	// provides for each of the senders.
+	stats         watch.Value[peerStats]
+	streamStats   stats.Getter
+	statsReporter sync.WaitGroup
+
+	// mu protects the choice of the video layer that the client receives,
+	// which follows the tuner's tracks, the quality that the client requests,
+	// and the client's bandwidth. An empty quality selects a layer
+	// automatically. It also protects the client's share in tuning the audio
+	// for packet loss.
+	mu          sync.Mutex
+	current     tuner.Tracks
+	videoSender *webrtc.RTPSender
+	audioSender *webrtc.RTPSender
+	layer       tuner.VideoLayer
+	quality     tuner.VideoLayer
+	lossClient  *tuner.LossClient
 
 	trackWatch   watch.Watch
 	clientReader sync.WaitGroup
 }
 
 func (h *Handler) handleSocketWebRTCPeer(w http.ResponseWriter, r *http.Request) {
-	ctx, shutdown := context.WithCancelCause(r.Context())
-	wh := &WebRTCHandler{
-		log:      slog.With("client", r.RemoteAddr),
-		tuner:    h.tuner,
+	wh, release, ok := h.newWebRTCHandler(r.Context(), w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	h.peers.Add(wh)
+	defer h.peers.Remove(wh)
+	wh.ServeHTTP(w, r)
+}
+
+// newWebRTCHandler creates a handler for the client of r, which streams the
+// tracks of the tuner and channel that r's query selects until ctx ends. The
+// caller must call release once the handler is done. If r selects no valid
+// tuner or channel, newWebRTCHandler responds with an HTTP error and returns
+// false.
+func (h *Handler) newWebRTCHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) (wh *WebRTCHandler, release func(), ok bool) {
+	t, ok := h.lookupSocketTuner(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	closeViewer := func() {}
+
+	log := slog.With("client", r.RemoteAddr, "tuner", t.ID())
+	var tracks trackSource = t
+	info := peerStats{Client: r.RemoteAddr, Tuner: t.ID()}
+
+	// A client may watch a different channel from the tuner's current one, as
+	// long as the tuner can receive both from the same multiplex.
+	if channelName := r.URL.Query().Get("channel"); channelName != "" {
+		viewer, err := t.AddViewer(channelName)
+		switch {
+		case errors.Is(err, tuner.ErrChannelNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return nil, nil, false
+		case errors.Is(err, tuner.ErrChannelNotOnMultiplex):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return nil, nil, false
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return nil, nil, false
+		}
+		closeViewer = viewer.Close
+		log = log.With("channel", viewer.ChannelName())
+		tracks = viewer
+		info.Channel = viewer.ChannelName()
+	}
+
+	// The hold keeps the tuner from stopping as idle while the client watches.
+	unhold := t.Hold()
+	release = func() {
+		closeViewer()
+		unhold()
+	}
+
+	ctx, shutdown := context.WithCancelCause(ctx)
+	wh = &WebRTCHandler{
+		log:      log,
+		tracks:   tracks,
 		ctx:      ctx,
 		shutdown: shutdown,
+		quality:  tuner.VideoLayer(r.URL.Query().Get("quality")),
+
+		iceSettings: h.iceSettings,
+		iceConfig:   h.ice.configuration(),
 	}
-	wh.ServeHTTP(w, r)
+	wh.stats.Set(info)
+	return wh, release, true
 }
 
 func (wh *WebRTCHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -73,101 +153,355 @@ func (wh *WebRTCHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if wh.trackWatch != nil {
 			wh.trackWatch.Wait()
 		}
+		if wh.bandwidthWatch != nil {
+			wh.bandwidthWatch.Wait()
+		}
 		wh.clientReader.Wait()
+		wh.rtcpReaders.Wait()
+		wh.statsReporter.Wait()
+		wh.closeLossClient()
 		wh.log.Info("Disconnected WebRTC socket", "error", context.Cause(wh.ctx))
 	}()
 
-	if socket, err := websocket.Accept(w, r, nil); err == nil {
+	opts := &websocket.AcceptOptions{Subprotocols: []string{webrtcSocketProtocolV2}}
+	if socket, err := websocket.Accept(w, r, opts); err == nil {
 		wh.socket = socket
+		wh.trickle = socket.Subprotocol() == webrtcSocketProtocolV2
 	} else {
 		return
 	}
 
 	defer wh.socket.Close(websocket.StatusGoingAway, "server is shutting down")
 
-	if rtcPeer, err := webrtcAPI.NewPeerConnection(webrtc.Configuration{}); err == nil {
-		wh.rtcPeer = rtcPeer
-	} else {
+	defer wh.bandwidth.Close()
+
+	if err := wh.createPeer(); err != nil {
+		wh.shutdown(err)
 		return
 	}
 
 	defer wh.rtcPeer.Close()
 
-	wh.clientReader.Go(wh.readClientSessionDescriptions)
+	if wh.trickle {
+		wh.rtcPeer.OnICECandidate(wh.sendICECandidate)
+	}
 
-	wh.trackWatch = wh.tuner.WatchTracks(wh.handleTrackUpdate)
+	// The data channel has to exist before the first offer, so that the client
+	// can receive captions without renegotiating the session.
+	if captions, err := newCaptionChannel(wh.log, wh.rtcPeer); err == nil {
+		wh.captions = captions
+	} else {
+		wh.shutdown(err)
+		return
+	}
+
+	defer wh.captions.Close()
+
+	wh.clientReader.Go(wh.readClientMessages)
+
+	wh.trackWatch = wh.tracks.WatchTracks(wh.handleTrackUpdate)
 	defer wh.trackWatch.Cancel()
 
+	wh.bandwidthWatch = wh.bandwidth.Watch(func(int) { wh.updateVideoLayer() })
+	defer wh.bandwidthWatch.Cancel()
+
+	wh.statsReporter.Go(wh.reportStats)
+
 	<-wh.ctx.Done()
 }
 
-func (wh *WebRTCHandler) readClientSessionDescriptions() {
+// createPeer creates the peer connection to the client.
+func (wh *WebRTCHandler) createPeer() error {
+	api, err := newWebRTCAPI(
+		wh.iceSettings,
+		func(estimator cc.BandwidthEstimator) {
+			estimator.OnTargetBitrateChange(wh.bandwidth.SetGCC)
+		},
+		func(getter stats.Getter) { wh.streamStats = getter },
+	)
+	if err != nil {
+		return err
+	}
+	wh.rtcPeer, err = api.NewPeerConnection(wh.iceConfig)
+	return err
+}
+
+// readClientMessages handles the client's answers to our session descriptions
+// and its ICE candidates, along with its requests for a video quality and for
+// ICE restarts. Pion has no use for the end of the client's candidates, so the
+// server ignores it.
+func (wh *WebRTCHandler) readClientMessages() {
 	for {
-		var msg struct{ SDP webrtc.SessionDescription }
+		var msg struct {
+			SDP        *webrtc.SessionDescription
+			Candidate  *webrtc.ICECandidateInit
+			Quality    *tuner.VideoLayer
+			RestartICE bool
+		}
 		if err := wsjson.Read(wh.ctx, wh.socket, &msg); err != nil {
 			wh.shutdown(err)
 			return
 		}
-		if err := wh.rtcPeer.SetRemoteDescription(msg.SDP); err != nil {
-			wh.shutdown(err)
-			return
+		if msg.SDP != nil {
+			if err := wh.rtcPeer.SetRemoteDescription(*msg.SDP); err != nil {
+				wh.shutdown(err)
+				return
+			}
+		}
+		if msg.Candidate != nil {
+			// A candidate that the server can't use shouldn't end a session that
+			// others might carry.
+			if err := wh.rtcPeer.AddICECandidate(*msg.Candidate); err != nil {
+				wh.log.Warn("Ignoring client ICE candidate", "candidate", msg.Candidate.Candidate, "error", err)
+			}
+		}
+		if msg.Quality != nil {
+			wh.mu.Lock()
+			wh.quality = *msg.Quality
+			wh.mu.Unlock()
+			wh.updateVideoLayer()
+		}
+		if msg.RestartICE {
+			if err := wh.restartICE(); err != nil {
+				wh.shutdown(err)
+				return
+			}
 		}
 	}
 }
 
+// restartICE sends the client an offer that restarts ICE, which a client whose
+// connection has failed asks for after fetching new ICE servers, such as when
+// its TURN credentials have expired.
+func (wh *WebRTCHandler) restartICE() error {
+	wh.log.Info("Restarting ICE")
+	wh.mu.Lock()
+	sendOffer, err := wh.renegotiateSession(&webrtc.OfferOptions{ICERestart: true})
+	wh.mu.Unlock()
+	if err != nil || sendOffer == nil {
+		return err
+	}
+	return sendOffer()
+}
+
+// handleTrackUpdate streams ts to the client. It sends any new offer without
+// holding wh.mu, as gathering candidates for a client that doesn't trickle them
+// can take a while, and the client's other requests shouldn't wait on it.
 func (wh *WebRTCHandler) handleTrackUpdate(ts tuner.Tracks) {
+	wh.mu.Lock()
 	wh.logTracks(ts)
-	if err := wh.replaceTracks(ts); err != nil {
+	wh.captions.SetStream(ts.Captions)
+	sendOffer, err := wh.updateTracks(ts)
+	wh.mu.Unlock()
+
+	if err == nil && sendOffer != nil {
+		err = sendOffer()
+	}
+	if err != nil {
 		wh.shutdown(err)
 		return
 	}
-	if err := wh.renegotiateSession(); err != nil {
+
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	if err := wh.sendVideoLayers(); err != nil {
 		wh.shutdown(err)
 		return
 	}
 }
 
+// updateVideoLayer switches the client to a different layer of its current
+// video if its quality request or its bandwidth calls for one. The layers share
+// a codec, so the switch doesn't need a new session description.
+func (wh *WebRTCHandler) updateVideoLayer() {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	if wh.videoSender == nil || len(wh.current.Video) == 0 {
+		return
+	}
+	video := wh.current.SelectVideo(wh.quality, wh.layer, wh.bandwidth.Get())
+	if video.Layer == wh.layer {
+		return
+	}
+
+	wh.log.Info("Switching video layer", "from", wh.layer, "to", video.Layer, "bandwidth", wh.bandwidth.Get())
+	if err := wh.videoSender.ReplaceTrack(video.Track); err != nil {
+		wh.shutdown(err)
+		return
+	}
+	wh.setVideoLayer(video)
+	if err := wh.sendVideoLayers(); err != nil {
+		wh.shutdown(err)
+		return
+	}
+}
+
+func (wh *WebRTCHandler) setVideoLayer(video tuner.VideoTrack) {
+	wh.layer = video.Layer
+	wh.bandwidth.SetControl(video.Bitrate)
+}
+
+// videoTrack returns the video layer that the client receives, or the zero
+// value if the client isn't receiving video.
+func (wh *WebRTCHandler) videoTrack() tuner.VideoTrack {
+	for _, vt := range wh.current.Video {
+		if vt.Layer == wh.layer {
+			return vt
+		}
+	}
+	return tuner.VideoTrack{}
+}
+
+// sendVideoLayers tells the client which layers its current video has, and
+// which of them it receives. WHEP clients have no channel for the message.
+func (wh *WebRTCHandler) sendVideoLayers() error {
+	if wh.socket == nil {
+		return nil
+	}
+	var msg struct {
+		Layers []tuner.VideoLayer
+		Layer  tuner.VideoLayer
+	}
+	for _, vt := range wh.current.Video {
+		msg.Layers = append(msg.Layers, vt.Layer)
+	}
+	if wh.videoSender != nil {
+		msg.Layer = wh.layer
+	}
+	return wsjson.Write(wh.ctx, wh.socket, struct{ Video any }{msg})
+}
+
 func (wh *WebRTCHandler) logTracks(ts tuner.Tracks) {
-	if ts == (tuner.Tracks{}) {
+	if len(ts.Video) == 0 {
 		wh.log.Info("Clearing WebRTC tracks")
 	} else {
 		wh.log.Info("Sending WebRTC tracks")
 	}
 }
 
+// updateTracks streams ts to the client. While the client has tracks, it
+// switches to new ones on the same transceivers, which needs no new session
+// description unless the switch fails. The
+// client renegotiates its session when tracks appear or go away, so that it
+// can tell when the tuner stops streaming. When it renegotiates, the caller
+// must call sendOffer, as [WebRTCHandler.renegotiateSession] describes.
+func (wh *WebRTCHandler) updateTracks(ts tuner.Tracks) (sendOffer func() error, err error) {
+	if wh.videoSender != nil && len(ts.Video) > 0 {
+		err := wh.swapTracks(ts)
+		if err == nil {
+			return nil, nil
+		}
+		wh.log.Warn("Renegotiating to switch WebRTC tracks", "error", err)
+	}
+	if err := wh.replaceTracks(ts); err != nil {
+		return nil, err
+	}
+	return wh.renegotiateSession(nil)
+}
+
+// swapTracks switches the client's existing senders to ts without
+// renegotiating the session. A sender whose track hasn't changed keeps its
+// binding, and with it the continuity of its RTP stream.
+func (wh *WebRTCHandler) swapTracks(ts tuner.Tracks) error {
+	wh.current = ts
+	wh.closeLossClientLocked()
+
+	var (
+		video tuner.VideoTrack
+		audio webrtc.TrackLocal
+	)
+	if len(ts.Video) > 0 {
+		video, audio = ts.SelectVideo(wh.quality, wh.layer, wh.bandwidth.Get()), ts.Audio
+		if ts.AudioLoss != nil {
+			wh.lossClient = ts.AudioLoss.NewClient()
+		}
+	}
+	wh.setVideoLayer(video)
+
+	if wh.videoSender != nil && wh.videoSender.Track() != video.Track {
+		if err := wh.videoSender.ReplaceTrack(video.Track); err != nil {
+			return err
+		}
+	}
+	if wh.audioSender != nil && wh.audioSender.Track() != audio {
+		if err := wh.audioSender.ReplaceTrack(audio); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (wh *WebRTCHandler) replaceTracks(ts tuner.Tracks) error {
 	if err := wh.removeTracks(); err != nil {
 		return err
 	}
-	if ts == (tuner.Tracks{}) {
+	wh.current, wh.videoSender, wh.audioSender = ts, nil, nil
+	wh.setVideoLayer(tuner.VideoTrack{})
+	wh.closeLossClientLocked()
+	if len(ts.Video) == 0 {
 		return nil
 	}
 	return wh.addTracks(ts)
 }
 
-func (wh *WebRTCHandler) renegotiateSession() error {
+// renegotiateSession creates a new offer for the client's current tracks with
+// the provided options, and applies it as the local description. It returns a function that sends the
+// offer to the client once it's ready, which the caller must call without
+// holding wh.mu, or nil if there's no offer to send. Until the offer is sent,
+// signalMu holds back any candidates that the server trickles after it.
+func (wh *WebRTCHandler) renegotiateSession(options *webrtc.OfferOptions) (sendOffer func() error, err error) {
 	if !wh.hasTransceivers() {
 		// Skip negotiation until we've had a chance to properly define video and
 		// audio transceivers based on Tuner tracks.
-		return nil
+		return nil, nil
 	}
 
-	sdp, err := wh.rtcPeer.CreateOffer(nil)
+	sdp, err := wh.rtcPeer.CreateOffer(options)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// TODO: Should probably implement trickle ICE, but since Hypcast doesn't
-	// implement STUN support it's not like ICE gathering takes much time.
-	gatherComplete := webrtc.GatheringCompletePromise(wh.rtcPeer)
+	wh.signalMu.Lock()
+
+	// Clients that don't trickle candidates need all of them in the offer.
+	var gatherComplete <-chan struct{}
+	if !wh.trickle {
+		gatherComplete = webrtc.GatheringCompletePromise(wh.rtcPeer)
+	}
 
 	if err := wh.rtcPeer.SetLocalDescription(sdp); err != nil {
-		return err
+		wh.signalMu.Unlock()
+		return nil, err
 	}
 
-	<-gatherComplete
-	msg := struct{ SDP webrtc.SessionDescription }{*wh.rtcPeer.LocalDescription()}
-	return wsjson.Write(wh.ctx, wh.socket, msg)
+	return func() error {
+		defer wh.signalMu.Unlock()
+		if gatherComplete != nil {
+			select {
+			case <-gatherComplete:
+			case <-wh.ctx.Done():
+				return context.Cause(wh.ctx)
+			}
+		}
+		msg := struct{ SDP webrtc.SessionDescription }{*wh.rtcPeer.LocalDescription()}
+		return wsjson.Write(wh.ctx, wh.socket, msg)
+	}, nil
+}
+
+// sendICECandidate trickles a local ICE candidate to the client, or tells the
+// client that gathering has finished when c is nil.
+func (wh *WebRTCHandler) sendICECandidate(c *webrtc.ICECandidate) {
+	wh.signalMu.Lock()
+	defer wh.signalMu.Unlock()
+
+	var msg any = struct{ EndOfCandidates bool }{true}
+	if c != nil {
+		msg = struct{ Candidate webrtc.ICECandidateInit }{c.ToJSON()}
+	}
+	if err := wsjson.Write(wh.ctx, wh.socket, msg); err != nil {
+		wh.shutdown(err)
+	}
 }
 
 func (wh *WebRTCHandler) removeTracks() error {
@@ -180,33 +514,74 @@ func (wh *WebRTCHandler) removeTracks() error {
 }
 
 func (wh *WebRTCHandler) addTracks(ts tuner.Tracks) error {
+	video := ts.SelectVideo(wh.quality, wh.layer, wh.bandwidth.Get())
+
+	var (
+		senders []*webrtc.RTPSender
+		err     error
+	)
 	if wh.hasTransceivers() {
-		return wh.addTracksWithExistingTransceivers(ts)
+		senders, err = wh.addTracksWithExistingTransceivers(video.Track, ts.Audio)
+	} else {
+		senders, err = wh.addTracksWithNewTransceivers(video.Track, ts.Audio)
+	}
+	if len(senders) > 0 {
+		wh.videoSender = senders[0]
+		wh.setVideoLayer(video)
+	}
+	if len(senders) > 1 {
+		wh.audioSender = senders[1]
+		if ts.AudioLoss != nil {
+			wh.lossClient = ts.AudioLoss.NewClient()
+		}
 	}
-	return wh.addTracksWithNewTransceivers(ts)
-}
 
-func (wh *WebRTCHandler) addTracksWithExistingTransceivers(ts tuner.Tracks) error {
-	if _, err := wh.rtcPeer.AddTrack(ts.Video); err != nil {
-		return err
+	// Each sender stops when its track is removed, or when the peer connection
+	// closes, which ends its RTCP reader.
+	for _, sender := range senders {
+		wh.rtcpReaders.Go(func() { wh.readRTCP(sender) })
 	}
-	if _, err := wh.rtcPeer.AddTrack(ts.Audio); err != nil {
-		return err
+	return err
+}
+
+func (wh *WebRTCHandler) addTracksWithExistingTransceivers(tracks ...webrtc.TrackLocal) ([]*webrtc.RTPSender, error) {
+	var senders []*webrtc.RTPSender
+	for _, track := range tracks {
+		sender, err := wh.rtcPeer.AddTrack(track)
+		if err != nil {
+			return senders, err
+		}
+		senders = append(senders, sender)
 	}
-	return nil
+	return senders, nil
 }
 
-func (wh *WebRTCHandler) addTracksWithNewTransceivers(ts tuner.Tracks) error {
+func (wh *WebRTCHandler) addTracksWithNewTransceivers(tracks ...webrtc.TrackLocal) ([]*webrtc.RTPSender, error) {
 	init := webrtc.RTPTransceiverInit{
 		Direction: webrtc.RTPTransceiverDirectionSendonly,
 	}
-	if _, err := wh.rtcPeer.AddTransceiverFromTrack(ts.Video, init); err != nil {
-		return err
+	var senders []*webrtc.RTPSender
+	for _, track := range tracks {
+		transceiver, err := wh.rtcPeer.AddTransceiverFromTrack(track, init)
+		if err != nil {
+			return senders, err
+		}
+		senders = append(senders, transceiver.Sender())
 	}
-	if _, err := wh.rtcPeer.AddTransceiverFromTrack(ts.Audio, init); err != nil {
-		return err
+	return senders, nil
+}
+
+func (wh *WebRTCHandler) closeLossClient() {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	wh.closeLossClientLocked()
+}
+
+func (wh *WebRTCHandler) closeLossClientLocked() {
+	if wh.lossClient != nil {
+		wh.lossClient.Close()
+		wh.lossClient = nil
 	}
-	return nil
 }
 
 func (wh *WebRTCHandler) hasTransceivers() bool {
@@ -0,0 +1,346 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/featherbread/hypcast/internal/atsc/tuner"
+)
+
+// The WHEP endpoints implement the WebRTC-HTTP Egress Protocol (RFC 9725), which
+// lets standard WebRTC players like OBS and GStreamer's whepsrc watch a tuner.
+// A player POSTs an SDP offer to /api/whep, with the same query parameters as
+// the WebRTC socket, and receives the answer along with the URL of its session.
+// It can PATCH the session with trickled ICE candidates, and DELETE it when
+// done.
+//
+// Unlike the WebRTC socket, WHEP gives the server no way to renegotiate a
+// session. When the tuner's tracks change, each player's existing senders
+// switch to the new tracks in place.
+
+const (
+	// whepMaxSDPBytes limits the size of the offers and trickle ICE fragments
+	// that WHEP players send.
+	whepMaxSDPBytes = 64 << 10
+	// whepTracksTimeout is how long a new WHEP session waits for the tuner to
+	// stream video before giving up, which covers a program that waits for
+	// its map table.
+	whepTracksTimeout = 10 * time.Second
+)
+
+var (
+	errWHEPSessionDeleted = errors.New("WHEP session deleted")
+	errWHEPInvalidOffer   = errors.New("invalid offer")
+	errWHEPNoTracks       = errors.New("tuner is not streaming")
+)
+
+// whepSessions tracks the active WHEP sessions by ID.
+type whepSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*WebRTCHandler
+}
+
+func (ws *whepSessions) Add(id string, wh *WebRTCHandler) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.sessions == nil {
+		ws.sessions = make(map[string]*WebRTCHandler)
+	}
+	ws.sessions[id] = wh
+}
+
+func (ws *whepSessions) Remove(id string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	delete(ws.sessions, id)
+}
+
+func (ws *whepSessions) Get(id string) (*WebRTCHandler, bool) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	wh, ok := ws.sessions[id]
+	return wh, ok
+}
+
+// allowCORS lets WHEP players on any origin use a WHEP endpoint, as they're
+// commonly served from somewhere other than Hypcast.
+func allowCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Expose-Headers", "Location")
+		next(w, r)
+	}
+}
+
+func handleWHEPOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Methods", "POST, PATCH, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Accept-Post", "application/sdp")
+	w.Header().Set("Accept-Patch", "application/trickle-ice-sdpfrag")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type whepAnswer struct {
+	sdp string
+	err error
+}
+
+func (h *Handler) handleWHEPOffer(w http.ResponseWriter, r *http.Request) {
+	offer, ok := readWHEPBody(w, r, "application/sdp")
+	if !ok {
+		return
+	}
+
+	// The session outlives the request that creates it.
+	wh, release, ok := h.newWebRTCHandler(context.Background(), w, r)
+	if !ok {
+		return
+	}
+	id := rand.Text()
+	wh.log = wh.log.With("whep", id)
+
+	answers := make(chan whepAnswer, 1)
+	h.whep.Add(id, wh)
+	h.peers.Add(wh)
+	go func() {
+		defer release()
+		defer h.whep.Remove(id)
+		defer h.peers.Remove(wh)
+		wh.serveWHEP(offer, answers)
+	}()
+
+	answer := <-answers
+	switch {
+	case errors.Is(answer.err, errWHEPInvalidOffer):
+		http.Error(w, answer.err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(answer.err, errWHEPNoTracks):
+		http.Error(w, answer.err.Error(), http.StatusServiceUnavailable)
+		return
+	case answer.err != nil:
+		http.Error(w, answer.err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", "/api/whep/"+id)
+	w.WriteHeader(http.StatusCreated)
+	io.WriteString(w, answer.sdp)
+}
+
+func (h *Handler) handleWHEPPatch(w http.ResponseWriter, r *http.Request) {
+	wh, ok := h.whep.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "WHEP session not found", http.StatusNotFound)
+		return
+	}
+	frag, ok := readWHEPBody(w, r, "application/trickle-ice-sdpfrag")
+	if !ok {
+		return
+	}
+	if err := wh.addTrickleCandidates(frag); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handleWHEPDelete(w http.ResponseWriter, r *http.Request) {
+	wh, ok := h.whep.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "WHEP session not found", http.StatusNotFound)
+		return
+	}
+	wh.shutdown(errWHEPSessionDeleted)
+	w.WriteHeader(http.StatusOK)
+}
+
+// readWHEPBody reads the body of r, which must have the provided media type, or
+// responds with an HTTP error and returns false.
+func readWHEPBody(w http.ResponseWriter, r *http.Request, mediaType string) (string, bool) {
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != mediaType {
+		http.Error(w, "content type must be "+mediaType, http.StatusUnsupportedMediaType)
+		return "", false
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, whepMaxSDPBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return string(body), true
+}
+
+// serveWHEP runs a WHEP session until the player deletes it or its connection
+// fails. It sends the answer to the player's offer, or the failure to answer
+// it, to answers.
+func (wh *WebRTCHandler) serveWHEP(offer string, answers chan<- whepAnswer) {
+	wh.log.Info("Starting WHEP session")
+	defer func() {
+		if wh.trackWatch != nil {
+			wh.trackWatch.Wait()
+		}
+		if wh.bandwidthWatch != nil {
+			wh.bandwidthWatch.Wait()
+		}
+		wh.rtcpReaders.Wait()
+		wh.statsReporter.Wait()
+		wh.closeLossClient()
+		wh.log.Info("Ended WHEP session", "error", context.Cause(wh.ctx))
+	}()
+
+	defer wh.bandwidth.Close()
+
+	if err := wh.createPeer(); err != nil {
+		answers <- whepAnswer{err: err}
+		wh.shutdown(err)
+		return
+	}
+
+	defer wh.rtcPeer.Close()
+
+	wh.rtcPeer.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			wh.shutdown(fmt.Errorf("peer connection %s", state))
+		}
+	})
+
+	sdp, err := wh.answerWHEP(offer)
+	answers <- whepAnswer{sdp: sdp, err: err}
+	if err != nil {
+		wh.shutdown(err)
+		return
+	}
+
+	wh.trackWatch = wh.tracks.WatchTracks(wh.handleWHEPTrackUpdate)
+	defer wh.trackWatch.Cancel()
+
+	wh.bandwidthWatch = wh.bandwidth.Watch(func(int) { wh.updateVideoLayer() })
+	defer wh.bandwidthWatch.Cancel()
+
+	wh.statsReporter.Go(wh.reportStats)
+
+	<-wh.ctx.Done()
+}
+
+// answerWHEP answers the player's offer with the tuner's current tracks, once
+// it has some. WHEP players can't receive candidates after the answer, so the
+// answer waits for ICE gathering to finish.
+func (wh *WebRTCHandler) answerWHEP(offer string) (string, error) {
+	err := wh.rtcPeer.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer})
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errWHEPInvalidOffer, err)
+	}
+
+	ts, err := wh.awaitTracks()
+	if err != nil {
+		return "", err
+	}
+
+	wh.mu.Lock()
+	wh.logTracks(ts)
+	wh.current = ts
+	err = wh.addTracks(ts)
+	wh.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	answer, err := wh.rtcPeer.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(wh.rtcPeer)
+	if err := wh.rtcPeer.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+	select {
+	case <-gatherComplete:
+		return wh.rtcPeer.LocalDescription().SDP, nil
+	case <-wh.ctx.Done():
+		return "", context.Cause(wh.ctx)
+	}
+}
+
+// awaitTracks waits up to whepTracksTimeout for the client's track source to
+// stream video, and returns its tracks.
+func (wh *WebRTCHandler) awaitTracks() (tuner.Tracks, error) {
+	ready := make(chan tuner.Tracks, 1)
+	w := wh.tracks.WatchTracks(func(ts tuner.Tracks) {
+		if len(ts.Video) > 0 {
+			select {
+			case ready <- ts:
+			default:
+			}
+		}
+	})
+	defer w.Wait()
+	defer w.Cancel()
+
+	timer := time.NewTimer(whepTracksTimeout)
+	defer timer.Stop()
+	select {
+	case ts := <-ready:
+		return ts, nil
+	case <-timer.C:
+		return tuner.Tracks{}, errWHEPNoTracks
+	case <-wh.ctx.Done():
+		return tuner.Tracks{}, context.Cause(wh.ctx)
+	}
+}
+
+// handleWHEPTrackUpdate switches a WHEP player to new tracks in place.
+func (wh *WebRTCHandler) handleWHEPTrackUpdate(ts tuner.Tracks) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	wh.logTracks(ts)
+	if err := wh.swapTracks(ts); err != nil {
+		wh.shutdown(err)
+	}
+}
+
+// addTrickleCandidates adds the remote ICE candidates in a trickle ICE SDP
+// fragment (RFC 8840).
+func (wh *WebRTCHandler) addTrickleCandidates(frag string) error {
+	for _, candidate := range parseTrickleCandidates(frag) {
+		if err := wh.rtcPeer.AddICECandidate(candidate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseTrickleCandidates returns the ICE candidates in a trickle ICE SDP
+// fragment, which identifies the media section of each candidate by its
+// preceding a=mid line. Candidates before any a=mid line have no SDPMid, and
+// apply to the bundled transport as a whole.
+func parseTrickleCandidates(frag string) []webrtc.ICECandidateInit {
+	var (
+		candidates []webrtc.ICECandidateInit
+		mid        *string
+	)
+	for line := range strings.Lines(frag) {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "a=mid:"):
+			m := strings.TrimPrefix(line, "a=mid:")
+			mid = &m
+		case strings.HasPrefix(line, "a=candidate:"):
+			candidates = append(candidates, webrtc.ICECandidateInit{
+				Candidate: strings.TrimPrefix(line, "a="),
+				SDPMid:    mid,
+			})
+		}
+	}
+	return candidates
+}
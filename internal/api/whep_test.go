@@ -0,0 +1,61 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestParseTrickleCandidates(t *testing.T) {
+	const (
+		host  = "candidate:1 1 udp 2122260223 192.0.2.5 50000 typ host"
+		srflx = "candidate:2 1 udp 1686052607 203.0.113.5 50001 typ srflx raddr 192.0.2.5 rport 50000"
+	)
+	mid := func(m string) *string { return &m }
+
+	testCases := []struct {
+		name string
+		frag string
+		want []webrtc.ICECandidateInit
+	}{
+		{name: "empty"},
+		{
+			name: "no candidates",
+			frag: "a=ice-ufrag:abcd\r\na=ice-pwd:efgh\r\nm=audio 9 UDP/TLS/RTP/SAVPF 0\r\na=mid:0\r\n",
+		},
+		{
+			name: "with mid",
+			frag: "a=ice-ufrag:abcd\r\nm=audio 9 UDP/TLS/RTP/SAVPF 0\r\na=mid:0\r\na=" + host + "\r\na=" + srflx + "\r\n",
+			want: []webrtc.ICECandidateInit{
+				{Candidate: host, SDPMid: mid("0")},
+				{Candidate: srflx, SDPMid: mid("0")},
+			},
+		},
+		{
+			name: "without mid",
+			frag: "a=" + host + "\na=end-of-candidates\n",
+			want: []webrtc.ICECandidateInit{{Candidate: host}},
+		},
+		{
+			name: "multiple mids",
+			frag: "a=" + host + "\r\na=mid:0\r\na=" + host + "\r\na=mid:video\r\na=" + srflx + "\r\n",
+			want: []webrtc.ICECandidateInit{
+				{Candidate: host},
+				{Candidate: host, SDPMid: mid("0")},
+				{Candidate: srflx, SDPMid: mid("video")},
+			},
+		},
+		{
+			name: "no final newline",
+			frag: "a=mid:1\na=" + host,
+			want: []webrtc.ICECandidateInit{{Candidate: host, SDPMid: mid("1")}},
+		},
+	}
+	for _, tc := range testCases {
+		got := parseTrickleCandidates(tc.frag)
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%s: unexpected candidates (-want +got):\n%s", tc.name, diff)
+		}
+	}
+}
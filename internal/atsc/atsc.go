@@ -3,6 +3,7 @@ package atsc
 
 import (
 	"bufio"
+	"cmp"
 	"fmt"
 	"io"
 	"strconv"
@@ -28,17 +29,101 @@ type Channel struct {
 	VideoPID    uint
 	AudioPID    uint
 	ProgramID   uint
+
+	// MajorNumber and MinorNumber form the virtual channel number that viewers
+	// know the channel by, such as 9.1, as broadcast in the PSIP virtual
+	// channel table of the multiplex. A MajorNumber of 0 means that the number
+	// is unknown, and a MinorNumber of 0 means that the channel has a one-part
+	// number (as some cable channels do).
+	MajorNumber uint
+	MinorNumber uint
+	// ShortName is the name that the station broadcasts for the channel, such
+	// as "KCTS-HD", which may differ from Name.
+	ShortName string
+
+	// File, if set, is the path to a recorded MPEG-TS capture to play in a
+	// continuous loop in place of a live signal.
+	File string
+	// TestPattern, if set, names a GStreamer videotestsrc pattern (e.g. "smpte")
+	// to generate along with a test tone in place of a live signal.
+	TestPattern string
 }
 
 // String returns the representation of c in the azap-compatible format
 // described by ParseChannelsConf.
 func (c Channel) String() string {
-	return fmt.Sprintf(
+	s := fmt.Sprintf(
 		"%s:%d:%s:%d:%d:%d",
 		c.Name, c.FrequencyHz, c.Modulation, c.VideoPID, c.AudioPID, c.ProgramID,
 	)
+	if number := c.Number(); number != "" {
+		s += ":" + optionNumber + "=" + number
+	}
+	if c.ShortName != "" {
+		s += ":" + optionShortName + "=" + c.ShortName
+	}
+	if c.File != "" {
+		s += ":" + optionFile + "=" + c.File
+	}
+	if c.TestPattern != "" {
+		s += ":" + optionTestPattern + "=" + c.TestPattern
+	}
+	return s
+}
+
+// Number returns the virtual channel number of c in the form that viewers know
+// it, such as "9.1", or an empty string if the number is unknown.
+func (c Channel) Number() string {
+	switch {
+	case c.MajorNumber == 0:
+		return ""
+	case c.MinorNumber == 0:
+		return strconv.FormatUint(uint64(c.MajorNumber), 10)
+	default:
+		return fmt.Sprintf("%d.%d", c.MajorNumber, c.MinorNumber)
+	}
+}
+
+// ParseNumber parses a virtual channel number in the form returned by
+// Channel.Number, also accepting a hyphen in place of the dot (e.g. "9-1").
+func ParseNumber(s string) (major, minor uint, ok bool) {
+	majorText, minorText, dotted := strings.Cut(strings.Replace(s, "-", ".", 1), ".")
+	major64, err := strconv.ParseUint(majorText, 10, 16)
+	if err != nil || major64 == 0 {
+		return 0, 0, false
+	}
+	if !dotted {
+		return uint(major64), 0, true
+	}
+	minor64, err := strconv.ParseUint(minorText, 10, 16)
+	if err != nil || minor64 == 0 {
+		return 0, 0, false
+	}
+	return uint(major64), uint(minor64), true
+}
+
+// CompareNumbers orders channels by their virtual channel numbers, with
+// channels whose numbers are unknown after all others. It is suitable for use
+// with slices.SortStableFunc.
+func CompareNumbers(a, b Channel) int {
+	switch {
+	case a.MajorNumber == 0 || b.MajorNumber == 0:
+		return cmp.Compare(b.MajorNumber, a.MajorNumber)
+	case a.MajorNumber != b.MajorNumber:
+		return cmp.Compare(a.MajorNumber, b.MajorNumber)
+	default:
+		return cmp.Compare(a.MinorNumber, b.MinorNumber)
+	}
 }
 
+// The following are the keys of optional channels.conf fields.
+const (
+	optionNumber      = "number"
+	optionShortName   = "shortname"
+	optionFile        = "file"
+	optionTestPattern = "testpattern"
+)
+
 // ParseChannelsConf parses Channels from an azap-compatible channels.conf file
 // read from r.
 //
@@ -50,6 +135,25 @@ func (c Channel) String() string {
 // FrequencyHz, VideoPID, AudioPID, and ProgramID are all represented in decimal
 // form.
 //
+// As an extension to the azap format, each line may end with additional
+// colon-separated fields of the form key=value. The following keys describe
+// the channel as broadcast:
+//
+//	number=MAJOR.MINOR  sets the virtual channel number (see MajorNumber)
+//	shortname=NAME      sets the station's name for the channel (see ShortName)
+//
+// The following keys define channels that don't require tuner hardware, and at
+// most one of them may appear on any line:
+//
+//	file=PATH           plays the MPEG-TS capture at PATH in a loop (see File)
+//	testpattern=NAME    generates a synthetic signal (see TestPattern)
+//
+// For example:
+//
+//	KCTS-HD:189000000:8VSB:49:52:3:number=9.1:shortname=KCTS-HD
+//	Capture:189000000:8VSB:49:52:3:file=/srv/captures/kcts.ts
+//	Color Bars:0:8VSB:49:52:1:testpattern=smpte
+//
 // The https://github.com/stefantalpalaru/w_scan2 utility is useful for
 // generating a compatible file. For example, to scan for terrestrial broadcast
 // channels in the United States of America:
@@ -68,9 +172,9 @@ func ParseChannelsConf(r io.Reader) ([]Channel, error) {
 		fields := strings.Split(scanner.Text(), ":")
 
 		const expectedFields = 6
-		if len(fields) != expectedFields {
+		if len(fields) < expectedFields {
 			return nil, fmt.Errorf(
-				"channels.conf line %d has %d fields, expected %d",
+				"channels.conf line %d has %d fields, expected at least %d",
 				line, len(fields), expectedFields,
 			)
 		}
@@ -100,17 +204,23 @@ func ParseChannelsConf(r io.Reader) ([]Channel, error) {
 			return Modulation8VSB
 		}
 
-		channels = append(channels, Channel{
+		channel := Channel{
 			Name:        fields[0],
 			FrequencyHz: parseUint(fields[1]),
 			Modulation:  parseModulation(fields[2]),
 			VideoPID:    parseUint(fields[3]),
 			AudioPID:    parseUint(fields[4]),
 			ProgramID:   parseUint(fields[5]),
-		})
+		}
 		if err != nil {
 			return nil, err
 		}
+
+		if err := parseOptions(&channel, fields[expectedFields:], line); err != nil {
+			return nil, err
+		}
+
+		channels = append(channels, channel)
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -119,3 +229,61 @@ func ParseChannelsConf(r io.Reader) ([]Channel, error) {
 
 	return channels, nil
 }
+
+// WriteChannelsConf writes channels to w in the channels.conf format described
+// by ParseChannelsConf, one channel per line.
+func WriteChannelsConf(w io.Writer, channels []Channel) error {
+	bw := bufio.NewWriter(w)
+	for _, ch := range channels {
+		bw.WriteString(ch.String())
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// parseOptions sets the fields of channel defined by the optional key=value
+// fields of a channels.conf line.
+func parseOptions(channel *Channel, options []string, line int) error {
+	var number string
+	for _, option := range options {
+		key, value, ok := strings.Cut(option, "=")
+		if !ok || value == "" {
+			return fmt.Errorf("channels.conf line %d has invalid option %q", line, option)
+		}
+
+		var field *string
+		switch key {
+		case optionNumber:
+			field = &number
+		case optionShortName:
+			field = &channel.ShortName
+		case optionFile:
+			field = &channel.File
+		case optionTestPattern:
+			field = &channel.TestPattern
+		default:
+			return fmt.Errorf("channels.conf line %d has unknown option %q", line, key)
+		}
+
+		if *field != "" {
+			return fmt.Errorf("channels.conf line %d has duplicate option %q", line, key)
+		}
+		*field = value
+	}
+
+	if number != "" {
+		var ok bool
+		channel.MajorNumber, channel.MinorNumber, ok = ParseNumber(number)
+		if !ok {
+			return fmt.Errorf("channels.conf line %d has invalid channel number %q", line, number)
+		}
+	}
+
+	if channel.File != "" && channel.TestPattern != "" {
+		return fmt.Errorf(
+			"channels.conf line %d has both %q and %q options",
+			line, optionFile, optionTestPattern,
+		)
+	}
+	return nil
+}
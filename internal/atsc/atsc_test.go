@@ -1,6 +1,7 @@
 package atsc
 
 import (
+	"slices"
 	"strings"
 	"testing"
 
@@ -16,6 +17,10 @@ WORLD:189000000:8VSB:97:100:6`
 	validChannelsConfNonstandard8VSB = "KCTS-HD:189000000:VSB_8:49:52:3"
 	validChannelsConfQAM64           = "Test QAM 64:255000000:QAM_64:42:43:5"
 	validChannelsConfQAM256          = "WLFI:255000000:QAM_256:66:68:4"
+	validChannelsConfFile            = "Capture:189000000:8VSB:49:52:3:file=/srv/captures/kcts.ts"
+	validChannelsConfTestPattern     = "Color Bars:0:8VSB:49:52:1:testpattern=smpte"
+	validChannelsConfVirtual         = "KCTS 9:189000000:8VSB:49:52:3:number=9.1:shortname=KCTS-HD"
+	validChannelsConfOnePart         = "HBO:255000000:QAM_256:66:68:4:number=1029"
 )
 
 func TestParseChannelsConf(t *testing.T) {
@@ -29,10 +34,10 @@ func TestParseChannelsConf(t *testing.T) {
 			name:  "valid channels.conf",
 			input: validChannelsConf,
 			want: []Channel{
-				{"KCTS-HD", 189_000_000, Modulation8VSB, 49, 52, 3},
-				{"KIDS", 189_000_000, Modulation8VSB, 65, 68, 4},
-				{"CREATE", 189_000_000, Modulation8VSB, 81, 84, 5},
-				{"WORLD", 189_000_000, Modulation8VSB, 97, 100, 6},
+				{Name: "KCTS-HD", FrequencyHz: 189_000_000, Modulation: Modulation8VSB, VideoPID: 49, AudioPID: 52, ProgramID: 3},
+				{Name: "KIDS", FrequencyHz: 189_000_000, Modulation: Modulation8VSB, VideoPID: 65, AudioPID: 68, ProgramID: 4},
+				{Name: "CREATE", FrequencyHz: 189_000_000, Modulation: Modulation8VSB, VideoPID: 81, AudioPID: 84, ProgramID: 5},
+				{Name: "WORLD", FrequencyHz: 189_000_000, Modulation: Modulation8VSB, VideoPID: 97, AudioPID: 100, ProgramID: 6},
 			},
 		},
 
@@ -40,7 +45,7 @@ func TestParseChannelsConf(t *testing.T) {
 			name:  "w_scan2 nonstandard 8VSB output",
 			input: validChannelsConfNonstandard8VSB,
 			want: []Channel{
-				{"KCTS-HD", 189_000_000, Modulation8VSB, 49, 52, 3},
+				{Name: "KCTS-HD", FrequencyHz: 189_000_000, Modulation: Modulation8VSB, VideoPID: 49, AudioPID: 52, ProgramID: 3},
 			},
 		},
 
@@ -48,7 +53,7 @@ func TestParseChannelsConf(t *testing.T) {
 			name:  "QAM64 modulation",
 			input: validChannelsConfQAM64,
 			want: []Channel{
-				{"Test QAM 64", 255_000_000, ModulationQAM64, 42, 43, 5},
+				{Name: "Test QAM 64", FrequencyHz: 255_000_000, Modulation: ModulationQAM64, VideoPID: 42, AudioPID: 43, ProgramID: 5},
 			},
 		},
 
@@ -56,7 +61,47 @@ func TestParseChannelsConf(t *testing.T) {
 			name:  "QAM256 modulation",
 			input: validChannelsConfQAM256,
 			want: []Channel{
-				{"WLFI", 255_000_000, ModulationQAM256, 66, 68, 4},
+				{Name: "WLFI", FrequencyHz: 255_000_000, Modulation: ModulationQAM256, VideoPID: 66, AudioPID: 68, ProgramID: 4},
+			},
+		},
+
+		{
+			name:  "file source",
+			input: validChannelsConfFile,
+			want: []Channel{
+				{Name: "Capture", FrequencyHz: 189_000_000, Modulation: Modulation8VSB, VideoPID: 49, AudioPID: 52, ProgramID: 3, File: "/srv/captures/kcts.ts"},
+			},
+		},
+
+		{
+			name:  "test pattern source",
+			input: validChannelsConfTestPattern,
+			want: []Channel{
+				{Name: "Color Bars", FrequencyHz: 0, Modulation: Modulation8VSB, VideoPID: 49, AudioPID: 52, ProgramID: 1, TestPattern: "smpte"},
+			},
+		},
+
+		{
+			name:  "virtual channel number and short name",
+			input: validChannelsConfVirtual,
+			want: []Channel{
+				{Name: "KCTS 9", FrequencyHz: 189_000_000, Modulation: Modulation8VSB, VideoPID: 49, AudioPID: 52, ProgramID: 3, MajorNumber: 9, MinorNumber: 1, ShortName: "KCTS-HD"},
+			},
+		},
+
+		{
+			name:  "one-part virtual channel number",
+			input: validChannelsConfOnePart,
+			want: []Channel{
+				{Name: "HBO", FrequencyHz: 255_000_000, Modulation: ModulationQAM256, VideoPID: 66, AudioPID: 68, ProgramID: 4, MajorNumber: 1029},
+			},
+		},
+
+		{
+			name:  "virtual channel number with a source",
+			input: "Capture:189000000:8VSB:49:52:3:file=/srv/captures/kcts.ts:number=9-1",
+			want: []Channel{
+				{Name: "Capture", FrequencyHz: 189_000_000, Modulation: Modulation8VSB, VideoPID: 49, AudioPID: 52, ProgramID: 3, MajorNumber: 9, MinorNumber: 1, File: "/srv/captures/kcts.ts"},
 			},
 		},
 
@@ -83,6 +128,42 @@ func TestParseChannelsConf(t *testing.T) {
 			input:   "KCTS-HD:189000000:8VSB:49:52:?",
 			wantErr: true,
 		},
+
+		{
+			name:    "unknown option",
+			input:   "KCTS-HD:189000000:8VSB:49:52:3:adapter=1",
+			wantErr: true,
+		},
+
+		{
+			name:    "option without value",
+			input:   "KCTS-HD:189000000:8VSB:49:52:3:file",
+			wantErr: true,
+		},
+
+		{
+			name:    "duplicate option",
+			input:   "KCTS-HD:189000000:8VSB:49:52:3:file=a.ts:file=b.ts",
+			wantErr: true,
+		},
+
+		{
+			name:    "invalid virtual channel number",
+			input:   "KCTS-HD:189000000:8VSB:49:52:3:number=9.x",
+			wantErr: true,
+		},
+
+		{
+			name:    "zero virtual channel number",
+			input:   "KCTS-HD:189000000:8VSB:49:52:3:number=0.1",
+			wantErr: true,
+		},
+
+		{
+			name:    "conflicting options",
+			input:   "KCTS-HD:189000000:8VSB:49:52:3:file=a.ts:testpattern=smpte",
+			wantErr: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -104,11 +185,62 @@ func TestParseChannelsConf(t *testing.T) {
 	}
 }
 
+func TestCompareNumbers(t *testing.T) {
+	channels := []Channel{
+		{Name: "Unnumbered B"},
+		{Name: "Eleven Two", MajorNumber: 11, MinorNumber: 2},
+		{Name: "Unnumbered A"},
+		{Name: "Nine Two", MajorNumber: 9, MinorNumber: 2},
+		{Name: "Eleven One", MajorNumber: 11, MinorNumber: 1},
+		{Name: "Nine One", MajorNumber: 9, MinorNumber: 1},
+	}
+	slices.SortStableFunc(channels, CompareNumbers)
+
+	var got []string
+	for _, ch := range channels {
+		got = append(got, ch.Name)
+	}
+	want := []string{"Nine One", "Nine Two", "Eleven One", "Eleven Two", "Unnumbered B", "Unnumbered A"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected order (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseNumber(t *testing.T) {
+	testCases := []struct {
+		input        string
+		major, minor uint
+		ok           bool
+	}{
+		{input: "9.1", major: 9, minor: 1, ok: true},
+		{input: "9-1", major: 9, minor: 1, ok: true},
+		{input: "1029", major: 1029, ok: true},
+		{input: "9.0"},
+		{input: "0.1"},
+		{input: "9."},
+		{input: "KCTS"},
+		{input: ""},
+	}
+	for _, tc := range testCases {
+		major, minor, ok := ParseNumber(tc.input)
+		if major != tc.major || minor != tc.minor || ok != tc.ok {
+			t.Errorf(
+				"ParseNumber(%q) = %d, %d, %v; want %d, %d, %v",
+				tc.input, major, minor, ok, tc.major, tc.minor, tc.ok,
+			)
+		}
+	}
+}
+
 func FuzzParseChannelsConf(f *testing.F) {
 	f.Add(validChannelsConf)
 	f.Add(validChannelsConfNonstandard8VSB)
 	f.Add(validChannelsConfQAM64)
 	f.Add(validChannelsConfQAM256)
+	f.Add(validChannelsConfFile)
+	f.Add(validChannelsConfTestPattern)
+	f.Add(validChannelsConfVirtual)
+	f.Add(validChannelsConfOnePart)
 
 	f.Fuzz(func(t *testing.T, inputStringConf string) {
 		parsedChannels, err := ParseChannelsConf(strings.NewReader(inputStringConf))
@@ -116,8 +248,11 @@ func FuzzParseChannelsConf(f *testing.F) {
 			t.SkipNow()
 		}
 
-		encodedChannels := formatChannelsConf(t, parsedChannels)
-		parsedChannels2, err := ParseChannelsConf(strings.NewReader(encodedChannels))
+		var encodedChannels strings.Builder
+		if err := WriteChannelsConf(&encodedChannels, parsedChannels); err != nil {
+			t.Fatalf("error encoding channel list: %v", err)
+		}
+		parsedChannels2, err := ParseChannelsConf(strings.NewReader(encodedChannels.String()))
 		if err != nil {
 			t.Fatalf("error re-parsing encoded channel list: %v", err)
 		}
@@ -128,13 +263,3 @@ func FuzzParseChannelsConf(f *testing.F) {
 		}
 	})
 }
-
-func formatChannelsConf(t *testing.T, channels []Channel) string {
-	t.Helper()
-	var buf strings.Builder
-	for _, ch := range channels {
-		buf.WriteString(ch.String())
-		buf.WriteRune('\n')
-	}
-	return buf.String()
-}
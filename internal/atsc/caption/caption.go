@@ -0,0 +1,253 @@
+// Package caption decodes the CEA-608 and CEA-708 closed captions carried in
+// the video of ATSC programs, and formats what they display as WebVTT cues.
+package caption
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/featherbread/hypcast/internal/atsc/psip"
+)
+
+// Service identifies a caption service within a video stream, as "CC1" through
+// "CC4" for CEA-608 captions, or as "SERVICE1" through "SERVICE63" for CEA-708
+// caption services.
+type Service string
+
+const (
+	cea608Prefix = "CC"
+	cea708Prefix = "SERVICE"
+)
+
+func cea608Service(channel int) Service {
+	return Service(cea608Prefix + strconv.Itoa(channel))
+}
+
+func cea708Service(number int) Service {
+	return Service(cea708Prefix + strconv.Itoa(number))
+}
+
+// ServiceFor returns the service that an entry of a caption service
+// descriptor describes.
+func ServiceFor(cs psip.CaptionService) Service {
+	switch {
+	case cs.Digital:
+		return cea708Service(int(cs.ServiceNumber))
+	case cs.Line21Field == 1:
+		return cea608Service(3)
+	default:
+		return cea608Service(1)
+	}
+}
+
+// compareServices orders CEA-608 services before CEA-708 services, and each
+// kind of service by number.
+func compareServices(a, b Service) int {
+	ka, na := a.kindAndNumber()
+	kb, nb := b.kindAndNumber()
+	return cmp.Or(cmp.Compare(ka, kb), cmp.Compare(na, nb))
+}
+
+func (s Service) kindAndNumber() (kind, number int) {
+	if n, ok := strings.CutPrefix(string(s), cea608Prefix); ok {
+		number, _ = strconv.Atoi(n)
+		return 0, number
+	}
+	if n, ok := strings.CutPrefix(string(s), cea708Prefix); ok {
+		number, _ = strconv.Atoi(n)
+		return 1, number
+	}
+	return 2, 0
+}
+
+// CCType identifies the kind of data in a single caption data pair.
+type CCType uint8
+
+// The following are the types of caption data defined by CEA-708.
+const (
+	// CCTypeField1 carries CEA-608 data for field 1, which holds CC1 and CC2.
+	CCTypeField1 CCType = 0
+	// CCTypeField2 carries CEA-608 data for field 2, which holds CC3 and CC4.
+	CCTypeField2 CCType = 1
+	// CCTypeDTVCCData continues a DTVCC packet of CEA-708 data.
+	CCTypeDTVCCData CCType = 2
+	// CCTypeDTVCCStart starts a DTVCC packet of CEA-708 data.
+	CCTypeDTVCCStart CCType = 3
+)
+
+// CCData represents a single valid pair of caption data bytes.
+type CCData struct {
+	Type CCType
+	Data [2]byte
+}
+
+var (
+	userDataStartCode = []byte{0x00, 0x00, 0x01, 0xB2}
+	a53Identifier     = []byte("GA94")
+)
+
+// a53UserDataTypeCC identifies ATSC A/53 user data that carries cc_data.
+const a53UserDataTypeCC = 0x03
+
+// ExtractMPEG2 returns the caption data carried in the ATSC A/53 picture user
+// data of MPEG-2 video, from a buffer holding any number of complete pictures.
+func ExtractMPEG2(video []byte) []CCData {
+	var data []CCData
+	for {
+		i := bytes.Index(video, userDataStartCode)
+		if i < 0 {
+			return data
+		}
+		video = video[i+len(userDataStartCode):]
+		data = appendA53(data, video)
+	}
+}
+
+// appendA53 appends the caption data of the A/53 user data at the start of b,
+// which may continue past the end of the user data.
+func appendA53(data []CCData, b []byte) []CCData {
+	const headerSize = 7 // Identifier, type code, flags and count, em_data.
+	if len(b) < headerSize || !bytes.HasPrefix(b, a53Identifier) || b[4] != a53UserDataTypeCC {
+		return data
+	}
+	if b[5]&0x40 == 0 {
+		return data // process_cc_data_flag is clear.
+	}
+
+	count := int(b[5] & 0x1F)
+	b = b[headerSize:]
+	for i := 0; i < count && len(b) >= 3; i++ {
+		if b[0]&0x04 != 0 { // cc_valid
+			data = append(data, CCData{Type: CCType(b[0] & 0x03), Data: [2]byte{b[1], b[2]}})
+		}
+		b = b[3:]
+	}
+	return data
+}
+
+// Cue represents what a caption service displays at a moment in time, as the
+// payload and settings of a WebVTT cue. A cue with an empty Text clears the
+// display.
+type Cue struct {
+	// Text is formatted in WebVTT cue text syntax, and may include italic and
+	// underline tags.
+	Text string
+	// Settings positions the cue, as a WebVTT cue setting like "line:85%".
+	Settings string
+}
+
+// Decoder decodes every caption service of a single video stream. The zero
+// value is ready to use.
+type Decoder struct {
+	fields [2]cea608Field
+	dtvcc  dtvccDecoder
+}
+
+// Decode processes caption data in the order that it was transmitted, and
+// calls emit with the new cue of each service whose display changes.
+func (d *Decoder) Decode(data []CCData, emit func(Service, Cue)) {
+	for _, cc := range data {
+		switch cc.Type {
+		case CCTypeField1, CCTypeField2:
+			d.fields[cc.Type].decode(int(cc.Type), cc.Data, emit)
+		case CCTypeDTVCCData, CCTypeDTVCCStart:
+			d.dtvcc.decode(cc, emit)
+		}
+	}
+}
+
+// style holds the attributes of a displayed character that WebVTT can render.
+type style struct {
+	italic, underline bool
+}
+
+// cell is a single character position of a caption display. The zero value is
+// an empty position, which is transparent rather than a space.
+type cell struct {
+	r     rune
+	style style
+}
+
+// cueBuilder builds a WebVTT cue from rows of caption cells, positioning the
+// cue by the first row that displays anything.
+type cueBuilder struct {
+	text    strings.Builder
+	line    int
+	hasLine bool
+}
+
+// addRow adds a row of cells to the cue, where linePercent is the vertical
+// position of the row within the video.
+func (cb *cueBuilder) addRow(row []cell, linePercent int) {
+	isBlank := func(c cell) bool { return c.r == 0 || c.r == ' ' }
+	start := 0
+	for start < len(row) && isBlank(row[start]) {
+		start++
+	}
+	end := len(row)
+	for end > start && isBlank(row[end-1]) {
+		end--
+	}
+	if start == end {
+		return
+	}
+
+	if cb.hasLine {
+		cb.text.WriteByte('\n')
+	} else {
+		cb.line, cb.hasLine = linePercent, true
+	}
+
+	var current style
+	for _, c := range row[start:end] {
+		if c.style != current {
+			cb.closeStyle(current)
+			cb.openStyle(c.style)
+			current = c.style
+		}
+		switch c.r {
+		case 0:
+			cb.text.WriteByte(' ')
+		case '&':
+			cb.text.WriteString("&amp;")
+		case '<':
+			cb.text.WriteString("&lt;")
+		case '>':
+			cb.text.WriteString("&gt;")
+		default:
+			cb.text.WriteRune(c.r)
+		}
+	}
+	cb.closeStyle(current)
+}
+
+func (cb *cueBuilder) openStyle(s style) {
+	if s.italic {
+		cb.text.WriteString("<i>")
+	}
+	if s.underline {
+		cb.text.WriteString("<u>")
+	}
+}
+
+func (cb *cueBuilder) closeStyle(s style) {
+	if s.underline {
+		cb.text.WriteString("</u>")
+	}
+	if s.italic {
+		cb.text.WriteString("</i>")
+	}
+}
+
+func (cb *cueBuilder) cue() Cue {
+	if !cb.hasLine {
+		return Cue{}
+	}
+	return Cue{
+		Text:     cb.text.String(),
+		Settings: fmt.Sprintf("line:%d%%", max(0, min(cb.line, 100))),
+	}
+}
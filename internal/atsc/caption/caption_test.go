@@ -0,0 +1,290 @@
+package caption
+
+import (
+	"math/bits"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/featherbread/hypcast/internal/atsc/psip"
+)
+
+func TestExtractMPEG2(t *testing.T) {
+	video := []byte{0x00, 0x00, 0x01, 0x00, 0x12, 0x34} // Picture header.
+	// User data from another standard.
+	video = append(video, 0x00, 0x00, 0x01, 0xB2, 'D', 'T', 'G', '1', 0xAA)
+	video = append(video, 0x00, 0x00, 0x01, 0xB2, 'G', 'A', '9', '4', 0x03)
+	video = append(video, 0x43, 0xFF) // process_cc_data_flag, 3 triplets, em_data.
+	video = append(video,
+		0xFC, 0x94, 0x20,
+		0xF9, 0x00, 0x00, // Not valid.
+		0xFF, 0x02, 0x21,
+		0xFF, // Marker bits.
+	)
+	video = append(video, 0x00, 0x00, 0x01, 0x01, 0x56, 0x78) // Slice.
+
+	got := ExtractMPEG2(video)
+	want := []CCData{
+		{Type: CCTypeField1, Data: [2]byte{0x94, 0x20}},
+		{Type: CCTypeDTVCCStart, Data: [2]byte{0x02, 0x21}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected caption data (-want +got):\n%s", diff)
+	}
+}
+
+func TestExtractH264(t *testing.T) {
+	video := []byte{0x00, 0x00, 0x00, 0x01, 0x09, 0xF0} // Access unit delimiter.
+	video = append(video, 0x00, 0x00, 0x01, 0x06)       // SEI.
+	video = append(video, 0x01, 0x01, 0x00)             // Buffering period.
+	video = append(video, 0x04, 0x14, 0xB5, 0x00, 0x31, 'G', 'A', '9', '4', 0x03, 0x43, 0xFF)
+	video = append(video,
+		0xFC, 0x94, 0x20,
+		0xFC, 0x00, 0x00,
+		0x03,             // Emulation prevention.
+		0x03, 0x12, 0x34, // Not valid.
+		0xFF,
+	)
+	video = append(video, 0x80)                               // Trailing bits.
+	video = append(video, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84) // IDR slice.
+
+	got := ExtractH264(video)
+	want := []CCData{
+		{Type: CCTypeField1, Data: [2]byte{0x94, 0x20}},
+		{Type: CCTypeField1, Data: [2]byte{0x00, 0x00}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected caption data (-want +got):\n%s", diff)
+	}
+}
+
+type emitted struct {
+	Service Service
+	Cue     Cue
+}
+
+func decodeAll(d *Decoder, data []CCData) []emitted {
+	var got []emitted
+	d.Decode(data, func(svc Service, cue Cue) {
+		got = append(got, emitted{svc, cue})
+	})
+	return got
+}
+
+// cc608 returns a CEA-608 byte pair for a field, with odd parity applied.
+func cc608(field CCType, b1, b2 byte) CCData {
+	withParity := func(b byte) byte {
+		if bits.OnesCount8(b)%2 == 0 {
+			return b | 0x80
+		}
+		return b
+	}
+	return CCData{Type: field, Data: [2]byte{withParity(b1), withParity(b2)}}
+}
+
+// control returns a CEA-608 control code, sent twice as broadcasters do.
+func control(field CCType, b1, b2 byte) []CCData {
+	return []CCData{cc608(field, b1, b2), cc608(field, b1, b2)}
+}
+
+func text608(field CCType, s string) []CCData {
+	var data []CCData
+	for i := 0; i < len(s); i += 2 {
+		b2 := byte(0)
+		if i+1 < len(s) {
+			b2 = s[i+1]
+		}
+		data = append(data, cc608(field, s[i], b2))
+	}
+	return data
+}
+
+func TestCEA608PopOn(t *testing.T) {
+	var data []CCData
+	data = append(data, control(CCTypeField1, 0x14, 0x20)...) // RCL
+	data = append(data, control(CCTypeField1, 0x14, 0x40)...) // PAC row 14
+	data = append(data, text608(CCTypeField1, "HELLO")...)
+	data = append(data, control(CCTypeField1, 0x11, 0x2E)...) // Italics
+	data = append(data, text608(CCTypeField1, "W<RLD")...)
+	data = append(data, control(CCTypeField1, 0x14, 0x60)...) // PAC row 15
+	data = append(data, text608(CCTypeField1, "Espa")...)
+	data = append(data, control(CCTypeField1, 0x11, 0x3B)...) // â
+	data = append(data, text608(CCTypeField1, "na")...)
+	data = append(data, control(CCTypeField1, 0x12, 0x27)...) // ¡, replacing a
+	var d Decoder
+	if got := decodeAll(&d, data); len(got) > 0 {
+		t.Fatalf("displayed captions before end of caption: %v", got)
+	}
+
+	got := decodeAll(&d, control(CCTypeField1, 0x14, 0x2F)) // EOC
+	want := []emitted{{"CC1", Cue{Text: "HELLO <i>W&lt;RLD</i>\nEspaân¡", Settings: "line:79%"}}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected cues after end of caption (-want +got):\n%s", diff)
+	}
+
+	got = decodeAll(&d, control(CCTypeField1, 0x14, 0x2C)) // EDM
+	want = []emitted{{"CC1", Cue{}}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected cues after erasing display (-want +got):\n%s", diff)
+	}
+}
+
+func TestCEA608RollUp(t *testing.T) {
+	var data []CCData
+	data = append(data, control(CCTypeField2, 0x15, 0x25)...) // CC3 RU2
+	for _, line := range []string{"ONE", "TWO", "THREE"} {
+		data = append(data, control(CCTypeField2, 0x15, 0x2D)...) // CR
+		data = append(data, text608(CCTypeField2, line)...)
+	}
+	// CC4 shares the field, and must not disturb CC3.
+	data = append(data, control(CCTypeField2, 0x1D, 0x29)...) // CC4 RDC
+	data = append(data, control(CCTypeField2, 0x19, 0x40)...) // PAC row 1
+	data = append(data, text608(CCTypeField2, "HI")...)
+
+	var d Decoder
+	got := decodeAll(&d, data)
+	last := make(map[Service]Cue)
+	for _, e := range got {
+		last[e.Service] = e.Cue
+	}
+	want := map[Service]Cue{
+		"CC3": {Text: "TWO\nTHREE", Settings: "line:79%"},
+		"CC4": {Text: "HI", Settings: "line:10%"},
+	}
+	if diff := cmp.Diff(want, last); diff != "" {
+		t.Errorf("unexpected final cues (-want +got):\n%s", diff)
+	}
+}
+
+// dtvccPacket returns the caption data for a DTVCC packet carrying a single
+// service block.
+func dtvccPacket(service int, block []byte) []CCData {
+	packet := []byte{0x00, byte(service<<5 | len(block))}
+	packet = append(packet, block...)
+	if len(packet)%2 != 0 {
+		packet = append(packet, 0x00) // Null block.
+	}
+	packet[0] = byte(len(packet) / 2)
+
+	var data []CCData
+	for i := 0; i < len(packet); i += 2 {
+		typ := CCTypeDTVCCData
+		if i == 0 {
+			typ = CCTypeDTVCCStart
+		}
+		data = append(data, CCData{Type: typ, Data: [2]byte{packet[i], packet[i+1]}})
+	}
+	return data
+}
+
+func TestCEA708(t *testing.T) {
+	block := []byte{0x98, 0x38, 0x00, 0x00, 0x01, 0x1F, 0x00} // DF0: visible, 2 rows, 32 columns.
+	block = append(block, "Hi <x> & "...)
+	block = append(block, 0x90, 0x00, 0x80) // SPA: italics.
+	block = append(block, "you"...)
+	block = append(block, 0x90, 0x00, 0x00, 0x0D) // SPA: plain, CR.
+	block = append(block, 0x10, 0x25, 0xE9)       // Ellipsis, é.
+
+	var d Decoder
+	got := decodeAll(&d, dtvccPacket(1, block))
+	want := []emitted{{"SERVICE1", Cue{Text: "Hi &lt;x&gt; &amp; <i>you</i>\n…é", Settings: "line:0%"}}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected cues (-want +got):\n%s", diff)
+	}
+
+	// Hiding the window clears the display.
+	got = decodeAll(&d, dtvccPacket(1, []byte{0x8A, 0x01}))
+	want = []emitted{{"SERVICE1", Cue{}}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected cues after hiding window (-want +got):\n%s", diff)
+	}
+
+	// Extended service numbers follow the service block header.
+	data := dtvccPacket(7, []byte{0x0A, 0x98, 0x38, 0x00, 0x00, 0x00, 0x1F, 0x00, 'X'})
+	got = decodeAll(&d, data)
+	want = []emitted{{"SERVICE10", Cue{Text: "X", Settings: "line:0%"}}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected cues for extended service (-want +got):\n%s", diff)
+	}
+}
+
+func TestStream(t *testing.T) {
+	s := NewStream(func() []psip.CaptionService {
+		return []psip.CaptionService{
+			{Language: "eng"},
+			{Language: "spa", Line21Field: 1},
+		}
+	})
+
+	cues := make(chan Cue, 10)
+	w := s.WatchCues("CC1", func(cue Cue) { cues <- cue })
+	defer w.Cancel()
+	if cue := <-cues; cue != (Cue{}) {
+		t.Errorf("unexpected initial cue: %v", cue)
+	}
+
+	var data []CCData
+	data = append(data, control(CCTypeField1, 0x14, 0x29)...) // RDC
+	data = append(data, control(CCTypeField1, 0x14, 0x60)...) // PAC row 15
+	data = append(data, text608(CCTypeField1, "OK")...)
+	var video []byte
+	for _, cc := range data {
+		video = append(video, 0x00, 0x00, 0x01, 0xB2, 'G', 'A', '9', '4', 0x03, 0x41, 0xFF)
+		video = append(video, 0xFC|byte(cc.Type), cc.Data[0], cc.Data[1])
+	}
+	s.DecodeMPEG2(video)
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case cue := <-cues:
+			if cue.Text != "OK" {
+				continue // Intermediate cues may or may not be delivered.
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for cue")
+		}
+		break
+	}
+
+	want := []ServiceInfo{{Service: "CC1", Language: "eng"}}
+	if diff := cmp.Diff(want, s.Services()); diff != "" {
+		t.Errorf("unexpected services (-want +got):\n%s", diff)
+	}
+	for _, tc := range []struct {
+		language string
+		want     Service
+		ok       bool
+	}{
+		{"eng", "CC1", true},
+		{"spa", "CC3", true},
+		{"fra", "", false},
+	} {
+		if got, ok := s.ServiceForLanguage(tc.language); got != tc.want || ok != tc.ok {
+			t.Errorf("ServiceForLanguage(%q) = %q, %v; want %q, %v", tc.language, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestCompareServices(t *testing.T) {
+	got := []Service{"SERVICE10", "CC3", "SERVICE2", "CC1"}
+	slices.SortFunc(got, compareServices)
+	want := []Service{"CC1", "CC3", "SERVICE2", "SERVICE10"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected order (-want +got):\n%s", diff)
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add([]byte{0xFC, 0x94, 0x20, 0xFF, 0x02, 0x21, 0xFE, 0x98, 0x38})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var data []CCData
+		for ; len(b) >= 3; b = b[3:] {
+			data = append(data, CCData{Type: CCType(b[0] & 0x03), Data: [2]byte{b[1], b[2]}})
+		}
+		var d Decoder
+		d.Decode(data, func(Service, Cue) {})
+	})
+}
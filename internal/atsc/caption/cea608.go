@@ -0,0 +1,329 @@
+package caption
+
+import "math/bits"
+
+// The dimensions of the CEA-608 caption grid.
+const (
+	cea608Rows    = 15
+	cea608Columns = 32
+)
+
+type cea608Grid [cea608Rows][cea608Columns]cell
+
+// cea608LinePercent returns the vertical position of a row of the CEA-608
+// grid, which fills the middle 80% of the video.
+func cea608LinePercent(row int) int {
+	return 10 + row*80/cea608Rows
+}
+
+// cea608Field decodes the two data channels interleaved in a single field of
+// CEA-608 captions: CC1 and CC2 in field 1, or CC3 and CC4 in field 2.
+type cea608Field struct {
+	channels [2]cea608Channel
+	// current is the data channel selected by the most recent control code,
+	// which receives any characters that follow it.
+	current int
+	// lastControl is the most recent control code, which broadcasters send
+	// twice in a row for redundancy.
+	lastControl [2]byte
+}
+
+func (f *cea608Field) decode(field int, pair [2]byte, emit func(Service, Cue)) {
+	b1, b2 := pair[0], pair[1]
+	if !oddParity(b1) || !oddParity(b2) {
+		return
+	}
+	b1, b2 = b1&0x7F, b2&0x7F
+
+	switch {
+	case b1 == 0 && b2 == 0:
+		return // Padding.
+
+	case b1 < 0x10:
+		// Extended data services, which only appear in field 2, carry no
+		// captions.
+		f.lastControl = [2]byte{}
+		return
+
+	case b1 < 0x20:
+		if f.lastControl == [2]byte{b1, b2} {
+			f.lastControl = [2]byte{} // A third copy would be a new code.
+			return
+		}
+		f.lastControl = [2]byte{b1, b2}
+		f.current = int(b1 >> 3 & 0x01)
+		f.channels[f.current].control(b1&^0x08, b2)
+
+	default:
+		f.lastControl = [2]byte{}
+		ch := &f.channels[f.current]
+		ch.write(cea608BasicChar(b1))
+		if b2 >= 0x20 {
+			ch.write(cea608BasicChar(b2))
+		}
+	}
+
+	ch := &f.channels[f.current]
+	if cue := ch.displayCue(); cue != ch.cue {
+		ch.cue = cue
+		emit(cea608Service(2*field+f.current+1), cue)
+	}
+}
+
+func oddParity(b byte) bool {
+	return bits.OnesCount8(b)%2 == 1
+}
+
+type cea608Mode int
+
+const (
+	cea608PopOn cea608Mode = iota
+	cea608RollUp
+	cea608PaintOn
+)
+
+// cea608Channel holds the display state of a single CEA-608 data channel.
+type cea608Channel struct {
+	mode       cea608Mode
+	rollUpRows int
+	// text is set while the channel carries a text service (T1 through T4)
+	// instead of captions.
+	text bool
+
+	// displayed is on screen, while nondisplayed is built up off screen for
+	// pop-on captions.
+	displayed, nondisplayed cea608Grid
+
+	row, col int
+	style    style
+
+	cue Cue // The last cue emitted for the channel.
+}
+
+// control handles a control code, with the bit that selects the data channel
+// cleared from b1.
+func (ch *cea608Channel) control(b1, b2 byte) {
+	switch {
+	case b2 >= 0x40:
+		ch.preambleAddress(b1, b2)
+
+	case b1 == 0x11 && b2 >= 0x20 && b2 <= 0x2F:
+		// A mid-row code displays as a space, and changes the style of the
+		// characters that follow it.
+		ch.write(' ')
+		ch.style = style{italic: b2&0x0E == 0x0E, underline: b2&0x01 != 0}
+
+	case b1 == 0x11 && b2 >= 0x30 && b2 <= 0x3F:
+		ch.write(cea608SpecialChars[b2&0x0F])
+
+	case (b1 == 0x12 || b1 == 0x13) && b2 >= 0x20 && b2 <= 0x3F:
+		// An extended character replaces the standard character sent before it
+		// for the benefit of decoders that don't support it.
+		ch.backspace()
+		ch.write(cea608ExtendedChars[b1-0x12][b2-0x20])
+
+	case (b1 == 0x14 || b1 == 0x15) && b2 >= 0x20 && b2 <= 0x2F:
+		ch.command(b2)
+
+	case b1 == 0x17 && b2 >= 0x21 && b2 <= 0x23:
+		ch.col = min(ch.col+int(b2-0x20), cea608Columns-1)
+	}
+}
+
+// cea608PACRows maps the first byte of a preamble address code to the pair of
+// rows that it can address.
+var cea608PACRows = [8][2]int{
+	{10, 10}, {0, 1}, {2, 3}, {11, 12}, {13, 14}, {4, 5}, {6, 7}, {8, 9},
+}
+
+func (ch *cea608Channel) preambleAddress(b1, b2 byte) {
+	row := cea608PACRows[b1&0x07][b2>>5&0x01]
+	if ch.mode == cea608RollUp {
+		row = max(row, ch.rollUpRows-1)
+		if row != ch.row {
+			ch.moveRollUp(row)
+		}
+	}
+	ch.row = row
+
+	attr := b2 & 0x1F
+	ch.style = style{underline: attr&0x01 != 0}
+	if attr >= 0x10 {
+		ch.col = int(attr>>1&0x07) * 4
+	} else {
+		ch.col = 0
+		ch.style.italic = attr>>1 == 0x07
+	}
+}
+
+// moveRollUp moves the rows of a roll-up caption so that row becomes the base
+// row.
+func (ch *cea608Channel) moveRollUp(row int) {
+	var moved cea608Grid
+	for i := range ch.rollUpRows {
+		if from := ch.row - i; from >= 0 {
+			moved[row-i] = ch.displayed[from]
+		}
+	}
+	ch.displayed = moved
+}
+
+// The commands of the miscellaneous control codes. Commands that don't affect
+// the text of the captions, like flash on, are ignored.
+const (
+	cea608RCL = 0x20 // Resume caption loading.
+	cea608BS  = 0x21 // Backspace.
+	cea608DER = 0x24 // Delete to end of row.
+	cea608RU2 = 0x25 // Roll-up captions, 2 rows.
+	cea608RU3 = 0x26 // Roll-up captions, 3 rows.
+	cea608RU4 = 0x27 // Roll-up captions, 4 rows.
+	cea608RDC = 0x29 // Resume direct captioning.
+	cea608TR  = 0x2A // Text restart.
+	cea608RTD = 0x2B // Resume text display.
+	cea608EDM = 0x2C // Erase displayed memory.
+	cea608CR  = 0x2D // Carriage return.
+	cea608ENM = 0x2E // Erase non-displayed memory.
+	cea608EOC = 0x2F // End of caption.
+)
+
+func (ch *cea608Channel) command(cmd byte) {
+	switch cmd {
+	case cea608RCL:
+		ch.mode, ch.text = cea608PopOn, false
+
+	case cea608BS:
+		ch.backspace()
+
+	case cea608DER:
+		grid := ch.target()
+		for col := ch.col; col < cea608Columns; col++ {
+			grid[ch.row][col] = cell{}
+		}
+
+	case cea608RU2, cea608RU3, cea608RU4:
+		if ch.mode != cea608RollUp {
+			ch.displayed, ch.nondisplayed = cea608Grid{}, cea608Grid{}
+			ch.row = cea608Rows - 1
+		}
+		ch.mode, ch.text = cea608RollUp, false
+		ch.rollUpRows = int(cmd-cea608RU2) + 2
+		ch.row = max(ch.row, ch.rollUpRows-1)
+		ch.col = 0
+
+	case cea608RDC:
+		ch.mode, ch.text = cea608PaintOn, false
+
+	case cea608TR, cea608RTD:
+		ch.text = true
+
+	case cea608EDM:
+		ch.displayed = cea608Grid{}
+
+	case cea608CR:
+		if ch.mode == cea608RollUp && !ch.text {
+			ch.rollUp()
+		}
+
+	case cea608ENM:
+		ch.nondisplayed = cea608Grid{}
+
+	case cea608EOC:
+		ch.displayed, ch.nondisplayed = ch.nondisplayed, ch.displayed
+		ch.mode = cea608PopOn
+	}
+}
+
+// rollUp scrolls the rows of a roll-up caption up by one, clearing the base row
+// and any row outside of the caption.
+func (ch *cea608Channel) rollUp() {
+	top := ch.row - ch.rollUpRows + 1
+	for row := range cea608Rows {
+		if row < top || row > ch.row {
+			ch.displayed[row] = [cea608Columns]cell{}
+		}
+	}
+	copy(ch.displayed[top:ch.row], ch.displayed[top+1:ch.row+1])
+	ch.displayed[ch.row] = [cea608Columns]cell{}
+	ch.col = 0
+}
+
+// target returns the grid that characters are written to in the current mode.
+func (ch *cea608Channel) target() *cea608Grid {
+	if ch.mode == cea608PopOn {
+		return &ch.nondisplayed
+	}
+	return &ch.displayed
+}
+
+func (ch *cea608Channel) write(r rune) {
+	if ch.text {
+		return
+	}
+	col := min(ch.col, cea608Columns-1)
+	ch.target()[ch.row][col] = cell{r: r, style: ch.style}
+	ch.col = col + 1
+}
+
+func (ch *cea608Channel) backspace() {
+	if ch.col > 0 && !ch.text {
+		ch.col--
+		ch.target()[ch.row][ch.col] = cell{}
+	}
+}
+
+func (ch *cea608Channel) displayCue() Cue {
+	var cb cueBuilder
+	for row := range cea608Rows {
+		cb.addRow(ch.displayed[row][:], cea608LinePercent(row))
+	}
+	return cb.cue()
+}
+
+// cea608BasicChar returns the character for a byte of the standard CEA-608
+// character set, which mostly matches ASCII.
+func cea608BasicChar(b byte) rune {
+	switch b {
+	case 0x2A:
+		return 'á'
+	case 0x5C:
+		return 'é'
+	case 0x5E:
+		return 'í'
+	case 0x5F:
+		return 'ó'
+	case 0x60:
+		return 'ú'
+	case 0x7B:
+		return 'ç'
+	case 0x7C:
+		return '÷'
+	case 0x7D:
+		return 'Ñ'
+	case 0x7E:
+		return 'ñ'
+	case 0x7F:
+		return '█'
+	default:
+		return rune(b)
+	}
+}
+
+// cea608SpecialChars is the special character set, selected by the low 4 bits
+// of the second byte of the code.
+var cea608SpecialChars = [16]rune{
+	'®', '°', '½', '¿', '™', '¢', '£', '♪', 'à', ' ', 'è', 'â', 'ê', 'î', 'ô', 'û',
+}
+
+// cea608ExtendedChars holds the extended Spanish, French, and miscellaneous
+// characters, followed by the extended Portuguese, German, and Danish
+// characters.
+var cea608ExtendedChars = [2][32]rune{
+	{
+		'Á', 'É', 'Ó', 'Ú', 'Ü', 'ü', '‘', '¡', '*', '’', '—', '©', '℠', '•', '“', '”',
+		'À', 'Â', 'Ç', 'È', 'Ê', 'Ë', 'ë', 'Î', 'Ï', 'ï', 'Ô', 'Ù', 'ù', 'Û', '«', '»',
+	},
+	{
+		'Ã', 'ã', 'Í', 'Ì', 'ì', 'Ò', 'ò', 'Õ', 'õ', '{', '}', '\\', '^', '_', '|', '~',
+		'Ä', 'ä', 'Ö', 'ö', 'ß', '¥', '¤', '│', 'Å', 'å', 'Ø', 'ø', '┌', '┐', '└', '┘',
+	},
+}
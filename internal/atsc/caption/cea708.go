@@ -0,0 +1,409 @@
+package caption
+
+import (
+	"cmp"
+	"slices"
+)
+
+// dtvccDecoder reassembles the DTVCC packets of CEA-708 caption data, and
+// decodes the caption services that they carry.
+type dtvccDecoder struct {
+	packet   []byte
+	size     int // Of the current packet, or 0 between packets.
+	services map[int]*dtvccService
+}
+
+func (d *dtvccDecoder) decode(cc CCData, emit func(Service, Cue)) {
+	if cc.Type == CCTypeDTVCCStart {
+		// Any unfinished packet is lost.
+		d.packet = d.packet[:0]
+		d.size = int(cc.Data[0]&0x3F) * 2
+		if d.size == 0 {
+			d.size = 128
+		}
+	} else if d.size == 0 {
+		return // The start of the packet is lost.
+	}
+
+	d.packet = append(d.packet, cc.Data[:]...)
+	if len(d.packet) >= d.size {
+		d.decodePacket(d.packet[1:d.size], emit)
+		d.size = 0
+	}
+}
+
+// decodePacket decodes the service blocks of a packet, without its header.
+func (d *dtvccDecoder) decodePacket(b []byte, emit func(Service, Cue)) {
+	for len(b) > 0 {
+		number, size := int(b[0]>>5), int(b[0]&0x1F)
+		b = b[1:]
+		if number == 0 {
+			return // A null block fills the rest of the packet.
+		}
+		if number == 7 {
+			if len(b) < 1 {
+				return
+			}
+			number, b = int(b[0]&0x3F), b[1:]
+		}
+		if size > len(b) {
+			return
+		}
+
+		if d.services == nil {
+			d.services = make(map[int]*dtvccService)
+		}
+		svc, ok := d.services[number]
+		if !ok {
+			svc = &dtvccService{current: -1}
+			d.services[number] = svc
+		}
+		svc.decode(b[:size])
+		if cue := svc.displayCue(); cue != svc.cue {
+			svc.cue = cue
+			emit(cea708Service(number), cue)
+		}
+		b = b[size:]
+	}
+}
+
+// dtvccService holds the display state of a single CEA-708 caption service.
+type dtvccService struct {
+	windows [8]*dtvccWindow
+	current int // The index of the current window, or -1 if there is none.
+
+	cue Cue // The last cue emitted for the service.
+}
+
+// dtvccWindow represents a window of a CEA-708 caption service.
+type dtvccWindow struct {
+	visible  bool
+	priority int
+	// relative is set when anchorV is a percentage of the video's height,
+	// rather than a position in a grid of 75 rows.
+	relative bool
+	anchorV  int
+
+	cells    [][]cell
+	row, col int
+	style    style
+}
+
+func newDTVCCWindow(rows, cols int) *dtvccWindow {
+	w := &dtvccWindow{cells: make([][]cell, rows)}
+	for i := range w.cells {
+		w.cells[i] = make([]cell, cols)
+	}
+	return w
+}
+
+const (
+	cea708EXT1 = 0x10
+	cea708P16  = 0x18
+)
+
+// The C0 control codes that affect the text of the captions.
+const (
+	cea708BS  = 0x08 // Backspace.
+	cea708FF  = 0x0C // Form feed.
+	cea708CR  = 0x0D // Carriage return.
+	cea708HCR = 0x0E // Horizontal carriage return.
+)
+
+// The C1 caption commands.
+const (
+	cea708CW0 = 0x80 // Set current window, through CW7 at 0x87.
+	cea708CLW = 0x88 // Clear windows.
+	cea708DSW = 0x89 // Display windows.
+	cea708HDW = 0x8A // Hide windows.
+	cea708TGW = 0x8B // Toggle windows.
+	cea708DLW = 0x8C // Delete windows.
+	cea708DLY = 0x8D // Delay.
+	cea708RST = 0x8F // Reset.
+	cea708SPA = 0x90 // Set pen attributes.
+	cea708SPC = 0x91 // Set pen color.
+	cea708SPL = 0x92 // Set pen location.
+	cea708SWA = 0x97 // Set window attributes.
+	cea708DF0 = 0x98 // Define window, through DF7 at 0x9F.
+)
+
+// cea708C1Params returns the number of parameter bytes that follow a C1 code.
+func cea708C1Params(c byte) int {
+	switch {
+	case c >= cea708CLW && c <= cea708DLY:
+		return 1
+	case c == cea708SPA, c == cea708SPL:
+		return 2
+	case c == cea708SPC:
+		return 3
+	case c == cea708SWA:
+		return 4
+	case c >= cea708DF0:
+		return 6
+	default:
+		return 0
+	}
+}
+
+// decode decodes the data of a single service block. Commands that don't
+// affect the text of the captions, like those that set colors and delays, are
+// ignored.
+func (s *dtvccService) decode(b []byte) {
+	for len(b) > 0 {
+		c := b[0]
+		switch {
+		case c == cea708EXT1:
+			b = s.decodeExtended(b[1:])
+
+		case c < 0x20:
+			s.c0(c)
+			switch {
+			case c >= cea708P16:
+				b = skip(b, 3)
+			case c > cea708EXT1:
+				b = skip(b, 2)
+			default:
+				b = b[1:]
+			}
+
+		case c < 0x7F:
+			s.write(rune(c))
+			b = b[1:]
+
+		case c == 0x7F:
+			s.write('♪')
+			b = b[1:]
+
+		case c < 0xA0:
+			n := cea708C1Params(c)
+			if len(b) < 1+n {
+				return
+			}
+			s.c1(c, b[1:1+n])
+			b = b[1+n:]
+
+		default:
+			s.write(rune(c)) // G1 matches Latin-1.
+			b = b[1:]
+		}
+	}
+}
+
+// decodeExtended decodes the code following EXT1 at the start of b, and returns
+// the rest of b.
+func (s *dtvccService) decodeExtended(b []byte) []byte {
+	if len(b) < 1 {
+		return nil
+	}
+	c := b[0]
+	switch {
+	case c < 0x08:
+		return b[1:]
+	case c < 0x10:
+		return skip(b, 2)
+	case c < 0x18:
+		return skip(b, 3)
+	case c < 0x20:
+		return skip(b, 4)
+	case c < 0x80:
+		if r, ok := cea708G2[c]; ok {
+			s.write(r)
+		}
+		return b[1:]
+	case c < 0x88:
+		return skip(b, 5)
+	case c < 0x90:
+		return skip(b, 6)
+	case c < 0xA0:
+		if len(b) < 2 {
+			return nil
+		}
+		return skip(b, 2+int(b[1]&0x1F))
+	default:
+		s.write('_') // G3 holds only the [CC] icon, which has no Unicode form.
+		return b[1:]
+	}
+}
+
+func skip(b []byte, n int) []byte {
+	if n > len(b) {
+		return nil
+	}
+	return b[n:]
+}
+
+func (s *dtvccService) c0(c byte) {
+	w := s.currentWindow()
+	if w == nil {
+		return
+	}
+	switch c {
+	case cea708BS:
+		if w.col > 0 {
+			w.col--
+			w.cells[w.row][w.col] = cell{}
+		}
+	case cea708FF:
+		w.clear()
+		w.row, w.col = 0, 0
+	case cea708CR:
+		w.newline()
+	case cea708HCR:
+		clear(w.cells[w.row])
+		w.col = 0
+	}
+}
+
+func (s *dtvccService) c1(c byte, p []byte) {
+	switch {
+	case c >= cea708CW0 && c < cea708CLW:
+		if s.windows[c-cea708CW0] != nil {
+			s.current = int(c - cea708CW0)
+		}
+
+	case c == cea708CLW:
+		s.eachWindow(p[0], (*dtvccWindow).clear)
+	case c == cea708DSW:
+		s.eachWindow(p[0], func(w *dtvccWindow) { w.visible = true })
+	case c == cea708HDW:
+		s.eachWindow(p[0], func(w *dtvccWindow) { w.visible = false })
+	case c == cea708TGW:
+		s.eachWindow(p[0], func(w *dtvccWindow) { w.visible = !w.visible })
+	case c == cea708DLW:
+		for i := range s.windows {
+			if p[0]&(1<<i) != 0 {
+				s.windows[i] = nil
+				if s.current == i {
+					s.current = -1
+				}
+			}
+		}
+
+	case c == cea708RST:
+		*s = dtvccService{current: -1, cue: s.cue}
+
+	case c == cea708SPA:
+		if w := s.currentWindow(); w != nil {
+			w.style = style{italic: p[1]&0x80 != 0, underline: p[1]&0x40 != 0}
+		}
+
+	case c == cea708SPL:
+		if w := s.currentWindow(); w != nil {
+			w.row = min(int(p[0]&0x0F), len(w.cells)-1)
+			w.col = min(int(p[1]&0x3F), len(w.cells[0])-1)
+		}
+
+	case c >= cea708DF0:
+		s.defineWindow(int(c-cea708DF0), p)
+	}
+}
+
+func (s *dtvccService) eachWindow(bitmap byte, fn func(*dtvccWindow)) {
+	for i, w := range s.windows {
+		if w != nil && bitmap&(1<<i) != 0 {
+			fn(w)
+		}
+	}
+}
+
+func (s *dtvccService) defineWindow(id int, p []byte) {
+	rows, cols := int(p[3]&0x0F)+1, int(p[4]&0x3F)+1
+
+	// Broadcasters repeat window definitions for receivers that tune in late,
+	// so redefining a window keeps as much of its text as still fits.
+	w := s.windows[id]
+	if w == nil || len(w.cells) != rows || len(w.cells[0]) != cols {
+		resized := newDTVCCWindow(rows, cols)
+		if w != nil {
+			for i := range min(rows, len(w.cells)) {
+				copy(resized.cells[i], w.cells[i])
+			}
+			resized.row, resized.col = min(w.row, rows-1), min(w.col, cols)
+			resized.style = w.style
+		}
+		w = resized
+		s.windows[id] = w
+	}
+
+	w.visible = p[0]&0x20 != 0
+	w.priority = int(p[0] & 0x07)
+	w.relative = p[1]&0x80 != 0
+	w.anchorV = int(p[1] & 0x7F)
+	s.current = id
+}
+
+func (s *dtvccService) currentWindow() *dtvccWindow {
+	if s.current < 0 {
+		return nil
+	}
+	return s.windows[s.current]
+}
+
+func (s *dtvccService) write(r rune) {
+	w := s.currentWindow()
+	if w == nil {
+		return
+	}
+	if w.col >= len(w.cells[w.row]) {
+		w.newline()
+	}
+	w.cells[w.row][w.col] = cell{r: r, style: w.style}
+	w.col++
+}
+
+func (w *dtvccWindow) newline() {
+	w.col = 0
+	if w.row < len(w.cells)-1 {
+		w.row++
+		return
+	}
+	// The window scrolls when the pen moves past its last row.
+	first := w.cells[0]
+	copy(w.cells, w.cells[1:])
+	clear(first)
+	w.cells[len(w.cells)-1] = first
+}
+
+func (w *dtvccWindow) clear() {
+	for _, row := range w.cells {
+		clear(row)
+	}
+}
+
+// linePercent returns the vertical position of a row of the window.
+func (w *dtvccWindow) linePercent(row int) int {
+	top := w.anchorV
+	if !w.relative {
+		top = w.anchorV * 100 / 75
+	}
+	return top + row*80/15
+}
+
+func (s *dtvccService) displayCue() Cue {
+	var visible []*dtvccWindow
+	for _, w := range s.windows {
+		if w != nil && w.visible {
+			visible = append(visible, w)
+		}
+	}
+	slices.SortStableFunc(visible, func(a, b *dtvccWindow) int {
+		return cmp.Compare(a.priority, b.priority)
+	})
+
+	var cb cueBuilder
+	for _, w := range visible {
+		for i, row := range w.cells {
+			cb.addRow(row, w.linePercent(i))
+		}
+	}
+	return cb.cue()
+}
+
+// cea708G2 holds the characters of the G2 character set.
+var cea708G2 = map[byte]rune{
+	0x20: ' ', 0x21: ' ', 0x25: '…', 0x2A: 'Š', 0x2C: 'Œ',
+	0x30: '█', 0x31: '‘', 0x32: '’', 0x33: '“', 0x34: '”', 0x35: '•',
+	0x39: '™', 0x3A: 'š', 0x3C: 'œ', 0x3D: '℠', 0x3F: 'Ÿ',
+	0x76: '⅛', 0x77: '⅜', 0x78: '⅝', 0x79: '⅞',
+	0x7A: '│', 0x7B: '┐', 0x7C: '└', 0x7D: '─', 0x7E: '┘', 0x7F: '┌',
+}
@@ -0,0 +1,106 @@
+package caption
+
+import (
+	"bytes"
+	"iter"
+)
+
+// The values that identify A/53 caption data in an H.264 SEI message, which
+// carries it as ITU-T T.35 user data as ATSC A/72 describes.
+const (
+	h264NALTypeSEI             = 6
+	seiPayloadTypeUserDataT35  = 4
+	t35CountryCodeUnitedStates = 0xB5
+	t35ProviderCodeATSC        = 0x0031
+)
+
+var (
+	nalStartCode = []byte{0x00, 0x00, 0x01}
+	// emulationPrevention is the sequence that a NAL unit uses to escape what
+	// would otherwise look like a start code in its payload.
+	emulationPrevention = []byte{0x00, 0x00, 0x03}
+)
+
+// ExtractH264 returns the caption data carried in the SEI messages of H.264
+// video, from a buffer holding any number of complete access units in byte
+// stream format.
+func ExtractH264(video []byte) []CCData {
+	var data []CCData
+	for nal := range nalUnits(video) {
+		if len(nal) > 1 && nal[0]&0x1F == h264NALTypeSEI {
+			data = appendSEI(data, unescapeRBSP(nal[1:]))
+		}
+	}
+	return data
+}
+
+// nalUnits returns an iterator over the NAL units of a byte stream, without
+// their start codes.
+func nalUnits(stream []byte) iter.Seq[[]byte] {
+	return func(yield func([]byte) bool) {
+		i := bytes.Index(stream, nalStartCode)
+		for i >= 0 {
+			stream = stream[i+len(nalStartCode):]
+			i = bytes.Index(stream, nalStartCode)
+			nal := stream
+			if i >= 0 {
+				nal = stream[:i]
+			}
+			if !yield(nal) {
+				return
+			}
+		}
+	}
+}
+
+// unescapeRBSP removes the emulation prevention bytes from the payload of a
+// NAL unit.
+func unescapeRBSP(b []byte) []byte {
+	if !bytes.Contains(b, emulationPrevention) {
+		return b
+	}
+	rbsp := make([]byte, 0, len(b))
+	for {
+		i := bytes.Index(b, emulationPrevention)
+		if i < 0 {
+			return append(rbsp, b...)
+		}
+		rbsp = append(rbsp, b[:i+2]...)
+		b = b[i+3:]
+	}
+}
+
+// appendSEI appends the caption data from the messages of an SEI payload.
+func appendSEI(data []CCData, b []byte) []CCData {
+	for len(b) > 0 && b[0] != 0x80 { // rbsp_trailing_bits
+		var payloadType, payloadSize int
+		payloadType, b = seiValue(b)
+		payloadSize, b = seiValue(b)
+		if payloadSize > len(b) {
+			return data
+		}
+
+		payload := b[:payloadSize]
+		if payloadType == seiPayloadTypeUserDataT35 && len(payload) >= 3 &&
+			payload[0] == t35CountryCodeUnitedStates &&
+			int(payload[1])<<8|int(payload[2]) == t35ProviderCodeATSC {
+			data = appendA53(data, payload[3:])
+		}
+		b = b[payloadSize:]
+	}
+	return data
+}
+
+// seiValue parses the type or size of an SEI message, which is coded as a run
+// of 0xFF bytes that each add 255 to the value of the byte that ends the run.
+func seiValue(b []byte) (int, []byte) {
+	var v int
+	for len(b) > 0 && b[0] == 0xFF {
+		v += 255
+		b = b[1:]
+	}
+	if len(b) == 0 {
+		return v, nil
+	}
+	return v + int(b[0]), b[1:]
+}
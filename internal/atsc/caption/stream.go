@@ -0,0 +1,152 @@
+package caption
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/featherbread/hypcast/internal/atsc/psip"
+	"github.com/featherbread/hypcast/internal/watch"
+)
+
+// ServiceInfo describes a caption service that has appeared in a Stream.
+type ServiceInfo struct {
+	Service Service
+	// Language is the three letter ISO 639-2 code for the language of the
+	// service, if the broadcaster lists it.
+	Language   string
+	EasyReader bool
+}
+
+// Stream decodes the captions in the video of a single program, and shares the
+// cues of each caption service with any number of watchers.
+type Stream struct {
+	describe func() []psip.CaptionService
+
+	mu       sync.Mutex
+	decoder  Decoder
+	cues     map[Service]*watch.Value[Cue]
+	services *watch.Value[[]Service] // In compareServices order.
+}
+
+// NewStream creates a Stream. If describe is not nil, it returns the caption
+// services that the broadcaster lists for the program that is airing, which
+// the stream uses to learn the languages of its services.
+func NewStream(describe func() []psip.CaptionService) *Stream {
+	return &Stream{
+		describe: describe,
+		cues:     make(map[Service]*watch.Value[Cue]),
+		services: watch.NewValue[[]Service](nil),
+	}
+}
+
+// DecodeMPEG2 decodes the captions in MPEG-2 video, from a buffer holding any
+// number of complete pictures.
+func (s *Stream) DecodeMPEG2(video []byte) {
+	s.decode(ExtractMPEG2(video))
+}
+
+// DecodeH264 decodes the captions in H.264 video, from a buffer holding any
+// number of complete access units in byte stream format.
+func (s *Stream) DecodeH264(video []byte) {
+	s.decode(ExtractH264(video))
+}
+
+func (s *Stream) decode(data []CCData) {
+	if len(data) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.decoder.Decode(data, s.setCue)
+}
+
+func (s *Stream) setCue(svc Service, cue Cue) {
+	s.cueValue(svc).Set(cue)
+
+	services := s.services.Get()
+	if i, found := slices.BinarySearchFunc(services, svc, compareServices); !found {
+		s.services.Set(slices.Insert(slices.Clone(services), i, svc))
+	}
+}
+
+func (s *Stream) cueValue(svc Service) *watch.Value[Cue] {
+	v, ok := s.cues[svc]
+	if !ok {
+		v = watch.NewValue(Cue{})
+		s.cues[svc] = v
+	}
+	return v
+}
+
+// Services returns the caption services that have displayed anything in the
+// stream so far.
+func (s *Stream) Services() []ServiceInfo {
+	return s.describeServices(s.services.Get())
+}
+
+// WatchServices sets up a handler function to be called with the stream's
+// caption services whenever a new one appears. See the watch package
+// documentation for details.
+func (s *Stream) WatchServices(handler func([]ServiceInfo)) watch.Watch {
+	return s.services.Watch(func(services []Service) {
+		handler(s.describeServices(services))
+	})
+}
+
+func (s *Stream) describeServices(services []Service) []ServiceInfo {
+	var descriptions []psip.CaptionService
+	if s.describe != nil {
+		descriptions = s.describe()
+	}
+
+	infos := make([]ServiceInfo, len(services))
+	for i, svc := range services {
+		infos[i] = ServiceInfo{Service: svc}
+		for _, cs := range descriptions {
+			if ServiceFor(cs) == svc {
+				infos[i].Language, infos[i].EasyReader = cs.Language, cs.EasyReader
+				break
+			}
+		}
+	}
+	return infos
+}
+
+// ServiceForLanguage returns the caption service that the broadcaster lists
+// for the provided ISO 639-2 language code, preferring a service that has
+// appeared in the stream over one that has not.
+func (s *Stream) ServiceForLanguage(language string) (Service, bool) {
+	if s.describe == nil {
+		return "", false
+	}
+
+	var (
+		match Service
+		found bool
+	)
+	services := s.services.Get()
+	for _, cs := range s.describe() {
+		if cs.Language != language {
+			continue
+		}
+		svc := ServiceFor(cs)
+		if _, ok := slices.BinarySearchFunc(services, svc, compareServices); ok {
+			return svc, true
+		}
+		if !found {
+			match, found = svc, true
+		}
+	}
+	return match, found
+}
+
+// WatchCues sets up a handler function to continuously receive the cues that
+// a caption service displays, starting with its current cue. The service does
+// not need to have appeared in the stream. See the watch package documentation
+// for details.
+func (s *Stream) WatchCues(svc Service, handler func(Cue)) watch.Watch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cueValue(svc).Watch(handler)
+}
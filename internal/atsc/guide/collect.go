@@ -0,0 +1,216 @@
+package guide
+
+import (
+	"errors"
+	"io"
+
+	"github.com/featherbread/hypcast/internal/atsc"
+	"github.com/featherbread/hypcast/internal/atsc/mpegts"
+	"github.com/featherbread/hypcast/internal/atsc/psip"
+)
+
+// maxEITs limits the event information tables that Collect reads, from the
+// EIT-0 table for the current 3 hour time slot onward. Broadcasters must send
+// at least 4 tables, covering the next 9 to 12 hours.
+const maxEITs = 8
+
+// Collect reads the PSIP tables of the transport stream in r, and adds the
+// events that they describe to the guide, until r returns an error. The stream
+// must carry the multiplex of channel, whose programs the events belong to.
+//
+// Collect returns nil if r reaches the end of the stream, or the error that it
+// returns otherwise.
+func (g *Guide) Collect(r io.Reader, channel atsc.Channel) error {
+	c := collector{
+		guide:        g,
+		mux:          multiplexOf(channel),
+		sr:           mpegts.NewSectionReader(r),
+		gpsUTCOffset: psip.DefaultGPSUTCOffset,
+		tablePIDs:    make(map[mpegts.PID]struct{}),
+		channels:     make(map[uint16]*schedule),
+		versions:     make(map[sectionKey]uint8),
+		texts:        make(map[uint32]string),
+	}
+	c.sr.Deselect(mpegts.PIDPAT)
+	c.sr.Select(psip.PIDBase)
+
+	for {
+		pid, s, err := c.sr.ReadSection()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		c.handleSection(pid, s)
+	}
+}
+
+// collector holds the state of a single call to Collect.
+type collector struct {
+	guide        *Guide
+	mux          multiplex
+	sr           *mpegts.SectionReader
+	gpsUTCOffset uint8
+
+	// tablePIDs holds the PIDs of the EITs and ETTs listed in the MGT.
+	tablePIDs map[mpegts.PID]struct{}
+	// channels maps the source IDs of the multiplex's VCT to schedules.
+	channels map[uint16]*schedule
+	// versions holds the versions of the sections already handled, so that
+	// unchanged tables aren't parsed again each time they repeat.
+	versions map[sectionKey]uint8
+	// texts holds the descriptions of events whose ETTs arrived before their
+	// EITs, by ETM ID.
+	texts map[uint32]string
+}
+
+type sectionKey struct {
+	pid       mpegts.PID
+	tableID   uint8
+	extension uint16
+	number    uint8
+}
+
+// maxPendingTexts bounds the descriptions that a collector holds for events
+// that it hasn't seen.
+const maxPendingTexts = 1024
+
+func (c *collector) handleSection(pid mpegts.PID, s mpegts.Section) {
+	if !s.CurrentNext {
+		return
+	}
+
+	key := sectionKey{pid, s.TableID, s.TableIDExtension, s.SectionNumber}
+	if s.TableID != psip.TableIDSTT && s.TableID != psip.TableIDETT {
+		// ETTs don't use versions to signal changes, and the STT changes
+		// continuously without changing its version.
+		if version, ok := c.versions[key]; ok && version == s.Version {
+			return
+		}
+	}
+
+	var handled bool
+	switch {
+	case pid == psip.PIDBase && s.TableID == psip.TableIDMGT:
+		handled = c.handleMGT(s)
+	case pid == psip.PIDBase && (s.TableID == psip.TableIDTVCT || s.TableID == psip.TableIDCVCT):
+		handled = c.handleVCT(s)
+	case pid == psip.PIDBase && s.TableID == psip.TableIDSTT:
+		if stt, err := psip.ParseSTT(s); err == nil {
+			c.gpsUTCOffset = stt.GPSUTCOffset
+		}
+	case s.TableID == psip.TableIDEIT:
+		handled = c.handleEIT(s)
+	case s.TableID == psip.TableIDETT:
+		c.handleETT(s)
+	}
+	if handled {
+		c.versions[key] = s.Version
+	}
+}
+
+func (c *collector) handleMGT(s mpegts.Section) bool {
+	mgt, err := psip.ParseMGT(s)
+	if err != nil {
+		return false
+	}
+
+	pids := make(map[mpegts.PID]struct{})
+	for _, table := range mgt.Tables {
+		if index, ok := table.Type.EIT(); ok && index < maxEITs {
+			pids[table.PID] = struct{}{}
+		}
+		if index, ok := table.Type.EventETT(); ok && index < maxEITs {
+			pids[table.PID] = struct{}{}
+		}
+	}
+
+	for pid := range c.tablePIDs {
+		if _, ok := pids[pid]; !ok && pid != psip.PIDBase {
+			c.sr.Deselect(pid)
+		}
+	}
+	for pid := range pids {
+		c.sr.Select(pid)
+	}
+	c.tablePIDs = pids
+	return true
+}
+
+func (c *collector) handleVCT(s mpegts.Section) bool {
+	vct, err := psip.ParseVCT(s)
+	if err != nil {
+		return false
+	}
+
+	c.guide.mu.Lock()
+	defer c.guide.mu.Unlock()
+	for _, vc := range vct.Channels {
+		c.channels[vc.SourceID] = c.guide.addChannel(c.mux, uint(vc.ProgramNumber))
+	}
+	return true
+}
+
+func (c *collector) handleEIT(s mpegts.Section) bool {
+	eit, err := psip.ParseEIT(s)
+	if err != nil {
+		return false
+	}
+	sch, ok := c.channels[eit.SourceID]
+	if !ok {
+		// The EIT can't be used until the VCT identifies its channel, and
+		// will be parsed again when it repeats.
+		return false
+	}
+
+	c.guide.mu.Lock()
+	defer c.guide.mu.Unlock()
+
+	var changed bool
+	for _, pe := range eit.Events {
+		etmID := psip.EventETMID(eit.SourceID, pe.EventID)
+		e := Event{
+			Start:       psip.GPSTime(pe.StartTime, c.gpsUTCOffset),
+			Duration:    pe.Duration,
+			Title:       pe.Title.Lookup("eng"),
+			Description: c.texts[etmID],
+		}
+		if d, ok := mpegts.FindDescriptor(pe.Descriptors, psip.DescriptorTagCaptionService); ok {
+			e.Captions, _ = psip.ParseCaptionServices(d)
+		}
+		delete(c.texts, etmID)
+		if c.guide.addEvent(sch, eventKey{eit.SourceID, pe.EventID}, e) {
+			changed = true
+		}
+	}
+	if changed {
+		c.guide.notify()
+	}
+	return true
+}
+
+func (c *collector) handleETT(s mpegts.Section) {
+	ett, err := psip.ParseETT(s)
+	if err != nil || ett.ETMID&0x03 != 0x02 {
+		return // Only event descriptions are used.
+	}
+
+	id := eventKey{SourceID: uint16(ett.ETMID >> 16), EventID: uint16(ett.ETMID >> 2 & 0x3FFF)}
+	text := ett.Text.Lookup("eng")
+
+	c.guide.mu.Lock()
+	defer c.guide.mu.Unlock()
+
+	if sch, ok := c.channels[id.SourceID]; ok {
+		if _, ok := sch.events[id]; ok {
+			if c.guide.setDescription(sch, id, text) {
+				c.guide.notify()
+			}
+			return
+		}
+	}
+	if len(c.texts) < maxPendingTexts {
+		c.texts[ett.ETMID] = text
+	}
+}
@@ -0,0 +1,208 @@
+// Package guide maintains an electronic program guide built from the event
+// information that ATSC broadcasters send in PSIP tables.
+package guide
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/featherbread/hypcast/internal/atsc"
+	"github.com/featherbread/hypcast/internal/atsc/psip"
+	"github.com/featherbread/hypcast/internal/watch"
+)
+
+// Event represents a single program in the guide.
+type Event struct {
+	Start    time.Time
+	Duration time.Duration
+	Title    string
+	// Description is the long description of the event from its extended text
+	// table, which may arrive later than the rest of the event or not at all.
+	Description string
+	// Captions describes the closed caption services that accompany the
+	// event, if the broadcaster lists them.
+	Captions []psip.CaptionService
+}
+
+// End returns the time at which the event ends.
+func (e Event) End() time.Time {
+	return e.Start.Add(e.Duration)
+}
+
+func (e Event) equal(other Event) bool {
+	return e.Start.Equal(other.Start) &&
+		e.Duration == other.Duration &&
+		e.Title == other.Title &&
+		e.Description == other.Description &&
+		slices.Equal(e.Captions, other.Captions)
+}
+
+// multiplex identifies the transport stream that carries a channel. Over the
+// air and cable channels can share numbers, but never a frequency and
+// modulation.
+type multiplex struct {
+	FrequencyHz uint
+	Modulation  atsc.Modulation
+	File        string
+}
+
+func multiplexOf(channel atsc.Channel) multiplex {
+	return multiplex{channel.FrequencyHz, channel.Modulation, channel.File}
+}
+
+// program identifies a program by its multiplex and its program number.
+type program struct {
+	multiplex
+	ProgramNumber uint
+}
+
+// eventKey identifies an event by the source ID of its channel and its event
+// ID, which is unique only among the events of the source.
+type eventKey struct {
+	SourceID, EventID uint16
+}
+
+// retention is how long the guide keeps events after they end.
+const retention = time.Hour
+
+// Guide stores the events of virtual channels, and notifies watchers when they
+// change. The zero value is not valid; use New to create a Guide.
+type Guide struct {
+	mu        sync.Mutex
+	schedules map[program]*schedule
+	revision  *watch.Value[uint64]
+}
+
+// schedule holds the events of a single virtual channel.
+type schedule struct {
+	events map[eventKey]Event
+}
+
+// New creates an empty Guide.
+func New() *Guide {
+	return &Guide{
+		schedules: make(map[program]*schedule),
+		revision:  watch.NewValue[uint64](0),
+	}
+}
+
+// Watch sets up a handler function to be called whenever the events in the
+// guide change. See the watch package documentation for details.
+func (g *Guide) Watch(handler func()) watch.Watch {
+	return g.revision.Watch(func(uint64) { handler() })
+}
+
+// Events returns the events of channel that overlap the window between from
+// and to, ordered by their start times.
+func (g *Guide) Events(channel atsc.Channel, from, to time.Time) []Event {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	sch := g.lookup(channel)
+	if sch == nil {
+		return nil
+	}
+
+	var events []Event
+	for _, e := range sch.events {
+		if e.Start.Before(to) && e.End().After(from) {
+			events = append(events, e)
+		}
+	}
+	slices.SortFunc(events, func(a, b Event) int { return a.Start.Compare(b.Start) })
+	return events
+}
+
+// NowNext returns the event of channel that is airing at the provided time, and
+// the event that follows it. Either result is nil if the guide has no such
+// event.
+func (g *Guide) NowNext(channel atsc.Channel, at time.Time) (now, next *Event) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	sch := g.lookup(channel)
+	if sch == nil {
+		return nil, nil
+	}
+
+	for _, e := range sch.events {
+		switch {
+		case !e.Start.After(at) && e.End().After(at):
+			now = &e
+		case e.Start.After(at) && (next == nil || e.Start.Before(next.Start)):
+			next = &e
+		}
+	}
+	return now, next
+}
+
+// lookup returns the schedule for channel, by its multiplex and program
+// number.
+func (g *Guide) lookup(channel atsc.Channel) *schedule {
+	return g.schedules[program{multiplexOf(channel), channel.ProgramID}]
+}
+
+// addChannel returns the schedule for a program of mux, creating it if
+// necessary.
+//
+// The schedule keeps the events of every source ID that the program has
+// carried, so that a multiplex whose tables disagree on the source ID doesn't
+// replace the schedule each time they repeat. The events of a replaced source
+// give way to the new source's events as they overlap.
+func (g *Guide) addChannel(mux multiplex, programNumber uint) *schedule {
+	key := program{mux, programNumber}
+	sch, ok := g.schedules[key]
+	if !ok {
+		sch = &schedule{events: make(map[eventKey]Event)}
+		g.schedules[key] = sch
+	}
+	return sch
+}
+
+// addEvent stores an event in sch, replacing any previous version of the event
+// along with any other events that it overlaps (as when a station changes its
+// schedule). It reports whether the schedule changed.
+func (g *Guide) addEvent(sch *schedule, id eventKey, e Event) bool {
+	if old, ok := sch.events[id]; ok {
+		e.Description = cmp.Or(e.Description, old.Description)
+		if old.equal(e) {
+			return false
+		}
+	}
+
+	for otherID, other := range sch.events {
+		if otherID != id && other.Start.Before(e.End()) && other.End().After(e.Start) {
+			delete(sch.events, otherID)
+		}
+	}
+	sch.events[id] = e
+	g.expire(sch, time.Now())
+	return true
+}
+
+// setDescription sets the description of an event in sch, and reports whether
+// the event exists and changed.
+func (g *Guide) setDescription(sch *schedule, id eventKey, description string) bool {
+	e, ok := sch.events[id]
+	if !ok || e.Description == description {
+		return false
+	}
+	e.Description = description
+	sch.events[id] = e
+	return true
+}
+
+func (g *Guide) expire(sch *schedule, now time.Time) {
+	for id, e := range sch.events {
+		if now.Sub(e.End()) > retention {
+			delete(sch.events, id)
+		}
+	}
+}
+
+// notify informs watchers that the guide has changed.
+func (g *Guide) notify() {
+	g.revision.Set(g.revision.Get() + 1)
+}
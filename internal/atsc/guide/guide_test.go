@@ -0,0 +1,253 @@
+package guide
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/featherbread/hypcast/internal/atsc"
+	"github.com/featherbread/hypcast/internal/atsc/mpegts"
+	"github.com/featherbread/hypcast/internal/atsc/mpegts/mpegtstest"
+	"github.com/featherbread/hypcast/internal/atsc/psip"
+)
+
+const (
+	testFrequencyHz = 189_000_000
+	testEITPID      = 0x1D00
+	testETTPID      = 0x1E00
+)
+
+var (
+	kcts = atsc.Channel{Name: "KCTS-HD", FrequencyHz: testFrequencyHz, ProgramID: 3, MajorNumber: 9, MinorNumber: 1}
+	kids = atsc.Channel{Name: "KIDS", FrequencyHz: testFrequencyHz, ProgramID: 4, MajorNumber: 9, MinorNumber: 2}
+)
+
+func TestCollect(t *testing.T) {
+	// The guide only keeps recent events, so the test schedule has to be
+	// built around the current time.
+	slot := time.Now().Truncate(time.Hour)
+	start := gpsSeconds(slot)
+
+	var stream []byte
+	stream = append(stream, mpegtstest.Packetize(psip.PIDBase, makeMGT())...)
+	// The EIT for KIDS arrives before the VCT, and must be picked up when it
+	// repeats.
+	stream = append(stream, mpegtstest.Packetize(testEITPID, makeEIT(2, 0, []testEvent{{1, start, 7200, "Curious George"}}))...)
+	stream = append(stream, mpegtstest.Packetize(psip.PIDBase, makeVCT())...)
+	// The description for the second event arrives before its EIT.
+	stream = append(stream, mpegtstest.Packetize(testETTPID, makeETT(1, 2, "Penguins."))...)
+	stream = append(stream, mpegtstest.Packetize(testEITPID, makeEIT(1, 0, []testEvent{
+		{1, start, 3600, "Newshour"},
+		{2, start + 3600, 1800, "Nature"},
+		{3, start + 5400, 1800, "Nova"},
+	}))...)
+	stream = append(stream, mpegtstest.Packetize(testETTPID, makeETT(1, 1, "The day's news."))...)
+	stream = append(stream, mpegtstest.Packetize(testEITPID, makeEIT(2, 0, []testEvent{{1, start, 7200, "Curious George"}}))...)
+	// A new version of the schedule replaces the last event with a longer
+	// one, which must remove the event that it overlaps.
+	stream = append(stream, mpegtstest.Packetize(testEITPID, makeEIT(1, 1, []testEvent{
+		{1, start, 3600, "Newshour"},
+		{2, start + 3600, 1800, "Nature"},
+		{4, start + 5400, 3600, "Frontline"},
+	}))...)
+
+	g := New()
+	if err := g.Collect(bytes.NewReader(stream), kcts); err != nil {
+		t.Fatalf("Collect() error: %v", err)
+	}
+
+	captions := []psip.CaptionService{{Language: "eng", Digital: true, ServiceNumber: 1}}
+	got := g.Events(kcts, slot, slot.Add(3*time.Hour))
+	want := []Event{
+		{Start: slot, Duration: time.Hour, Title: "Newshour", Description: "The day's news.", Captions: captions},
+		{Start: slot.Add(time.Hour), Duration: 30 * time.Minute, Title: "Nature", Description: "Penguins.", Captions: captions},
+		{Start: slot.Add(90 * time.Minute), Duration: time.Hour, Title: "Frontline", Captions: captions},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected events for KCTS (-want +got):\n%s", diff)
+	}
+
+	if got := g.Events(kcts, slot.Add(time.Hour), slot.Add(80*time.Minute)); len(got) != 1 || got[0].Title != "Nature" {
+		t.Errorf("unexpected events in narrow window: %v", got)
+	}
+
+	// Channels are found by their multiplex and program, whether or not
+	// their numbers are known.
+	unnumbered := atsc.Channel{Name: "KIDS", FrequencyHz: testFrequencyHz, ProgramID: 4}
+	if got := g.Events(unnumbered, slot, slot.Add(time.Hour)); len(got) != 1 || got[0].Title != "Curious George" {
+		t.Errorf("unexpected events for unnumbered channel: %v", got)
+	}
+	if got := g.Events(atsc.Channel{MajorNumber: 2, MinorNumber: 1}, slot, slot.Add(time.Hour)); got != nil {
+		t.Errorf("unexpected events for unknown channel: %v", got)
+	}
+}
+
+func TestCollectMultiplexes(t *testing.T) {
+	slot := time.Now().Truncate(time.Hour)
+	start := gpsSeconds(slot)
+
+	collect := func(g *Guide, channel atsc.Channel, sections ...[]byte) {
+		t.Helper()
+		var stream []byte
+		stream = append(stream, mpegtstest.Packetize(psip.PIDBase, makeMGT())...)
+		for _, s := range sections {
+			pid := psip.PIDBase
+			if s[0] == psip.TableIDEIT {
+				pid = testEITPID
+			}
+			stream = append(stream, mpegtstest.Packetize(pid, s)...)
+		}
+		if err := g.Collect(bytes.NewReader(stream), channel); err != nil {
+			t.Fatalf("Collect() error: %v", err)
+		}
+	}
+
+	// A cable system carries channels with the same numbers as the broadcast
+	// ones, on the same frequency with a different modulation.
+	g := New()
+	cable := kcts
+	cable.Modulation = atsc.ModulationQAM256
+	collect(g, kcts, makeVCT(), makeEIT(1, 0, []testEvent{{1, start, 3600, "Broadcast"}}))
+	collect(g, cable, makeVCT(), makeEIT(1, 0, []testEvent{{1, start, 3600, "Cable"}}))
+	if got := g.Events(kcts, slot, slot.Add(time.Hour)); len(got) != 1 || got[0].Title != "Broadcast" {
+		t.Errorf("unexpected events for broadcast channel: %v", got)
+	}
+	if got := g.Events(cable, slot, slot.Add(time.Hour)); len(got) != 1 || got[0].Title != "Cable" {
+		t.Errorf("unexpected events for cable channel: %v", got)
+	}
+
+	// A multiplex whose tables give a program two source IDs keeps the events
+	// of both, rather than starting over each time the tables repeat.
+	g = New()
+	collect(g, kcts,
+		makeVCT(), makeEIT(1, 0, []testEvent{{1, start, 3600, "First"}}),
+		makeVCTWithSourceIDs(7, 8), makeEIT(7, 0, []testEvent{{1, start + 3600, 3600, "Second"}}),
+		makeVCT(),
+	)
+	got := g.Events(kcts, slot, slot.Add(2*time.Hour))
+	if len(got) != 2 || got[0].Title != "First" || got[1].Title != "Second" {
+		t.Errorf("unexpected events after source ID change: %v", got)
+	}
+}
+
+func TestNowNext(t *testing.T) {
+	g := New()
+	slot := time.Now().Truncate(time.Hour)
+
+	g.mu.Lock()
+	sch := g.addChannel(multiplexOf(kids), 4)
+	g.addEvent(sch, eventKey{2, 1}, Event{Start: slot, Duration: time.Hour, Title: "First"})
+	g.addEvent(sch, eventKey{2, 2}, Event{Start: slot.Add(90 * time.Minute), Duration: time.Hour, Title: "Third"})
+	g.addEvent(sch, eventKey{2, 3}, Event{Start: slot.Add(time.Hour), Duration: 30 * time.Minute, Title: "Second"})
+	g.mu.Unlock()
+
+	testCases := []struct {
+		at        time.Time
+		now, next string
+	}{
+		{at: slot, now: "First", next: "Second"},
+		{at: slot.Add(59 * time.Minute), now: "First", next: "Second"},
+		{at: slot.Add(time.Hour), now: "Second", next: "Third"},
+		{at: slot.Add(3 * time.Hour), now: "", next: ""},
+		{at: slot.Add(-time.Minute), now: "", next: "First"},
+	}
+	for _, tc := range testCases {
+		now, next := g.NowNext(kids, tc.at)
+		if title(now) != tc.now || title(next) != tc.next {
+			t.Errorf(
+				"NowNext(%v) = %q, %q; want %q, %q",
+				tc.at.Sub(slot), title(now), title(next), tc.now, tc.next,
+			)
+		}
+	}
+}
+
+func title(e *Event) string {
+	if e == nil {
+		return ""
+	}
+	return e.Title
+}
+
+func gpsSeconds(t time.Time) uint32 {
+	return uint32(t.Sub(psip.GPSEpoch)/time.Second) + psip.DefaultGPSUTCOffset
+}
+
+func makeMGT() []byte {
+	mgt := []byte{0x00, 0x00, 0x02} // Protocol version, 2 tables.
+	for _, table := range []struct {
+		tableType uint16
+		pid       mpegts.PID
+	}{{0x0100, testEITPID}, {0x0200, testETTPID}} {
+		mgt = binary.BigEndian.AppendUint16(mgt, table.tableType)
+		mgt = binary.BigEndian.AppendUint16(mgt, 0xE000|uint16(table.pid))
+		mgt = append(mgt, 0xE0)                          // Version 0.
+		mgt = binary.BigEndian.AppendUint32(mgt, 0)      // Size.
+		mgt = binary.BigEndian.AppendUint16(mgt, 0xF000) // Descriptors length.
+	}
+	mgt = binary.BigEndian.AppendUint16(mgt, 0xF000)
+	return mpegtstest.Section(psip.TableIDMGT, 0, 0, mgt)
+}
+
+func makeVCT() []byte {
+	return makeVCTWithSourceIDs(1, 2)
+}
+
+// makeVCTWithSourceIDs makes a VCT for KCTS-HD and KIDS whose version changes
+// along with their source IDs.
+func makeVCTWithSourceIDs(sourceIDs ...uint16) []byte {
+	vct := []byte{0x00, 0x02} // Protocol version, 2 channels.
+	for i, name := range []string{"KCTS-HD", "KIDS"} {
+		short := make([]byte, 14)
+		for j, r := range name {
+			binary.BigEndian.PutUint16(short[2*j:], uint16(r))
+		}
+		vct = append(vct, short...)
+		vct = binary.BigEndian.AppendUint32(vct, 0xF0000000|9<<18|uint32(i+1)<<8|0x04)
+		vct = binary.BigEndian.AppendUint32(vct, 0)            // Carrier frequency.
+		vct = binary.BigEndian.AppendUint16(vct, 1)            // Channel TSID.
+		vct = binary.BigEndian.AppendUint16(vct, uint16(i+3))  // Program number.
+		vct = binary.BigEndian.AppendUint16(vct, 0x0DC2)       // Digital TV.
+		vct = binary.BigEndian.AppendUint16(vct, sourceIDs[i]) // Source ID.
+		vct = binary.BigEndian.AppendUint16(vct, 0xFC00)       // Descriptors length.
+	}
+	vct = binary.BigEndian.AppendUint16(vct, 0xFC00)
+	return mpegtstest.Section(psip.TableIDTVCT, 1, uint8(sourceIDs[0]-1), vct)
+}
+
+type testEvent struct {
+	id      uint16
+	start   uint32
+	seconds uint32
+	title   string
+}
+
+func makeEIT(sourceID uint16, version uint8, events []testEvent) []byte {
+	eit := []byte{0x00, byte(len(events))}
+	for _, e := range events {
+		title := makeMultipleString(e.title)
+		eit = binary.BigEndian.AppendUint16(eit, 0xC000|e.id)
+		eit = binary.BigEndian.AppendUint32(eit, e.start)
+		eit = binary.BigEndian.AppendUint32(eit, (0xD00000|e.seconds)<<8|uint32(len(title)))
+		eit = append(eit, title...)
+		// Every event has English captions on CEA-708 service 1.
+		captions := []byte{psip.DescriptorTagCaptionService, 7, 0xE1, 'e', 'n', 'g', 0xC1, 0x3F, 0xFF}
+		eit = binary.BigEndian.AppendUint16(eit, 0xF000|uint16(len(captions)))
+		eit = append(eit, captions...)
+	}
+	return mpegtstest.Section(psip.TableIDEIT, sourceID, version, eit)
+}
+
+func makeETT(sourceID, eventID uint16, text string) []byte {
+	ett := binary.BigEndian.AppendUint32([]byte{0x00}, psip.EventETMID(sourceID, eventID))
+	ett = append(ett, makeMultipleString(text)...)
+	return mpegtstest.Section(psip.TableIDETT, 0, 0, ett)
+}
+
+func makeMultipleString(text string) []byte {
+	b := []byte{0x01, 'e', 'n', 'g', 0x01, 0x00, 0x00, byte(len(text))}
+	return append(b, text...)
+}
@@ -0,0 +1,107 @@
+package mpegts
+
+import (
+	"errors"
+)
+
+// Descriptor represents a descriptor from a descriptor loop of a PSI table.
+type Descriptor struct {
+	Tag uint8
+	// Data contains the contents of the descriptor, without its tag or length.
+	Data []byte
+}
+
+// The following are the tags of the descriptors that this package can parse.
+const (
+	DescriptorTagRegistration uint8 = 0x05
+	DescriptorTagISO639       uint8 = 0x0A
+	DescriptorTagAVCVideo     uint8 = 0x28
+)
+
+// ErrInvalidDescriptor is returned when parsing a malformed descriptor.
+var ErrInvalidDescriptor error = errors.New("invalid descriptor")
+
+// ParseDescriptors parses a loop of descriptors that fills b. The Data of each
+// returned descriptor aliases b.
+func ParseDescriptors(b []byte) ([]Descriptor, error) {
+	var descriptors []Descriptor
+	for len(b) > 0 {
+		if len(b) < 2 || 2+int(b[1]) > len(b) {
+			return nil, ErrInvalidDescriptor
+		}
+		length := int(b[1])
+		descriptors = append(descriptors, Descriptor{Tag: b[0], Data: b[2 : 2+length]})
+		b = b[2+length:]
+	}
+	return descriptors, nil
+}
+
+// FindDescriptor returns the first descriptor in descriptors with the provided
+// tag.
+func FindDescriptor(descriptors []Descriptor, tag uint8) (d Descriptor, ok bool) {
+	for _, d := range descriptors {
+		if d.Tag == tag {
+			return d, true
+		}
+	}
+	return Descriptor{}, false
+}
+
+// ParseRegistration returns the format identifier of a registration
+// descriptor, such as "AC-3" or "GA94".
+func ParseRegistration(d Descriptor) (string, error) {
+	if d.Tag != DescriptorTagRegistration || len(d.Data) < 4 {
+		return "", ErrInvalidDescriptor
+	}
+	return string(d.Data[:4]), nil
+}
+
+// AudioType classifies the audio in a stream with an ISO 639 language
+// descriptor.
+type AudioType uint8
+
+// The following are the audio types defined by ISO/IEC 13818-1.
+const (
+	AudioTypeUndefined       AudioType = 0x00
+	AudioTypeCleanEffects    AudioType = 0x01
+	AudioTypeHearingImpaired AudioType = 0x02
+	AudioTypeVisualImpaired  AudioType = 0x03
+)
+
+// Language represents a single entry of an ISO 639 language descriptor.
+type Language struct {
+	// Code is the three letter ISO 639-2 code for the language, such as "eng"
+	// or "spa".
+	Code string
+	Type AudioType
+}
+
+// ParseISO639Language returns the entries of an ISO 639 language descriptor.
+func ParseISO639Language(d Descriptor) ([]Language, error) {
+	if d.Tag != DescriptorTagISO639 || len(d.Data)%4 != 0 {
+		return nil, ErrInvalidDescriptor
+	}
+	var languages []Language
+	for b := d.Data; len(b) >= 4; b = b[4:] {
+		languages = append(languages, Language{Code: string(b[:3]), Type: AudioType(b[3])})
+	}
+	return languages, nil
+}
+
+// AVCVideo represents an AVC video descriptor, which gives the profile and
+// level of an H.264 video stream.
+type AVCVideo struct {
+	ProfileIDC uint8
+	// Constraints holds the constraint set flags of the stream, in the same
+	// form as the second byte of an RFC 6184 profile-level-id.
+	Constraints uint8
+	LevelIDC    uint8
+}
+
+// ParseAVCVideo parses an AVC video descriptor.
+func ParseAVCVideo(d Descriptor) (AVCVideo, error) {
+	if d.Tag != DescriptorTagAVCVideo || len(d.Data) < 4 {
+		return AVCVideo{}, ErrInvalidDescriptor
+	}
+	return AVCVideo{ProfileIDC: d.Data[0], Constraints: d.Data[1], LevelIDC: d.Data[2]}, nil
+}
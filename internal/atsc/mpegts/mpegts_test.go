@@ -0,0 +1,356 @@
+package mpegts_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/featherbread/hypcast/internal/atsc/mpegts"
+	"github.com/featherbread/hypcast/internal/atsc/mpegts/mpegtstest"
+)
+
+func TestParsePacket(t *testing.T) {
+	testCases := []struct {
+		name    string
+		packet  []byte
+		want    mpegts.Packet
+		wantErr bool
+	}{
+		{
+			name:   "payload only",
+			packet: mpegtstest.Packet([]byte{0x47, 0x40, 0x31, 0x17}, []byte{0xAA}),
+			want: mpegts.Packet{
+				PID:               0x31,
+				PayloadUnitStart:  true,
+				ContinuityCounter: 7,
+				Payload:           append([]byte{0xAA}, bytes.Repeat([]byte{0xFF}, mpegts.PacketSize-5)...),
+			},
+		},
+		{
+			name:   "adaptation field and payload",
+			packet: mpegtstest.Packet([]byte{0x47, 0x00, 0x44, 0x33, 0x01, 0x50}, []byte{0xBB}),
+			want: mpegts.Packet{
+				PID:               0x44,
+				ContinuityCounter: 3,
+				AdaptationField:   []byte{0x50},
+				Payload:           append([]byte{0xBB}, bytes.Repeat([]byte{0xFF}, mpegts.PacketSize-7)...),
+			},
+		},
+		{
+			name:   "adaptation field only",
+			packet: mpegtstest.Packet([]byte{0x47, 0x9F, 0xFF, 0xE0, 0xB7}, nil),
+			want: mpegts.Packet{
+				PID:             mpegts.PIDNull,
+				TransportError:  true,
+				Scrambled:       true,
+				AdaptationField: bytes.Repeat([]byte{0xFF}, mpegts.PacketSize-5),
+			},
+		},
+		{
+			name:    "bad sync byte",
+			packet:  mpegtstest.Packet([]byte{0x46, 0x00, 0x00, 0x10}, nil),
+			wantErr: true,
+		},
+		{
+			name:    "adaptation field overflow",
+			packet:  mpegtstest.Packet([]byte{0x47, 0x00, 0x00, 0x30, 0xFF}, nil),
+			wantErr: true,
+		},
+		{
+			name:    "short packet",
+			packet:  []byte{0x47, 0x00, 0x00, 0x10},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := mpegts.ParsePacket(tc.packet)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParsePacket() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected packet (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPacketReaderResync(t *testing.T) {
+	var stream []byte
+	stream = append(stream, 0x00, 0x12, 0x34) // Garbage before the first packet.
+	stream = append(stream, mpegtstest.Packetize(0x100, []byte{0x01})...)
+	stream = append(stream, 0x47, 0xFF) // A truncated packet...
+	stream = append(stream, mpegtstest.Packetize(0x101, []byte{0x02})...)
+
+	pr := mpegts.NewPacketReader(bytes.NewReader(stream))
+	var pids []mpegts.PID
+	for {
+		p, err := pr.ReadPacket()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("ReadPacket() unexpected error: %v", err)
+			}
+			break
+		}
+		pids = append(pids, p.PID)
+	}
+
+	// ...which swallows the start of the packet that follows it, so the reader
+	// must find its way back to the real packet boundaries afterward.
+	if len(pids) == 0 || pids[0] != 0x100 {
+		t.Errorf("ReadPacket() got PIDs %v, want the first to be 0x100", pids)
+	}
+}
+
+func TestSectionAssembler(t *testing.T) {
+	long := mpegtstest.Section(mpegts.TableIDPMT, 1, 0, bytes.Repeat([]byte{0xAB}, 400))
+	short1 := mpegtstest.Section(mpegts.TableIDPAT, 2, 0, []byte{0x00, 0x01, 0xE0, 0x30})
+	short2 := mpegtstest.Section(mpegts.TableIDPAT, 3, 0, []byte{0x00, 0x02, 0xE0, 0x40})
+
+	var stream []byte
+	stream = append(stream, mpegtstest.Packetize(0x30, long)...)
+	// Two sections in a single packet, and a section that starts partway
+	// through a packet after the end of the previous one.
+	stream = append(stream, mpegtstest.Packetize(0x30, append(append([]byte{}, short1...), short2...))...)
+
+	var (
+		sa  mpegts.SectionAssembler
+		got [][]byte
+		pr  = mpegts.NewPacketReader(bytes.NewReader(stream))
+	)
+	for {
+		p, err := pr.ReadPacket()
+		if err != nil {
+			break
+		}
+		got = append(got, sa.Push(p)...)
+	}
+
+	want := [][]byte{long, short1, short2}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected sections (-want +got):\n%s", diff)
+	}
+}
+
+func TestSectionAssemblerMidstream(t *testing.T) {
+	// A reader that joins partway through a section must skip it, and pick up
+	// with the next section that starts in a packet.
+	section := mpegtstest.Section(mpegts.TableIDPMT, 1, 0, bytes.Repeat([]byte{0xCD}, 300))
+	packets := mpegtstest.Packetize(0x30, section)
+
+	var sa mpegts.SectionAssembler
+	p, _ := mpegts.ParsePacket(packets[mpegts.PacketSize : 2*mpegts.PacketSize])
+	if got := sa.Push(p); len(got) != 0 {
+		t.Fatalf("Push() of continuation packet returned %d sections", len(got))
+	}
+	for i := 0; i < len(packets); i += mpegts.PacketSize {
+		p, _ := mpegts.ParsePacket(packets[i : i+mpegts.PacketSize])
+		if got := sa.Push(p); i > 0 && !cmp.Equal(got, [][]byte{section}) {
+			t.Errorf("Push() = %x, want the complete section", got)
+		}
+	}
+}
+
+func TestParseSection(t *testing.T) {
+	valid := mpegtstest.Section(mpegts.TableIDPAT, 0x1234, 0, []byte{0x00, 0x03, 0xE0, 0x30})
+
+	got, err := mpegts.ParseSection(valid)
+	if err != nil {
+		t.Fatalf("ParseSection() error: %v", err)
+	}
+	want := mpegts.Section{
+		TableID:          mpegts.TableIDPAT,
+		TableIDExtension: 0x1234,
+		CurrentNext:      true,
+		Data:             []byte{0x00, 0x03, 0xE0, 0x30},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected section (-want +got):\n%s", diff)
+	}
+
+	corrupt := bytes.Clone(valid)
+	corrupt[9] ^= 0x01
+	if _, err := mpegts.ParseSection(corrupt); !errors.Is(err, mpegts.ErrCRC) {
+		t.Errorf("ParseSection() of corrupt section error = %v, want %v", err, mpegts.ErrCRC)
+	}
+
+	if _, err := mpegts.ParseSection(valid[:len(valid)-1]); !errors.Is(err, mpegts.ErrInvalidSection) {
+		t.Errorf("ParseSection() of truncated section error = %v, want %v", err, mpegts.ErrInvalidSection)
+	}
+}
+
+func TestParsePAT(t *testing.T) {
+	s := mustParseSection(t, mpegtstest.Section(mpegts.TableIDPAT, 0x0BB1, 0, []byte{
+		0x00, 0x00, 0xE0, 0x10, // Network information table.
+		0x00, 0x03, 0xE0, 0x30,
+		0x00, 0x04, 0xE0, 0x40,
+	}))
+
+	got, err := mpegts.ParsePAT(s)
+	if err != nil {
+		t.Fatalf("ParsePAT() error: %v", err)
+	}
+	want := mpegts.PAT{
+		TransportStreamID: 0x0BB1,
+		NetworkPID:        0x10,
+		Programs: []mpegts.PATProgram{
+			{Number: 3, PMTPID: 0x30},
+			{Number: 4, PMTPID: 0x40},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected PAT (-want +got):\n%s", diff)
+	}
+}
+
+func TestParsePMT(t *testing.T) {
+	s := mustParseSection(t, mpegtstest.Section(mpegts.TableIDPMT, 3, 0, []byte{
+		0xE0, 0x31, // PCR PID.
+		0xF0, 0x06, 0x05, 0x04, 'G', 'A', '9', '4', // Registration descriptor.
+		0x02, 0xE0, 0x31, 0xF0, 0x00,
+		0x81, 0xE0, 0x34, 0xF0, 0x06, 0x0A, 0x04, 'e', 'n', 'g', 0x00,
+		0x81, 0xE0, 0x35, 0xF0, 0x06, 0x0A, 0x04, 's', 'p', 'a', 0x03,
+	}))
+
+	got, err := mpegts.ParsePMT(s)
+	if err != nil {
+		t.Fatalf("ParsePMT() error: %v", err)
+	}
+	want := mpegts.PMT{
+		ProgramNumber: 3,
+		PCRPID:        0x31,
+		Descriptors:   []mpegts.Descriptor{{Tag: mpegts.DescriptorTagRegistration, Data: []byte("GA94")}},
+		Streams: []mpegts.ElementaryStream{
+			{Type: mpegts.StreamTypeMPEG2Video, PID: 0x31},
+			{Type: mpegts.StreamTypeAC3Audio, PID: 0x34, Descriptors: []mpegts.Descriptor{{Tag: mpegts.DescriptorTagISO639, Data: []byte("eng\x00")}}},
+			{Type: mpegts.StreamTypeAC3Audio, PID: 0x35, Descriptors: []mpegts.Descriptor{{Tag: mpegts.DescriptorTagISO639, Data: []byte("spa\x03")}}},
+		},
+	}
+	if diff := cmp.Diff(want, got, cmpopts.EquateEmpty()); diff != "" {
+		t.Fatalf("unexpected PMT (-want +got):\n%s", diff)
+	}
+
+	if format, err := mpegts.ParseRegistration(got.Descriptors[0]); err != nil || format != "GA94" {
+		t.Errorf("ParseRegistration() = %q, %v; want GA94", format, err)
+	}
+
+	d, ok := mpegts.FindDescriptor(got.Streams[2].Descriptors, mpegts.DescriptorTagISO639)
+	if !ok {
+		t.Fatal("FindDescriptor() did not find ISO 639 descriptor")
+	}
+	languages, err := mpegts.ParseISO639Language(d)
+	if err != nil {
+		t.Fatalf("ParseISO639Language() error: %v", err)
+	}
+	if diff := cmp.Diff([]mpegts.Language{{Code: "spa", Type: mpegts.AudioTypeVisualImpaired}}, languages); diff != "" {
+		t.Errorf("unexpected languages (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseAVCVideo(t *testing.T) {
+	d := mpegts.Descriptor{Tag: mpegts.DescriptorTagAVCVideo, Data: []byte{0x64, 0x00, 0x28, 0x3F}}
+	got, err := mpegts.ParseAVCVideo(d)
+	if err != nil {
+		t.Fatalf("ParseAVCVideo() error: %v", err)
+	}
+	if want := (mpegts.AVCVideo{ProfileIDC: 100, LevelIDC: 40}); got != want {
+		t.Errorf("ParseAVCVideo() = %+v, want %+v", got, want)
+	}
+
+	d.Data = d.Data[:3]
+	if _, err := mpegts.ParseAVCVideo(d); !errors.Is(err, mpegts.ErrInvalidDescriptor) {
+		t.Errorf("ParseAVCVideo() on short descriptor error = %v, want %v", err, mpegts.ErrInvalidDescriptor)
+	}
+}
+
+func TestParsePMTInvalid(t *testing.T) {
+	s := mustParseSection(t, mpegtstest.Section(mpegts.TableIDPMT, 3, 0, []byte{
+		0xE0, 0x31, 0xF0, 0x00,
+		0x02, 0xE0, 0x31, 0xF0, 0x08, 0x0A, 0x04, // Descriptor loop overflows.
+	}))
+	if _, err := mpegts.ParsePMT(s); !errors.Is(err, mpegts.ErrInvalidSection) {
+		t.Errorf("ParsePMT() error = %v, want %v", err, mpegts.ErrInvalidSection)
+	}
+}
+
+func TestSectionReader(t *testing.T) {
+	pat := mpegtstest.Section(mpegts.TableIDPAT, 1, 0, []byte{0x00, 0x03, 0xE0, 0x30})
+	pmt := mpegtstest.Section(mpegts.TableIDPMT, 3, 0, []byte{0xE0, 0x31, 0xF0, 0x00, 0x02, 0xE0, 0x31, 0xF0, 0x00})
+
+	var stream []byte
+	stream = append(stream, mpegtstest.Packetize(0x30, pmt)...) // Not yet selected.
+	stream = append(stream, mpegtstest.Packetize(mpegts.PIDPAT, pat)...)
+	stream = append(stream, mpegtstest.Packetize(mpegts.PIDNull, nil)...)
+	stream = append(stream, mpegtstest.Packetize(0x30, pmt)...)
+
+	sr := mpegts.NewSectionReader(bytes.NewReader(stream))
+
+	pid, s, err := sr.ReadSection()
+	if err != nil || pid != mpegts.PIDPAT || s.TableID != mpegts.TableIDPAT {
+		t.Fatalf("ReadSection() = %v, %+v, %v; want the PAT", pid, s, err)
+	}
+
+	sr.Select(0x30)
+	pid, s, err = sr.ReadSection()
+	if err != nil || pid != 0x30 || s.TableID != mpegts.TableIDPMT {
+		t.Fatalf("ReadSection() = %v, %+v, %v; want the PMT", pid, s, err)
+	}
+	if got := sr.Packets(); got != 4 {
+		t.Errorf("Packets() = %d, want 4", got)
+	}
+
+	if _, _, err := sr.ReadSection(); !errors.Is(err, io.EOF) {
+		t.Errorf("ReadSection() at end of stream error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestStreamTypes(t *testing.T) {
+	if !mpegts.StreamTypeMPEG2Video.IsVideo() || mpegts.StreamTypeMPEG2Video.IsAudio() {
+		t.Errorf("%v misclassified", mpegts.StreamTypeMPEG2Video)
+	}
+	if !mpegts.StreamTypeAC3Audio.IsAudio() || mpegts.StreamTypeAC3Audio.IsVideo() {
+		t.Errorf("%v misclassified", mpegts.StreamTypeAC3Audio)
+	}
+	if got, want := mpegts.StreamType(0x7F).String(), "StreamType(0x7f)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestCRC32(t *testing.T) {
+	const want = 0x0376E6E7 // The standard check value for CRC-32/MPEG-2.
+	if got := mpegts.CRC32([]byte("123456789")); got != want {
+		t.Errorf("CRC32() = %#08x, want %#08x", got, want)
+	}
+}
+
+func FuzzSectionReader(f *testing.F) {
+	f.Add(mpegtstest.Packetize(mpegts.PIDPAT, mpegtstest.Section(mpegts.TableIDPAT, 1, 0, []byte{0x00, 0x03, 0xE0, 0x30})))
+	f.Add(mpegtstest.Packetize(mpegts.PIDPAT, mpegtstest.Section(mpegts.TableIDPMT, 3, 0, []byte{0xE0, 0x31, 0xF0, 0x00})))
+
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		sr := mpegts.NewSectionReader(bytes.NewReader(stream))
+		for {
+			_, s, err := sr.ReadSection()
+			if err != nil {
+				return
+			}
+			// Parsing must not panic, whether or not the contents are valid.
+			mpegts.ParsePAT(s)
+			mpegts.ParsePMT(s)
+		}
+	})
+}
+
+func mustParseSection(t *testing.T, b []byte) mpegts.Section {
+	t.Helper()
+	s, err := mpegts.ParseSection(b)
+	if err != nil {
+		t.Fatalf("ParseSection() error: %v", err)
+	}
+	return s
+}
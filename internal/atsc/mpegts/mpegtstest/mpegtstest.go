@@ -0,0 +1,47 @@
+// Package mpegtstest builds MPEG transport streams for tests of the packages
+// that parse them.
+package mpegtstest
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/featherbread/hypcast/internal/atsc/mpegts"
+)
+
+// Packet returns a transport stream packet that starts with header followed
+// by payload, padded with stuffing bytes to the full packet size.
+func Packet(header, payload []byte) []byte {
+	packet := bytes.Repeat([]byte{0xFF}, mpegts.PacketSize)
+	copy(packet, header)
+	copy(packet[len(header):], payload)
+	return packet
+}
+
+// Section returns a long-form PSI section with the provided table ID, table
+// ID extension, and version, as the current and only section of its table.
+func Section(tableID uint8, extension uint16, version uint8, body []byte) []byte {
+	length := 5 + len(body) + 4
+	section := []byte{tableID, 0xB0 | byte(length>>8), byte(length)}
+	section = binary.BigEndian.AppendUint16(section, extension)
+	section = append(section, 0xC1|version<<1, 0x00, 0x00) // Current, section 0 of 0.
+	section = append(section, body...)
+	return binary.BigEndian.AppendUint32(section, mpegts.CRC32(section))
+}
+
+// Packetize splits a section across as many packets on pid as necessary, with
+// a pointer field in the first packet to start the section and stuffing after
+// its end.
+func Packetize(pid mpegts.PID, section []byte) []byte {
+	var stream []byte
+	payload := append([]byte{0x00}, section...) // Pointer field.
+	for first := true; len(payload) > 0; first = false {
+		header := []byte{mpegts.SyncByte, byte(pid >> 8), byte(pid), 0x10}
+		if first {
+			header[1] |= 0x40
+		}
+		stream = append(stream, Packet(header, payload)...)
+		payload = payload[min(len(payload), mpegts.PacketSize-len(header)):]
+	}
+	return stream
+}
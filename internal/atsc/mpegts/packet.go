@@ -0,0 +1,118 @@
+// Package mpegts parses MPEG transport streams as defined by ISO/IEC 13818-1,
+// including the program specific information (PSI) that describes the
+// programs and elementary streams that a transport stream carries.
+//
+// The package reads transport streams from any [io.Reader], and performs no
+// I/O of its own beyond that.
+package mpegts
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// PacketSize is the size in bytes of a single transport stream packet.
+const PacketSize = 188
+
+// SyncByte is the first byte of every transport stream packet.
+const SyncByte = 0x47
+
+// PID identifies the elementary stream or table carried by a packet.
+type PID uint16
+
+// The following PIDs have fixed assignments in every transport stream.
+const (
+	PIDPAT  PID = 0x0000
+	PIDCAT  PID = 0x0001
+	PIDNull PID = 0x1FFF
+)
+
+// Packet represents a single transport stream packet.
+type Packet struct {
+	PID PID
+	// PayloadUnitStart indicates that the payload begins a new PES packet, or
+	// contains the start of a new PSI section.
+	PayloadUnitStart bool
+	// TransportError indicates that the packet was received with an
+	// uncorrectable error.
+	TransportError    bool
+	Scrambled         bool
+	ContinuityCounter uint8
+	// AdaptationField contains the adaptation field of the packet without its
+	// length prefix, or is nil if the packet has no adaptation field.
+	AdaptationField []byte
+	// Payload contains the payload of the packet, or is nil if the packet has
+	// no payload.
+	Payload []byte
+}
+
+// ErrInvalidPacket is returned when parsing a malformed transport stream
+// packet.
+var ErrInvalidPacket error = errors.New("invalid transport stream packet")
+
+// ParsePacket parses a single transport stream packet. The fields of the
+// returned packet alias b.
+func ParsePacket(b []byte) (Packet, error) {
+	if len(b) != PacketSize || b[0] != SyncByte {
+		return Packet{}, ErrInvalidPacket
+	}
+
+	p := Packet{
+		PID:               PID(binary.BigEndian.Uint16(b[1:3]) & 0x1FFF),
+		TransportError:    b[1]&0x80 != 0,
+		PayloadUnitStart:  b[1]&0x40 != 0,
+		Scrambled:         b[3]>>6 != 0,
+		ContinuityCounter: b[3] & 0x0F,
+	}
+
+	rest := b[4:]
+	control := b[3] >> 4 & 0b11
+	if control&0b10 != 0 {
+		length := int(rest[0])
+		if 1+length > len(rest) {
+			return Packet{}, ErrInvalidPacket
+		}
+		p.AdaptationField = rest[1 : 1+length]
+		rest = rest[1+length:]
+	}
+	if control&0b01 != 0 {
+		p.Payload = rest
+	}
+	return p, nil
+}
+
+// PacketReader reads transport stream packets from an underlying reader.
+type PacketReader struct {
+	r   io.Reader
+	buf [PacketSize]byte
+}
+
+// NewPacketReader returns a PacketReader that reads from r.
+func NewPacketReader(r io.Reader) *PacketReader {
+	return &PacketReader{r: r}
+}
+
+// ReadPacket reads the next valid packet from the stream, skipping any data
+// that does not start with a sync byte, along with any packet that can't be
+// parsed. The fields of the returned packet are only valid until the next call
+// to ReadPacket.
+//
+// At the end of the stream, ReadPacket returns [io.EOF], or
+// [io.ErrUnexpectedEOF] if the stream ends partway through a packet.
+func (pr *PacketReader) ReadPacket() (Packet, error) {
+	for {
+		if _, err := io.ReadFull(pr.r, pr.buf[:1]); err != nil {
+			return Packet{}, err
+		}
+		if pr.buf[0] != SyncByte {
+			continue
+		}
+		if _, err := io.ReadFull(pr.r, pr.buf[1:]); err != nil {
+			return Packet{}, err
+		}
+		if p, err := ParsePacket(pr.buf[:]); err == nil {
+			return p, nil
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package mpegts
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Section represents a PSI section in its long form, which all of the tables
+// in this package use.
+type Section struct {
+	TableID uint8
+	// TableIDExtension identifies the instance of the table that the section
+	// belongs to. For example, it holds the transport stream ID of a PAT and
+	// the program number of a PMT.
+	TableIDExtension  uint16
+	Version           uint8
+	CurrentNext       bool
+	SectionNumber     uint8
+	LastSectionNumber uint8
+	// Data contains the table-specific contents of the section, between the
+	// header and the CRC.
+	Data []byte
+}
+
+var (
+	// ErrInvalidSection is returned when parsing a malformed PSI section.
+	ErrInvalidSection error = errors.New("invalid PSI section")
+
+	// ErrCRC is returned when parsing a PSI section whose CRC does not match
+	// its contents.
+	ErrCRC error = errors.New("PSI section CRC mismatch")
+)
+
+// ParseSection parses a complete long-form PSI section, including its CRC. The
+// Data of the returned section aliases b.
+func ParseSection(b []byte) (Section, error) {
+	const headerSize, crcSize = 8, 4
+	if len(b) < headerSize+crcSize || b[1]&0x80 == 0 {
+		return Section{}, ErrInvalidSection
+	}
+	if length := 3 + int(binary.BigEndian.Uint16(b[1:3])&0x0FFF); length != len(b) {
+		return Section{}, ErrInvalidSection
+	}
+	if CRC32(b) != 0 {
+		return Section{}, ErrCRC
+	}
+
+	return Section{
+		TableID:           b[0],
+		TableIDExtension:  binary.BigEndian.Uint16(b[3:5]),
+		Version:           b[5] >> 1 & 0x1F,
+		CurrentNext:       b[5]&0x01 != 0,
+		SectionNumber:     b[6],
+		LastSectionNumber: b[7],
+		Data:              b[headerSize : len(b)-crcSize],
+	}, nil
+}
+
+// SectionAssembler reassembles PSI sections that span the payloads of
+// multiple packets on a single PID. The zero value is ready to use.
+type SectionAssembler struct {
+	pending []byte
+	started bool
+}
+
+// Push adds the payload of the next packet on the assembler's PID, and returns
+// any raw sections that the packet completes, which may be passed to
+// [ParseSection]. Returned sections remain valid after later calls to Push.
+func (sa *SectionAssembler) Push(p Packet) (sections [][]byte) {
+	payload := p.Payload
+	if p.PayloadUnitStart {
+		if len(payload) == 0 {
+			return nil
+		}
+		pointer := int(payload[0])
+		payload = payload[1:]
+		if pointer > len(payload) {
+			sa.pending, sa.started = nil, false
+			return nil
+		}
+		if sa.started {
+			sa.pending = append(sa.pending, payload[:pointer]...)
+			sections = sa.drain(sections)
+		}
+		sa.pending, sa.started = nil, true
+		payload = payload[pointer:]
+	} else if !sa.started {
+		return nil
+	}
+
+	sa.pending = append(sa.pending, payload...)
+	return sa.drain(sections)
+}
+
+func (sa *SectionAssembler) drain(sections [][]byte) [][]byte {
+	for len(sa.pending) >= 3 {
+		if sa.pending[0] == 0xFF {
+			// The rest of the packet is stuffing.
+			sa.pending, sa.started = nil, false
+			break
+		}
+		length := 3 + int(binary.BigEndian.Uint16(sa.pending[1:3])&0x0FFF)
+		if len(sa.pending) < length {
+			break
+		}
+		sections = append(sections, sa.pending[:length:length])
+		sa.pending = sa.pending[length:]
+	}
+	return sections
+}
+
+// SectionReader reads PSI sections from the packets of selected PIDs in a
+// transport stream.
+type SectionReader struct {
+	pr         *PacketReader
+	assemblers map[PID]*SectionAssembler
+	queue      []pidSection
+	packets    int
+}
+
+type pidSection struct {
+	pid     PID
+	section Section
+}
+
+// NewSectionReader returns a SectionReader that reads from r, initially
+// selecting only the PAT's PID.
+func NewSectionReader(r io.Reader) *SectionReader {
+	return &SectionReader{
+		pr:         NewPacketReader(r),
+		assemblers: map[PID]*SectionAssembler{PIDPAT: {}},
+	}
+}
+
+// Select adds pid to the set of PIDs that the reader reads sections from.
+func (sr *SectionReader) Select(pid PID) {
+	if _, ok := sr.assemblers[pid]; !ok {
+		sr.assemblers[pid] = new(SectionAssembler)
+	}
+}
+
+// Deselect removes pid from the set of PIDs that the reader reads sections
+// from, discarding any partial section for the PID.
+func (sr *SectionReader) Deselect(pid PID) {
+	delete(sr.assemblers, pid)
+}
+
+// Packets returns the number of packets that the reader has consumed from the
+// stream, including those on PIDs that it does not select.
+func (sr *SectionReader) Packets() int {
+	return sr.packets
+}
+
+// ReadSection reads the next intact section on any of the selected PIDs,
+// skipping any section whose CRC does not match. It returns the PID that
+// carried the section along with the section itself.
+//
+// ReadSection returns the errors of [PacketReader.ReadPacket] at the end of
+// the stream.
+func (sr *SectionReader) ReadSection() (PID, Section, error) {
+	for len(sr.queue) == 0 {
+		p, err := sr.pr.ReadPacket()
+		if err != nil {
+			return 0, Section{}, err
+		}
+		sr.packets++
+
+		sa, ok := sr.assemblers[p.PID]
+		if !ok || p.TransportError {
+			continue
+		}
+		for _, b := range sa.Push(p) {
+			if s, err := ParseSection(b); err == nil {
+				sr.queue = append(sr.queue, pidSection{p.PID, s})
+			}
+		}
+	}
+
+	next := sr.queue[0]
+	sr.queue = sr.queue[1:]
+	return next.pid, next.section, nil
+}
+
+var crcTable = func() (table [256]uint32) {
+	const poly = 0x04C11DB7
+	for i := range table {
+		crc := uint32(i) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ poly
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return
+}()
+
+// CRC32 computes the CRC used by PSI sections (CRC-32/MPEG-2). The result
+// over a complete section, including its CRC field, is zero if the section is
+// intact.
+func CRC32(b []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, v := range b {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^v]
+	}
+	return crc
+}
@@ -0,0 +1,192 @@
+package mpegts
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The following are the table IDs of the tables defined by ISO/IEC 13818-1.
+const (
+	TableIDPAT uint8 = 0x00
+	TableIDCAT uint8 = 0x01
+	TableIDPMT uint8 = 0x02
+)
+
+// PAT represents a program association table, which lists the programs in a
+// transport stream.
+type PAT struct {
+	TransportStreamID uint16
+	Programs          []PATProgram
+	// NetworkPID is the PID of the network information table, or 0 if the
+	// table does not list one.
+	NetworkPID PID
+}
+
+// PATProgram represents a single program in a program association table.
+type PATProgram struct {
+	Number uint16
+	// PMTPID is the PID that carries the program map table for the program.
+	PMTPID PID
+}
+
+// ParsePAT parses a program association table from a single section. Tables
+// that span multiple sections must be merged by the caller.
+func ParsePAT(s Section) (PAT, error) {
+	if s.TableID != TableIDPAT || len(s.Data)%4 != 0 {
+		return PAT{}, ErrInvalidSection
+	}
+
+	pat := PAT{TransportStreamID: s.TableIDExtension}
+	for b := s.Data; len(b) >= 4; b = b[4:] {
+		number := binary.BigEndian.Uint16(b[0:2])
+		pid := PID(binary.BigEndian.Uint16(b[2:4]) & 0x1FFF)
+		if number == 0 {
+			pat.NetworkPID = pid
+			continue
+		}
+		pat.Programs = append(pat.Programs, PATProgram{Number: number, PMTPID: pid})
+	}
+	return pat, nil
+}
+
+// PMT represents a program map table, which lists the elementary streams of a
+// single program.
+type PMT struct {
+	ProgramNumber uint16
+	Version       uint8
+	PCRPID        PID
+	Descriptors   []Descriptor
+	Streams       []ElementaryStream
+}
+
+// ElementaryStream represents a single stream of a program.
+type ElementaryStream struct {
+	Type        StreamType
+	PID         PID
+	Descriptors []Descriptor
+}
+
+// ParsePMT parses a program map table from a section.
+func ParsePMT(s Section) (PMT, error) {
+	b := s.Data
+	if s.TableID != TableIDPMT || len(b) < 4 {
+		return PMT{}, ErrInvalidSection
+	}
+
+	pmt := PMT{
+		ProgramNumber: s.TableIDExtension,
+		Version:       s.Version,
+		PCRPID:        PID(binary.BigEndian.Uint16(b[0:2]) & 0x1FFF),
+	}
+
+	var err error
+	pmt.Descriptors, b, err = parseDescriptorLoop(b[2:])
+	if err != nil {
+		return PMT{}, err
+	}
+
+	for len(b) > 0 {
+		if len(b) < 5 {
+			return PMT{}, ErrInvalidSection
+		}
+		es := ElementaryStream{
+			Type: StreamType(b[0]),
+			PID:  PID(binary.BigEndian.Uint16(b[1:3]) & 0x1FFF),
+		}
+		es.Descriptors, b, err = parseDescriptorLoop(b[3:])
+		if err != nil {
+			return PMT{}, err
+		}
+		pmt.Streams = append(pmt.Streams, es)
+	}
+	return pmt, nil
+}
+
+// parseDescriptorLoop parses a descriptor loop prefixed by a 12-bit length,
+// and returns the descriptors along with the data that follows the loop.
+func parseDescriptorLoop(b []byte) ([]Descriptor, []byte, error) {
+	if len(b) < 2 {
+		return nil, nil, ErrInvalidSection
+	}
+	length := int(binary.BigEndian.Uint16(b[0:2]) & 0x0FFF)
+	if 2+length > len(b) {
+		return nil, nil, ErrInvalidSection
+	}
+	descriptors, err := ParseDescriptors(b[2 : 2+length])
+	return descriptors, b[2+length:], err
+}
+
+// StreamType identifies the kind of data carried by an elementary stream.
+type StreamType uint8
+
+// The following are the stream types commonly found in ATSC transport streams.
+const (
+	StreamTypeMPEG1Video StreamType = 0x01
+	StreamTypeMPEG2Video StreamType = 0x02
+	StreamTypeMPEG1Audio StreamType = 0x03
+	StreamTypeMPEG2Audio StreamType = 0x04
+	StreamTypePrivate    StreamType = 0x05
+	StreamTypePESPrivate StreamType = 0x06
+	StreamTypeAACAudio   StreamType = 0x0F
+	StreamTypeLATMAudio  StreamType = 0x11
+	StreamTypeH264Video  StreamType = 0x1B
+	StreamTypeH265Video  StreamType = 0x24
+	// StreamTypeAC3Audio and StreamTypeEAC3Audio are defined by ATSC A/52.
+	StreamTypeAC3Audio  StreamType = 0x81
+	StreamTypeEAC3Audio StreamType = 0x87
+	// StreamTypeATSCPSIP carries ATSC PSIP tables other than those on the base
+	// PID, as defined by ATSC A/65.
+	StreamTypeATSCPSIP StreamType = 0x86
+)
+
+// IsVideo reports whether t identifies a video stream.
+func (t StreamType) IsVideo() bool {
+	switch t {
+	case StreamTypeMPEG1Video, StreamTypeMPEG2Video, StreamTypeH264Video, StreamTypeH265Video:
+		return true
+	}
+	return false
+}
+
+// IsAudio reports whether t identifies an audio stream.
+func (t StreamType) IsAudio() bool {
+	switch t {
+	case StreamTypeMPEG1Audio, StreamTypeMPEG2Audio, StreamTypeAACAudio,
+		StreamTypeLATMAudio, StreamTypeAC3Audio, StreamTypeEAC3Audio:
+		return true
+	}
+	return false
+}
+
+func (t StreamType) String() string {
+	switch t {
+	case StreamTypeMPEG1Video:
+		return "MPEG-1 video"
+	case StreamTypeMPEG2Video:
+		return "MPEG-2 video"
+	case StreamTypeMPEG1Audio:
+		return "MPEG-1 audio"
+	case StreamTypeMPEG2Audio:
+		return "MPEG-2 audio"
+	case StreamTypePrivate:
+		return "private sections"
+	case StreamTypePESPrivate:
+		return "PES private data"
+	case StreamTypeAACAudio:
+		return "AAC audio"
+	case StreamTypeLATMAudio:
+		return "LATM AAC audio"
+	case StreamTypeH264Video:
+		return "H.264 video"
+	case StreamTypeH265Video:
+		return "H.265 video"
+	case StreamTypeAC3Audio:
+		return "AC-3 audio"
+	case StreamTypeEAC3Audio:
+		return "E-AC-3 audio"
+	case StreamTypeATSCPSIP:
+		return "ATSC PSIP"
+	default:
+		return fmt.Sprintf("StreamType(%#02x)", uint8(t))
+	}
+}
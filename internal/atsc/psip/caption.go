@@ -0,0 +1,53 @@
+package psip
+
+import "github.com/featherbread/hypcast/internal/atsc/mpegts"
+
+// CaptionService represents a single entry of a caption service descriptor,
+// which describes the closed captions carried in a video stream.
+type CaptionService struct {
+	// Language is the three letter ISO 639-2 code for the language of the
+	// captions, such as "eng" or "spa".
+	Language string
+	// Digital is set for CEA-708 caption services, and clear for CEA-608
+	// captions carried for compatibility with analog receivers.
+	Digital bool
+	// ServiceNumber identifies a CEA-708 caption service, from 1 to 63.
+	ServiceNumber uint8
+	// Line21Field identifies the field that carries CEA-608 captions, with 0
+	// for field 1 and 1 for field 2.
+	Line21Field     uint8
+	EasyReader      bool
+	WideAspectRatio bool
+}
+
+// ParseCaptionServices returns the entries of a caption service descriptor.
+func ParseCaptionServices(d mpegts.Descriptor) ([]CaptionService, error) {
+	if d.Tag != DescriptorTagCaptionService || len(d.Data) < 1 {
+		return nil, mpegts.ErrInvalidDescriptor
+	}
+
+	const entrySize = 6
+	count := int(d.Data[0] & 0x1F)
+	b := d.Data[1:]
+	if len(b) < count*entrySize {
+		return nil, mpegts.ErrInvalidDescriptor
+	}
+
+	services := make([]CaptionService, count)
+	for i := range services {
+		cs := CaptionService{
+			Language:        string(b[:3]),
+			Digital:         b[3]&0x80 != 0,
+			EasyReader:      b[4]&0x80 != 0,
+			WideAspectRatio: b[4]&0x40 != 0,
+		}
+		if cs.Digital {
+			cs.ServiceNumber = b[3] & 0x3F
+		} else {
+			cs.Line21Field = b[3] & 0x01
+		}
+		services[i] = cs
+		b = b[entrySize:]
+	}
+	return services, nil
+}
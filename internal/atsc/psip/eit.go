@@ -0,0 +1,149 @@
+package psip
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/featherbread/hypcast/internal/atsc/mpegts"
+)
+
+// GPSEpoch is the time from which ATSC system time counts GPS seconds.
+var GPSEpoch = time.Date(1980, time.January, 6, 0, 0, 0, 0, time.UTC)
+
+// DefaultGPSUTCOffset is the number of leap seconds between GPS and UTC time
+// since 2017, for use until a system time table provides the current value.
+const DefaultGPSUTCOffset = 18
+
+// GPSTime converts a count of GPS seconds since GPSEpoch to UTC, given the
+// current offset between GPS and UTC time in seconds.
+func GPSTime(seconds uint32, gpsUTCOffset uint8) time.Time {
+	return GPSEpoch.Add(time.Duration(int64(seconds)-int64(gpsUTCOffset)) * time.Second)
+}
+
+// STT represents a system time table, which carries the current time.
+type STT struct {
+	// SystemTime is the current time as a count of GPS seconds since GPSEpoch.
+	SystemTime uint32
+	// GPSUTCOffset is the number of leap seconds between GPS and UTC time.
+	GPSUTCOffset uint8
+	Descriptors  []mpegts.Descriptor
+}
+
+// Time returns the current time that the table represents, in UTC.
+func (stt STT) Time() time.Time {
+	return GPSTime(stt.SystemTime, stt.GPSUTCOffset)
+}
+
+// ParseSTT parses a system time table from a section.
+func ParseSTT(s mpegts.Section) (STT, error) {
+	b := s.Data
+	if s.TableID != TableIDSTT || len(b) < 8 {
+		return STT{}, ErrInvalidTable
+	}
+	descriptors, err := mpegts.ParseDescriptors(b[8:])
+	if err != nil {
+		return STT{}, err
+	}
+	return STT{
+		SystemTime:   binary.BigEndian.Uint32(b[1:5]),
+		GPSUTCOffset: b[5],
+		Descriptors:  descriptors,
+	}, nil
+}
+
+// EIT represents a single section of an event information table, which lists
+// the events of a single virtual channel during a 3 hour time slot.
+type EIT struct {
+	// SourceID identifies the virtual channel that carries the events.
+	SourceID uint16
+	Version  uint8
+	Events   []Event
+}
+
+// Event represents a single entry of an event information table.
+type Event struct {
+	EventID uint16
+	// StartTime is the start of the event as a count of GPS seconds since
+	// GPSEpoch. See GPSTime to convert it to UTC.
+	StartTime   uint32
+	Duration    time.Duration
+	ETMLocation uint8
+	Title       MultipleString
+	Descriptors []mpegts.Descriptor
+}
+
+// ParseEIT parses a section of an event information table.
+func ParseEIT(s mpegts.Section) (EIT, error) {
+	b := s.Data
+	if s.TableID != TableIDEIT || len(b) < 2 {
+		return EIT{}, ErrInvalidTable
+	}
+
+	eit := EIT{SourceID: s.TableIDExtension, Version: s.Version}
+	count := int(b[1])
+	b = b[2:]
+	for range count {
+		const fixedSize = 10
+		if len(b) < fixedSize || fixedSize+int(b[9]) > len(b) {
+			return EIT{}, ErrInvalidTable
+		}
+		var title MultipleString
+		titleLength := int(b[9])
+		if titleLength > 0 {
+			var err error
+			if title, err = ParseMultipleString(b[fixedSize : fixedSize+titleLength]); err != nil {
+				return EIT{}, err
+			}
+		}
+		descriptors, rest, err := parseDescriptorLoop(b[fixedSize+titleLength:], 0x0FFF)
+		if err != nil {
+			return EIT{}, err
+		}
+
+		length := binary.BigEndian.Uint32(b[6:10]) >> 8 & 0x0FFFFF
+		eit.Events = append(eit.Events, Event{
+			EventID:     binary.BigEndian.Uint16(b[0:2]) & 0x3FFF,
+			StartTime:   binary.BigEndian.Uint32(b[2:6]),
+			Duration:    time.Duration(length) * time.Second,
+			ETMLocation: b[6] >> 4 & 0x03,
+			Title:       title,
+			Descriptors: descriptors,
+		})
+		b = rest
+	}
+	return eit, nil
+}
+
+// ETT represents an extended text table, which carries a long description of
+// a single virtual channel or event.
+type ETT struct {
+	// ETMID identifies the channel or event that the text describes. See
+	// EventETMID and ChannelETMID.
+	ETMID uint32
+	Text  MultipleString
+}
+
+// EventETMID returns the ETM ID that identifies the extended text message for
+// an event of the virtual channel with the provided source ID.
+func EventETMID(sourceID, eventID uint16) uint32 {
+	return uint32(sourceID)<<16 | uint32(eventID&0x3FFF)<<2 | 0x02
+}
+
+// ChannelETMID returns the ETM ID that identifies the extended text message
+// for the virtual channel with the provided source ID.
+func ChannelETMID(sourceID uint16) uint32 {
+	return uint32(sourceID) << 16
+}
+
+// ParseETT parses an extended text table from a section.
+func ParseETT(s mpegts.Section) (ETT, error) {
+	b := s.Data
+	if s.TableID != TableIDETT || len(b) < 5 {
+		return ETT{}, ErrInvalidTable
+	}
+	text, err := ParseMultipleString(b[5:])
+	if err != nil {
+		return ETT{}, err
+	}
+	return ETT{ETMID: binary.BigEndian.Uint32(b[1:5]), Text: text}, nil
+}
@@ -0,0 +1,103 @@
+package psip
+
+import (
+	"strings"
+	"unicode/utf16"
+)
+
+// MultipleString represents a multiple string structure, which carries the
+// same text in one or more languages.
+type MultipleString []LangString
+
+// LangString represents the text of a multiple string structure in a single
+// language.
+type LangString struct {
+	// Language is the three letter ISO 639-2 code for the language of the
+	// text, such as "eng" or "spa".
+	Language string
+	Text     string
+}
+
+// String returns the text of the first language in ms, or an empty string if ms
+// is empty.
+func (ms MultipleString) String() string {
+	if len(ms) == 0 {
+		return ""
+	}
+	return ms[0].Text
+}
+
+// Lookup returns the text of ms in the provided language, falling back to the
+// first language in ms if it does not include the requested one.
+func (ms MultipleString) Lookup(language string) string {
+	for _, ls := range ms {
+		if ls.Language == language {
+			return ls.Text
+		}
+	}
+	return ms.String()
+}
+
+// The following are the compression types and modes of multiple string
+// segments that ParseMultipleString understands.
+const (
+	compressionNone = 0x00
+
+	// Modes up to modeMaxUnicodePage select the Unicode page (the upper byte
+	// of each UTF-16 code unit) for the single byte characters of a segment.
+	modeMaxUnicodePage = 0x33
+	modeUTF16          = 0x3F
+)
+
+// ParseMultipleString parses a multiple string structure that fills b.
+//
+// ParseMultipleString decodes uncompressed segments in UTF-16 or in any of the
+// single Unicode page modes. Other segments, including those compressed with
+// the Huffman tables of A/65 Annex C, are omitted from the resulting text.
+func ParseMultipleString(b []byte) (MultipleString, error) {
+	if len(b) < 1 {
+		return nil, ErrInvalidTable
+	}
+	count := int(b[0])
+	b = b[1:]
+
+	ms := make(MultipleString, 0, count)
+	for range count {
+		if len(b) < 4 {
+			return nil, ErrInvalidTable
+		}
+		ls := LangString{Language: string(b[:3])}
+		segments := int(b[3])
+		b = b[4:]
+
+		var text strings.Builder
+		for range segments {
+			if len(b) < 3 || 3+int(b[2]) > len(b) {
+				return nil, ErrInvalidTable
+			}
+			compression, mode, data := b[0], b[1], b[3:3+int(b[2])]
+			b = b[3+len(data):]
+			if compression == compressionNone {
+				decodeSegment(&text, mode, data)
+			}
+		}
+		ls.Text = text.String()
+		ms = append(ms, ls)
+	}
+	return ms, nil
+}
+
+func decodeSegment(text *strings.Builder, mode byte, data []byte) {
+	switch {
+	case mode <= modeMaxUnicodePage:
+		for _, c := range data {
+			text.WriteRune(rune(mode)<<8 | rune(c))
+		}
+	case mode == modeUTF16:
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		}
+		text.WriteString(string(utf16.Decode(units)))
+	}
+}
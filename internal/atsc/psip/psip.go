@@ -0,0 +1,137 @@
+// Package psip parses the tables of the ATSC Program and System Information
+// Protocol (PSIP), as defined by ATSC A/65, from sections read with the mpegts
+// package.
+package psip
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/featherbread/hypcast/internal/atsc/mpegts"
+)
+
+// PIDBase is the PID that carries the master guide table, virtual channel
+// tables, and system time table of every ATSC transport stream.
+const PIDBase mpegts.PID = 0x1FFB
+
+// The following are the table IDs of the PSIP tables.
+const (
+	TableIDMGT  uint8 = 0xC7
+	TableIDTVCT uint8 = 0xC8
+	TableIDCVCT uint8 = 0xC9
+	TableIDRRT  uint8 = 0xCA
+	TableIDEIT  uint8 = 0xCB
+	TableIDETT  uint8 = 0xCC
+	TableIDSTT  uint8 = 0xCD
+)
+
+// The following are the tags of the PSIP descriptors that this package can
+// parse.
+const (
+	DescriptorTagCaptionService      uint8 = 0x86
+	DescriptorTagExtendedChannelName uint8 = 0xA0
+)
+
+// ErrInvalidTable is returned when parsing a malformed PSIP table.
+var ErrInvalidTable error = errors.New("invalid PSIP table")
+
+// MGT represents a master guide table, which lists the PIDs and versions of
+// every other PSIP table in the transport stream.
+type MGT struct {
+	Version     uint8
+	Tables      []MGTTable
+	Descriptors []mpegts.Descriptor
+}
+
+// MGTTable represents a single entry of a master guide table.
+type MGTTable struct {
+	Type    TableType
+	PID     mpegts.PID
+	Version uint8
+	// Size is the total size in bytes of every section of the table.
+	Size        uint32
+	Descriptors []mpegts.Descriptor
+}
+
+// TableType identifies the kind of a table listed in a master guide table.
+type TableType uint16
+
+// The following are the table types that identify a single table. See the
+// methods of TableType for the ranges that identify numbered tables.
+const (
+	TableTypeTVCTCurrent TableType = 0x0000
+	TableTypeTVCTNext    TableType = 0x0001
+	TableTypeCVCTCurrent TableType = 0x0002
+	TableTypeCVCTNext    TableType = 0x0003
+	TableTypeChannelETT  TableType = 0x0004
+)
+
+// EIT reports whether t identifies an event information table, along with the
+// index of its 3 hour time slot (EIT-0 covers the current time slot).
+func (t TableType) EIT() (index int, ok bool) {
+	if t >= 0x0100 && t <= 0x017F {
+		return int(t - 0x0100), true
+	}
+	return 0, false
+}
+
+// EventETT reports whether t identifies an extended text table for events,
+// along with the index of the EIT that it corresponds to.
+func (t TableType) EventETT() (index int, ok bool) {
+	if t >= 0x0200 && t <= 0x027F {
+		return int(t - 0x0200), true
+	}
+	return 0, false
+}
+
+// ParseMGT parses a master guide table from a section.
+func ParseMGT(s mpegts.Section) (MGT, error) {
+	b := s.Data
+	if s.TableID != TableIDMGT || len(b) < 3 {
+		return MGT{}, ErrInvalidTable
+	}
+
+	mgt := MGT{Version: s.Version}
+	count := int(binary.BigEndian.Uint16(b[1:3]))
+	b = b[3:]
+	for range count {
+		const fixedSize = 11
+		if len(b) < fixedSize {
+			return MGT{}, ErrInvalidTable
+		}
+		descriptors, rest, err := parseDescriptorLoop(b[fixedSize-2:], 0x0FFF)
+		if err != nil {
+			return MGT{}, err
+		}
+		mgt.Tables = append(mgt.Tables, MGTTable{
+			Type:        TableType(binary.BigEndian.Uint16(b[0:2])),
+			PID:         mpegts.PID(binary.BigEndian.Uint16(b[2:4]) & 0x1FFF),
+			Version:     b[4] & 0x1F,
+			Size:        binary.BigEndian.Uint32(b[5:9]),
+			Descriptors: descriptors,
+		})
+		b = rest
+	}
+
+	descriptors, _, err := parseDescriptorLoop(b, 0x0FFF)
+	if err != nil {
+		return MGT{}, err
+	}
+	mgt.Descriptors = descriptors
+	return mgt, nil
+}
+
+// parseDescriptorLoop parses a descriptor loop prefixed by a length field, and
+// returns the descriptors along with the data that follows the loop. PSIP
+// tables use both 10 and 12 bit lengths, as selected by mask.
+func parseDescriptorLoop(b []byte, mask uint16) ([]mpegts.Descriptor, []byte, error) {
+	if len(b) < 2 {
+		return nil, nil, ErrInvalidTable
+	}
+	length := int(binary.BigEndian.Uint16(b[0:2]) & mask)
+	if 2+length > len(b) {
+		return nil, nil, ErrInvalidTable
+	}
+	descriptors, err := mpegts.ParseDescriptors(b[2 : 2+length])
+	return descriptors, b[2+length:], err
+}
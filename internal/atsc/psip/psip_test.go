@@ -0,0 +1,318 @@
+package psip
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/featherbread/hypcast/internal/atsc/mpegts"
+)
+
+func TestParseMGT(t *testing.T) {
+	data := []byte{0x00, 0x00, 0x03} // Protocol version, 3 tables.
+	data = appendMGTTable(data, 0x0000, 0x1FFB, 4, 0x0123)
+	data = appendMGTTable(data, 0x0100, 0x1D00, 7, 0x0456)
+	data = appendMGTTable(data, 0x0201, 0x1E01, 2, 0x0789)
+	data = append(data, 0xF0, 0x03, 0x80, 0x01, 0xAA) // One global descriptor.
+
+	got, err := ParseMGT(mpegts.Section{TableID: TableIDMGT, Version: 5, Data: data})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := MGT{
+		Version: 5,
+		Tables: []MGTTable{
+			{Type: TableTypeTVCTCurrent, PID: 0x1FFB, Version: 4, Size: 0x0123},
+			{Type: 0x0100, PID: 0x1D00, Version: 7, Size: 0x0456},
+			{Type: 0x0201, PID: 0x1E01, Version: 2, Size: 0x0789},
+		},
+		Descriptors: []mpegts.Descriptor{{Tag: 0x80, Data: []byte{0xAA}}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected MGT (-want +got):\n%s", diff)
+	}
+
+	if index, ok := got.Tables[1].Type.EIT(); !ok || index != 0 {
+		t.Errorf("EIT() of %#04x = %d, %v; want 0, true", got.Tables[1].Type, index, ok)
+	}
+	if index, ok := got.Tables[2].Type.EventETT(); !ok || index != 1 {
+		t.Errorf("EventETT() of %#04x = %d, %v; want 1, true", got.Tables[2].Type, index, ok)
+	}
+	if _, ok := got.Tables[0].Type.EIT(); ok {
+		t.Errorf("EIT() of %#04x reported an EIT", got.Tables[0].Type)
+	}
+}
+
+func TestParseVCT(t *testing.T) {
+	extendedName := appendLangString([]byte{0x01}, "eng", 0x00, []byte("KCTS Cascade PBS"))
+
+	data := []byte{0x00, 0x03} // Protocol version, 3 channels.
+	data = appendVirtualChannel(data, testChannel{
+		name: "KCTS-HD", major: 9, minor: 1, program: 3, source: 1,
+		descriptors: append([]byte{DescriptorTagExtendedChannelName, byte(len(extendedName))}, extendedName...),
+	})
+	data = appendVirtualChannel(data, testChannel{name: "KIDS", major: 9, minor: 2, program: 4, source: 2})
+	data = appendVirtualChannel(data, testChannel{name: "TEST", major: 9, minor: 99, program: 5, source: 3, hidden: true})
+	data = append(data, 0xFC, 0x00) // Additional descriptors length.
+
+	got, err := ParseVCT(mpegts.Section{TableID: TableIDTVCT, TableIDExtension: 0x0ABC, Version: 2, Data: data})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got.Cable || got.TransportStreamID != 0x0ABC || got.Version != 2 || len(got.Channels) != 3 {
+		t.Fatalf("unexpected VCT header: %+v", got)
+	}
+
+	type summary struct {
+		ShortName     string
+		Number        string
+		ProgramNumber uint16
+		SourceID      uint16
+		Hidden        bool
+		ServiceType   ServiceType
+		ExtendedName  string
+	}
+	var gotSummaries []summary
+	for _, vc := range got.Channels {
+		gotSummaries = append(gotSummaries, summary{
+			ShortName:     vc.ShortName,
+			Number:        vc.Number(),
+			ProgramNumber: vc.ProgramNumber,
+			SourceID:      vc.SourceID,
+			Hidden:        vc.Hidden,
+			ServiceType:   vc.ServiceType,
+			ExtendedName:  vc.ExtendedName(),
+		})
+	}
+	want := []summary{
+		{"KCTS-HD", "9.1", 3, 1, false, ServiceTypeDigitalTV, "KCTS Cascade PBS"},
+		{"KIDS", "9.2", 4, 2, false, ServiceTypeDigitalTV, ""},
+		{"TEST", "9.99", 5, 3, true, ServiceTypeDigitalTV, ""},
+	}
+	if diff := cmp.Diff(want, gotSummaries); diff != "" {
+		t.Errorf("unexpected channels (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseVCTInvalid(t *testing.T) {
+	data := appendVirtualChannel([]byte{0x00, 0x02}, testChannel{name: "ONLY", major: 2, minor: 1})
+	if _, err := ParseVCT(mpegts.Section{TableID: TableIDCVCT, Data: data}); err == nil {
+		t.Error("parsed a VCT with a missing channel")
+	}
+	if _, err := ParseVCT(mpegts.Section{TableID: TableIDMGT, Data: data}); err == nil {
+		t.Error("parsed a VCT from an MGT section")
+	}
+}
+
+func TestVirtualChannelNumber(t *testing.T) {
+	testCases := []struct {
+		major, minor uint16
+		want         string
+	}{
+		{major: 4, minor: 1, want: "4.1"},
+		{major: 99, minor: 12, want: "99.12"},
+		{major: 1008, minor: 5, want: "5"},
+		{major: 1009, minor: 5, want: "1029"},
+	}
+	for _, tc := range testCases {
+		if got := (VirtualChannel{Major: tc.major, Minor: tc.minor}).Number(); got != tc.want {
+			t.Errorf("Number() of %d-%d = %q, want %q", tc.major, tc.minor, got, tc.want)
+		}
+	}
+}
+
+func TestParseMultipleString(t *testing.T) {
+	var utf16Data []byte
+	for _, u := range utf16.Encode([]rune("Noticias ☂")) {
+		utf16Data = binary.BigEndian.AppendUint16(utf16Data, u)
+	}
+
+	b := []byte{0x03} // 3 strings.
+	b = appendLangString(b, "eng", 0x00, []byte("News"))
+	b = append(b, "spa"...)
+	b = append(b, 0x01) // 1 segment.
+	b = append(b, 0x00, 0x3F, byte(len(utf16Data)))
+	b = append(b, utf16Data...)
+	b = append(b, "fra"...)
+	b = append(b, 0x03) // 3 segments.
+	b = append(b, 0x00, 0x00, 0x03, 'J', 'o', 'u')
+	b = append(b, 0x01, 0x00, 0x02, 0xDE, 0xAD) // Huffman compressed, skipped.
+	b = append(b, 0x00, 0x01, 0x02, 0x7D, 0x7E) // Latin Extended-A page.
+
+	got, err := ParseMultipleString(b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := MultipleString{
+		{Language: "eng", Text: "News"},
+		{Language: "spa", Text: "Noticias ☂"},
+		{Language: "fra", Text: "JouŽž"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected result (-want +got):\n%s", diff)
+	}
+
+	if text := got.Lookup("spa"); text != "Noticias ☂" {
+		t.Errorf("Lookup(spa) = %q", text)
+	}
+	if text := got.Lookup("deu"); text != "News" {
+		t.Errorf("Lookup(deu) = %q, want fallback to first language", text)
+	}
+
+	if _, err := ParseMultipleString(b[:len(b)-1]); err == nil {
+		t.Error("parsed a truncated multiple string structure")
+	}
+}
+
+func TestParseEIT(t *testing.T) {
+	title := appendLangString([]byte{0x01}, "eng", 0x00, []byte("Nature"))
+	data := []byte{0x00, 0x02} // Protocol version, 2 events.
+	data = appendEvent(data, 0x0101, 1_400_000_000, 3600, title)
+	data = appendEvent(data, 0x0102, 1_400_003_600, 1800, nil)
+
+	got, err := ParseEIT(mpegts.Section{TableID: TableIDEIT, TableIDExtension: 3, Version: 1, Data: data})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := EIT{
+		SourceID: 3,
+		Version:  1,
+		Events: []Event{
+			{EventID: 0x0101, StartTime: 1_400_000_000, Duration: time.Hour, ETMLocation: 1, Title: MultipleString{{Language: "eng", Text: "Nature"}}},
+			{EventID: 0x0102, StartTime: 1_400_003_600, Duration: 30 * time.Minute, ETMLocation: 1},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected EIT (-want +got):\n%s", diff)
+	}
+
+	if _, err := ParseEIT(mpegts.Section{TableID: TableIDEIT, Data: data[:len(data)-1]}); err == nil {
+		t.Error("parsed a truncated EIT")
+	}
+}
+
+func TestParseETT(t *testing.T) {
+	data := []byte{0x00}
+	data = binary.BigEndian.AppendUint32(data, EventETMID(3, 0x0101))
+	data = appendLangString(append(data, 0x01), "eng", 0x00, []byte("Penguins."))
+
+	got, err := ParseETT(mpegts.Section{TableID: TableIDETT, Data: data})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := ETT{ETMID: 0x0003_0406, Text: MultipleString{{Language: "eng", Text: "Penguins."}}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected ETT (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseSTT(t *testing.T) {
+	data := []byte{0x00}
+	data = binary.BigEndian.AppendUint32(data, 1_400_000_018)
+	data = append(data, 18, 0x60, 0x00) // GPS-UTC offset, daylight saving.
+
+	got, err := ParseSTT(mpegts.Section{TableID: TableIDSTT, Data: data})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := time.Date(2024, time.May, 17, 16, 53, 20, 0, time.UTC)
+	if !got.Time().Equal(want) {
+		t.Errorf("Time() = %v, want %v", got.Time(), want)
+	}
+}
+
+func TestParseCaptionServices(t *testing.T) {
+	data := []byte{0xC2} // 2 services.
+	data = append(data, 'e', 'n', 'g', 0xC1, 0x3F, 0xFF)
+	data = append(data, 's', 'p', 'a', 0x3F, 0xBF, 0xFF)
+
+	got, err := ParseCaptionServices(mpegts.Descriptor{Tag: DescriptorTagCaptionService, Data: data})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []CaptionService{
+		{Language: "eng", Digital: true, ServiceNumber: 1},
+		{Language: "spa", Line21Field: 1, EasyReader: true},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected services (-want +got):\n%s", diff)
+	}
+
+	if _, err := ParseCaptionServices(mpegts.Descriptor{Tag: DescriptorTagCaptionService, Data: data[:8]}); err == nil {
+		t.Error("ParseCaptionServices() succeeded with truncated descriptor")
+	}
+}
+
+func FuzzParseVCT(f *testing.F) {
+	data := appendVirtualChannel([]byte{0x00, 0x01}, testChannel{name: "KCTS-HD", major: 9, minor: 1, program: 3})
+	f.Add(append(data, 0xFC, 0x00))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		vct, err := ParseVCT(mpegts.Section{TableID: TableIDTVCT, Data: data})
+		if err != nil {
+			return
+		}
+		for _, vc := range vct.Channels {
+			_ = vc.Number()
+			_ = vc.ExtendedName()
+		}
+	})
+}
+
+func appendMGTTable(b []byte, tableType uint16, pid mpegts.PID, version uint8, size uint32) []byte {
+	b = binary.BigEndian.AppendUint16(b, tableType)
+	b = binary.BigEndian.AppendUint16(b, 0xE000|uint16(pid))
+	b = append(b, 0xE0|version)
+	b = binary.BigEndian.AppendUint32(b, size)
+	return binary.BigEndian.AppendUint16(b, 0xF000) // Descriptors length.
+}
+
+func appendEvent(b []byte, eventID uint16, start, seconds uint32, title []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, 0xC000|eventID)
+	b = binary.BigEndian.AppendUint32(b, start)
+	b = binary.BigEndian.AppendUint32(b, (0xD00000|seconds)<<8|uint32(len(title))) // ETM in PSIP PID.
+	b = append(b, title...)
+	return binary.BigEndian.AppendUint16(b, 0xF000) // Descriptors length.
+}
+
+type testChannel struct {
+	name         string
+	major, minor uint32
+	program      uint16
+	source       uint16
+	hidden       bool
+	descriptors  []byte
+}
+
+func appendVirtualChannel(b []byte, c testChannel) []byte {
+	name := make([]byte, 14)
+	for i, r := range c.name {
+		binary.BigEndian.PutUint16(name[2*i:], uint16(r))
+	}
+	b = append(b, name...)
+	b = binary.BigEndian.AppendUint32(b, 0xF0000000|c.major<<18|c.minor<<8|0x04)
+	b = binary.BigEndian.AppendUint32(b, 0) // Carrier frequency.
+	b = binary.BigEndian.AppendUint16(b, 1) // Channel TSID.
+	b = binary.BigEndian.AppendUint16(b, c.program)
+	flags := uint16(0x0DC2) // No ETM, not hidden, digital TV.
+	if c.hidden {
+		flags |= 0x1000
+	}
+	b = binary.BigEndian.AppendUint16(b, flags)
+	b = binary.BigEndian.AppendUint16(b, c.source)
+	b = binary.BigEndian.AppendUint16(b, 0xFC00|uint16(len(c.descriptors)))
+	return append(b, c.descriptors...)
+}
+
+func appendLangString(b []byte, language string, mode byte, data []byte) []byte {
+	b = append(b, language...)
+	b = append(b, 0x01, 0x00, mode, byte(len(data)))
+	return append(b, data...)
+}
@@ -0,0 +1,175 @@
+package psip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/featherbread/hypcast/internal/atsc/mpegts"
+)
+
+// VCT represents a terrestrial or cable virtual channel table, which maps the
+// channel numbers that viewers see to the programs of transport streams.
+type VCT struct {
+	// Cable is set for a cable virtual channel table (CVCT), and unset for a
+	// terrestrial virtual channel table (TVCT).
+	Cable             bool
+	TransportStreamID uint16
+	Version           uint8
+	Channels          []VirtualChannel
+	Descriptors       []mpegts.Descriptor
+}
+
+// VirtualChannel represents a single entry of a virtual channel table.
+type VirtualChannel struct {
+	// ShortName is the name of the channel as broadcast by the station, up to 7
+	// characters long, such as "KCTS-HD".
+	ShortName      string
+	Major, Minor   uint16
+	ModulationMode uint8
+	// CarrierFrequency is deprecated by A/65, and is usually zero.
+	CarrierFrequency uint32
+	// ChannelTSID is the ID of the transport stream that carries the channel,
+	// which may differ from the one that carries the table.
+	ChannelTSID      uint16
+	ProgramNumber    uint16
+	ETMLocation      uint8
+	AccessControlled bool
+	// Hidden is set for channels that viewers should not be able to tune to
+	// directly, such as test signals.
+	Hidden bool
+	// HideGuide is set for hidden channels that should nonetheless appear in
+	// program guides.
+	HideGuide   bool
+	ServiceType ServiceType
+	// SourceID links the channel to the events of the EITs.
+	SourceID    uint16
+	Descriptors []mpegts.Descriptor
+}
+
+// Number returns the channel number in the form that viewers know it, such as
+// "9.1". A cable channel whose major number is in the range 1008 to 1023 uses a
+// one-part number formed from the low bits of both numbers.
+func (vc VirtualChannel) Number() string {
+	if n, ok := vc.OnePartNumber(); ok {
+		return fmt.Sprint(n)
+	}
+	return fmt.Sprintf("%d.%d", vc.Major, vc.Minor)
+}
+
+// OnePartNumber returns the one-part number of a cable channel, and reports
+// whether the channel has a one-part number instead of a major and minor
+// number.
+func (vc VirtualChannel) OnePartNumber() (n uint16, ok bool) {
+	if vc.Major&0x3F0 != 0x3F0 {
+		return 0, false
+	}
+	return (vc.Major&0x00F)<<10 | vc.Minor, true
+}
+
+// ExtendedName returns the channel's long name from its extended channel name
+// descriptor, such as "KCTS Cascade PBS", or an empty string if the channel has
+// no such descriptor.
+func (vc VirtualChannel) ExtendedName() string {
+	d, ok := mpegts.FindDescriptor(vc.Descriptors, DescriptorTagExtendedChannelName)
+	if !ok {
+		return ""
+	}
+	ms, err := ParseMultipleString(d.Data)
+	if err != nil {
+		return ""
+	}
+	return ms.String()
+}
+
+// ServiceType identifies the kind of service that a virtual channel carries.
+type ServiceType uint8
+
+// The following are the service types defined by ATSC A/65 and A/53.
+const (
+	ServiceTypeAnalogTV   ServiceType = 0x01
+	ServiceTypeDigitalTV  ServiceType = 0x02
+	ServiceTypeAudio      ServiceType = 0x03
+	ServiceTypeData       ServiceType = 0x04
+	ServiceTypeSoftware   ServiceType = 0x05
+	ServiceTypeATSC3Audio ServiceType = 0x08
+	ServiceTypeATSC3Video ServiceType = 0x09
+)
+
+func (t ServiceType) String() string {
+	switch t {
+	case ServiceTypeAnalogTV:
+		return "analog television"
+	case ServiceTypeDigitalTV:
+		return "digital television"
+	case ServiceTypeAudio:
+		return "audio"
+	case ServiceTypeData:
+		return "data"
+	case ServiceTypeSoftware:
+		return "software download"
+	case ServiceTypeATSC3Audio:
+		return "ATSC 3.0 audio"
+	case ServiceTypeATSC3Video:
+		return "ATSC 3.0 video"
+	default:
+		return fmt.Sprintf("ServiceType(%#02x)", uint8(t))
+	}
+}
+
+// ParseVCT parses a terrestrial or cable virtual channel table from a single
+// section. Tables that span multiple sections must be merged by the caller.
+func ParseVCT(s mpegts.Section) (VCT, error) {
+	b := s.Data
+	if (s.TableID != TableIDTVCT && s.TableID != TableIDCVCT) || len(b) < 2 {
+		return VCT{}, ErrInvalidTable
+	}
+
+	vct := VCT{
+		Cable:             s.TableID == TableIDCVCT,
+		TransportStreamID: s.TableIDExtension,
+		Version:           s.Version,
+	}
+	count := int(b[1])
+	b = b[2:]
+	for range count {
+		const fixedSize = 32
+		if len(b) < fixedSize {
+			return VCT{}, ErrInvalidTable
+		}
+		descriptors, rest, err := parseDescriptorLoop(b[fixedSize-2:], 0x03FF)
+		if err != nil {
+			return VCT{}, err
+		}
+
+		name := make([]uint16, 7)
+		for i := range name {
+			name[i] = binary.BigEndian.Uint16(b[2*i:])
+		}
+		vct.Channels = append(vct.Channels, VirtualChannel{
+			ShortName:        strings.TrimRight(string(utf16.Decode(name)), "\x00 "),
+			Major:            uint16(b[14]&0x0F)<<6 | uint16(b[15])>>2,
+			Minor:            uint16(b[15]&0x03)<<8 | uint16(b[16]),
+			ModulationMode:   b[17],
+			CarrierFrequency: binary.BigEndian.Uint32(b[18:22]),
+			ChannelTSID:      binary.BigEndian.Uint16(b[22:24]),
+			ProgramNumber:    binary.BigEndian.Uint16(b[24:26]),
+			ETMLocation:      b[26] >> 6,
+			AccessControlled: b[26]&0x20 != 0,
+			Hidden:           b[26]&0x10 != 0,
+			HideGuide:        b[26]&0x02 != 0,
+			ServiceType:      ServiceType(b[27] & 0x3F),
+			SourceID:         binary.BigEndian.Uint16(b[28:30]),
+			Descriptors:      descriptors,
+		})
+		b = rest
+	}
+
+	descriptors, _, err := parseDescriptorLoop(b, 0x03FF)
+	if err != nil {
+		return VCT{}, err
+	}
+	vct.Descriptors = descriptors
+	return vct, nil
+}
@@ -0,0 +1,102 @@
+package scan
+
+import (
+	"fmt"
+
+	"github.com/featherbread/hypcast/internal/atsc"
+)
+
+// Frequency represents a single RF channel of a band plan.
+type Frequency struct {
+	// Channel is the RF channel number that the band plan assigns to the
+	// frequency, which is generally unrelated to any virtual channel number.
+	Channel     uint
+	FrequencyHz uint
+	Modulation  atsc.Modulation
+}
+
+func (f Frequency) String() string {
+	return fmt.Sprintf("RF %d (%d Hz %s)", f.Channel, f.FrequencyHz, f.Modulation)
+}
+
+// BandPlan represents the set of frequencies to search in a scan, in the order
+// they should be searched. A band plan may list the same RF channel more than
+// once with different modulations, in which case the scan stops searching the
+// channel after the first modulation that produces a signal.
+type BandPlan []Frequency
+
+// BandPlanByName returns the band plan with the provided name, which must be
+// one of the following:
+//
+//	atsc   US and Canada terrestrial broadcast (8VSB, RF 2-36)
+//	qam    US and Canada cable (QAM-256 then QAM-64, standard plan RF 2-135)
+func BandPlanByName(name string) (BandPlan, bool) {
+	switch name {
+	case "atsc":
+		return BandPlanATSC, true
+	case "qam":
+		return BandPlanQAM, true
+	default:
+		return nil, false
+	}
+}
+
+var (
+	// BandPlanATSC covers terrestrial broadcast channels in the United States
+	// and Canada. The plan ends at RF 36, the highest channel remaining for
+	// television after the 600 MHz incentive auction.
+	BandPlanATSC = makeBandPlan(broadcastCenterHz, 2, 36, atsc.Modulation8VSB)
+
+	// BandPlanQAM covers clear QAM cable channels in the United States and
+	// Canada, using the standard (non-HRC, non-IRC) cable frequency plan.
+	BandPlanQAM = makeBandPlan(cableCenterHz, 2, 135, atsc.ModulationQAM256, atsc.ModulationQAM64)
+)
+
+func makeBandPlan(center func(uint) uint, first, last uint, modulations ...atsc.Modulation) BandPlan {
+	var plan BandPlan
+	for channel := first; channel <= last; channel++ {
+		for _, m := range modulations {
+			plan = append(plan, Frequency{
+				Channel:     channel,
+				FrequencyHz: center(channel),
+				Modulation:  m,
+			})
+		}
+	}
+	return plan
+}
+
+const mhz = 1_000_000
+
+// broadcastCenterHz returns the center frequency of a 6 MHz terrestrial
+// broadcast channel.
+func broadcastCenterHz(channel uint) uint {
+	switch {
+	case channel <= 4:
+		return (57 + 6*(channel-2)) * mhz
+	case channel <= 6:
+		return (79 + 6*(channel-5)) * mhz
+	case channel <= 13:
+		return (177 + 6*(channel-7)) * mhz
+	default:
+		return (473 + 6*(channel-14)) * mhz
+	}
+}
+
+// cableCenterHz returns the center frequency of a 6 MHz channel in the
+// standard cable frequency plan, which matches the broadcast plan for channels
+// 2 through 13.
+func cableCenterHz(channel uint) uint {
+	switch {
+	case channel <= 13:
+		return broadcastCenterHz(channel)
+	case channel <= 22:
+		return (123 + 6*(channel-14)) * mhz
+	case channel <= 94:
+		return (219 + 6*(channel-23)) * mhz
+	case channel <= 99:
+		return (93 + 6*(channel-95)) * mhz
+	default:
+		return (651 + 6*(channel-100)) * mhz
+	}
+}
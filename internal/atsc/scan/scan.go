@@ -0,0 +1,279 @@
+// Package scan searches transport streams for ATSC channels, to generate
+// channel lists for Hypcast without external tools.
+package scan
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/featherbread/hypcast/internal/atsc"
+	"github.com/featherbread/hypcast/internal/atsc/mpegts"
+	"github.com/featherbread/hypcast/internal/atsc/psip"
+)
+
+// Source provides transport streams for the frequencies of a band plan.
+type Source interface {
+	// Open starts receiving the transport stream at f, and returns a reader
+	// for the stream. If Open can tell that there is no signal at f, it may
+	// return ErrNoSignal, either from Open itself or from Read.
+	//
+	// The stream's Close method must unblock any concurrent Read, and must
+	// tolerate multiple calls.
+	Open(ctx context.Context, f Frequency) (io.ReadCloser, error)
+}
+
+var (
+	// ErrNoSignal indicates that a Source could not receive a signal at a
+	// frequency.
+	ErrNoSignal error = errors.New("no signal")
+
+	// ErrNoPrograms indicates that a transport stream did not contain a
+	// program association table.
+	ErrNoPrograms error = errors.New("no programs found")
+)
+
+// frequencyTimeout bounds the time that Scan spends searching a single
+// frequency, including the time for the source to acquire a signal.
+const frequencyTimeout = 15 * time.Second
+
+// Progress reports how far a scan has come through its band plan.
+type Progress struct {
+	// Done counts the frequencies of the band plan that the scan has finished
+	// searching, out of Total.
+	Done, Total int
+	// Channels counts the channels that the scan has found so far.
+	Channels int
+}
+
+// Scan searches every frequency of plan through src, and returns every channel
+// that it finds in the order of the band plan. When ctx ends before the scan
+// is complete, Scan returns the channels that it found along with the error of
+// ctx.
+//
+// If progress is not nil, Scan calls it after finishing each frequency of the
+// band plan.
+//
+// Scan ensures that the names of the returned channels are unique, so that the
+// result is suitable for [atsc.WriteChannelsConf].
+func Scan(ctx context.Context, src Source, plan BandPlan, progress func(Progress)) ([]atsc.Channel, error) {
+	var (
+		channels []atsc.Channel
+		names    = make(map[string]bool)
+		locked   = make(map[uint]bool)
+	)
+
+	report := func(done int) {
+		if progress != nil {
+			progress(Progress{Done: done, Total: len(plan), Channels: len(channels)})
+		}
+	}
+
+	for i, f := range plan {
+		if err := ctx.Err(); err != nil {
+			return channels, err
+		}
+		if locked[f.Channel] {
+			report(i + 1)
+			continue
+		}
+
+		found, err := scanFrequency(ctx, src, f)
+		if err != nil {
+			slog.Debug("No channels found", "frequency", f, "error", err)
+			report(i + 1)
+			continue
+		}
+		locked[f.Channel] = true
+		slog.Info("Found channels", "frequency", f, "count", len(found))
+
+		for _, ch := range found {
+			if names[ch.Name] {
+				ch.Name = fmt.Sprintf("%s (RF %d-%d)", ch.Name, f.Channel, ch.ProgramID)
+			}
+			names[ch.Name] = true
+			channels = append(channels, ch)
+		}
+		report(i + 1)
+	}
+
+	return channels, ctx.Err()
+}
+
+func scanFrequency(ctx context.Context, src Source, f Frequency) ([]atsc.Channel, error) {
+	ctx, cancel := context.WithTimeout(ctx, frequencyTimeout)
+	defer cancel()
+
+	rc, err := src.Open(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	stop := context.AfterFunc(ctx, func() { rc.Close() })
+	defer stop()
+
+	return ScanStream(rc, f)
+}
+
+const (
+	// maxScanPackets bounds the length of the stream that ScanStream reads,
+	// and is equivalent to several seconds of a full ATSC multiplex.
+	maxScanPackets = 1 << 16
+
+	// vctWaitPackets bounds how long ScanStream waits for a virtual channel
+	// table after it has found every program, since not every stream carries
+	// one. ATSC A/65 requires a virtual channel table at least every 400 ms.
+	vctWaitPackets = 1 << 14
+)
+
+// ScanStream reads the program association, program map, and virtual channel
+// tables from the transport stream in r, and returns a channel for each program
+// in the stream that carries video. The frequency and modulation of each
+// channel are taken from f.
+//
+// Channels are named and numbered by the virtual channel table when the stream
+// carries one. Otherwise, they are named by the RF channel number of f and their
+// program number, and have no virtual channel number.
+//
+// ScanStream stops reading once it has found every table it needs, or after
+// reading a limited number of packets. If r ends first, ScanStream returns the
+// channels that it found in the available data.
+func ScanStream(r io.Reader, f Frequency) ([]atsc.Channel, error) {
+	var (
+		pr         = mpegts.NewPacketReader(r)
+		assemblers = map[mpegts.PID]*mpegts.SectionAssembler{mpegts.PIDPAT: {}, psip.PIDBase: {}}
+		readErr    error
+
+		pat       *mpegts.PAT
+		pmts      = make(map[uint16]mpegts.PMT)
+		vct       = make(map[uint8][]psip.VirtualChannel)
+		vctLast   = -1
+		psiDoneAt = -1
+	)
+
+	vctComplete := func() bool {
+		return vctLast >= 0 && len(vct) == vctLast+1
+	}
+
+	for packets := 0; packets < maxScanPackets; packets++ {
+		p, err := pr.ReadPacket()
+		if err != nil {
+			readErr = err
+			break
+		}
+
+		sa, ok := assemblers[p.PID]
+		if !ok {
+			continue
+		}
+
+		for _, b := range sa.Push(p) {
+			s, err := mpegts.ParseSection(b)
+			if err != nil || !s.CurrentNext {
+				continue
+			}
+			switch {
+			case p.PID == mpegts.PIDPAT && pat == nil:
+				if parsed, err := mpegts.ParsePAT(s); err == nil {
+					pat = &parsed
+					for _, program := range pat.Programs {
+						assemblers[program.PMTPID] = new(mpegts.SectionAssembler)
+					}
+				}
+			case s.TableID == mpegts.TableIDPMT:
+				if pmt, err := mpegts.ParsePMT(s); err == nil {
+					pmts[pmt.ProgramNumber] = pmt
+				}
+			case p.PID == psip.PIDBase && (s.TableID == psip.TableIDTVCT || s.TableID == psip.TableIDCVCT):
+				if parsed, err := psip.ParseVCT(s); err == nil {
+					vct[s.SectionNumber] = parsed.Channels
+					vctLast = int(s.LastSectionNumber)
+				}
+			}
+		}
+
+		if psiDoneAt < 0 && pat != nil && len(pmts) >= len(pat.Programs) {
+			psiDoneAt = packets
+		}
+		if psiDoneAt >= 0 && (vctComplete() || packets-psiDoneAt >= vctWaitPackets) {
+			break
+		}
+	}
+
+	if pat == nil {
+		if readErr != nil && !errors.Is(readErr, io.EOF) && !errors.Is(readErr, io.ErrUnexpectedEOF) {
+			return nil, readErr
+		}
+		return nil, ErrNoPrograms
+	}
+
+	virtuals := make(map[uint16]psip.VirtualChannel)
+	for _, vcs := range vct {
+		for _, vc := range vcs {
+			virtuals[vc.ProgramNumber] = vc
+		}
+	}
+
+	var channels []atsc.Channel
+	for _, program := range pat.Programs {
+		videoPID, audioPID := selectStreams(pmts[program.Number])
+		if videoPID == 0 {
+			continue
+		}
+
+		channel := atsc.Channel{
+			Name:        fmt.Sprintf("RF%d-%d", f.Channel, program.Number),
+			FrequencyHz: f.FrequencyHz,
+			Modulation:  f.Modulation,
+			VideoPID:    uint(videoPID),
+			AudioPID:    uint(audioPID),
+			ProgramID:   uint(program.Number),
+		}
+		if vc, ok := virtuals[program.Number]; ok {
+			if vc.Hidden {
+				continue
+			}
+			applyVirtualChannel(&channel, vc)
+		}
+		channels = append(channels, channel)
+	}
+	slices.SortFunc(channels, func(a, b atsc.Channel) int { return cmp.Compare(a.ProgramID, b.ProgramID) })
+	return channels, nil
+}
+
+// applyVirtualChannel sets the number and names of channel from the entry of a
+// virtual channel table that describes it.
+func applyVirtualChannel(channel *atsc.Channel, vc psip.VirtualChannel) {
+	if n, ok := vc.OnePartNumber(); ok {
+		channel.MajorNumber, channel.MinorNumber = uint(n), 0
+	} else {
+		channel.MajorNumber, channel.MinorNumber = uint(vc.Major), uint(vc.Minor)
+	}
+
+	// Colons would break the channels.conf format.
+	channel.ShortName = strings.ReplaceAll(vc.ShortName, ":", "-")
+	if channel.ShortName != "" {
+		channel.Name = channel.ShortName
+	}
+}
+
+// selectStreams returns the PIDs of the first video and audio streams of a
+// program, or 0 if the program has no stream of that kind.
+func selectStreams(pmt mpegts.PMT) (videoPID, audioPID mpegts.PID) {
+	for _, es := range pmt.Streams {
+		if es.Type.IsVideo() && videoPID == 0 {
+			videoPID = es.PID
+		}
+		if es.Type.IsAudio() && audioPID == 0 {
+			audioPID = es.PID
+		}
+	}
+	return
+}
@@ -0,0 +1,236 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/featherbread/hypcast/internal/atsc"
+	"github.com/featherbread/hypcast/internal/atsc/mpegts"
+	"github.com/featherbread/hypcast/internal/atsc/mpegts/mpegtstest"
+	"github.com/featherbread/hypcast/internal/atsc/psip"
+)
+
+var kctsFrequency = Frequency{Channel: 9, FrequencyHz: 189_000_000, Modulation: atsc.Modulation8VSB}
+
+type testProgram struct {
+	number   uint16
+	pmtPID   mpegts.PID
+	videoPID uint16
+	audioPID uint16
+	name     string
+	hidden   bool
+}
+
+var kctsPrograms = []testProgram{
+	{number: 3, pmtPID: 48, videoPID: 49, audioPID: 52, name: "KCTS-HD"},
+	{number: 4, pmtPID: 64, videoPID: 65, audioPID: 68, name: "KIDS"},
+	{number: 5, pmtPID: 80, videoPID: 81, audioPID: 84, name: "CREATE"},
+	{number: 6, pmtPID: 96, videoPID: 97, audioPID: 100, name: "WORLD"},
+	{number: 7, pmtPID: 112, videoPID: 113, audioPID: 116, name: "HIDDEN", hidden: true},
+}
+
+var kctsChannels = []atsc.Channel{
+	{Name: "KCTS-HD", FrequencyHz: 189_000_000, Modulation: atsc.Modulation8VSB, VideoPID: 49, AudioPID: 52, ProgramID: 3, MajorNumber: 9, MinorNumber: 1, ShortName: "KCTS-HD"},
+	{Name: "KIDS", FrequencyHz: 189_000_000, Modulation: atsc.Modulation8VSB, VideoPID: 65, AudioPID: 68, ProgramID: 4, MajorNumber: 9, MinorNumber: 2, ShortName: "KIDS"},
+	{Name: "CREATE", FrequencyHz: 189_000_000, Modulation: atsc.Modulation8VSB, VideoPID: 81, AudioPID: 84, ProgramID: 5, MajorNumber: 9, MinorNumber: 3, ShortName: "CREATE"},
+	{Name: "WORLD", FrequencyHz: 189_000_000, Modulation: atsc.Modulation8VSB, VideoPID: 97, AudioPID: 100, ProgramID: 6, MajorNumber: 9, MinorNumber: 4, ShortName: "WORLD"},
+}
+
+func TestScanStream(t *testing.T) {
+	testCases := []struct {
+		name    string
+		stream  []byte
+		want    []atsc.Channel
+		wantErr error
+	}{
+		{
+			name:   "full multiplex",
+			stream: makeStream(kctsPrograms, true),
+			want:   kctsChannels,
+		},
+		{
+			name:   "leading garbage",
+			stream: append([]byte{0x00, 0x47, 0x12}, makeStream(kctsPrograms, true)...),
+			want:   kctsChannels,
+		},
+		{
+			name:   "no virtual channel table",
+			stream: makeStream(kctsPrograms[:2], false),
+			want: []atsc.Channel{
+				{Name: "RF9-3", FrequencyHz: 189_000_000, Modulation: atsc.Modulation8VSB, VideoPID: 49, AudioPID: 52, ProgramID: 3},
+				{Name: "RF9-4", FrequencyHz: 189_000_000, Modulation: atsc.Modulation8VSB, VideoPID: 65, AudioPID: 68, ProgramID: 4},
+			},
+		},
+		{
+			name:    "no program association table",
+			stream:  bytes.Repeat(nullPacket(), 16),
+			wantErr: ErrNoPrograms,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ScanStream(bytes.NewReader(tc.stream), kctsFrequency)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("ScanStream() error = %v, want %v", err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected channels (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestScan(t *testing.T) {
+	src := testSource{
+		// The first modulation to lock wins.
+		{Channel: 9, FrequencyHz: 189_000_000, Modulation: atsc.ModulationQAM256}: makeStream(kctsPrograms, true),
+		{Channel: 9, FrequencyHz: 189_000_000, Modulation: atsc.ModulationQAM64}:  makeStream(kctsPrograms, true),
+		// Duplicate names must be made unique.
+		{Channel: 10, FrequencyHz: 195_000_000, Modulation: atsc.ModulationQAM256}: makeStream(kctsPrograms[1:2], true),
+	}
+	plan := makeBandPlan(cableCenterHz, 8, 10, atsc.ModulationQAM256, atsc.ModulationQAM64)
+
+	var progress []Progress
+	got, err := Scan(context.Background(), src, plan, func(p Progress) { progress = append(progress, p) })
+	if err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+
+	var want []atsc.Channel
+	for _, ch := range kctsChannels {
+		ch.Modulation = atsc.ModulationQAM256
+		want = append(want, ch)
+	}
+	want = append(want, atsc.Channel{
+		Name: "KIDS (RF 10-4)", FrequencyHz: 195_000_000, Modulation: atsc.ModulationQAM256, VideoPID: 65, AudioPID: 68, ProgramID: 4,
+		MajorNumber: 9, MinorNumber: 1, ShortName: "KIDS",
+	})
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected channels (-want +got):\n%s", diff)
+	}
+
+	// Frequencies skipped after another modulation locked still count.
+	n := len(kctsChannels)
+	wantProgress := []Progress{
+		{Done: 1, Total: 6}, {Done: 2, Total: 6},
+		{Done: 3, Total: 6, Channels: n}, {Done: 4, Total: 6, Channels: n},
+		{Done: 5, Total: 6, Channels: n + 1}, {Done: 6, Total: 6, Channels: n + 1},
+	}
+	if diff := cmp.Diff(wantProgress, progress); diff != "" {
+		t.Errorf("unexpected progress (-want +got):\n%s", diff)
+	}
+}
+
+func TestScanCanceled(t *testing.T) {
+	src := testSource{
+		{Channel: 9, FrequencyHz: 189_000_000, Modulation: atsc.ModulationQAM256}: makeStream(kctsPrograms, true),
+	}
+	plan := makeBandPlan(cableCenterHz, 9, 10, atsc.ModulationQAM256)
+
+	// Canceling after the first frequency keeps the channels found on it.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got, err := Scan(ctx, src, plan, func(Progress) { cancel() })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Scan() error = %v, want %v", err, context.Canceled)
+	}
+	if len(got) != len(kctsChannels) {
+		t.Errorf("canceled Scan() found %d channels, want %d", len(got), len(kctsChannels))
+	}
+}
+
+func TestBandPlanFrequencies(t *testing.T) {
+	testCases := []struct {
+		center  func(uint) uint
+		channel uint
+		want    uint
+	}{
+		{broadcastCenterHz, 2, 57_000_000},
+		{broadcastCenterHz, 6, 85_000_000},
+		{broadcastCenterHz, 9, 189_000_000},
+		{broadcastCenterHz, 14, 473_000_000},
+		{broadcastCenterHz, 36, 605_000_000},
+		{cableCenterHz, 14, 123_000_000},
+		{cableCenterHz, 23, 219_000_000},
+		{cableCenterHz, 95, 93_000_000},
+		{cableCenterHz, 100, 651_000_000},
+	}
+	for _, tc := range testCases {
+		if got := tc.center(tc.channel); got != tc.want {
+			t.Errorf("center frequency of channel %d = %d, want %d", tc.channel, got, tc.want)
+		}
+	}
+}
+
+type testSource map[Frequency][]byte
+
+func (ts testSource) Open(_ context.Context, f Frequency) (io.ReadCloser, error) {
+	if stream, ok := ts[f]; ok {
+		return io.NopCloser(bytes.NewReader(stream)), nil
+	}
+	return nil, ErrNoSignal
+}
+
+// makeStream generates a transport stream carrying the PSI for programs, with
+// null packets standing in for audio and video data.
+func makeStream(programs []testProgram, withVCT bool) []byte {
+	var pat []byte
+	for _, p := range programs {
+		pat = binary.BigEndian.AppendUint16(pat, p.number)
+		pat = binary.BigEndian.AppendUint16(pat, 0xE000|uint16(p.pmtPID))
+	}
+
+	var stream []byte
+	stream = append(stream, nullPacket()...)
+	stream = append(stream, mpegtstest.Packetize(mpegts.PIDPAT, mpegtstest.Section(mpegts.TableIDPAT, 1, 0, pat))...)
+	for _, p := range programs {
+		pmt := []byte{0xE0 | byte(p.videoPID>>8), byte(p.videoPID), 0xF0, 0x00}
+		pmt = append(pmt, 0x02, 0xE0|byte(p.videoPID>>8), byte(p.videoPID), 0xF0, 0x00)
+		pmt = append(pmt, 0x81, 0xE0|byte(p.audioPID>>8), byte(p.audioPID), 0xF0, 0x00)
+		stream = append(stream, mpegtstest.Packetize(p.pmtPID, mpegtstest.Section(mpegts.TableIDPMT, p.number, 0, pmt))...)
+		stream = append(stream, nullPacket()...)
+	}
+	if withVCT {
+		stream = append(stream, mpegtstest.Packetize(psip.PIDBase, mpegtstest.Section(psip.TableIDTVCT, 1, 0, makeVCT(programs)))...)
+	}
+	return stream
+}
+
+func makeVCT(programs []testProgram) []byte {
+	vct := []byte{0x00, byte(len(programs))}
+	for i, p := range programs {
+		name := make([]byte, 14)
+		for j, r := range utf16.Encode([]rune(p.name)) {
+			binary.BigEndian.PutUint16(name[2*j:], r)
+		}
+		vct = append(vct, name...)
+
+		major, minor := uint32(9), uint32(i+1)
+		vct = binary.BigEndian.AppendUint32(vct, 0xF0000000|major<<18|minor<<8|0x04)
+		vct = binary.BigEndian.AppendUint32(vct, 0)        // Carrier frequency.
+		vct = binary.BigEndian.AppendUint16(vct, 1)        // Channel TSID.
+		vct = binary.BigEndian.AppendUint16(vct, p.number) // Program number.
+		flags := uint16(0x0DC2)                            // ATSC digital television.
+		if p.hidden {
+			flags |= 0x1000
+		}
+		vct = binary.BigEndian.AppendUint16(vct, flags)
+		vct = binary.BigEndian.AppendUint16(vct, p.number) // Source ID.
+		vct = binary.BigEndian.AppendUint16(vct, 0xFC00)   // Descriptors length.
+	}
+	return binary.BigEndian.AppendUint16(vct, 0xFC00) // Additional descriptors length.
+}
+
+func nullPacket() []byte {
+	packet := make([]byte, mpegts.PacketSize)
+	copy(packet, []byte{mpegts.SyncByte, 0x1F, 0xFF, 0x10})
+	return packet
+}
@@ -0,0 +1,184 @@
+package tuner
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/featherbread/hypcast/internal/atsc"
+	"github.com/featherbread/hypcast/internal/atsc/mpegts"
+)
+
+// AudioTrack describes an audio stream of a program, as listed in the
+// program's map table.
+type AudioTrack struct {
+	PID        uint
+	StreamType mpegts.StreamType
+	// Language is the three letter ISO 639-2 code for the language of the
+	// track, such as "eng" or "spa", if the broadcaster lists it.
+	Language string
+	// Type distinguishes special purpose tracks, like descriptive audio for the
+	// visually impaired, from the main audio of the program.
+	Type mpegts.AudioType
+}
+
+// supported reports whether the tuner can decode the track.
+func (at AudioTrack) supported() bool {
+	_, ok := audioDecoders[at.StreamType]
+	return ok
+}
+
+// defaultAudioTrack returns the first audio track in tracks that the tuner can
+// decode.
+func defaultAudioTrack(tracks []AudioTrack) (AudioTrack, bool) {
+	i := slices.IndexFunc(tracks, AudioTrack.supported)
+	if i < 0 {
+		return AudioTrack{}, false
+	}
+	return tracks[i], true
+}
+
+func audioTracksOf(pmt mpegts.PMT) []AudioTrack {
+	var tracks []AudioTrack
+	for _, es := range pmt.Streams {
+		if !es.Type.IsAudio() {
+			continue
+		}
+		track := AudioTrack{PID: uint(es.PID), StreamType: es.Type}
+		if d, ok := mpegts.FindDescriptor(es.Descriptors, mpegts.DescriptorTagISO639); ok {
+			if languages, err := mpegts.ParseISO639Language(d); err == nil && len(languages) > 0 {
+				track.Language, track.Type = languages[0].Code, languages[0].Type
+			}
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks
+}
+
+// updateAudio makes sure that p, which has started, streams one of the audio
+// tracks that its program map table now lists.
+func (t *Tuner) updateAudio(p *program, tracks []AudioTrack) {
+	fallback, ok := defaultAudioTrack(tracks)
+	switch {
+	case !ok:
+		// There's nothing better to switch to.
+	case p.audio == nil:
+		// The program started without knowing which track to use.
+		if err := t.startAudio(p, fallback); err != nil {
+			t.log.Error("Failed to start audio track", "channel", p.channel.Name, "error", err)
+		}
+	case !slices.ContainsFunc(tracks, func(at AudioTrack) bool { return at.PID == p.audioTrack.PID }):
+		// The channel definition may name a stream that the broadcaster no
+		// longer sends.
+		t.log.Warn("Audio track not found in program", "channel", p.channel.Name, "pid", p.audioTrack.PID)
+		if err := t.switchAudio(p, fallback); err != nil {
+			t.log.Error("Failed to switch audio track", "channel", p.channel.Name, "error", err)
+		}
+	}
+}
+
+var (
+	// ErrAudioTrackNotFound is returned when selecting an audio track that the
+	// tuner's current program does not list.
+	ErrAudioTrackNotFound error = errors.New("audio track not found")
+
+	// ErrAudioTrackUnsupported is returned when selecting an audio track that
+	// the tuner can't decode.
+	ErrAudioTrackUnsupported error = errors.New("audio track format not supported")
+)
+
+// SelectAudio switches the audio of the tuner's current channel to the audio
+// track with the provided PID, as listed in the tuner's status, without
+// interrupting the video. Viewers of the tuner's current channel hear the new
+// track along with the tuner's own clients.
+func (t *Tuner) SelectAudio(pid uint) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.current == nil || t.current.branch == nil {
+		return ErrTunerNotPlaying
+	}
+	p := t.current
+
+	tracks := t.mux.programMaps[p.channel.ProgramID].AudioTracks
+	i := slices.IndexFunc(tracks, func(at AudioTrack) bool { return at.PID == pid })
+	if i < 0 {
+		return ErrAudioTrackNotFound
+	}
+	if !tracks[i].supported() {
+		return ErrAudioTrackUnsupported
+	}
+	if pid == p.audioTrack.PID {
+		return nil
+	}
+
+	if err := t.switchAudio(p, tracks[i]); err != nil {
+		return err
+	}
+	t.status.Set(t.currentStatus())
+	return nil
+}
+
+// ErrTunerNotPlaying is returned when changing the stream of a tuner that
+// isn't streaming a channel.
+var ErrTunerNotPlaying error = errors.New("tuner is not playing")
+
+// startAudio starts the audio branch of p for track.
+func (t *Tuner) startAudio(p *program, track AudioTrack) error {
+	decoder, ok := audioDecoders[track.StreamType]
+	if !ok {
+		return ErrAudioTrackUnsupported
+	}
+	description, err := t.createPipelineDescription("audio", p.channel, p.loss.audioLossStreams(pipelineStreams{
+		AudioPID:     track.PID,
+		AudioDecoder: decoder,
+	}))
+	if err != nil {
+		return err
+	}
+
+	branch, err := t.mux.pipeline.NewBranch(audioBranchName(p.channel), description)
+	if err != nil {
+		return err
+	}
+	branch.SetSink(sinkNameAudio, p.audioSource.WriteSample)
+	if err := branch.Link(teeNameMultiplex); err != nil {
+		branch.Close()
+		return err
+	}
+
+	p.audio, p.audioTrack = branch, track
+	t.log.Info("Started audio", "channel", p.channel.Name, "pid", track.PID, "codec", track.StreamType)
+	return nil
+}
+
+// switchAudio replaces the audio branch of p with one for track. The program
+// keeps the same WebRTC audio track, so clients hear the change without
+// renegotiating their sessions.
+func (t *Tuner) switchAudio(p *program, track AudioTrack) error {
+	old := p.audioTrack
+	t.stopAudio(p)
+
+	err := t.startAudio(p, track)
+	if err != nil && old.PID != 0 {
+		// Try not to leave the program silent.
+		if restoreErr := t.startAudio(p, old); restoreErr != nil {
+			t.log.Error("Failed to restore audio track", "channel", p.channel.Name, "error", restoreErr)
+		}
+	}
+	return err
+}
+
+// stopAudio stops the audio branch of p, if it has one.
+func (t *Tuner) stopAudio(p *program) {
+	if p.audio == nil {
+		return
+	}
+	err := p.audio.Close()
+	t.log.Info("Stopped audio", "channel", p.channel.Name, "pid", p.audioTrack.PID, "error", err)
+	p.audio, p.audioTrack = nil, AudioTrack{}
+}
+
+func audioBranchName(channel atsc.Channel) string {
+	return fmt.Sprintf("program-%d-audio", channel.ProgramID)
+}
@@ -0,0 +1,124 @@
+package tuner
+
+import (
+	"log/slog"
+	"strconv"
+	"sync"
+
+	"github.com/featherbread/hypcast/internal/gst"
+)
+
+// encoderBitrates gives the range of video bitrates, in bits per second, that
+// each video pipeline's encoder runs at for VideoLayerHigh. Each encoder
+// starts at the top of its range, and the template writes that bitrate into
+// the pipeline description.
+var encoderBitrates = map[VideoPipeline]struct{ Min, Max int }{
+	VideoPipelineDefault:  {Min: 500_000, Max: 8_000_000},
+	VideoPipelineLowPower: {Min: 300_000, Max: 2_500_000},
+	VideoPipelineVAAPI:    {Min: 500_000, Max: 12_000_000},
+}
+
+// audioBitrate is the bitrate of the Opus audio that the tuner produces, which
+// clients receive alongside the video. This needs to match up with the
+// GStreamer pipeline definition.
+const audioBitrate = 128_000
+
+// encoderNamePrefix starts the name of the video encoder element for each
+// layer in the program branch, which is followed by the name of the layer. The
+// encoders' bitrate properties are in kilobits per second.
+const encoderNamePrefix = "encoder-"
+
+// BitrateControl adjusts the bitrate of a video encoder to suit the clients
+// that receive its layer of a program, following the client with the least
+// bandwidth.
+type BitrateControl struct {
+	log       *slog.Logger
+	min, max  int
+	estimates worstReport[int]
+
+	mu      sync.Mutex
+	set     func(bitrate int) // nil once the program stops.
+	current int
+}
+
+func newBitrateControl(log *slog.Logger, limits struct{ Min, Max int }, set func(bitrate int)) *BitrateControl {
+	bc := &BitrateControl{
+		log:     log,
+		min:     limits.Min,
+		max:     limits.Max,
+		set:     set,
+		current: limits.Max,
+	}
+	bc.estimates = worstReport[int]{
+		worse:    func(a, b int) bool { return a < b },
+		retarget: bc.retarget,
+	}
+	return bc
+}
+
+// setEncoderBitrate returns a function that sets the bitrate of the encoder for
+// layer in the branch, for use with newBitrateControl.
+func setEncoderBitrate(log *slog.Logger, branch *gst.Branch, layer VideoLayer) func(int) {
+	return func(bitrate int) {
+		if err := branch.SetProperty(encoderNamePrefix+string(layer), "bitrate", strconv.Itoa(bitrate/1000)); err != nil {
+			log.Error("Failed to set encoder bitrate", "error", err)
+		}
+	}
+}
+
+// BitrateClient represents a single client's view of the bandwidth available
+// to it, as one input to a BitrateControl.
+type BitrateClient struct {
+	*reportClient[int]
+}
+
+// NewClient adds a client to the control. The encoder ignores the client until
+// it reports an estimate of its bandwidth with [BitrateClient.SetEstimate].
+func (bc *BitrateControl) NewClient() *BitrateClient {
+	return &BitrateClient{bc.estimates.newClient()}
+}
+
+// SetEstimate sets the estimated bandwidth available to the client, in bits
+// per second, for both the video and the audio of the program.
+func (c *BitrateClient) SetEstimate(bitrate int) {
+	c.report(max(bitrate-audioBitrate, 1))
+}
+
+// bitrateHysteresis is the fraction of the current bitrate that the target
+// bitrate must differ by to change the encoder's settings, other than to reach
+// the bounds of the encoder's range. It keeps small fluctuations in estimates
+// from constantly reconfiguring the encoder.
+const bitrateHysteresis = 0.1
+
+// retarget changes the encoder's bitrate to the lowest estimate among the
+// clients, within the encoder's range.
+func (bc *BitrateControl) retarget(estimate int, ok bool) func() {
+	target := bc.max
+	if ok {
+		target = min(target, estimate)
+	}
+	target = max(target, bc.min)
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if bc.set == nil || target == bc.current {
+		return nil
+	}
+	diff := float64(target-bc.current) / float64(bc.current)
+	if (target != bc.min && target != bc.max) && diff > -bitrateHysteresis && diff < bitrateHysteresis {
+		return nil
+	}
+
+	bc.log.Info("Changing video bitrate", "from", bc.current, "to", target)
+	bc.set(target)
+	bc.current = target
+	return nil
+}
+
+// stop keeps the control from changing the encoder, and must be called before
+// the encoder's branch closes.
+func (bc *BitrateControl) stop() {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.set = nil
+}
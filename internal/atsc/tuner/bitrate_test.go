@@ -0,0 +1,44 @@
+package tuner
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBitrateControl(t *testing.T) {
+	var set []int
+	bc := newBitrateControl(slog.Default(), struct{ Min, Max int }{Min: 500_000, Max: 8_000_000},
+		func(bitrate int) { set = append(set, bitrate) })
+
+	// Estimates cover the audio too, which the control takes out.
+	estimate := func(video int) int { return video + audioBitrate }
+
+	var a, b *BitrateClient
+	steps := []struct {
+		name string
+		do   func()
+		want []int
+	}{
+		{"new client without estimate", func() { a = bc.NewClient() }, nil},
+		{"first estimate", func() { a.SetEstimate(estimate(4_000_000)) }, []int{4_000_000}},
+		{"small increase", func() { a.SetEstimate(estimate(4_200_000)) }, nil},
+		{"small decrease", func() { a.SetEstimate(estimate(3_700_000)) }, nil},
+		{"large decrease", func() { a.SetEstimate(estimate(3_400_000)) }, []int{3_400_000}},
+		{"slower client clamped to minimum", func() { b = bc.NewClient(); b.SetEstimate(100_000) }, []int{500_000}},
+		{"slower client still below minimum", func() { b.SetEstimate(estimate(450_000)) }, nil},
+		{"slower client leaves", func() { b.Close() }, []int{3_400_000}},
+		{"large increase", func() { a.SetEstimate(estimate(7_500_000)) }, []int{7_500_000}},
+		{"small increase to maximum", func() { a.SetEstimate(estimate(20_000_000)) }, []int{8_000_000}},
+		{"last client leaves at maximum", func() { a.Close() }, nil},
+		{"stopped control", func() { bc.stop(); bc.NewClient().SetEstimate(estimate(1_000_000)) }, nil},
+	}
+	for _, step := range steps {
+		set = nil
+		step.do()
+		if diff := cmp.Diff(step.want, set); diff != "" {
+			t.Errorf("%s: unexpected bitrates set (-want +got):\n%s", step.name, diff)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package tuner
+
+import (
+	"errors"
+
+	"github.com/featherbread/hypcast/internal/atsc/caption"
+	"github.com/featherbread/hypcast/internal/atsc/mpegts"
+)
+
+// videoCodec describes the elements that the program branch uses for a kind of
+// video stream.
+type videoCodec struct {
+	// Parser follows the demuxer, and splits the stream into whole pictures for
+	// the decoder and the caption sink.
+	Parser       string
+	Decoder      string
+	VAAPIDecoder string
+	// DecodeCaptions decodes the captions carried in the parsed pictures, or is
+	// nil when the tuner can't find captions in the format.
+	DecodeCaptions func(*caption.Stream, []byte)
+}
+
+// h264Parser splits H.264 video into access units, and repeats the sequence
+// and picture parameter sets at each keyframe so that passed through video
+// can be decoded by clients that join at any keyframe.
+const h264Parser = "h264parse config-interval=-1 ! video/x-h264,stream-format=byte-stream,alignment=au"
+
+var videoCodecs = map[mpegts.StreamType]videoCodec{
+	mpegts.StreamTypeMPEG1Video: {
+		Parser:         "mpegvideoparse",
+		Decoder:        "avdec_mpeg2video",
+		VAAPIDecoder:   "vaapimpeg2dec",
+		DecodeCaptions: (*caption.Stream).DecodeMPEG2,
+	},
+	mpegts.StreamTypeMPEG2Video: {
+		Parser:         "mpegvideoparse",
+		Decoder:        "avdec_mpeg2video",
+		VAAPIDecoder:   "vaapimpeg2dec",
+		DecodeCaptions: (*caption.Stream).DecodeMPEG2,
+	},
+	mpegts.StreamTypeH264Video: {
+		Parser:         h264Parser,
+		Decoder:        "avdec_h264",
+		VAAPIDecoder:   "vaapih264dec",
+		DecodeCaptions: (*caption.Stream).DecodeH264,
+	},
+	mpegts.StreamTypeH265Video: {
+		Parser:       "h265parse",
+		Decoder:      "avdec_h265",
+		VAAPIDecoder: "vaapih265dec",
+	},
+}
+
+// audioDecoders holds the elements that the audio branch uses to decode each
+// kind of audio stream that the tuner supports.
+var audioDecoders = map[mpegts.StreamType]string{
+	mpegts.StreamTypeAC3Audio:   "a52dec",
+	mpegts.StreamTypeEAC3Audio:  "ac3parse ! avdec_eac3",
+	mpegts.StreamTypeMPEG1Audio: "mpegaudioparse ! avdec_mp2float",
+	mpegts.StreamTypeMPEG2Audio: "mpegaudioparse ! avdec_mp2float",
+	mpegts.StreamTypeAACAudio:   "aacparse ! avdec_aac",
+	mpegts.StreamTypeLATMAudio:  "aacparse ! avdec_aac_latm",
+}
+
+// ErrVideoUnsupported is reported through the tuner's status when the current
+// channel has no video stream that the tuner can decode.
+var ErrVideoUnsupported error = errors.New("video format not supported")
+
+// canPassThrough reports whether the tuner can send the H.264 video in v to
+// clients as is, which it can if the video is in the Constrained Baseline
+// profile at no more than the level in [VideoCodecCapability]. Main and High
+// profile video may use B-frames, whose timestamps don't increase in the order
+// that WebRTC sends them, or interlaced pictures, which browsers don't
+// deinterlace. The tuner transcodes it like any other video.
+func canPassThrough(v videoStream) bool {
+	const (
+		constraintSet1 = 0x40
+		maxLevel       = 40
+	)
+	return v.StreamType == mpegts.StreamTypeH264Video &&
+		v.AVC.ProfileIDC == 66 && v.AVC.Constraints&constraintSet1 != 0 &&
+		v.AVC.LevelIDC <= maxLevel
+}
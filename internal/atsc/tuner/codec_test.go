@@ -0,0 +1,29 @@
+package tuner
+
+import (
+	"testing"
+
+	"github.com/featherbread/hypcast/internal/atsc/mpegts"
+)
+
+func TestCanPassThrough(t *testing.T) {
+	testCases := []struct {
+		name   string
+		stream videoStream
+		want   bool
+	}{
+		{"constrained baseline", videoStream{StreamType: mpegts.StreamTypeH264Video, AVC: mpegts.AVCVideo{ProfileIDC: 66, Constraints: 0xC0, LevelIDC: 40}}, true},
+		{"constrained baseline level 3.1", videoStream{StreamType: mpegts.StreamTypeH264Video, AVC: mpegts.AVCVideo{ProfileIDC: 66, Constraints: 0xC0, LevelIDC: 31}}, true},
+		{"constrained baseline level 4.2", videoStream{StreamType: mpegts.StreamTypeH264Video, AVC: mpegts.AVCVideo{ProfileIDC: 66, Constraints: 0xC0, LevelIDC: 42}}, false},
+		{"baseline", videoStream{StreamType: mpegts.StreamTypeH264Video, AVC: mpegts.AVCVideo{ProfileIDC: 66, LevelIDC: 40}}, false},
+		{"main", videoStream{StreamType: mpegts.StreamTypeH264Video, AVC: mpegts.AVCVideo{ProfileIDC: 77, Constraints: 0x40, LevelIDC: 40}}, false},
+		{"high", videoStream{StreamType: mpegts.StreamTypeH264Video, AVC: mpegts.AVCVideo{ProfileIDC: 100, LevelIDC: 40}}, false},
+		{"no descriptor", videoStream{StreamType: mpegts.StreamTypeH264Video}, false},
+		{"mpeg-2", videoStream{StreamType: mpegts.StreamTypeMPEG2Video}, false},
+	}
+	for _, tc := range testCases {
+		if got := canPassThrough(tc.stream); got != tc.want {
+			t.Errorf("canPassThrough(%s) = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
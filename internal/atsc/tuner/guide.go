@@ -0,0 +1,101 @@
+package tuner
+
+import (
+	"context"
+	"time"
+
+	"github.com/featherbread/hypcast/internal/atsc"
+	"github.com/featherbread/hypcast/internal/atsc/guide"
+	"github.com/featherbread/hypcast/internal/atsc/scan"
+)
+
+// Guide returns the program guide that the tuner adds to as it receives
+// multiplexes. Every tuner in a Pool shares the same guide.
+func (t *Tuner) Guide() *guide.Guide {
+	return t.guide
+}
+
+// guideSweepDwell is how long a guide sweep receives each multiplex. A/65
+// requires broadcasters to repeat the first EITs at least once every 500 ms,
+// but the ETTs that describe events may repeat only once per minute.
+const guideSweepDwell = time.Minute
+
+// sweep represents an in-progress guide sweep of a single multiplex.
+type sweep struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// SweepGuide collects the program guide from every multiplex in the tuner's
+// channel list that arrives through its DVB device, receiving each multiplex
+// for a short time.
+//
+// SweepGuide only uses the tuner while it is stopped, and gives way to any call
+// to Tune or Scan. It returns ErrTunerBusy if the tuner is in use before the
+// sweep is complete.
+func (t *Tuner) SweepGuide(ctx context.Context) error {
+	for _, channel := range t.sweepChannels() {
+		if err := t.sweepMultiplex(ctx, channel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sweepChannels returns one channel from each multiplex in the channel list
+// that a guide sweep can receive.
+func (t *Tuner) sweepChannels() []atsc.Channel {
+	var (
+		channels []atsc.Channel
+		seen     = make(map[multiplexKey]bool)
+	)
+	for _, channel := range t.channels {
+		key := multiplexOf(channel)
+		if channel.File == "" && channel.TestPattern == "" && !seen[key] {
+			seen[key] = true
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+func (t *Tuner) sweepMultiplex(ctx context.Context, channel atsc.Channel) error {
+	t.mu.Lock()
+	if t.mux != nil || t.cancelScan != nil || t.sweep != nil {
+		t.mu.Unlock()
+		return ErrTunerBusy
+	}
+	sweepCtx, cancel := context.WithTimeout(ctx, guideSweepDwell)
+	sw := &sweep{cancel: cancel, done: make(chan struct{})}
+	t.sweep = sw
+	t.mu.Unlock()
+
+	f := scan.Frequency{FrequencyHz: channel.FrequencyHz, Modulation: channel.Modulation}
+	if rc, err := (scanSource{t}).Open(sweepCtx, f); err == nil {
+		stop := context.AfterFunc(sweepCtx, func() { rc.Close() })
+		t.guide.Collect(rc, channel)
+		stop()
+		rc.Close()
+	}
+	cancel()
+	close(sw.done)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sweep != sw {
+		return ErrTunerBusy
+	}
+	t.sweep = nil
+	return ctx.Err()
+}
+
+// stopAnySweep ends any guide sweep in progress, and waits for the sweep to
+// release the DVB device.
+func (t *Tuner) stopAnySweep() {
+	if t.sweep == nil {
+		return
+	}
+	t.sweep.cancel()
+	<-t.sweep.done
+	t.sweep = nil
+}
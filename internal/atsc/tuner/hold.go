@@ -0,0 +1,62 @@
+package tuner
+
+import (
+	"sync"
+	"time"
+)
+
+// tunerIdleTimeout is how long a tuner that the pool allocated keeps streaming
+// without any clients before it stops. Stopping frees the tuner for the pool to
+// allocate to another channel. The delay gives a client that tunes a moment to connect, and lets a
+// client that reloads pick up where it left off.
+const tunerIdleTimeout = time.Minute
+
+// Hold records that a client is watching the tuner, and returns a function
+// that releases the hold. A tuner that [Pool.Tune] allocated stops once nothing
+// has held it for tunerIdleTimeout, and its open streams count as holds of
+// their own. A tuner tuned with [Tuner.Tune] keeps playing.
+func (t *Tuner) Hold() (release func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.holders++
+	t.stopIdleTimer()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.holders--
+			t.startIdleTimer()
+		})
+	}
+}
+
+// startIdleTimer starts the countdown to stopping the tuner, if the pool
+// allocated it and nothing holds it.
+func (t *Tuner) startIdleTimer() {
+	if !t.pooled || t.mux == nil || t.holders > 0 || t.streams > 0 || t.idle != nil {
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(tunerIdleTimeout, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.idle != timer {
+			return
+		}
+		t.idle = nil
+		t.log.Info("Stopping idle tuner")
+		t.stop()
+	})
+	t.idle = timer
+}
+
+// stopIdleTimer cancels any countdown to stopping the tuner.
+func (t *Tuner) stopIdleTimer() {
+	if t.idle != nil {
+		t.idle.Stop()
+		t.idle = nil
+	}
+}
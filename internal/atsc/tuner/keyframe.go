@@ -0,0 +1,101 @@
+package tuner
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/featherbread/hypcast/internal/gst"
+)
+
+// keyframeInterval is the minimum spacing between the keyframes that clients
+// can force from a single encoder. Every keyframe costs several times the bits
+// of an ordinary picture, so an encoder that took every request from several
+// lossy clients would have little bitrate left for anything else.
+const keyframeInterval = time.Second
+
+// keyframeControl forces keyframes from a video encoder on behalf of its
+// clients, no more often than keyframeInterval. A request that arrives too soon
+// after the last keyframe is deferred rather than dropped, and combined with
+// any other requests until the interval passes.
+type keyframeControl struct {
+	log *slog.Logger
+
+	mu      sync.Mutex
+	force   func() error // nil once the program stops.
+	last    time.Time
+	pending *time.Timer
+}
+
+func newKeyframeControl(log *slog.Logger, force func() error) *keyframeControl {
+	return &keyframeControl{log: log, force: force}
+}
+
+// forceEncoderKeyframe returns a function that forces a keyframe from the
+// encoder for layer in the branch, for use with newKeyframeControl.
+func forceEncoderKeyframe(branch *gst.Branch, layer VideoLayer) func() error {
+	return func() error {
+		return branch.ForceKeyUnit(encoderNamePrefix + string(layer))
+	}
+}
+
+// Request asks for a keyframe as soon as the rate limit allows.
+func (kc *keyframeControl) Request() {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	if kc.force == nil || kc.pending != nil {
+		return
+	}
+	if wait := keyframeInterval - time.Since(kc.last); wait > 0 {
+		kc.pending = time.AfterFunc(wait, kc.forceDeferred)
+		return
+	}
+	kc.forceLocked()
+}
+
+func (kc *keyframeControl) forceDeferred() {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	kc.pending = nil
+	if kc.force != nil {
+		kc.forceLocked()
+	}
+}
+
+func (kc *keyframeControl) forceLocked() {
+	kc.last = time.Now()
+	if err := kc.force(); err != nil {
+		kc.log.Error("Failed to force keyframe", "error", err)
+		return
+	}
+	kc.log.Debug("Forced keyframe")
+}
+
+// stop keeps the control from touching the encoder, and must be called before
+// the encoder's branch closes.
+func (kc *keyframeControl) stop() {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	kc.force = nil
+	if kc.pending != nil {
+		kc.pending.Stop()
+		kc.pending = nil
+	}
+}
+
+// RequestKeyframe asks the layer's encoder for a keyframe, so that a client
+// that lost part of a picture, or that started receiving the layer without a
+// cached group of pictures to replay, can decode the video again. The encoder
+// produces the keyframe soon, but perhaps not right away if other clients
+// requested one recently.
+//
+// RequestKeyframe does nothing for video that the tuner passes through without
+// encoding it, which has keyframes only where the source does.
+func (vt VideoTrack) RequestKeyframe() {
+	if vt.keyframes != nil {
+		vt.keyframes.Request()
+	}
+}
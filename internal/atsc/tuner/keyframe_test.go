@@ -0,0 +1,59 @@
+package tuner
+
+import (
+	"log/slog"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestKeyframeControl(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		var forced []time.Duration
+		start := time.Now()
+		kc := newKeyframeControl(slog.Default(), func() error {
+			forced = append(forced, time.Since(start))
+			return nil
+		})
+
+		check := func(want ...time.Duration) {
+			t.Helper()
+			synctest.Wait()
+			if len(forced) != len(want) {
+				t.Fatalf("forced keyframes at %v, want %v", forced, want)
+			}
+			for i := range want {
+				if forced[i] != want[i] {
+					t.Fatalf("forced keyframes at %v, want %v", forced, want)
+				}
+			}
+		}
+
+		// The first request goes through right away.
+		kc.Request()
+		check(0)
+
+		// Requests within the interval merge into one at its end.
+		time.Sleep(200 * time.Millisecond)
+		kc.Request()
+		time.Sleep(300 * time.Millisecond)
+		kc.Request()
+		kc.Request()
+		check(0)
+		time.Sleep(500 * time.Millisecond)
+		check(0, keyframeInterval)
+
+		// A request after a full interval goes through right away.
+		time.Sleep(2 * keyframeInterval)
+		kc.Request()
+		check(0, keyframeInterval, 3*keyframeInterval)
+
+		// Stopping the control cancels a deferred request, and ignores new
+		// ones.
+		kc.Request()
+		kc.stop()
+		time.Sleep(2 * keyframeInterval)
+		kc.Request()
+		check(0, keyframeInterval, 3*keyframeInterval)
+	})
+}
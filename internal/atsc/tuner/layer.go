@@ -0,0 +1,147 @@
+package tuner
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// VideoLayer identifies one rendition of the video that the tuner encodes for
+// a program. The tuner can encode several layers from a single decode of the
+// source, so that each client can receive the quality that its network
+// supports.
+type VideoLayer string
+
+// The following are the video layers that the tuner supports, from the highest
+// quality to the lowest.
+const (
+	// VideoLayerHigh keeps the size of the source video, at the top of the
+	// video pipeline's bitrate range.
+	VideoLayerHigh VideoLayer = "high"
+	// VideoLayerMedium scales the video to 720p.
+	VideoLayerMedium VideoLayer = "medium"
+	// VideoLayerLow scales the video to 360p.
+	VideoLayerLow VideoLayer = "low"
+)
+
+var videoLayerOrder = []VideoLayer{VideoLayerHigh, VideoLayerMedium, VideoLayerLow}
+
+// videoLayerSizes gives the size that each video layer other than
+// VideoLayerHigh scales the source video to.
+var videoLayerSizes = map[VideoLayer]struct{ Width, Height int }{
+	VideoLayerMedium: {Width: 1280, Height: 720},
+	VideoLayerLow:    {Width: 640, Height: 360},
+}
+
+// videoLayerBitrates gives the range of bitrates, in bits per second, that
+// each video layer other than VideoLayerHigh encodes at. VideoLayerHigh uses
+// the range in encoderBitrates.
+var videoLayerBitrates = map[VideoLayer]struct{ Min, Max int }{
+	VideoLayerMedium: {Min: 500_000, Max: 3_000_000},
+	VideoLayerLow:    {Min: 200_000, Max: 800_000},
+}
+
+// ParseVideoLayers parses a comma-separated list of video layer names, and
+// returns the layers from the highest quality to the lowest.
+func ParseVideoLayers(s string) ([]VideoLayer, error) {
+	var layers []VideoLayer
+	for name := range strings.SplitSeq(s, ",") {
+		layer := VideoLayer(strings.TrimSpace(name))
+		if !slices.Contains(videoLayerOrder, layer) {
+			return nil, fmt.Errorf("unknown video layer %q", name)
+		}
+		if !slices.Contains(layers, layer) {
+			layers = append(layers, layer)
+		}
+	}
+	slices.SortFunc(layers, func(a, b VideoLayer) int {
+		return slices.Index(videoLayerOrder, a) - slices.Index(videoLayerOrder, b)
+	})
+	return layers, nil
+}
+
+// VideoTrack represents the WebRTC track for a single layer of a program's
+// video.
+type VideoTrack struct {
+	Layer VideoLayer
+	Track webrtc.TrackLocal
+	// Bitrate adapts the layer to the bandwidth of its clients, or is nil when
+	// the tuner passes the video through without encoding it.
+	Bitrate *BitrateControl
+
+	keyframes *keyframeControl // nil for passed through video.
+}
+
+// videoLayerHysteresis is the fraction of a layer's top bitrate that a
+// client's bandwidth may fall short by before the client moves to a lower
+// layer. The layer's encoder follows the client down within that margin, and
+// it keeps clients from flapping between layers as their estimates fluctuate.
+const videoLayerHysteresis = 0.25
+
+// SelectVideo picks the video layer that a client receives, for a client
+// currently receiving the current layer whose estimated bandwidth for video
+// and audio together is bandwidth bits per second.
+//
+// When quality names one of the tracks' layers, SelectVideo picks that layer
+// regardless of the bandwidth. Otherwise, it picks the highest quality layer
+// that the bandwidth supports at its top bitrate, or the lowest quality layer
+// if the bandwidth supports none of them. A bandwidth of 0 means the client
+// has no estimate yet, and selects the highest quality layer.
+func (ts Tracks) SelectVideo(quality, current VideoLayer, bandwidth int) VideoTrack {
+	if i := slices.IndexFunc(ts.Video, func(vt VideoTrack) bool { return vt.Layer == quality }); i >= 0 {
+		return ts.Video[i]
+	}
+	if bandwidth == 0 {
+		return ts.Video[0]
+	}
+	for _, vt := range ts.Video {
+		if vt.Bitrate == nil {
+			return vt
+		}
+		need := float64(vt.Bitrate.max)
+		if vt.Layer == current {
+			need *= 1 - videoLayerHysteresis
+		}
+		if float64(bandwidth-audioBitrate) >= need {
+			return vt
+		}
+	}
+	return ts.Video[len(ts.Video)-1]
+}
+
+// pipelineLayer describes a video layer to the program branch's template.
+type pipelineLayer struct {
+	Name string
+	// Width and Height are 0 to keep the size of the source video.
+	Width, Height int
+	// Bitrate is the starting bitrate of the layer's encoder, in kilobits per
+	// second.
+	Bitrate int
+}
+
+// pipelineLayers describes the tuner's video layers to the program branch's
+// template.
+func (t *Tuner) pipelineLayers() []pipelineLayer {
+	layers := make([]pipelineLayer, len(t.videoLayers))
+	for i, layer := range t.videoLayers {
+		size := videoLayerSizes[layer]
+		layers[i] = pipelineLayer{
+			Name:    string(layer),
+			Width:   size.Width,
+			Height:  size.Height,
+			Bitrate: t.videoLayerBitrates(layer).Max / 1000,
+		}
+	}
+	return layers
+}
+
+// videoLayerBitrates returns the range of bitrates that the encoder for layer
+// runs at.
+func (t *Tuner) videoLayerBitrates(layer VideoLayer) struct{ Min, Max int } {
+	if layer == VideoLayerHigh {
+		return encoderBitrates[t.videoPipeline]
+	}
+	return videoLayerBitrates[layer]
+}
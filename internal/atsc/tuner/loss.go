@@ -0,0 +1,103 @@
+package tuner
+
+import (
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+)
+
+// audioEncoderName is the name of the Opus encoder element in the audio branch.
+const audioEncoderName = "audioencoder"
+
+// audioFECThreshold is the packet loss percentage at which the Opus encoder
+// starts to carry in-band forward error correction, which lets clients rebuild
+// a lost packet from the one that follows it at the cost of some bitrate.
+const audioFECThreshold = 1
+
+// LossControl tunes a program's Opus encoder for the packet loss that the
+// program's clients report, protecting against the loss of the client with the
+// worst network.
+type LossControl struct {
+	log     *slog.Logger
+	apply   func() // tunes the encoder for the latest percent.
+	reports worstReport[int]
+
+	// percent is the loss percentage that the encoder is tuned for, which new
+	// audio branches start with.
+	percent atomic.Int32
+}
+
+func newLossControl(log *slog.Logger, apply func()) *LossControl {
+	lc := &LossControl{log: log, apply: apply}
+	lc.reports = worstReport[int]{
+		worse:    func(a, b int) bool { return a > b },
+		retarget: lc.retarget,
+	}
+	return lc
+}
+
+// applyAudioPacketLoss returns a function that tunes the encoder in the audio
+// branch of p for the loss that p.loss is tuned for, for use with
+// newLossControl. It reads the latest percentage rather than taking one, so
+// that concurrent changes can't apply out of order.
+func (t *Tuner) applyAudioPacketLoss(p *program) func() {
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if !t.hasProgram(p) || p.audio == nil {
+			return
+		}
+		for property, value := range opusLossSettings(int(p.loss.percent.Load())) {
+			if err := p.audio.SetProperty(audioEncoderName, property, value); err != nil {
+				t.log.Error("Failed to tune audio encoder", "channel", p.channel.Name, "error", err)
+				return
+			}
+		}
+	}
+}
+
+// opusLossSettings returns the opusenc properties for a packet loss
+// percentage.
+func opusLossSettings(percent int) map[string]string {
+	return map[string]string{
+		"packet-loss-percentage": strconv.Itoa(percent),
+		"inband-fec":             strconv.FormatBool(percent >= audioFECThreshold),
+	}
+}
+
+// LossClient represents a single client's reports of the audio packets that it
+// loses, as one input to a LossControl.
+type LossClient struct {
+	*reportClient[int]
+}
+
+// NewClient adds a client to the control.
+func (lc *LossControl) NewClient() *LossClient {
+	return &LossClient{lc.reports.newClient()}
+}
+
+// SetLoss sets the fraction of the audio packets that the client recently
+// lost, from 0 to 1.
+func (c *LossClient) SetLoss(fraction float64) {
+	c.report(min(int(fraction*100+0.5), 100))
+}
+
+// retarget tunes the control for the worst loss among its clients. It returns
+// the function that applies a change to the encoder after the reports are
+// unlocked, as doing so takes the tuner's lock.
+func (lc *LossControl) retarget(percent int, _ bool) func() {
+	old := int(lc.percent.Swap(int32(percent)))
+	if old == percent {
+		return nil
+	}
+	lc.log.Info("Tuning audio for packet loss", "from", old, "to", percent)
+	return lc.apply
+}
+
+// audioLossStreams returns the settings that a new audio branch starts its
+// encoder with, to match the loss that the control is tuned for.
+func (lc *LossControl) audioLossStreams(streams pipelineStreams) pipelineStreams {
+	streams.AudioPacketLoss = int(lc.percent.Load())
+	streams.AudioFEC = streams.AudioPacketLoss >= audioFECThreshold
+	return streams
+}
@@ -0,0 +1,48 @@
+package tuner
+
+import (
+	"log/slog"
+	"testing"
+)
+
+func TestLossControl(t *testing.T) {
+	var applied int
+	lc := newLossControl(slog.Default(), func() { applied++ })
+
+	a, b := lc.NewClient(), lc.NewClient()
+	steps := []struct {
+		name        string
+		do          func()
+		wantPercent int
+		wantApplied bool
+	}{
+		{"no loss", func() { a.SetLoss(0) }, 0, false},
+		{"rounded loss", func() { a.SetLoss(0.024) }, 2, true},
+		{"same rounded loss", func() { a.SetLoss(0.016) }, 2, false},
+		{"worse client", func() { b.SetLoss(0.5) }, 50, true},
+		{"clamped loss", func() { b.SetLoss(1.5) }, 100, true},
+		{"worse client leaves", func() { b.Close() }, 2, true},
+		{"last client leaves", func() { a.Close() }, 0, true},
+	}
+	for _, step := range steps {
+		applied = 0
+		step.do()
+		if got := int(lc.percent.Load()); got != step.wantPercent {
+			t.Errorf("%s: tuned for %d%% loss, want %d%%", step.name, got, step.wantPercent)
+		}
+		if got := applied > 0; got != step.wantApplied {
+			t.Errorf("%s: applied = %v, want %v", step.name, got, step.wantApplied)
+		}
+	}
+
+	streams := lc.audioLossStreams(pipelineStreams{})
+	if streams.AudioPacketLoss != 0 || streams.AudioFEC {
+		t.Errorf("new branch without loss starts with %d%% loss and FEC %v", streams.AudioPacketLoss, streams.AudioFEC)
+	}
+	c := lc.NewClient()
+	c.SetLoss(0.05)
+	streams = lc.audioLossStreams(pipelineStreams{})
+	if streams.AudioPacketLoss != 5 || !streams.AudioFEC {
+		t.Errorf("new branch with loss starts with %d%% loss and FEC %v", streams.AudioPacketLoss, streams.AudioFEC)
+	}
+}
@@ -0,0 +1,561 @@
+package tuner
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/featherbread/hypcast/internal/atsc"
+	"github.com/featherbread/hypcast/internal/atsc/caption"
+	"github.com/featherbread/hypcast/internal/atsc/mpegts"
+	"github.com/featherbread/hypcast/internal/atsc/psip"
+	"github.com/featherbread/hypcast/internal/gst"
+	"github.com/featherbread/hypcast/internal/watch"
+)
+
+// multiplexKey identifies the transport stream that carries a channel. All
+// channels with the same key can be streamed from a single multiplex.
+type multiplexKey struct {
+	Modulation  atsc.Modulation
+	FrequencyHz uint
+	File        string
+	// TestChannel names the channel whose definition generates a test pattern
+	// stream, which carries no other channels.
+	TestChannel string
+}
+
+func multiplexOf(channel atsc.Channel) multiplexKey {
+	switch {
+	case channel.File != "":
+		return multiplexKey{File: channel.File}
+	case channel.TestPattern != "":
+		return multiplexKey{TestChannel: channel.Name}
+	default:
+		return multiplexKey{Modulation: channel.Modulation, FrequencyHz: channel.FrequencyHz}
+	}
+}
+
+// multiplex represents a pipeline that receives every program of a transport
+// stream, and feeds each program that someone is watching to its own transcode
+// branch.
+type multiplex struct {
+	key      multiplexKey
+	pipeline *gst.Pipeline
+	programs map[uint]*program
+
+	// ts is the branch that feeds the transport stream to Go, through each of
+	// tsReaders.
+	ts        *gst.Branch
+	tsReaders []io.Closer
+
+	// programMaps holds the streams of each program in the transport stream,
+	// by program number, once the program's map table arrives.
+	programMaps map[uint]programMap
+
+	// streams holds the branches that stream single programs to HTTP clients.
+	streams map[streamKey]*programStream
+}
+
+// program represents the transcode branches of a multiplex for a single
+// channel: one for the video, and one for the selected audio track.
+type program struct {
+	channel atsc.Channel
+	tracks  *watch.Value[Tracks]
+
+	// branch is the branch for the program's video, or nil while the program
+	// waits for its map table to show how to decode it. pending starts the
+	// program with the streams in its channel definition if the table doesn't
+	// arrive in time.
+	branch  *gst.Branch
+	pending *time.Timer
+	video   []VideoTrack
+
+	// videoSources and audioSource feed the program's video layers and audio
+	// to its own tracks, and to the tuner's tracks while the program is the
+	// tuner's current one. The audio source outlives any one audio branch.
+	videoSources []*sampleSource
+	audioSource  *sampleSource
+
+	// audio is the branch for audioTrack, or nil if the program has no audio
+	// track to stream yet.
+	audio      *gst.Branch
+	audioTrack AudioTrack
+	// loss tunes the encoder of every audio branch for the packet loss that
+	// the program's clients report.
+	loss *LossControl
+
+	// refs counts the holders of the program, including the tuner itself when
+	// the program is for its current channel, and every open Viewer. The
+	// program is removed from the multiplex when the last holder releases it.
+	refs int
+}
+
+func (m *multiplex) programForBranch(name string) *program {
+	if name == "" {
+		return nil
+	}
+	for _, p := range m.programs {
+		if (p.branch != nil && p.branch.Name() == name) || (p.audio != nil && p.audio.Name() == name) {
+			return p
+		}
+	}
+	return nil
+}
+
+func (t *Tuner) startMultiplex(channel atsc.Channel) (m *multiplex, err error) {
+	description, err := t.createPipelineDescription("multiplex", channel, pipelineStreams{})
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline, err := gst.NewPipeline(description)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			pipeline.Close()
+		}
+	}()
+
+	if err := setSourceProperties(pipeline, channel); err != nil {
+		return nil, err
+	}
+	pipeline.SetMessageHandler(t.createPipelineMessageHandler(pipeline))
+
+	t.log.Info("Starting transcode pipeline")
+	if err := pipeline.Start(); err != nil {
+		return nil, err
+	}
+	t.log.Info("Started transcode pipeline")
+
+	m = &multiplex{
+		key:         multiplexOf(channel),
+		pipeline:    pipeline,
+		programs:    make(map[uint]*program),
+		programMaps: make(map[uint]programMap),
+		streams:     make(map[streamKey]*programStream),
+	}
+	if err := t.startTransportStream(m, channel); err != nil {
+		t.log.Warn("Failed to start transport stream readers", "error", err)
+	}
+	return m, nil
+}
+
+// setSourceProperties sets the properties of the file or test pattern source of
+// a multiplex pipeline for channel.
+func setSourceProperties(pipeline *gst.Pipeline, channel atsc.Channel) error {
+	switch {
+	case channel.File != "":
+		// multifilesrc only fails once the pipeline starts streaming, and then
+		// without naming the file.
+		if _, err := os.Stat(channel.File); err != nil {
+			return fmt.Errorf("file source: %w", err)
+		}
+		// multifilesrc formats its location with the index of the file that it
+		// plays, so a literal percent sign has to be doubled.
+		location := strings.ReplaceAll(channel.File, "%", "%%")
+		return pipeline.SetProperty(elementNameFile, "location", quoteLaunchString(location))
+	case channel.TestPattern != "":
+		return pipeline.SetProperty(elementNameTestPattern, "pattern", channel.TestPattern)
+	default:
+		return nil
+	}
+}
+
+// quoteLaunchString quotes s as a string value in the syntax used in the
+// gst-launch-1.0 utility, so that GStreamer takes it literally.
+func quoteLaunchString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// branchNameTransportStream names the branch of a multiplex that feeds the
+// transport stream to Go.
+const branchNameTransportStream = "ts"
+
+// startTransportStream starts reading the program map tables from the
+// transport stream of m, which carries channel, along with the program guide
+// for streams that carry one.
+func (t *Tuner) startTransportStream(m *multiplex, channel atsc.Channel) error {
+	description, err := t.createPipelineDescription("transport-stream", channel, pipelineStreams{})
+	if err != nil {
+		return err
+	}
+	branch, err := m.pipeline.NewBranch(branchNameTransportStream, description)
+	if err != nil {
+		return err
+	}
+
+	mapsReader, mapsWriter := io.Pipe()
+	readers, writers := []io.Closer{mapsReader}, []io.Writer{mapsWriter}
+	var guideReader *io.PipeReader
+	if channel.TestPattern == "" { // Generated test streams have no PSIP tables.
+		var guideWriter *io.PipeWriter
+		guideReader, guideWriter = io.Pipe()
+		readers, writers = append(readers, guideReader), append(writers, guideWriter)
+	}
+
+	w := io.MultiWriter(writers...)
+	branch.SetSink(sinkNameTransportStream, func(sample gst.Sample) {
+		w.Write(sample.Data)
+	})
+	if err := branch.Link(teeNameMultiplex); err != nil {
+		branch.Close()
+		return err
+	}
+
+	m.ts, m.tsReaders = branch, readers
+	go func() {
+		err := t.readProgramMaps(m, mapsReader)
+		t.log.Debug("Stopped reading program maps", "error", err)
+	}()
+	if guideReader != nil {
+		go func() {
+			err := t.guide.Collect(guideReader, channel)
+			t.log.Debug("Stopped collecting program guide", "error", err)
+		}()
+	}
+	return nil
+}
+
+// stopTransportStream stops reading the transport stream of m.
+func (t *Tuner) stopTransportStream(m *multiplex) {
+	if m.ts == nil {
+		return
+	}
+	// Closing the readers first unblocks the appsink if it's waiting on a
+	// write, so the branch can stop.
+	for _, r := range m.tsReaders {
+		r.Close()
+	}
+	m.ts.Close()
+	m.ts, m.tsReaders = nil, nil
+}
+
+func (t *Tuner) destroyAnyRunningMultiplex() error {
+	if t.mux == nil {
+		return nil
+	}
+
+	for _, p := range t.mux.programs {
+		p.stopEncoderControls()
+		p.tracks.Set(Tracks{})
+	}
+	for _, s := range t.mux.streams {
+		if s.pending != nil {
+			s.pending.Stop()
+		}
+	}
+	t.stopTransportStream(t.mux)
+
+	// Closing the pipeline closes the branches of all of its programs and
+	// streams.
+	err := t.mux.pipeline.Close()
+	for _, s := range t.mux.streams {
+		s.end(io.EOF)
+	}
+	t.mux, t.current = nil, nil
+	t.streamsTuned, t.pooled = false, false
+	t.stopIdleTimer()
+	t.signal.Clear()
+	t.log.Info("Destroyed transcode pipeline", "error", err)
+	return err
+}
+
+// programMapTimeout is how long a new program waits for its map table before
+// starting with the streams in its channel definition.
+const programMapTimeout = 5 * time.Second
+
+// acquireProgram returns a reference to the program for channel on the current
+// multiplex, starting a new program if necessary. The caller must eventually
+// release the reference with releaseProgram.
+//
+// A new program starts streaming once the tuner knows its map table, which may
+// be after acquireProgram returns.
+func (t *Tuner) acquireProgram(channel atsc.Channel) (*program, error) {
+	if p, ok := t.mux.programs[channel.ProgramID]; ok {
+		p.refs++
+		return p, nil
+	}
+
+	p := &program{
+		channel: channel,
+		tracks:  watch.NewValue(Tracks{}),
+		refs:    1,
+	}
+	if pm, ok := t.mux.programMaps[channel.ProgramID]; ok {
+		if err := t.startProgram(p, pm); err != nil {
+			return nil, err
+		}
+	} else {
+		p.pending = time.AfterFunc(programMapTimeout, func() { t.startProgramWithoutMap(p) })
+	}
+	t.mux.programs[channel.ProgramID] = p
+	return p, nil
+}
+
+// startProgram starts the branches for the streams of p in pm.
+func (t *Tuner) startProgram(p *program, pm programMap) error {
+	channel := p.channel
+	codec, ok := videoCodecs[pm.Video.StreamType]
+	if !ok {
+		return ErrVideoUnsupported
+	}
+	layers := t.videoLayers
+	passthrough := canPassThrough(pm.Video)
+	if passthrough {
+		layers = []VideoLayer{VideoLayerHigh}
+	}
+
+	description, err := t.createPipelineDescription("program", channel, pipelineStreams{
+		VideoPID:          pm.Video.PID,
+		VideoParser:       codec.Parser,
+		VideoDecoder:      codec.Decoder,
+		VAAPIVideoDecoder: codec.VAAPIDecoder,
+		VideoPassthrough:  passthrough,
+	})
+	if err != nil {
+		return err
+	}
+
+	branchName := fmt.Sprintf("program-%d", channel.ProgramID)
+	branch, err := t.mux.pipeline.NewBranch(branchName, description)
+	if err != nil {
+		return err
+	}
+
+	videoSources, audioSource := make([]*sampleSource, len(layers)), newSampleSource(false)
+	for i := range videoSources {
+		videoSources[i] = newSampleSource(true)
+	}
+	vts, at := t.createTracks(videoSources, audioSource)
+
+	captions := t.createCaptionStream(channel)
+	video := make([]VideoTrack, len(layers))
+	for i, layer := range layers {
+		video[i] = VideoTrack{Layer: layer, Track: vts[i]}
+		branch.SetSink(sinkNamePrefixVideo+string(layer), videoSources[i].WriteSample)
+	}
+	if codec.DecodeCaptions != nil {
+		branch.SetSink(sinkNameCaptions, func(sample gst.Sample) {
+			codec.DecodeCaptions(captions, sample.Data)
+		})
+	}
+	if err := branch.Link(teeNameMultiplex); err != nil {
+		branch.Close()
+		return err
+	}
+
+	p.branch = branch
+	p.videoSources, p.audioSource = videoSources, audioSource
+	p.loss = newLossControl(t.log.With("channel", channel.Name), t.applyAudioPacketLoss(p))
+	if track, ok := initialAudioTrack(channel, pm.AudioTracks); ok {
+		if err := t.startAudio(p, track); err != nil {
+			branch.Close()
+			p.branch = nil
+			return err
+		}
+	}
+	if !passthrough {
+		for i, layer := range layers {
+			log := t.log.With("channel", channel.Name, "layer", layer)
+			video[i].Bitrate = newBitrateControl(log, t.videoLayerBitrates(layer), setEncoderBitrate(log, branch, layer))
+			video[i].keyframes = newKeyframeControl(log, forceEncoderKeyframe(branch, layer))
+		}
+	}
+	p.video = video
+
+	tracks := Tracks{Video: video, Audio: at, AudioLoss: p.loss, Captions: captions}
+	p.tracks.Set(tracks)
+	if p == t.current {
+		t.publishTracks()
+	}
+	t.log.Info(
+		"Started program",
+		"channel", channel.Name, "program", channel.ProgramID,
+		"video", pm.Video.StreamType, "passthrough", passthrough, "layers", layers,
+	)
+	return nil
+}
+
+// startProgramWithoutMap starts p with the streams in its channel definition,
+// if it's still waiting for its map table.
+func (t *Tuner) startProgramWithoutMap(p *program) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.hasProgram(p) || p.branch != nil {
+		return
+	}
+
+	t.log.Warn("Program map table not found", "channel", p.channel.Name, "program", p.channel.ProgramID)
+	if err := t.startProgram(p, defaultProgramMap(p.channel)); err != nil {
+		t.log.Error("Failed to start program", "channel", p.channel.Name, "error", err)
+		t.failProgram(p, err)
+		return
+	}
+	if p == t.current {
+		t.status.Set(t.currentStatus())
+	}
+}
+
+// defaultProgramMap returns the streams in the definition of channel, which
+// programs fall back on when the channel's program map table doesn't arrive.
+func defaultProgramMap(channel atsc.Channel) programMap {
+	pm := programMap{
+		Video: videoStream{PID: channel.VideoPID, StreamType: mpegts.StreamTypeMPEG2Video},
+	}
+	if channel.AudioPID != 0 {
+		pm.AudioTracks = []AudioTrack{{PID: channel.AudioPID, StreamType: mpegts.StreamTypeAC3Audio}}
+	}
+	return pm
+}
+
+// createCaptionStream creates the caption stream for channel, which learns the
+// languages of its caption services from the program guide.
+func (t *Tuner) createCaptionStream(channel atsc.Channel) *caption.Stream {
+	return caption.NewStream(func() []psip.CaptionService {
+		now, _ := t.guide.NowNext(channel, time.Now())
+		if now == nil {
+			return nil
+		}
+		return now.Captions
+	})
+}
+
+// initialAudioTrack returns the audio track that a new program for channel
+// starts with: the one in the channel definition, unless the program's tracks
+// don't include it.
+func initialAudioTrack(channel atsc.Channel, tracks []AudioTrack) (AudioTrack, bool) {
+	i := slices.IndexFunc(tracks, func(at AudioTrack) bool { return at.PID == channel.AudioPID })
+	if i >= 0 && tracks[i].supported() {
+		return tracks[i], true
+	}
+	return defaultAudioTrack(tracks)
+}
+
+// releaseProgram releases a reference to p obtained from acquireProgram.
+func (t *Tuner) releaseProgram(p *program) {
+	p.refs--
+	if p.refs == 0 {
+		t.removeProgram(p)
+	}
+}
+
+// removeProgram stops the transcode branch for p, regardless of how many
+// references to it remain.
+func (t *Tuner) removeProgram(p *program) {
+	var err error
+	if p.pending != nil {
+		p.pending.Stop()
+	}
+	t.stopAudio(p)
+	p.stopEncoderControls()
+	if p.branch != nil {
+		err = p.branch.Close()
+	}
+	delete(t.mux.programs, p.channel.ProgramID)
+	p.tracks.Set(Tracks{})
+	t.log.Info("Stopped program", "channel", p.channel.Name, "program", p.channel.ProgramID, "error", err)
+}
+
+// stopEncoderControls keeps the bitrate and keyframe controls of p's video
+// layers from touching their encoders, ahead of the encoders' branch closing.
+func (p *program) stopEncoderControls() {
+	for _, vt := range p.video {
+		if vt.Bitrate != nil {
+			vt.Bitrate.stop()
+		}
+		if vt.keyframes != nil {
+			vt.keyframes.stop()
+		}
+	}
+}
+
+// switchProgram changes the tuner's current program to the one for channel on
+// the current multiplex. The old program keeps streaming if any viewers are
+// still watching it.
+func (t *Tuner) switchProgram(channel atsc.Channel) error {
+	next, err := t.acquireProgram(channel)
+	if err != nil {
+		return err
+	}
+	if t.current != nil {
+		t.releaseProgram(t.current)
+	}
+	t.current = next
+	return nil
+}
+
+// hasProgram reports whether p remains active on the tuner's current multiplex.
+func (t *Tuner) hasProgram(p *program) bool {
+	return t.mux != nil && t.mux.programs[p.channel.ProgramID] == p
+}
+
+// ErrChannelNotOnMultiplex is returned when adding a viewer for a channel that
+// the tuner can't receive alongside its current channel.
+var ErrChannelNotOnMultiplex error = errors.New("channel is not on the tuner's current multiplex")
+
+// Viewer represents a client watching a channel that the tuner streams
+// alongside its current channel, from the same multiplex.
+type Viewer struct {
+	tuner     *Tuner
+	program   *program
+	closeOnce sync.Once
+}
+
+// AddViewer starts streaming the named channel, which must be carried on the
+// same multiplex as the tuner's current channel, without changing the tuner's
+// current channel. All viewers of the same channel share a single stream, which
+// ends when the last viewer is closed or when the tuner leaves the multiplex.
+func (t *Tuner) AddViewer(channelName string) (*Viewer, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	channel, ok := t.lookupChannel(channelName)
+	if !ok {
+		return nil, ErrChannelNotFound
+	}
+	if t.mux == nil || t.mux.key != multiplexOf(channel) {
+		return nil, ErrChannelNotOnMultiplex
+	}
+
+	p, err := t.acquireProgram(channel)
+	if err != nil {
+		return nil, err
+	}
+	return &Viewer{tuner: t, program: p}, nil
+}
+
+// ChannelName returns the name of the channel that the viewer is watching.
+func (v *Viewer) ChannelName() string {
+	return v.program.channel.Name
+}
+
+// WatchTracks sets up a handler function to continuously receive the WebRTC
+// tracks for the viewer's channel. The tracks are cleared when the stream for
+// the channel ends. See the watch package documentation for details.
+func (v *Viewer) WatchTracks(handler func(Tracks)) watch.Watch {
+	return v.program.tracks.Watch(handler)
+}
+
+// Close releases the viewer's interest in its channel, stopping the stream for
+// the channel if no other viewer is watching it and the tuner is not tuned to
+// it.
+func (v *Viewer) Close() {
+	v.closeOnce.Do(func() {
+		t := v.tuner
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		// The program might have ended without us, along with its multiplex.
+		if t.hasProgram(v.program) {
+			t.releaseProgram(v.program)
+		}
+	})
+}
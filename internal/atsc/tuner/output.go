@@ -0,0 +1,68 @@
+package tuner
+
+import "slices"
+
+// outputTracks are the tuner's own WebRTC tracks, which carry its current
+// program to its clients. Clients keep the same tracks across channel changes,
+// and receive each new channel as new media on them without renegotiating
+// their sessions.
+type outputTracks struct {
+	streamID string
+	video    map[VideoLayer]*sampleTrack
+	audio    *sampleTrack
+}
+
+func newOutputTracks(streamID string) *outputTracks {
+	return &outputTracks{
+		streamID: streamID,
+		video:    make(map[VideoLayer]*sampleTrack),
+		audio:    newSampleTrack(AudioCodecCapability, streamID, streamID),
+	}
+}
+
+// play switches the output tracks to the sources of p, which must have started,
+// and returns the tracks of p with the output tracks in place of p's own. The
+// outputs that p doesn't use fall silent, as all of them do for a nil p.
+func (o *outputTracks) play(p *program) Tracks {
+	var ts Tracks
+	sources := make(map[*sampleTrack]*sampleSource)
+	if p != nil {
+		ts = p.tracks.Get()
+		ts.Video = slices.Clone(ts.Video)
+		for i := range ts.Video {
+			layer := ts.Video[i].Layer
+			track, ok := o.video[layer]
+			if !ok {
+				track = newSampleTrack(VideoCodecCapability, o.streamID, o.streamID)
+				o.video[layer] = track
+			}
+			sources[track] = p.videoSources[i]
+			ts.Video[i].Track = track
+		}
+		sources[o.audio] = p.audioSource
+		ts.Audio = o.audio
+	}
+
+	for _, track := range o.video {
+		track.setSource(sources[track])
+	}
+	o.audio.setSource(sources[o.audio])
+	return ts
+}
+
+// publishTracks publishes the tracks of the tuner's current program through
+// the tuner's output tracks. While the current program waits to start, the
+// tuner keeps its previous tracks in place, silent, so that its clients can
+// carry on with them once the program starts. Without a current program, the
+// tuner has no tracks.
+func (t *Tuner) publishTracks() {
+	switch {
+	case t.current == nil:
+		t.outputs.play(nil)
+		t.tracks.Set(Tracks{})
+	case t.current.branch == nil:
+		t.outputs.play(nil)
+	default:
+		t.tracks.Set(t.outputs.play(t.current))
+	}
+}
@@ -0,0 +1,205 @@
+package tuner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"sync"
+
+	"github.com/featherbread/hypcast/internal/atsc"
+	"github.com/featherbread/hypcast/internal/atsc/guide"
+)
+
+// Pool manages a set of Tuners that share a single channel list, allowing
+// clients to stream different channels at the same time.
+type Pool struct {
+	// mu serializes allocation of tuners, so that concurrent requests for
+	// different channels don't both claim the same stopped tuner.
+	mu     sync.Mutex
+	tuners []*Tuner
+}
+
+// NewPool creates a Pool containing one Tuner for each of the provided devices,
+// which must be unique. Each tuner can tune to any of the provided channels,
+// and encodes video as [NewTuner] describes.
+func NewPool(devices []Device, channels []atsc.Channel, videoPipeline VideoPipeline, videoLayers []VideoLayer) (*Pool, error) {
+	if len(devices) == 0 {
+		return nil, errors.New("tuner pool requires at least one device")
+	}
+
+	var (
+		tuners = make([]*Tuner, 0, len(devices))
+		g      = guide.New()
+	)
+	for i, device := range devices {
+		if slices.Contains(devices[:i], device) {
+			return nil, fmt.Errorf("duplicate tuner device %s", device)
+		}
+		t := NewTuner(device, channels, videoPipeline, videoLayers)
+		t.guide = g
+		tuners = append(tuners, t)
+	}
+	return &Pool{tuners: tuners}, nil
+}
+
+// Default returns the tuner that serves clients which don't request a specific
+// tuner, which is the tuner for the first device provided to [NewPool].
+func (p *Pool) Default() *Tuner {
+	return p.tuners[0]
+}
+
+// Get returns the tuner whose ID matches id, in any form that [ParseDevice]
+// accepts, so that "0.0" finds the tuner whose ID is "0".
+func (p *Pool) Get(id string) (t *Tuner, ok bool) {
+	device, err := ParseDevice(id)
+	if err != nil {
+		return nil, false
+	}
+	for _, t := range p.tuners {
+		if t.device == device {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// All returns an iterator over the tuners in the pool, in the order of the
+// devices provided to [NewPool].
+func (p *Pool) All() iter.Seq[*Tuner] {
+	return slices.Values(p.tuners)
+}
+
+// Channels returns an iterator over the channels that may be passed to
+// [Pool.Tune] or to [Tuner.Tune] for any tuner in the pool.
+func (p *Pool) Channels() iter.Seq[atsc.Channel] {
+	return p.Default().Channels()
+}
+
+// Guide returns the program guide shared by every tuner in the pool.
+func (p *Pool) Guide() *guide.Guide {
+	return p.Default().Guide()
+}
+
+// SweepGuide collects the program guide with the first tuner in the pool that
+// isn't in use. See [Tuner.SweepGuide] for details.
+func (p *Pool) SweepGuide(ctx context.Context) error {
+	for _, t := range p.tuners {
+		if t.Status().State != StateStopped {
+			continue
+		}
+		if err := t.SweepGuide(ctx); !errors.Is(err, ErrTunerBusy) {
+			return err
+		}
+	}
+	return ErrTunerBusy
+}
+
+// ErrNoTunerAvailable is returned when every tuner in a pool is already in use.
+var ErrNoTunerAvailable error = errors.New("no tuner available")
+
+// Tune allocates a tuner to stream the channel with the provided name or
+// virtual channel number, and returns the allocated tuner.
+//
+// Tune prefers a tuner that is already streaming the channel, in which case it
+// leaves that tuner undisturbed. Next, it prefers a tuner that only Streams are
+// using on the channel's multiplex, which then stays on the channel after its
+// streams close. Otherwise, it tunes a stopped tuner to the channel. It never
+// changes the channel of a tuner that is in use, and tuners return to the pool
+// once they stop, as idle tuners do on their own.
+func (p *Pool) Tune(channelName string) (*Tuner, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	channel, ok := p.Default().lookupChannel(channelName)
+	if !ok {
+		return nil, ErrChannelNotFound
+	}
+
+	for _, t := range p.tuners {
+		if t.tunedTo(channel) {
+			return t, nil
+		}
+	}
+
+	for _, t := range p.tuners {
+		if streamed, err := t.tuneIfStreamed(channel); streamed {
+			return t, err
+		}
+	}
+
+	for _, t := range p.tuners {
+		if stopped, err := t.tuneIfStopped(channel); stopped {
+			return t, err
+		}
+	}
+
+	return nil, ErrNoTunerAvailable
+}
+
+// tunedTo reports whether channel is the tuner's current channel.
+func (t *Tuner) tunedTo(channel atsc.Channel) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.current != nil && t.current.channel.Name == channel.Name
+}
+
+// tuneIfStreamed tunes the tuner to channel if only its streams are using it,
+// on the channel's multiplex, and reports whether they were. The tuner then
+// stops once idle, as a stopped tuner that the pool allocates does, rather
+// than when its last stream closes.
+func (t *Tuner) tuneIfStreamed(channel atsc.Channel) (streamed bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.streamsTuned || t.mux.key != multiplexOf(channel) {
+		return false, nil
+	}
+	t.streamsTuned, t.pooled = false, true
+	return true, t.tune(channel)
+}
+
+// tuneIfStopped tunes the tuner to channel if it's stopped, and reports whether
+// it was. Checking and tuning under one lock keeps a client that tunes the
+// tuner directly from claiming it at the same time.
+func (t *Tuner) tuneIfStopped(channel atsc.Channel) (stopped bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.mux != nil || t.cancelScan != nil {
+		return false, nil
+	}
+	t.pooled = true
+	return true, t.tune(channel)
+}
+
+// OpenStream allocates a tuner to stream the channel with the provided name or
+// virtual channel number, and opens a stream of the channel on that tuner as
+// [Tuner.OpenStream] does.
+//
+// OpenStream prefers a tuner whose current multiplex carries the channel, in
+// which case it leaves that tuner's channel undisturbed. Otherwise, it tunes a
+// stopped tuner to the channel. It never changes the channel of a tuner that
+// is in use.
+func (p *Pool) OpenStream(channelName string, format StreamFormat) (*Stream, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	channel, ok := p.Default().lookupChannel(channelName)
+	if !ok {
+		return nil, ErrChannelNotFound
+	}
+
+	for _, t := range p.tuners {
+		if st, ok, err := t.openStreamIfAvailable(channel, format, false); ok {
+			return st, err
+		}
+	}
+
+	for _, t := range p.tuners {
+		if st, ok, err := t.openStreamIfAvailable(channel, format, true); ok {
+			return st, err
+		}
+	}
+
+	return nil, ErrNoTunerAvailable
+}
@@ -0,0 +1,268 @@
+package tuner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/featherbread/hypcast/internal/atsc"
+)
+
+// testChannels can stream without tuner hardware, each from a multiplex of its
+// own.
+var testChannels = []atsc.Channel{
+	{Name: "Bars", Modulation: atsc.Modulation8VSB, VideoPID: 49, AudioPID: 52, ProgramID: 1, TestPattern: "smpte"},
+	{Name: "Ball", Modulation: atsc.Modulation8VSB, VideoPID: 49, AudioPID: 52, ProgramID: 1, TestPattern: "ball"},
+	{Name: "Snow", Modulation: atsc.Modulation8VSB, VideoPID: 49, AudioPID: 52, ProgramID: 1, TestPattern: "snow"},
+}
+
+// newTestPool creates a pool of tuners for testChannels, which stop at the end
+// of the test. It skips the test if GStreamer can't build the test pattern
+// pipeline.
+func newTestPool(t *testing.T, devices ...Device) *Pool {
+	t.Helper()
+
+	probe := NewTuner(Device{}, testChannels, VideoPipelineLowPower, nil)
+	if err := probe.Tune(testChannels[0].Name); err != nil {
+		t.Skipf("can't stream test pattern: %v", err)
+	}
+	probe.Stop()
+
+	p, err := NewPool(devices, testChannels, VideoPipelineLowPower, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for tuner := range p.All() {
+			tuner.Stop()
+		}
+	})
+	return p
+}
+
+func TestParseDevice(t *testing.T) {
+	testCases := []struct {
+		input   string
+		want    Device
+		wantStr string
+		wantErr bool
+	}{
+		{input: "0", want: Device{}, wantStr: "0"},
+		{input: "0.0", want: Device{}, wantStr: "0"},
+		{input: "1", want: Device{Adapter: 1}, wantStr: "1"},
+		{input: "1.2", want: Device{Adapter: 1, Frontend: 2}, wantStr: "1.2"},
+		{input: "", wantErr: true},
+		{input: "a", wantErr: true},
+		{input: "-1", wantErr: true},
+		{input: "1.", wantErr: true},
+		{input: "1.b", wantErr: true},
+		{input: "1.2.3", wantErr: true},
+	}
+	for _, tc := range testCases {
+		got, err := ParseDevice(tc.input)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseDevice(%q) error = %v; want error %v", tc.input, err, tc.wantErr)
+			continue
+		}
+		if tc.wantErr {
+			continue
+		}
+		if got != tc.want || got.String() != tc.wantStr {
+			t.Errorf("ParseDevice(%q) = %v (%q); want %v (%q)", tc.input, got, got.String(), tc.want, tc.wantStr)
+		}
+	}
+}
+
+func TestNewPoolDuplicateDevice(t *testing.T) {
+	if _, err := NewPool(nil, testChannels, VideoPipelineDefault, nil); err == nil {
+		t.Error("NewPool with no devices succeeded")
+	}
+	devices := []Device{{Adapter: 0}, {Adapter: 1}, {Adapter: 0}}
+	if _, err := NewPool(devices, testChannels, VideoPipelineDefault, nil); err == nil {
+		t.Error("NewPool with duplicate devices succeeded")
+	}
+}
+
+func TestPoolGet(t *testing.T) {
+	p, err := NewPool([]Device{{}, {Frontend: 1}}, testChannels, VideoPipelineDefault, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		id     string
+		wantID string
+		wantOK bool
+	}{
+		{id: "0", wantID: "0", wantOK: true},
+		{id: "0.0", wantID: "0", wantOK: true},
+		{id: "0.1", wantID: "0.1", wantOK: true},
+		{id: "1"},
+		{id: "tuner"},
+		{id: ""},
+	}
+	for _, tc := range testCases {
+		got, ok := p.Get(tc.id)
+		if ok != tc.wantOK || (ok && got.ID() != tc.wantID) {
+			t.Errorf("Get(%q) = %v, %v; want %q, %v", tc.id, got, ok, tc.wantID, tc.wantOK)
+		}
+	}
+}
+
+func TestPoolTune(t *testing.T) {
+	p := newTestPool(t, Device{Adapter: 0}, Device{Adapter: 1})
+	first, second := p.tuners[0], p.tuners[1]
+
+	mustTune := func(channel string, want *Tuner) {
+		t.Helper()
+		got, err := p.Tune(channel)
+		if err != nil {
+			t.Fatalf("Tune(%q) failed: %v", channel, err)
+		}
+		if got != want {
+			t.Fatalf("Tune(%q) allocated tuner %s; want %s", channel, got.ID(), want.ID())
+		}
+		if s := got.Status(); s.ChannelName != channel {
+			t.Fatalf("tuner %s is on %q after Tune(%q)", got.ID(), s.ChannelName, channel)
+		}
+	}
+
+	mustTune("Bars", first)
+	mustTune("Bars", first)
+	mustTune("Ball", second)
+
+	if _, err := p.Tune("Snow"); !errors.Is(err, ErrNoTunerAvailable) {
+		t.Fatalf("Tune with every tuner in use returned %v; want %v", err, ErrNoTunerAvailable)
+	}
+	if s := first.Status(); s.ChannelName != "Bars" {
+		t.Fatalf("failed allocation changed tuner %s to %q", first.ID(), s.ChannelName)
+	}
+
+	first.Stop()
+	mustTune("Snow", first)
+
+	if _, err := p.Tune("Nothing"); !errors.Is(err, ErrChannelNotFound) {
+		t.Errorf("Tune of unknown channel returned %v; want %v", err, ErrChannelNotFound)
+	}
+}
+
+func TestTunerIdle(t *testing.T) {
+	p := newTestPool(t, Device{})
+	tuner := p.Default()
+
+	idle := func() bool {
+		tuner.mu.Lock()
+		defer tuner.mu.Unlock()
+		return tuner.idle != nil
+	}
+
+	if got, err := p.Tune("Bars"); err != nil || got != tuner {
+		t.Fatalf("Tune allocated tuner %v, error %v; want %s", got, err, tuner.ID())
+	}
+	if !idle() {
+		t.Fatal("tuner with no holders is not counting down to stop")
+	}
+
+	release := tuner.Hold()
+	if idle() {
+		t.Fatal("held tuner is counting down to stop")
+	}
+	release2 := tuner.Hold()
+	release()
+	release() // Releasing twice must not drop the other hold.
+	if idle() {
+		t.Fatal("tuner counts down to stop while still held")
+	}
+	release2()
+	if !idle() {
+		t.Fatal("tuner with released holds is not counting down to stop")
+	}
+
+	// A client that tunes the tuner itself keeps it playing.
+	if err := tuner.Tune("Ball"); err != nil {
+		t.Fatal(err)
+	}
+	if idle() {
+		t.Fatal("directly tuned tuner is counting down to stop")
+	}
+	tuner.Hold()()
+	if idle() {
+		t.Fatal("directly tuned tuner counts down to stop after its holds")
+	}
+
+	tuner.Stop()
+	if idle() {
+		t.Fatal("stopped tuner is counting down to stop")
+	}
+}
+
+func TestPoolStreamedTuner(t *testing.T) {
+	p := newTestPool(t, Device{Adapter: 0}, Device{Adapter: 1})
+	first := p.tuners[0]
+
+	state := func() (current *program, streamsTuned bool, s Status) {
+		first.mu.Lock()
+		defer first.mu.Unlock()
+		return first.current, first.streamsTuned, first.Status()
+	}
+
+	stream, err := p.OpenStream("Bars", StreamFormatRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.Tuner() != first {
+		t.Fatalf("OpenStream allocated tuner %s; want %s", stream.Tuner().ID(), first.ID())
+	}
+	if current, streamsTuned, _ := state(); current != nil || !streamsTuned {
+		t.Fatalf("tuner opened for a stream has current program %v, streamsTuned %v; want false, true", current != nil, streamsTuned)
+	}
+
+	// A second stream of the multiplex shares the tuner rather than tuning
+	// the stopped one.
+	second, err := p.OpenStream("Bars", StreamFormatTranscode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Tuner() != first {
+		t.Fatalf("second OpenStream allocated tuner %s; want %s", second.Tuner().ID(), first.ID())
+	}
+	second.Close()
+
+	got, err := p.Tune("Bars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != first {
+		t.Fatalf("Tune allocated tuner %s; want streamed tuner %s", got.ID(), first.ID())
+	}
+	if current, streamsTuned, _ := state(); current == nil || streamsTuned {
+		t.Fatalf("tuned tuner has current program %v, streamsTuned %v; want true, false", current != nil, streamsTuned)
+	}
+
+	stream.Close()
+	if _, _, s := state(); s.State == StateStopped || s.ChannelName != "Bars" {
+		t.Fatalf("tuner is %v on %q after its last stream closed; want to stay on %q", s.State, s.ChannelName, "Bars")
+	}
+	first.mu.Lock()
+	idle := first.idle != nil
+	first.mu.Unlock()
+	if !idle {
+		t.Fatal("allocated tuner is not counting down to stop after its last stream closed")
+	}
+}
+
+func TestStreamStopsStreamedTuner(t *testing.T) {
+	p := newTestPool(t, Device{})
+	tuner := p.Default()
+
+	stream, err := tuner.OpenStream("Bars", StreamFormatRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tracks := tuner.tracks.Get(); len(tracks.Video) > 0 {
+		t.Fatal("tuner opened for a stream has tracks for its own clients")
+	}
+	stream.Close()
+	if s := tuner.Status(); s.State != StateStopped {
+		t.Fatalf("tuner is %v after its last stream closed; want stopped", s.State)
+	}
+}
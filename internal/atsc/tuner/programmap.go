@@ -0,0 +1,105 @@
+package tuner
+
+import (
+	"io"
+
+	"github.com/featherbread/hypcast/internal/atsc/mpegts"
+)
+
+// programMap describes the streams of a program that the tuner can use, as
+// listed in the program's map table.
+type programMap struct {
+	// Video is the first video stream of the program that the tuner can decode,
+	// or the zero value if there is none.
+	Video       videoStream
+	AudioTracks []AudioTrack
+}
+
+// videoStream describes the video stream of a program.
+type videoStream struct {
+	PID        uint
+	StreamType mpegts.StreamType
+	// AVC gives the profile and level of H.264 video, when the program map
+	// table includes them.
+	AVC mpegts.AVCVideo
+}
+
+func programMapOf(pmt mpegts.PMT) programMap {
+	pm := programMap{AudioTracks: audioTracksOf(pmt)}
+	for _, es := range pmt.Streams {
+		if _, ok := videoCodecs[es.Type]; !ok {
+			continue
+		}
+		pm.Video = videoStream{PID: uint(es.PID), StreamType: es.Type}
+		if d, ok := mpegts.FindDescriptor(es.Descriptors, mpegts.DescriptorTagAVCVideo); ok {
+			pm.Video.AVC, _ = mpegts.ParseAVCVideo(d)
+		}
+		break
+	}
+	return pm
+}
+
+// readProgramMaps reads the program map tables of the transport stream in r,
+// and updates m's programs as the tables change, until r returns an error.
+func (t *Tuner) readProgramMaps(m *multiplex, r io.Reader) error {
+	sr := mpegts.NewSectionReader(r)
+	versions := make(map[uint16]uint8)
+	for {
+		pid, s, err := sr.ReadSection()
+		if err != nil {
+			return err
+		}
+		if !s.CurrentNext {
+			continue
+		}
+
+		switch {
+		case pid == mpegts.PIDPAT && s.TableID == mpegts.TableIDPAT:
+			if pat, err := mpegts.ParsePAT(s); err == nil {
+				for _, program := range pat.Programs {
+					sr.Select(program.PMTPID)
+				}
+			}
+
+		case s.TableID == mpegts.TableIDPMT:
+			if version, ok := versions[s.TableIDExtension]; ok && version == s.Version {
+				continue
+			}
+			if pmt, err := mpegts.ParsePMT(s); err == nil {
+				versions[pmt.ProgramNumber] = pmt.Version
+				t.setProgramMap(m, uint(pmt.ProgramNumber), programMapOf(pmt))
+			}
+		}
+	}
+}
+
+// setProgramMap records the map table of a program on m, and starts the
+// program and its streams if they were waiting for the table.
+func (t *Tuner) setProgramMap(m *multiplex, programID uint, pm programMap) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.mux != m {
+		return
+	}
+	m.programMaps[programID] = pm
+	t.startPendingStreams(programID, pm)
+
+	p, ok := m.programs[programID]
+	if !ok {
+		return
+	}
+	if p.branch != nil {
+		t.updateAudio(p, pm.AudioTracks)
+	} else {
+		p.pending.Stop()
+		if err := t.startProgram(p, pm); err != nil {
+			t.log.Error("Failed to start program", "channel", p.channel.Name, "error", err)
+			t.failProgram(p, err)
+			return
+		}
+	}
+	if p == t.current {
+		t.status.Set(t.currentStatus())
+	}
+}
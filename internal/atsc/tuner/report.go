@@ -0,0 +1,79 @@
+package tuner
+
+import "sync"
+
+// worstReport collects the latest report from each client of a control, and
+// retargets the control at the worst of them whenever they change. Every
+// client of a control receives the same encoded stream, so the encoder has to
+// suit the client with the worst network.
+type worstReport[T any] struct {
+	// worse reports whether report a is worse than report b.
+	worse func(a, b T) bool
+	// retarget targets the control at the worst report, or at its default if
+	// ok is false because no client has reported. It may return a function to
+	// call after the reports are unlocked, or nil.
+	retarget func(worst T, ok bool) (apply func())
+
+	mu      sync.Mutex
+	reports map[*reportClient[T]]*T // nil until the client reports.
+}
+
+// reportClient represents a single client's reports to a control.
+type reportClient[T any] struct {
+	reports *worstReport[T]
+}
+
+// newClient adds a client, which the control ignores until it reports.
+func (w *worstReport[T]) newClient() *reportClient[T] {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.reports == nil {
+		w.reports = make(map[*reportClient[T]]*T)
+	}
+	c := &reportClient[T]{reports: w}
+	w.reports[c] = nil
+	return c
+}
+
+// report sets the client's latest report, unless the client is closed.
+func (c *reportClient[T]) report(v T) {
+	w := c.reports
+	w.mu.Lock()
+	if _, ok := w.reports[c]; !ok {
+		w.mu.Unlock()
+		return
+	}
+	w.reports[c] = &v
+	apply := w.updateLocked()
+	w.mu.Unlock()
+
+	if apply != nil {
+		apply()
+	}
+}
+
+// Close removes the client from its control.
+func (c *reportClient[T]) Close() {
+	w := c.reports
+	w.mu.Lock()
+	delete(w.reports, c)
+	apply := w.updateLocked()
+	w.mu.Unlock()
+
+	if apply != nil {
+		apply()
+	}
+}
+
+func (w *worstReport[T]) updateLocked() func() {
+	var (
+		worst T
+		ok    bool
+	)
+	for _, v := range w.reports {
+		if v != nil && (!ok || w.worse(*v, worst)) {
+			worst, ok = *v, true
+		}
+	}
+	return w.retarget(worst, ok)
+}
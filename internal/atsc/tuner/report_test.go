@@ -0,0 +1,49 @@
+package tuner
+
+import "testing"
+
+func TestWorstReport(t *testing.T) {
+	type target struct {
+		worst int
+		ok    bool
+	}
+	var (
+		w       *worstReport[int]
+		targets []target
+	)
+	w = &worstReport[int]{
+		worse: func(a, b int) bool { return a > b },
+		retarget: func(worst int, ok bool) func() {
+			targets = append(targets, target{worst, ok})
+			return func() {
+				if !w.mu.TryLock() {
+					t.Error("applied a change with the reports locked")
+					return
+				}
+				w.mu.Unlock()
+			}
+		},
+	}
+
+	a, b := w.newClient(), w.newClient()
+	steps := []struct {
+		name string
+		do   func()
+		want []target
+	}{
+		{"first report", func() { a.report(3) }, []target{{3, true}}},
+		{"worse report", func() { b.report(5) }, []target{{5, true}}},
+		{"better report", func() { b.report(1) }, []target{{3, true}}},
+		{"close", func() { b.Close() }, []target{{3, true}}},
+		{"report after close", func() { b.report(10) }, nil},
+		{"new client without report", func() { b = w.newClient() }, nil},
+		{"last reporting client closes", func() { a.Close() }, []target{{0, false}}},
+	}
+	for _, step := range steps {
+		targets = nil
+		step.do()
+		if len(targets) != len(step.want) || (len(targets) > 0 && targets[0] != step.want[0]) {
+			t.Errorf("%s: retargeted at %v, want %v", step.name, targets, step.want)
+		}
+	}
+}
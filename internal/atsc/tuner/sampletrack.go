@@ -0,0 +1,331 @@
+package tuner
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/featherbread/hypcast/internal/gst"
+)
+
+// sampleSource caches the encoded samples that a GStreamer sink produces, and
+// feeds them to every sampleTrack that plays from it. The cache lets each
+// client start playing as soon as it receives a track.
+//
+// Video sources cache the current group of pictures, from the last IDR picture
+// onward, so that a new client doesn't have to wait for the next keyframe to
+// show anything. Audio sources cache a short span of recent audio.
+type sampleSource struct {
+	video bool
+
+	mu     sync.Mutex
+	tracks map[*sampleTrack]struct{}
+	cache  []gst.Sample
+	// cacheBytes and cacheDuration total the size and duration of the cache.
+	// The video cache is invalid after a group of pictures outgrows the limits,
+	// until the next IDR picture.
+	cacheBytes    int
+	cacheDuration time.Duration
+	cacheValid    bool
+}
+
+// sampleTrack is a WebRTC track that plays the samples of a sampleSource.
+//
+// Every binding of the track to a client's RTP sender gets a packetizer and an
+// RTP timeline of its own, since a client that replays the source's cache is
+// briefly out of step with the others. The cache replays right before the
+// first live sample that follows a new binding.
+//
+// The timeline derives the RTP timestamp of each live sample from its
+// presentation time, rather than adding up the durations of the samples
+// before it, so that the timestamps of audio and video can't drift apart, even
+// after the pipeline drops samples. GStreamer releases each sample to the
+// track on the pipeline clock at its presentation time, so the RTCP sender
+// reports, which carry the timestamp of the latest packet forward to the
+// present, follow the presentation times too.
+//
+// The track's source can change while clients are bound to it, as when the
+// tuner changes channels. Each binding keeps its packetizer across the change,
+// so the client's RTP stream carries on with the same SSRC and with unbroken
+// sequence numbers and timestamps. Video bindings replay the new source's
+// cache, so that the client can decode the new video right away.
+type sampleTrack struct {
+	capability   webrtc.RTPCodecCapability
+	id, streamID string
+	video        bool
+
+	// source is the track's current source, which only setSource touches.
+	source *sampleSource
+
+	mu       sync.Mutex
+	bindings map[string]*sampleBinding
+}
+
+type sampleBinding struct {
+	track      *webrtc.TrackLocalStaticRTP
+	packetizer rtp.Packetizer
+	timeline   rtpTimeline
+	replayed   bool
+}
+
+// rtpMTU limits the size of the RTP packets that carry each sample, leaving
+// room for the headers of the lower layers within a typical network MTU.
+const rtpMTU = 1200
+
+const (
+	// maxVideoCacheBytes and maxVideoCacheDuration bound the group of pictures
+	// that a video source caches. A larger cache would take too long for a new
+	// client to catch up on.
+	maxVideoCacheBytes    = 8 << 20
+	maxVideoCacheDuration = 5 * time.Second
+	// audioCacheDuration is the span of recent audio that an audio source
+	// caches, and maxAudioCacheSamples bounds the cache in case the samples
+	// lack durations. Opus samples last 20 ms each.
+	audioCacheDuration   = 500 * time.Millisecond
+	maxAudioCacheSamples = 50
+	// videoReplayFrameDuration stands in for the durations of the cached
+	// pictures that a client replays, which go out with RTP timestamps this
+	// far apart, so that the client decodes them as a quick burst instead of
+	// falling behind the live video.
+	videoReplayFrameDuration = time.Millisecond
+)
+
+func newSampleSource(video bool) *sampleSource {
+	return &sampleSource{
+		video:  video,
+		tracks: make(map[*sampleTrack]struct{}),
+	}
+}
+
+// WriteSample caches sample and sends it to every track that plays from the
+// source.
+func (src *sampleSource) WriteSample(sample gst.Sample) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	src.cacheSample(sample)
+	for st := range src.tracks {
+		st.writeSample(src, sample)
+	}
+}
+
+func (src *sampleSource) attach(st *sampleTrack) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	src.tracks[st] = struct{}{}
+}
+
+// detach stops sending samples to st, which receives none from the source once
+// detach returns.
+func (src *sampleSource) detach(st *sampleTrack) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	delete(src.tracks, st)
+}
+
+// replayCache writes the cache to b, excluding the latest sample.
+func (src *sampleSource) replayCache(b *sampleBinding) {
+	for _, sample := range src.replaySamples() {
+		step := sample.Duration
+		if src.video {
+			step = videoReplayFrameDuration
+		}
+		b.write(sample, b.timeline.advance(step))
+	}
+}
+
+// replaySamples returns the cached samples that a new client replays. The
+// latest sample is left out, as the client receives it live right after.
+func (src *sampleSource) replaySamples() []gst.Sample {
+	if !src.cacheValid || len(src.cache) < 2 {
+		return nil
+	}
+	return src.cache[:len(src.cache)-1]
+}
+
+func (src *sampleSource) cacheSample(sample gst.Sample) {
+	if !src.video {
+		src.appendCache(sample)
+		for len(src.cache) > maxAudioCacheSamples ||
+			src.cacheDuration-src.cache[0].Duration >= audioCacheDuration {
+			src.dropCacheHead()
+		}
+		src.cacheValid = true
+		return
+	}
+
+	// A break in the stream leaves the cached pictures without some of the
+	// pictures that the next ones depend on.
+	if sample.Discont {
+		src.clearCache()
+	}
+	if sample.Keyframe && h264Keyframe(sample.Data) {
+		src.clearCache()
+		src.cacheValid = true
+	}
+	if !src.cacheValid {
+		return
+	}
+	src.appendCache(sample)
+	if src.cacheBytes > maxVideoCacheBytes || src.cacheDuration > maxVideoCacheDuration {
+		src.clearCache()
+	}
+}
+
+func (src *sampleSource) appendCache(sample gst.Sample) {
+	src.cache = append(src.cache, sample)
+	src.cacheBytes += len(sample.Data)
+	src.cacheDuration += sample.Duration
+}
+
+func (src *sampleSource) dropCacheHead() {
+	src.cacheBytes -= len(src.cache[0].Data)
+	src.cacheDuration -= src.cache[0].Duration
+	src.cache[0] = gst.Sample{}
+	src.cache = src.cache[1:]
+}
+
+func (src *sampleSource) clearCache() {
+	clear(src.cache)
+	src.cache = src.cache[:0]
+	src.cacheBytes, src.cacheDuration, src.cacheValid = 0, 0, false
+}
+
+func newSampleTrack(capability webrtc.RTPCodecCapability, id, streamID string) *sampleTrack {
+	return &sampleTrack{
+		capability: capability,
+		id:         id,
+		streamID:   streamID,
+		video:      strings.HasPrefix(capability.MimeType, "video/"),
+		bindings:   make(map[string]*sampleBinding),
+	}
+}
+
+// ID implements webrtc.TrackLocal.
+func (st *sampleTrack) ID() string { return st.id }
+
+// StreamID implements webrtc.TrackLocal.
+func (st *sampleTrack) StreamID() string { return st.streamID }
+
+// RID implements webrtc.TrackLocal.
+func (st *sampleTrack) RID() string { return "" }
+
+// Kind implements webrtc.TrackLocal.
+func (st *sampleTrack) Kind() webrtc.RTPCodecType {
+	if st.video {
+		return webrtc.RTPCodecTypeVideo
+	}
+	return webrtc.RTPCodecTypeAudio
+}
+
+// Bind implements webrtc.TrackLocal.
+func (st *sampleTrack) Bind(ctx webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	track, err := webrtc.NewTrackLocalStaticRTP(st.capability, st.id, st.streamID)
+	if err != nil {
+		return webrtc.RTPCodecParameters{}, err
+	}
+	codec, err := track.Bind(ctx)
+	if err != nil {
+		return webrtc.RTPCodecParameters{}, err
+	}
+
+	// The track sets the SSRC and payload type of each packet for the binding.
+	var payloader rtp.Payloader = &codecs.OpusPayloader{}
+	if st.video {
+		payloader = &codecs.H264Payloader{}
+	}
+	b := &sampleBinding{
+		track:      track,
+		packetizer: rtp.NewPacketizerWithOptions(rtpMTU, payloader, rtp.NewRandomSequencer(), codec.ClockRate),
+		timeline:   newRTPTimeline(codec.ClockRate),
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.bindings[ctx.ID()] = b
+	return codec, nil
+}
+
+// Unbind implements webrtc.TrackLocal.
+func (st *sampleTrack) Unbind(ctx webrtc.TrackLocalContext) error {
+	st.mu.Lock()
+	b, ok := st.bindings[ctx.ID()]
+	delete(st.bindings, ctx.ID())
+	st.mu.Unlock()
+
+	if !ok {
+		return webrtc.ErrUnbindFailed
+	}
+	return b.track.Unbind(ctx)
+}
+
+// setSource switches the track to play from src, or stops it from playing
+// anything when src is nil. Calls to setSource must not overlap.
+func (st *sampleTrack) setSource(src *sampleSource) {
+	if src == st.source {
+		return
+	}
+	if st.source != nil {
+		st.source.detach(st)
+	}
+
+	// Audio carries on without a replay, which would only put the client
+	// behind the live audio of the new source. The presentation times of the
+	// new source start a new timeline for each client, which picks up where
+	// the old one left off.
+	st.mu.Lock()
+	for _, b := range st.bindings {
+		b.replayed = !st.video
+		b.timeline.synced = false
+	}
+	st.mu.Unlock()
+
+	st.source = src
+	if src != nil {
+		src.attach(st)
+	}
+}
+
+// writeSample sends sample from src to every client of the track, after
+// replaying the cache of src to any client that hasn't seen it.
+func (st *sampleTrack) writeSample(src *sampleSource, sample gst.Sample) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for _, b := range st.bindings {
+		if !b.replayed {
+			b.replayed = true
+			src.replayCache(b)
+		}
+		b.write(sample, b.timeline.timestamp(sample))
+	}
+}
+
+// write sends sample to the binding's client with the RTP timestamp ts.
+func (b *sampleBinding) write(sample gst.Sample, ts uint32) {
+	for _, p := range b.packetizer.Packetize(sample.Data, 0) {
+		p.Timestamp = ts
+		b.track.WriteRTP(p)
+	}
+}
+
+// h264Keyframe reports whether an access unit of H.264 video in Annex B byte
+// stream format contains an IDR picture, from which a decoder can start.
+func h264Keyframe(data []byte) bool {
+	const nalTypeIDR = 5
+	for {
+		i := bytes.Index(data, []byte{0, 0, 1})
+		if i < 0 || i+3 >= len(data) {
+			return false
+		}
+		if data[i+3]&0x1f == nalTypeIDR {
+			return true
+		}
+		data = data[i+3:]
+	}
+}
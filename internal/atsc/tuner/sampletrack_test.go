@@ -0,0 +1,154 @@
+package tuner
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/featherbread/hypcast/internal/gst"
+)
+
+// testPicture describes a sample of H.264 video for a test of the cache, which
+// the test identifies by its PTS.
+type testPicture struct {
+	id       int
+	idr      bool
+	discont  bool
+	size     int
+	duration time.Duration
+}
+
+func (p testPicture) sample() gst.Sample {
+	nalType := byte(0x41) // Non-IDR slice.
+	if p.idr {
+		nalType = 0x65
+	}
+	data := append([]byte{0, 0, 1, nalType}, make([]byte, p.size)...)
+	return gst.Sample{
+		Data:     data,
+		PTS:      time.Duration(p.id),
+		Duration: p.duration,
+		Discont:  p.discont,
+		Keyframe: p.idr,
+	}
+}
+
+func TestSampleSourceVideoCache(t *testing.T) {
+	const frame = 33 * time.Millisecond
+	testCases := []struct {
+		name     string
+		pictures []testPicture
+		want     []int
+	}{
+		{
+			name:     "no IDR picture",
+			pictures: []testPicture{{id: 1}, {id: 2}, {id: 3}},
+		},
+		{
+			name:     "replay excludes latest",
+			pictures: []testPicture{{id: 1}, {id: 2, idr: true}, {id: 3}, {id: 4}},
+			want:     []int{2, 3},
+		},
+		{
+			name:     "IDR picture alone",
+			pictures: []testPicture{{id: 1, idr: true}},
+		},
+		{
+			name:     "reset on IDR picture",
+			pictures: []testPicture{{id: 1, idr: true}, {id: 2}, {id: 3, idr: true}, {id: 4}, {id: 5}},
+			want:     []int{3, 4},
+		},
+		{
+			name:     "discontinuity invalidates",
+			pictures: []testPicture{{id: 1, idr: true}, {id: 2}, {id: 3, discont: true}, {id: 4}},
+		},
+		{
+			name:     "discontinuity at IDR picture",
+			pictures: []testPicture{{id: 1, idr: true}, {id: 2}, {id: 3, idr: true, discont: true}, {id: 4}},
+			want:     []int{3},
+		},
+		{
+			name: "size limit",
+			pictures: []testPicture{
+				{id: 1, idr: true}, {id: 2, size: maxVideoCacheBytes / 2}, {id: 3, size: maxVideoCacheBytes / 2}, {id: 4},
+			},
+		},
+		{
+			name: "size limit until IDR picture",
+			pictures: []testPicture{
+				{id: 1, idr: true, size: maxVideoCacheBytes}, {id: 2}, {id: 3, idr: true}, {id: 4},
+			},
+			want: []int{3},
+		},
+		{
+			name: "duration limit",
+			pictures: []testPicture{
+				{id: 1, idr: true, duration: frame},
+				{id: 2, duration: maxVideoCacheDuration - frame},
+				{id: 3, duration: frame},
+				{id: 4, duration: frame},
+			},
+		},
+		{
+			name: "within duration limit",
+			pictures: []testPicture{
+				{id: 1, idr: true, duration: frame},
+				{id: 2, duration: maxVideoCacheDuration - 2*frame},
+				{id: 3, duration: frame},
+			},
+			want: []int{1, 2},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			src := newSampleSource(true)
+			for _, p := range tc.pictures {
+				src.WriteSample(p.sample())
+			}
+			var got []int
+			for _, sample := range src.replaySamples() {
+				got = append(got, int(sample.PTS))
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected replay (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSampleSourceAudioCache(t *testing.T) {
+	const frame = 20 * time.Millisecond
+	testCases := []struct {
+		name     string
+		count    int
+		duration time.Duration
+		want     int
+	}{
+		{name: "short", count: 10, duration: frame, want: 9},
+		{name: "duration limit", count: 100, duration: frame, want: int(audioCacheDuration/frame) - 1},
+		{name: "unknown durations", count: 1000, duration: 0, want: maxAudioCacheSamples - 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			src := newSampleSource(false)
+			for i := range tc.count {
+				src.WriteSample(gst.Sample{Data: []byte{0xFC}, PTS: time.Duration(i) * frame, Duration: tc.duration})
+			}
+
+			replay := src.replaySamples()
+			if len(replay) != tc.want {
+				t.Fatalf("replays %d samples, want %d", len(replay), tc.want)
+			}
+			// The replay ends right before the latest sample.
+			wantPTS := time.Duration(tc.count-2) * frame
+			if last := replay[len(replay)-1]; last.PTS != wantPTS {
+				t.Errorf("replay ends at %v, want %v", last.PTS, wantPTS)
+			}
+			if !slices.IsSortedFunc(replay, func(a, b gst.Sample) int { return int(a.PTS - b.PTS) }) {
+				t.Error("replay is out of order")
+			}
+		})
+	}
+}
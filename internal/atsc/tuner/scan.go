@@ -0,0 +1,136 @@
+package tuner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/featherbread/hypcast/internal/atsc"
+	"github.com/featherbread/hypcast/internal/atsc/scan"
+	"github.com/featherbread/hypcast/internal/gst"
+)
+
+// ErrTunerBusy is returned when scanning with a tuner that is streaming, or
+// when tuning a tuner that is scanning.
+var ErrTunerBusy error = errors.New("tuner is busy")
+
+// Scan searches for channels on every frequency of plan using the tuner's DVB
+// device, and returns the channels that it finds. See [scan.Scan] for details,
+// including the calls to progress.
+//
+// The tuner must be stopped when Scan is called, and reports StateScanning
+// until the scan is complete. Calling [Tuner.Stop] cancels the scan, in which
+// case Scan returns the channels found so far along with an error.
+func (t *Tuner) Scan(ctx context.Context, plan scan.BandPlan, progress func(scan.Progress)) ([]atsc.Channel, error) {
+	type result struct {
+		channels []atsc.Channel
+		err      error
+	}
+	done := make(chan result, 1)
+	err := t.StartScan(ctx, plan, progress, func(channels []atsc.Channel, err error) {
+		done <- result{channels, err}
+	})
+	if err != nil {
+		return nil, err
+	}
+	r := <-done
+	return r.channels, r.err
+}
+
+// StartScan begins a scan as described for [Tuner.Scan], without waiting for
+// it to complete. Once the tuner is scanning, StartScan returns, and the scan
+// continues in a new goroutine that calls done with its result.
+//
+// StartScan returns ErrTunerBusy, without calling done, if the tuner is not
+// stopped.
+func (t *Tuner) StartScan(
+	ctx context.Context, plan scan.BandPlan,
+	progress func(scan.Progress), done func([]atsc.Channel, error),
+) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.mux != nil || t.cancelScan != nil {
+		return ErrTunerBusy
+	}
+	t.stopAnySweep()
+	ctx, cancel := context.WithCancel(ctx)
+	t.cancelScan = cancel
+	t.status.Set(Status{State: StateScanning})
+
+	go func() {
+		t.log.Info("Starting channel scan", "frequencies", len(plan))
+		channels, err := scan.Scan(ctx, scanSource{t}, plan, progress)
+		t.log.Info("Finished channel scan", "channels", len(channels), "error", err)
+
+		t.mu.Lock()
+		cancel()
+		t.cancelScan = nil
+		t.status.Set(Status{})
+		t.mu.Unlock()
+
+		done(channels, err)
+	}()
+	return nil
+}
+
+// scanSource receives transport streams for a scan through the tuner's DVB
+// device.
+type scanSource struct {
+	t *Tuner
+}
+
+// scanTuningTimeout bounds how long the DVB device may try to lock onto each
+// frequency of a scan.
+const scanTuningTimeout = 3 * time.Second
+
+func (s scanSource) Open(_ context.Context, f scan.Frequency) (io.ReadCloser, error) {
+	channel := atsc.Channel{FrequencyHz: f.FrequencyHz, Modulation: f.Modulation}
+	description, err := s.t.createPipelineDescription("scan", channel, pipelineStreams{})
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline, err := gst.NewPipeline(description)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	pipeline.SetSink(sinkNameTransportStream, func(sample gst.Sample) {
+		pw.Write(sample.Data)
+	})
+	pipeline.SetMessageHandler(func(msg gst.Message) {
+		switch msg.Type {
+		case gst.MessageError:
+			pw.CloseWithError(fmt.Errorf("%w: %w", scan.ErrNoSignal, msg.Err))
+		case gst.MessageEOS:
+			pw.Close()
+		}
+	})
+
+	if err := pipeline.Start(); err != nil {
+		pipeline.Close()
+		return nil, fmt.Errorf("%w: %w", scan.ErrNoSignal, err)
+	}
+	return &pipelineReader{PipeReader: pr, pipeline: pipeline}, nil
+}
+
+// pipelineReader reads data from an appsink of a pipeline, and closes the
+// pipeline when it is closed.
+type pipelineReader struct {
+	*io.PipeReader
+	pipeline  *gst.Pipeline
+	closeOnce sync.Once
+}
+
+func (r *pipelineReader) Close() error {
+	// Closing the reader first unblocks the appsink if it's waiting on a write,
+	// so the pipeline can stop.
+	r.PipeReader.Close()
+	r.closeOnce.Do(func() { r.pipeline.Close() })
+	return nil
+}
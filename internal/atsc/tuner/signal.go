@@ -0,0 +1,129 @@
+package tuner
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/featherbread/hypcast/internal/gst"
+	"github.com/featherbread/hypcast/internal/watch"
+)
+
+// Signal represents the statistics that the tuner's DVB frontend reports about
+// the signal it receives.
+//
+// Strength and SNR are reported on a scale that depends on the DVB driver.
+// Most drivers use the full range of a 16-bit integer, where higher is better,
+// but the values are best compared with each other rather than with any
+// absolute threshold.
+type Signal struct {
+	Time time.Time
+	// Locked reports whether the frontend has locked onto the signal, and can
+	// deliver a transport stream.
+	Locked   bool
+	Strength int
+	SNR      int
+	// BER is the bit error rate of the signal before error correction, and UNC
+	// counts the blocks that error correction could not repair.
+	BER int
+	UNC int
+}
+
+// signalStructureName is the name of the element messages that dvbsrc posts
+// with its frontend statistics.
+const signalStructureName = "dvb-frontend-stats"
+
+// parseSignal parses the frontend statistics in an element message from
+// dvbsrc.
+func parseSignal(s *gst.Structure, now time.Time) (Signal, bool) {
+	if s == nil || s.Name != signalStructureName {
+		return Signal{}, false
+	}
+	locked, _ := s.Fields["lock"].(bool)
+	strength, _ := s.Fields["signal"].(int)
+	snr, _ := s.Fields["snr"].(int)
+	ber, _ := s.Fields["ber"].(int)
+	unc, _ := s.Fields["unc"].(int)
+	return Signal{
+		Time:     now,
+		Locked:   locked,
+		Strength: strength,
+		SNR:      snr,
+		BER:      ber,
+		UNC:      unc,
+	}, true
+}
+
+const (
+	// signalHistoryInterval is the minimum spacing between the samples that the
+	// signal history keeps, as dvbsrc reports its statistics several times per
+	// second.
+	signalHistoryInterval = time.Second
+	// signalHistoryLength bounds the number of samples in the signal history.
+	signalHistoryLength = 300
+)
+
+// signalMonitor keeps the latest signal statistics of a tuner, along with a
+// short history of them.
+//
+// It is updated from pipeline message handlers, which must not wait on the
+// tuner's lock, and so has a lock of its own.
+type signalMonitor struct {
+	value *watch.Value[Signal]
+
+	mu      sync.Mutex
+	history []Signal
+}
+
+func newSignalMonitor() *signalMonitor {
+	return &signalMonitor{value: watch.NewValue(Signal{})}
+}
+
+// Record makes s the latest signal sample, and adds it to the history unless
+// the history has a sample from less than signalHistoryInterval before it.
+func (sm *signalMonitor) Record(s Signal) {
+	sm.value.Set(s)
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if n := len(sm.history); n > 0 && s.Time.Sub(sm.history[n-1].Time) < signalHistoryInterval {
+		return
+	}
+	if len(sm.history) == signalHistoryLength {
+		sm.history = slices.Delete(sm.history, 0, 1)
+	}
+	sm.history = append(sm.history, s)
+}
+
+// Clear resets the latest sample when the tuner stops receiving a signal. The
+// history is kept, so that it still shows what led up to the loss.
+func (sm *signalMonitor) Clear() {
+	sm.value.Set(Signal{})
+}
+
+// History returns a copy of the signal history, from oldest to newest.
+func (sm *signalMonitor) History() []Signal {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return slices.Clone(sm.history)
+}
+
+// Signal returns the latest statistics that the tuner's DVB frontend reported,
+// or the zero value if the tuner isn't receiving a live signal.
+func (t *Tuner) Signal() Signal {
+	return t.signal.value.Get()
+}
+
+// WatchSignal sets up a handler function to continuously receive the signal
+// statistics of the tuner as the DVB frontend reports them. See the watch
+// package documentation for details.
+func (t *Tuner) WatchSignal(handler func(Signal)) watch.Watch {
+	return t.signal.value.Watch(handler)
+}
+
+// SignalHistory returns the signal statistics that the tuner recorded over the
+// last few minutes of receiving live signals, from oldest to newest, with no
+// more than one sample per second.
+func (t *Tuner) SignalHistory() []Signal {
+	return t.signal.History()
+}
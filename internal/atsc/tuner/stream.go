@@ -0,0 +1,402 @@
+package tuner
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/featherbread/hypcast/internal/atsc"
+	"github.com/featherbread/hypcast/internal/atsc/mpegts"
+	"github.com/featherbread/hypcast/internal/gst"
+)
+
+// StreamFormat selects how a Stream carries its program.
+type StreamFormat string
+
+const (
+	// StreamFormatRaw carries the program's packets from the multiplex
+	// untouched, in the source's video and audio formats.
+	StreamFormatRaw StreamFormat = "raw"
+
+	// StreamFormatTranscode remuxes the program with H.264 video and AAC audio,
+	// which more players can decode than broadcast MPEG-2 video and AC-3 audio.
+	StreamFormatTranscode StreamFormat = "transcode"
+)
+
+// ErrStreamFormatUnknown is returned when opening a stream in a format other
+// than the StreamFormat constants.
+var ErrStreamFormatUnknown error = errors.New("unknown stream format")
+
+// streamBufferSamples is how many samples a Stream holds for a client that
+// reads slower than the tuner streams. A client that falls further behind
+// than that would miss samples, which breaks the transport stream in ways
+// that players don't always recover from, so its stream ends with
+// ErrStreamBehind instead.
+const streamBufferSamples = 512
+
+// ErrStreamBehind ends a Stream whose client fell too far behind the tuner.
+var ErrStreamBehind error = errors.New("stream client fell behind")
+
+// streamKey identifies the branch of a multiplex that streams a program in a
+// format.
+type streamKey struct {
+	programID uint
+	format    StreamFormat
+}
+
+// programStream represents the branch of a multiplex that streams a program in
+// a single format, to every Stream open on it.
+type programStream struct {
+	key     streamKey
+	channel atsc.Channel
+
+	// branch is the branch for the stream, or nil while a transcoded stream
+	// waits for its program's map table, like a program does.
+	branch  *gst.Branch
+	pending *time.Timer
+
+	// mu protects readers, which the tuner changes while the branch writes to
+	// them.
+	mu      sync.Mutex
+	readers map[*Stream]struct{}
+}
+
+func (m *multiplex) streamForBranch(name string) *programStream {
+	if name == "" {
+		return nil
+	}
+	for _, s := range m.streams {
+		if s.branch != nil && s.branch.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// write passes a sample from the branch on to every reader of s. Rather than
+// wait on a reader that has fallen behind, it ends that reader with
+// ErrStreamBehind, and stops writing to it.
+func (s *programStream) write(sample gst.Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for r := range s.readers {
+		select {
+		case r.samples <- sample.Data:
+		default:
+			delete(s.readers, r)
+			r.end(ErrStreamBehind)
+		}
+	}
+}
+
+// end ends every reader of s with err.
+func (s *programStream) end(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for r := range s.readers {
+		r.end(err)
+	}
+}
+
+// Stream represents a client reading a program that the tuner streams as an
+// MPEG transport stream of its own.
+type Stream struct {
+	tuner  *Tuner
+	source *programStream
+
+	samples chan []byte
+	buf     []byte
+
+	endOnce sync.Once
+	ended   chan struct{}
+	err     error
+
+	closeOnce sync.Once
+}
+
+// OpenStream starts streaming the named channel to a client as an MPEG
+// transport stream in the provided format.
+//
+// When the tuner is stopped, OpenStream starts it on the channel's multiplex
+// without giving it a current channel, so the tuner's own clients receive
+// nothing. The tuner stops again once the last of its streams closes, unless
+// it is tuned in the meantime. Otherwise, the channel must be carried on the
+// same multiplex as the tuner's current channel, which OpenStream leaves
+// undisturbed.
+//
+// All streams of the same channel in the same format share a single branch,
+// which ends when the last stream is closed or when the tuner leaves the
+// multiplex.
+func (t *Tuner) OpenStream(channelName string, format StreamFormat) (*Stream, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	channel, ok := t.lookupChannel(channelName)
+	if !ok {
+		return nil, ErrChannelNotFound
+	}
+	return t.openStream(channel, format)
+}
+
+func (t *Tuner) openStream(channel atsc.Channel, format StreamFormat) (*Stream, error) {
+	if format != StreamFormatRaw && format != StreamFormatTranscode {
+		return nil, ErrStreamFormatUnknown
+	}
+
+	if t.mux == nil {
+		if err := t.tuneMultiplex(channel); err != nil {
+			return nil, err
+		}
+		t.streamsTuned = true
+	} else if t.mux.key != multiplexOf(channel) {
+		return nil, ErrChannelNotOnMultiplex
+	}
+
+	s, err := t.acquireStream(channel, format)
+	if err != nil {
+		t.stopIfUnstreamed()
+		return nil, err
+	}
+
+	st := &Stream{
+		tuner:   t,
+		source:  s,
+		samples: make(chan []byte, streamBufferSamples),
+		ended:   make(chan struct{}),
+	}
+	s.mu.Lock()
+	s.readers[st] = struct{}{}
+	s.mu.Unlock()
+	t.streams++
+	t.stopIdleTimer()
+	return st, nil
+}
+
+// tuneMultiplex starts receiving the multiplex that carries channel for the
+// tuner's streams, without starting the channel's program for the tuner's own
+// clients.
+func (t *Tuner) tuneMultiplex(channel atsc.Channel) (err error) {
+	if t.cancelScan != nil {
+		return ErrTunerBusy
+	}
+	t.stopAnySweep()
+
+	t.mux, err = t.startMultiplex(channel)
+	if err != nil {
+		t.status.Set(Status{Error: err})
+		return err
+	}
+	t.status.Set(Status{State: StatePlaying, ChannelName: channel.Name})
+	return nil
+}
+
+// openStreamIfAvailable opens a stream of channel in format if the tuner's
+// current multiplex carries the channel, or if tuneStopped is set and the
+// tuner is stopped, and reports whether it could. Checking and opening under
+// one lock keeps a client that tunes the tuner directly from claiming it at
+// the same time.
+func (t *Tuner) openStreamIfAvailable(channel atsc.Channel, format StreamFormat, tuneStopped bool) (st *Stream, available bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	receives := t.mux != nil && t.mux.key == multiplexOf(channel)
+	stopped := t.mux == nil && t.cancelScan == nil
+	if !receives && !(tuneStopped && stopped) {
+		return nil, false, nil
+	}
+	st, err = t.openStream(channel, format)
+	return st, true, err
+}
+
+// stopIfUnstreamed stops the tuner if it was tuned on behalf of streams that
+// have all closed.
+func (t *Tuner) stopIfUnstreamed() {
+	if t.streams == 0 && t.streamsTuned {
+		t.log.Info("Stopping tuner after its last stream closed")
+		t.stop()
+	}
+}
+
+// acquireStream returns the branch that streams channel in format on the
+// current multiplex, starting a new branch if necessary.
+func (t *Tuner) acquireStream(channel atsc.Channel, format StreamFormat) (*programStream, error) {
+	key := streamKey{programID: channel.ProgramID, format: format}
+	if s, ok := t.mux.streams[key]; ok {
+		return s, nil
+	}
+
+	s := &programStream{
+		key:     key,
+		channel: channel,
+		readers: make(map[*Stream]struct{}),
+	}
+	pm, ok := t.mux.programMaps[channel.ProgramID]
+	switch {
+	case format == StreamFormatRaw:
+		// The raw stream passes along whatever the program carries.
+		if err := t.startStream(s, programMap{}); err != nil {
+			return nil, err
+		}
+	case ok:
+		if err := t.startStream(s, pm); err != nil {
+			return nil, err
+		}
+	default:
+		s.pending = time.AfterFunc(programMapTimeout, func() { t.startStreamWithoutMap(s) })
+	}
+	t.mux.streams[key] = s
+	return s, nil
+}
+
+// startStream starts the branch for s. A transcoded stream decodes the streams
+// in pm, which the raw stream doesn't need.
+func (t *Tuner) startStream(s *programStream, pm programMap) error {
+	var streams pipelineStreams
+	if s.key.format == StreamFormatTranscode {
+		codec, ok := videoCodecs[pm.Video.StreamType]
+		if !ok {
+			return ErrVideoUnsupported
+		}
+		streams = pipelineStreams{
+			VideoPID:          pm.Video.PID,
+			VideoParser:       codec.Parser,
+			VideoDecoder:      codec.Decoder,
+			VAAPIVideoDecoder: codec.VAAPIDecoder,
+			VideoPassthrough:  pm.Video.StreamType == mpegts.StreamTypeH264Video,
+		}
+		if track, ok := initialAudioTrack(s.channel, pm.AudioTracks); ok {
+			streams.AudioPID, streams.AudioDecoder = track.PID, audioDecoders[track.StreamType]
+		}
+	}
+
+	description, err := t.createPipelineDescription("stream-"+string(s.key.format), s.channel, streams)
+	if err != nil {
+		return err
+	}
+
+	branchName := fmt.Sprintf("stream-%s-%d", s.key.format, s.key.programID)
+	branch, err := t.mux.pipeline.NewBranch(branchName, description)
+	if err != nil {
+		return err
+	}
+	branch.SetSink(sinkNameStream, s.write)
+	if err := branch.Link(teeNameMultiplex); err != nil {
+		branch.Close()
+		return err
+	}
+
+	s.branch = branch
+	t.log.Info("Started stream", "channel", s.channel.Name, "program", s.key.programID, "format", s.key.format)
+	return nil
+}
+
+// startPendingStreams starts the streams of a program that were waiting for
+// its map table.
+func (t *Tuner) startPendingStreams(programID uint, pm programMap) {
+	for _, s := range t.mux.streams {
+		if s.key.programID != programID || s.branch != nil {
+			continue
+		}
+		s.pending.Stop()
+		if err := t.startStream(s, pm); err != nil {
+			t.log.Error("Failed to start stream", "channel", s.channel.Name, "format", s.key.format, "error", err)
+			t.removeStream(s, err)
+		}
+	}
+}
+
+// startStreamWithoutMap starts s with the streams in its channel definition,
+// if it's still waiting for its program's map table.
+func (t *Tuner) startStreamWithoutMap(s *programStream) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.hasStream(s) || s.branch != nil {
+		return
+	}
+	if err := t.startStream(s, defaultProgramMap(s.channel)); err != nil {
+		t.log.Error("Failed to start stream", "channel", s.channel.Name, "format", s.key.format, "error", err)
+		t.removeStream(s, err)
+	}
+}
+
+// hasStream reports whether s remains active on the tuner's current multiplex.
+func (t *Tuner) hasStream(s *programStream) bool {
+	return t.mux != nil && t.mux.streams[s.key] == s
+}
+
+// removeStream stops the branch for s, and ends its readers with err, or with
+// io.EOF if err is nil.
+func (t *Tuner) removeStream(s *programStream, err error) {
+	var closeErr error
+	if s.pending != nil {
+		s.pending.Stop()
+	}
+	if s.branch != nil {
+		closeErr = s.branch.Close()
+	}
+	delete(t.mux.streams, s.key)
+	if err == nil {
+		s.end(io.EOF)
+	} else {
+		s.end(err)
+	}
+	t.log.Info("Stopped stream", "channel", s.channel.Name, "format", s.key.format, "error", errors.Join(err, closeErr))
+}
+
+// Tuner returns the tuner that the stream comes from.
+func (st *Stream) Tuner() *Tuner {
+	return st.tuner
+}
+
+// Read reads the next part of the transport stream, waiting for the tuner to
+// stream more if necessary. Once the stream ends, Read returns io.EOF, or the
+// error that ended it.
+func (st *Stream) Read(p []byte) (int, error) {
+	for len(st.buf) == 0 {
+		select {
+		case st.buf = <-st.samples:
+		case <-st.ended:
+			return 0, st.err
+		}
+	}
+	n := copy(p, st.buf)
+	st.buf = st.buf[n:]
+	return n, nil
+}
+
+func (st *Stream) end(err error) {
+	st.endOnce.Do(func() {
+		st.err = err
+		close(st.ended)
+	})
+}
+
+// Close ends the stream, stopping its branch if no other stream is reading it,
+// and stopping the tuner if the tuner was tuned for its streams and this was
+// the last of them. Any blocked Read returns io.ErrClosedPipe.
+func (st *Stream) Close() error {
+	st.closeOnce.Do(func() {
+		t := st.tuner
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		s := st.source
+		s.mu.Lock()
+		delete(s.readers, st)
+		unread := len(s.readers) == 0
+		s.mu.Unlock()
+		st.end(io.ErrClosedPipe)
+
+		// The stream might have ended without us, along with its multiplex.
+		if unread && t.hasStream(s) {
+			t.removeStream(s, nil)
+		}
+		t.streams--
+		t.stopIfUnstreamed()
+		t.startIdleTimer()
+	})
+	return nil
+}
@@ -0,0 +1,39 @@
+package tuner
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/featherbread/hypcast/internal/gst"
+)
+
+func TestProgramStreamWriteBehind(t *testing.T) {
+	newStream := func() *Stream {
+		return &Stream{samples: make(chan []byte, 1), ended: make(chan struct{})}
+	}
+	var (
+		fast = newStream()
+		slow = newStream()
+		s    = &programStream{readers: map[*Stream]struct{}{fast: {}, slow: {}}}
+	)
+
+	s.write(gst.Sample{Data: []byte("one")})
+	if got, err := io.ReadAll(io.LimitReader(fast, 3)); string(got) != "one" || err != nil {
+		t.Fatalf("fast reader read %q, %v; want %q", got, err, "one")
+	}
+	s.write(gst.Sample{Data: []byte("two")})
+
+	if _, ok := s.readers[slow]; ok {
+		t.Error("slow reader still receives samples after falling behind")
+	}
+	<-slow.ended
+	if !errors.Is(slow.err, ErrStreamBehind) {
+		t.Errorf("slow reader ended with %v; want %v", slow.err, ErrStreamBehind)
+	}
+	select {
+	case <-fast.ended:
+		t.Errorf("fast reader ended with %v", fast.err)
+	default:
+	}
+}
@@ -0,0 +1,102 @@
+package tuner
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/featherbread/hypcast/internal/gst"
+)
+
+// rtpTimeline maps the presentation times of the samples that a client
+// receives onto the RTP timestamps of the client's stream.
+//
+// The timeline synchronizes on the first live sample that it sees, and maps
+// the presentation time of every later sample relative to that one. It
+// synchronizes again after a sample without a presentation time, after
+// samples that it can't place, like replayed ones, and whenever decode times
+// run backward, as when the tuner starts a new pipeline. Each new
+// synchronization continues from the end of the latest sample to present, so
+// the client's stream stays continuous.
+//
+// Video with B-frames arrives in decode order, so presentation times can run
+// backward from one sample to the next without any break in the stream.
+type rtpTimeline struct {
+	clockRate int64
+
+	// next is the RTP timestamp right after the end of the sample that
+	// presents last.
+	next uint32
+
+	// synced is set while origin, the presentation time of the sample that
+	// the timeline last synchronized on, maps onto originRTP.
+	synced    bool
+	origin    time.Duration
+	originRTP uint32
+
+	// lastDTS is the decode time of the latest sample, or ClockTimeNone if it
+	// had none, and latestPTS is the latest presentation time since the
+	// timeline synchronized.
+	lastDTS   time.Duration
+	latestPTS time.Duration
+}
+
+// maxReorder is how far a sample without a decode time can present before the
+// latest sample, as a reordered B-frame does, without the timeline taking it
+// for the start of a new pipeline.
+const maxReorder = time.Second
+
+func newRTPTimeline(clockRate uint32) rtpTimeline {
+	return rtpTimeline{clockRate: int64(clockRate), next: rand.Uint32()}
+}
+
+// timestamp returns the RTP timestamp of a live sample.
+func (tl *rtpTimeline) timestamp(sample gst.Sample) uint32 {
+	if sample.PTS == gst.ClockTimeNone {
+		return tl.advance(sample.Duration)
+	}
+
+	if !tl.synced || tl.restarted(sample) {
+		tl.synced, tl.origin, tl.originRTP = true, sample.PTS, tl.next
+		tl.latestPTS = sample.PTS
+	}
+	tl.lastDTS = sample.DTS
+	tl.latestPTS = max(tl.latestPTS, sample.PTS)
+
+	ts := tl.originRTP + tl.ticks(sample.PTS-tl.origin)
+	tl.extend(ts + tl.ticks(sample.Duration))
+	return ts
+}
+
+// restarted returns whether a sample follows a break in the decode times of
+// the samples that the timeline synchronized on. Without decode times, only a
+// presentation time well before the latest one counts as a break.
+func (tl *rtpTimeline) restarted(sample gst.Sample) bool {
+	if sample.DTS != gst.ClockTimeNone && tl.lastDTS != gst.ClockTimeNone {
+		return sample.DTS < tl.lastDTS
+	}
+	return sample.PTS < tl.latestPTS-maxReorder
+}
+
+// extend moves next up to end, unless an earlier sample already presents past
+// end. Timestamps wrap around, so the comparison works on their difference.
+func (tl *rtpTimeline) extend(end uint32) {
+	if int32(end-tl.next) > 0 {
+		tl.next = end
+	}
+}
+
+// advance returns the RTP timestamp right after the end of the latest sample,
+// for a sample of duration d that the timeline can't place.
+func (tl *rtpTimeline) advance(d time.Duration) uint32 {
+	ts := tl.next
+	tl.next += tl.ticks(d)
+	tl.synced = false
+	return ts
+}
+
+// ticks converts d to units of the RTP clock. Timestamps wrap around, so a
+// negative d works out the same as a positive one.
+func (tl *rtpTimeline) ticks(d time.Duration) uint32 {
+	sec, frac := int64(d/time.Second), int64(d%time.Second)
+	return uint32(sec*tl.clockRate + frac*tl.clockRate/int64(time.Second))
+}
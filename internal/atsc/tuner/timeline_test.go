@@ -0,0 +1,147 @@
+package tuner
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/featherbread/hypcast/internal/gst"
+)
+
+func TestRTPTimeline(t *testing.T) {
+	const (
+		ms    = time.Millisecond
+		frame = 40 * ms // 3600 ticks of the 90 kHz clock.
+		none  = gst.ClockTimeNone
+	)
+
+	// Each step is a sample that the timeline places, unless resync is set to
+	// switch the timeline to a new source as a sampleTrack does.
+	type step struct {
+		pts, dts time.Duration
+		resync   bool
+	}
+	testCases := []struct {
+		name  string
+		start uint32
+		steps []step
+		// want gives the RTP timestamp of each sample relative to start.
+		want []uint32
+	}{
+		{
+			name:  "in order",
+			steps: []step{{pts: 1000 * ms, dts: none}, {pts: 1040 * ms, dts: none}, {pts: 1080 * ms, dts: none}},
+			want:  []uint32{0, 3600, 7200},
+		},
+		{
+			name: "B-frames with DTS",
+			steps: []step{
+				{pts: 1000 * ms, dts: 920 * ms},
+				{pts: 1120 * ms, dts: 960 * ms},
+				{pts: 1040 * ms, dts: 1000 * ms},
+				{pts: 1080 * ms, dts: 1040 * ms},
+				{pts: 1240 * ms, dts: 1080 * ms},
+			},
+			want: []uint32{0, 10800, 3600, 7200, 21600},
+		},
+		{
+			name: "B-frames without DTS",
+			steps: []step{
+				{pts: 1000 * ms, dts: none},
+				{pts: 1120 * ms, dts: none},
+				{pts: 1040 * ms, dts: none},
+				{pts: 1080 * ms, dts: none},
+			},
+			want: []uint32{0, 10800, 3600, 7200},
+		},
+		{
+			name: "missing PTS after B-frame",
+			steps: []step{
+				{pts: 1000 * ms, dts: 960 * ms},
+				{pts: 1080 * ms, dts: 1000 * ms},
+				{pts: 1040 * ms, dts: 1040 * ms},
+				{pts: none, dts: none},
+				{pts: 5000 * ms, dts: 5000 * ms},
+			},
+			// The timeline continues after the P-frame, which presents last.
+			want: []uint32{0, 7200, 3600, 10800, 14400},
+		},
+		{
+			name:  "missing PTS",
+			steps: []step{{pts: 1000 * ms, dts: none}, {pts: none, dts: none}, {pts: 5000 * ms, dts: none}, {pts: 5040 * ms, dts: none}},
+			want:  []uint32{0, 3600, 7200, 10800},
+		},
+		{
+			name: "DTS runs backward",
+			steps: []step{
+				{pts: 1000 * ms, dts: 1000 * ms},
+				{pts: 1040 * ms, dts: 1040 * ms},
+				{pts: 200 * ms, dts: 200 * ms},
+				{pts: 240 * ms, dts: 240 * ms},
+			},
+			want: []uint32{0, 3600, 7200, 10800},
+		},
+		{
+			name: "PTS runs far backward without DTS",
+			steps: []step{
+				{pts: 5000 * ms, dts: none},
+				{pts: 5040 * ms, dts: none},
+				{pts: 100 * ms, dts: none},
+				{pts: 140 * ms, dts: none},
+			},
+			want: []uint32{0, 3600, 7200, 10800},
+		},
+		{
+			name: "gap after drops",
+			steps: []step{
+				{pts: 1000 * ms, dts: 1000 * ms},
+				{pts: 1040 * ms, dts: 1040 * ms},
+				{pts: 1400 * ms, dts: 1400 * ms},
+				{pts: none, dts: none},
+			},
+			want: []uint32{0, 3600, 36000, 39600},
+		},
+		{
+			name: "source switch",
+			steps: []step{
+				{pts: 1000 * ms, dts: 1000 * ms},
+				{pts: 1040 * ms, dts: 1040 * ms},
+				{resync: true},
+				{pts: 90 * time.Second, dts: 90 * time.Second},
+				{pts: 90*time.Second + frame, dts: 90*time.Second + frame},
+			},
+			want: []uint32{0, 3600, 7200, 10800},
+		},
+		{
+			name:  "wraparound",
+			start: math.MaxUint32 - 3599,
+			steps: []step{
+				{pts: 1000 * ms, dts: 960 * ms},
+				{pts: 1080 * ms, dts: 1000 * ms},
+				{pts: 1040 * ms, dts: 1040 * ms},
+				{pts: none, dts: none},
+				{pts: 20 * ms, dts: 20 * ms},
+			},
+			want: []uint32{0, 7200, 3600, 10800, 14400},
+		},
+	}
+	for _, tc := range testCases {
+		tl := newRTPTimeline(90000)
+		tl.next = tc.start
+
+		var got []uint32
+		for _, s := range tc.steps {
+			if s.resync {
+				tl.synced = false
+				continue
+			}
+			sample := gst.Sample{PTS: s.pts, DTS: s.dts, Duration: frame}
+			got = append(got, tl.timestamp(sample)-tc.start)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%s: unexpected timestamps (-want +got):\n%s", tc.name, diff)
+		}
+	}
+}
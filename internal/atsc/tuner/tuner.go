@@ -3,19 +3,24 @@
 package tuner
 
 import (
+	"cmp"
+	"context"
 	"errors"
 	"fmt"
 	"iter"
 	"log/slog"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"text/template"
 	"time"
 
 	"github.com/pion/webrtc/v4"
-	"github.com/pion/webrtc/v4/pkg/media"
 
 	"github.com/featherbread/hypcast/internal/atsc"
+	"github.com/featherbread/hypcast/internal/atsc/caption"
+	"github.com/featherbread/hypcast/internal/atsc/guide"
 	"github.com/featherbread/hypcast/internal/gst"
 	"github.com/featherbread/hypcast/internal/watch"
 )
@@ -32,6 +37,9 @@ const (
 	// StatePlaying means that the tuner is locked onto a singal and is actively
 	// streaming video.
 	StatePlaying
+	// StateScanning means that the tuner is searching for channels, and can't
+	// stream video until the scan is complete.
+	StateScanning
 )
 
 // Status represents the public state of the tuner for reading by clients.
@@ -39,13 +47,25 @@ type Status struct {
 	State       State
 	ChannelName string
 	Error       error
+
+	// AudioTracks lists the audio tracks of the current channel, once the
+	// tuner receives the channel's program map table, and AudioPID identifies
+	// the track that the tuner streams.
+	AudioTracks []AudioTrack
+	AudioPID    uint
 }
 
 // Tracks represents the current set of video and audio tracks for use by WebRTC
-// clients.
+// clients, along with the closed captions that accompany the video.
 type Tracks struct {
-	Video webrtc.TrackLocal
+	// Video holds a track for each layer of the video, from the highest quality
+	// to the lowest. Video that the tuner passes through from the source has a
+	// single layer.
+	Video []VideoTrack
 	Audio webrtc.TrackLocal
+	// AudioLoss tunes the audio for the packet loss that its clients report.
+	AudioLoss *LossControl
+	Captions  *caption.Stream
 }
 
 // VideoPipeline controls which pipeline Hypcast uses to process video.
@@ -84,31 +104,124 @@ func ParseVideoPipeline(name string) VideoPipeline {
 	}
 }
 
+// Device identifies the DVB frontend that a Tuner uses to receive live
+// signals.
+type Device struct {
+	Adapter  uint
+	Frontend uint
+}
+
+// ParseDevice parses a Device from its string representation, as returned by
+// [Device.String].
+func ParseDevice(s string) (Device, error) {
+	adapter, frontend, hasFrontend := strings.Cut(s, ".")
+
+	a, err := strconv.ParseUint(adapter, 10, 0)
+	if err != nil {
+		return Device{}, fmt.Errorf("invalid DVB adapter in %q", s)
+	}
+	if !hasFrontend {
+		return Device{Adapter: uint(a)}, nil
+	}
+
+	f, err := strconv.ParseUint(frontend, 10, 0)
+	if err != nil {
+		return Device{}, fmt.Errorf("invalid DVB frontend in %q", s)
+	}
+	return Device{Adapter: uint(a), Frontend: uint(f)}, nil
+}
+
+// String returns the representation of d as "ADAPTER.FRONTEND", or as
+// "ADAPTER" for the first frontend of the adapter.
+func (d Device) String() string {
+	if d.Frontend == 0 {
+		return strconv.FormatUint(uint64(d.Adapter), 10)
+	}
+	return fmt.Sprintf("%d.%d", d.Adapter, d.Frontend)
+}
+
 // Tuner represents an ATSC tuner whose video and audio signals are encoded for
 // use by WebRTC clients, and whose consumers are notified of ongoing state
 // changes.
 type Tuner struct {
 	mu sync.Mutex
 
+	device Device
+	log    *slog.Logger
+
 	channels   []atsc.Channel
 	channelMap map[string]atsc.Channel
 
 	videoPipeline VideoPipeline
-	pipeline      *gst.Pipeline
+	videoLayers   []VideoLayer
+
+	// mux is the pipeline for the multiplex that carries the tuner's current
+	// channel, and current is the program for that channel. The tuner holds a
+	// reference to current for as long as it's tuned to the channel.
+	mux     *multiplex
+	current *program
+
+	// cancelScan is set while the tuner is scanning for channels, and sweep is
+	// set while it is sweeping multiplexes for the program guide.
+	cancelScan context.CancelFunc
+	sweep      *sweep
+
+	guide *guide.Guide
 
 	status *watch.Value[Status]
 	tracks *watch.Value[Tracks]
+	signal *signalMonitor
+
+	// outputs carries the current program to the tuner's clients, through
+	// tracks that outlive any one program.
+	outputs *outputTracks
+
+	// streams counts the open Streams of the tuner. streamsTuned is set while
+	// the tuner receives a multiplex on behalf of its streams alone, with no
+	// current program, and the last of them to close stops the tuner.
+	streams      int
+	streamsTuned bool
+
+	// pooled is set while the tuner plays a channel that the pool allocated it
+	// for, which lets it stop once idle. holders counts the clients watching
+	// the tuner, and idle is set while the tuner counts down to stopping
+	// because nothing holds it.
+	pooled  bool
+	holders int
+	idle    *time.Timer
 }
 
-// NewTuner creates a new Tuner that can tune to any of the provided channels.
-func NewTuner(channels []atsc.Channel, videoPipeline VideoPipeline) *Tuner {
-	return &Tuner{
+// NewTuner creates a new Tuner that receives live signals through the provided
+// DVB device, and that can tune to any of the provided channels.
+//
+// The tuner encodes each of the provided video layers, which must be ordered
+// from the highest quality to the lowest as [ParseVideoLayers] returns them.
+// With no layers, or with the low-power video pipeline, the tuner encodes
+// VideoLayerHigh alone.
+func NewTuner(device Device, channels []atsc.Channel, videoPipeline VideoPipeline, videoLayers []VideoLayer) *Tuner {
+	if len(videoLayers) == 0 || videoPipeline == VideoPipelineLowPower {
+		videoLayers = []VideoLayer{VideoLayerHigh}
+	}
+	t := &Tuner{
+		device:        device,
+		log:           slog.With("tuner", device.String()),
 		channels:      channels,
 		channelMap:    makeChannelMap(channels),
 		videoPipeline: videoPipeline,
+		videoLayers:   videoLayers,
+		guide:         guide.New(),
 		status:        watch.NewValue(Status{}),
 		tracks:        watch.NewValue(Tracks{}),
+		signal:        newSignalMonitor(),
 	}
+	t.outputs = newOutputTracks(t.streamID())
+	return t
+}
+
+// ID returns the string representation of the tuner's device, which uniquely
+// identifies the tuner among all tuners on the system.
+func (t *Tuner) ID() string {
+	return t.device.String()
 }
 
 func makeChannelMap(channels []atsc.Channel) map[string]atsc.Channel {
@@ -119,16 +232,22 @@ func makeChannelMap(channels []atsc.Channel) map[string]atsc.Channel {
 	return m
 }
 
-// ChannelNames returns an iterator over the names of channels that may be
-// passed to [Tuner.Tune].
-func (t *Tuner) ChannelNames() iter.Seq[string] {
-	return func(yield func(string) bool) {
-		for _, ch := range t.channels {
-			if !yield(ch.Name) {
-				break
-			}
-		}
-	}
+// Channels returns an iterator over the channels that the tuner can tune to, in
+// the order that they were provided to [NewTuner]. The name or the virtual
+// channel number of any of these channels may be passed to [Tuner.Tune].
+func (t *Tuner) Channels() iter.Seq[atsc.Channel] {
+	return slices.Values(t.channels)
+}
+
+// Channel returns the channel with the provided name, or with the provided
+// virtual channel number if no channel has that name.
+func (t *Tuner) Channel(name string) (atsc.Channel, bool) {
+	return t.lookupChannel(name)
+}
+
+// Status returns the current status of the tuner.
+func (t *Tuner) Status() Status {
+	return t.status.Get()
 }
 
 // WatchStatus sets up a handler function to continuously receive the status of
@@ -138,37 +257,65 @@ func (t *Tuner) WatchStatus(handler func(Status)) watch.Watch {
 }
 
 // WatchTracks sets up a handler function to continuously receive the tuner's
-// WebRTC tracks as they are updated. See the watch package documentation for
-// details.
+// WebRTC tracks as they are updated. The tracks stay the same when the tuner
+// changes channels, so clients can keep them and receive the new channel
+// without renegotiating. See the watch package documentation for details.
 func (t *Tuner) WatchTracks(handler func(Tracks)) watch.Watch {
 	return t.tracks.Watch(handler)
 }
 
-// Stop ends any active stream and releases the DVB device associated with this
-// tuner.
+// Stop ends any active stream or scan and releases the DVB device associated
+// with this tuner. Any programs that viewers are watching alongside the
+// tuner's channel end along with it.
 func (t *Tuner) Stop() error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	return t.stop()
+}
 
-	err := t.destroyAnyRunningPipeline()
+func (t *Tuner) stop() error {
+	if t.cancelScan != nil {
+		t.cancelScan()
+	}
+	t.stopAnySweep()
+
+	err := t.destroyAnyRunningMultiplex()
 	t.status.Set(Status{Error: err})
-	t.tracks.Set(Tracks{})
+	t.publishTracks()
 	return err
 }
 
-// ErrChannelNotFound is returned when tuning to a channel whose name is not in
-// the tuner's channel list.
+// ErrChannelNotFound is returned when tuning to a channel whose name or number
+// is not in the tuner's channel list.
 var ErrChannelNotFound error = errors.New("channel not found")
 
-// Tune attempts to start a stream for the named channel.
-func (t *Tuner) Tune(channelName string) (err error) {
+// Tune attempts to start a stream for the channel with the provided name, or
+// with the provided virtual channel number (such as "9.1") if no channel has
+// that name.
+//
+// When the channel is on the same multiplex as the tuner's current channel,
+// Tune switches to it without interrupting the tuner's signal, and without
+// disturbing any viewers of other channels on the multiplex.
+func (t *Tuner) Tune(channelName string) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	channel, ok := t.channelMap[channelName]
+	channel, ok := t.lookupChannel(channelName)
 	if !ok {
 		return ErrChannelNotFound
 	}
+	// The tuner now stays on the channel after its streams close, and after
+	// its clients leave.
+	t.streamsTuned, t.pooled = false, false
+	t.stopIdleTimer()
+	return t.tune(channel)
+}
+
+func (t *Tuner) tune(channel atsc.Channel) (err error) {
+	if t.cancelScan != nil {
+		return ErrTunerBusy
+	}
+	t.stopAnySweep()
 
 	t.status.Set(Status{
 		State:       StateStarting,
@@ -177,62 +324,142 @@ func (t *Tuner) Tune(channelName string) (err error) {
 
 	defer func() {
 		if err != nil {
-			t.destroyAnyRunningPipeline()
+			t.destroyAnyRunningMultiplex()
 			t.status.Set(Status{Error: err})
+			t.publishTracks()
 		}
 	}()
 
-	t.destroyAnyRunningPipeline()
+	if t.mux != nil && t.mux.key == multiplexOf(channel) {
+		// The new channel is on the multiplex that the tuner is already
+		// receiving, so we can keep the source and its signal lock, and switch
+		// programs without waiting for the frontend to lock again.
+		if err := t.switchProgram(channel); err != nil {
+			return err
+		}
+		t.status.Set(t.currentStatus())
+		t.publishTracks()
+		return nil
+	}
+
+	t.destroyAnyRunningMultiplex()
 
-	t.pipeline, err = t.newPipeline(channel)
+	t.mux, err = t.startMultiplex(channel)
 	if err != nil {
 		return err
 	}
 
-	vt, at, err := t.createTrackPair()
+	t.current, err = t.acquireProgram(channel)
 	if err != nil {
 		return err
 	}
 
-	t.pipeline.SetSink(sinkNameVideo, createTrackSink(vt))
-	t.pipeline.SetSink(sinkNameAudio, createTrackSink(at))
+	t.status.Set(t.currentStatus())
+	t.publishTracks()
+	t.startIdleTimer()
+	return nil
+}
 
-	slog.Info("Starting transcode pipeline")
-	err = t.pipeline.Start()
-	if err != nil {
-		return err
+// currentStatus returns the status of the tuner while it streams its current
+// program, which is starting until the program map table shows the tuner how
+// to decode it.
+func (t *Tuner) currentStatus() Status {
+	p := t.current
+	if p.branch == nil {
+		return Status{State: StateStarting, ChannelName: p.channel.Name}
+	}
+	return Status{
+		State:       StatePlaying,
+		ChannelName: p.channel.Name,
+		AudioTracks: t.mux.programMaps[p.channel.ProgramID].AudioTracks,
+		AudioPID:    p.audioTrack.PID,
 	}
-	slog.Info("Started transcode pipeline")
+}
 
-	t.status.Set(Status{State: StatePlaying, ChannelName: channelName})
-	t.tracks.Set(Tracks{Video: vt, Audio: at})
-	return nil
+// lookupChannel returns the channel with the provided name or virtual channel
+// number, with any defaults needed to build its pipeline filled in. Names take
+// precedence over numbers, and the first channel in the list wins when several
+// share a number (as when a station is available over the air and on cable).
+func (t *Tuner) lookupChannel(name string) (atsc.Channel, bool) {
+	channel, ok := t.channelMap[name]
+	if !ok {
+		channel, ok = t.lookupChannelNumber(name)
+	}
+	if ok && channel.TestPattern != "" {
+		// The test pattern's transport stream is generated from the channel
+		// definition, which can't use the PAT's PID or program number.
+		channel.VideoPID = cmp.Or(channel.VideoPID, defaultTestPatternVideoPID)
+		channel.AudioPID = cmp.Or(channel.AudioPID, defaultTestPatternAudioPID)
+		channel.ProgramID = cmp.Or(channel.ProgramID, defaultTestPatternProgramID)
+	}
+	return channel, ok
 }
 
-func (t *Tuner) newPipeline(channel atsc.Channel) (*gst.Pipeline, error) {
-	description, err := t.createPipelineDescription(channel)
-	if err != nil {
-		return nil, err
+func (t *Tuner) lookupChannelNumber(number string) (atsc.Channel, bool) {
+	major, minor, ok := atsc.ParseNumber(number)
+	if !ok {
+		return atsc.Channel{}, false
 	}
-	return gst.NewPipeline(description)
+	i := slices.IndexFunc(t.channels, func(ch atsc.Channel) bool {
+		return ch.MajorNumber == major && ch.MinorNumber == minor
+	})
+	if i < 0 {
+		return atsc.Channel{}, false
+	}
+	return t.channels[i], true
+}
+
+// pipelineStreams selects the elementary streams that the program and audio
+// branches decode, along with the elements that decode them. Other pipelines
+// don't use it.
+type pipelineStreams struct {
+	VideoPID          uint
+	VideoParser       string
+	VideoDecoder      string
+	VAAPIVideoDecoder string
+	VideoPassthrough  bool
+	AudioPID          uint
+	AudioDecoder      string
+	// AudioPacketLoss and AudioFEC start the audio encoder tuned for the
+	// packet loss that the program's clients report.
+	AudioPacketLoss int
+	AudioFEC        bool
 }
 
-func (t *Tuner) createPipelineDescription(channel atsc.Channel) (string, error) {
+func (t *Tuner) createPipelineDescription(name string, channel atsc.Channel, streams pipelineStreams) (string, error) {
 	var buf strings.Builder
 
-	err := pipelineDescriptionTemplate.Execute(&buf, struct {
+	err := pipelineDescriptionTemplates.ExecuteTemplate(&buf, name, struct {
+		Adapter       uint
+		Frontend      uint
 		Modulation    string
 		FrequencyHz   uint
+		VideoPID      uint
+		AudioPID      uint
 		ProgramID     uint
+		File          string
+		TestPattern   string
 		VideoPipeline string
+		VideoLayers   []pipelineLayer
+		TuningTimeout int64
+		Streams       pipelineStreams
 	}{
+		Adapter:       t.device.Adapter,
+		Frontend:      t.device.Frontend,
 		Modulation:    pipelineModulations[channel.Modulation],
 		FrequencyHz:   channel.FrequencyHz,
+		VideoPID:      channel.VideoPID,
+		AudioPID:      channel.AudioPID,
 		ProgramID:     channel.ProgramID,
+		File:          channel.File,
+		TestPattern:   channel.TestPattern,
 		VideoPipeline: string(t.videoPipeline),
+		VideoLayers:   t.pipelineLayers(),
+		TuningTimeout: scanTuningTimeout.Nanoseconds(),
+		Streams:       streams,
 	})
 	if err != nil {
-		return "", fmt.Errorf("building pipeline template: %w", err)
+		return "", fmt.Errorf("building %s pipeline template: %w", name, err)
 	}
 
 	return buf.String(), nil
@@ -245,25 +472,130 @@ var pipelineModulations = map[atsc.Modulation]string{
 }
 
 const (
-	sinkNameVideo = "video"
-	sinkNameAudio = "audio"
+	teeNameMultiplex        = "mux"
+	sinkNamePrefixVideo     = "video-"
+	sinkNameAudio           = "audio"
+	sinkNameCaptions        = "captions"
+	sinkNameTransportStream = "ts"
+	sinkNameStream          = "stream"
+
+	elementNameFile        = "file"
+	elementNameTestPattern = "testpattern"
 )
 
-var pipelineDescriptionTemplate = template.Must(template.New("").Parse(`
-	dvbsrc delsys=atsc modulation={{.Modulation}} frequency={{.FrequencyHz}}
-	{{- block "queue-max-time" 2_500_000_000 }}
-	! queue leaky=downstream max-size-time={{.}} max-size-buffers=0 max-size-bytes=0
+const (
+	defaultTestPatternVideoPID  = 0x31
+	defaultTestPatternAudioPID  = 0x34
+	defaultTestPatternProgramID = 1
+)
+
+// pipelineDescriptionTemplates defines the "multiplex" pipeline, which receives
+// a full transport stream and feeds it to a tee, and the "program" and "audio"
+// branches, which demux and transcode the video and audio of a single program
+// from the tee for WebRTC clients, along with the "transport-stream" branch,
+// which delivers the full transport stream from the tee to Go, and the
+// "stream-raw" and "stream-transcode" branches, which deliver a single program
+// from the tee to Go as a transport stream of its own. It also defines the
+// "scan" pipeline, which delivers the raw transport stream for a frequency to
+// Go.
+//
+// The program branch also delivers the program's untranscoded video to Go for
+// caption decoding. Like the video sink, the captions sink syncs each picture
+// to the pipeline clock, so that captions reach clients in step with the video
+// that they belong to.
+//
+// The program and audio branches pick the elements that parse and decode their
+// streams from Streams, according to the stream types in the program map
+// table. When Streams.VideoPassthrough is set, the program branch sends the
+// source's H.264 video to clients without transcoding it.
+//
+// The audio branch has a demuxer of its own, so that switching audio tracks
+// doesn't disturb the video. tsdemux names its pads after the PIDs of their
+// streams, which lets each branch pick its stream by PID.
+//
+// Unless the program branch passes its video through, it decodes the video
+// once and encodes it separately for each of VideoLayers, through an encoder
+// named "encoder-LAYER" and a sink named "video-LAYER". The tuner changes the
+// bitrate of each encoder to suit the bandwidth of the clients that receive
+// its layer. Passed through video goes to the "video-high" sink.
+//
+// The encoders keep their groups of pictures short enough for the tracks to
+// cache a whole group, which lets new clients start without a keyframe wait.
+//
+// The audio branch's Opus encoder, named "audioencoder", starts with the
+// packet loss settings in Streams, which the tuner changes as clients report
+// loss.
+//
+// The multiplex pipeline's file and test pattern sources are named "file" and
+// "testpattern". The tuner sets their location and pattern from the channel
+// definition once the pipeline is parsed, as a path could be anything.
+//
+// The stream-raw branch filters the program's packets out of the multiplex
+// without touching them, while the stream-transcode branch remuxes the
+// program with H.264 video and AAC audio for players that can't decode
+// broadcast formats. Like the program branch, it passes H.264 video through
+// as is. It leaves out the audio when Streams has no audio decoder.
+var pipelineDescriptionTemplates = template.Must(template.New("").Parse(`
+	{{- define "multiplex" }}
+	{{- if .File }}
+	multifilesrc name=file loop=true
+	! tsparse set-timestamps=true
+	! clocksync
+	{{- else if .TestPattern }}
+	videotestsrc name=testpattern is-live=true
+	! video/x-raw,width=1280,height=720,framerate=30000/1001
+	! avenc_mpeg2video bitrate=8000000
+	! mpegvideoparse
+	! testmux.sink_{{.VideoPID}}
+	mpegtsmux name=testmux prog-map="program_map,sink_{{.VideoPID}}=(int){{.ProgramID}},sink_{{.AudioPID}}=(int){{.ProgramID}}"
+	{{- else }}
+	dvbsrc adapter={{.Adapter}} frontend={{.Frontend}} delsys=atsc modulation={{.Modulation}} frequency={{.FrequencyHz}}
+	{{- end }}
+	{{- template "queue-max-time" 2_500_000_000 }}
+	! tee name=mux allow-not-linked=true
+
+	{{- if .TestPattern }}
+
+	audiotestsrc is-live=true wave=sine
+	! audioconvert
+	! audioresample
+	! audio/x-raw,rate=48000,channels=2
+	! avenc_ac3 bitrate=192000
+	! ac3parse
+	! testmux.sink_{{.AudioPID}}
+	{{- end }}
 	{{- end }}
+
+	{{- define "program" }}
+	{{ template "queue" 2_500_000_000 }}
 	! tsdemux name=demux program-number={{.ProgramID}} latency=500
 
-	demux.
+	demux.{{ with .Streams.VideoPID }}video_0_{{ printf "%04x" . }}{{ end }}
 	{{- template "queue-max-time" 2_500_000_000 }}
-	{{- if eq .VideoPipeline "vaapi" }}
-	! vaapimpeg2dec
+	! {{.Streams.VideoParser}}
+	! tee name=video
+
+	video.
+	{{- template "queue-max-time" 2_500_000_000 }}
+	{{- if .Streams.VideoPassthrough }}
+	! appsink name=video-high max-buffers=50 drop=true
+	{{- else if eq .VideoPipeline "vaapi" }}
+	! {{.Streams.VAAPIVideoDecoder}}
 	! vaapipostproc deinterlace-mode=auto
-	! vaapih264enc rate-control=cbr bitrate=12000 cpb-length=1000 quality-level=1 tune=high-compression
+	! tee name=raw
+	{{- range .VideoLayers }}
+
+	raw.
+	{{- template "queue-max-time" 2_500_000_000 }}
+	{{- if .Height }}
+	! vaapipostproc width={{.Width}} height={{.Height}}
+	{{- end }}
+	! vaapih264enc name=encoder-{{.Name}} rate-control=cbr bitrate={{.Bitrate}} cpb-length=1000 quality-level=1 tune=high-compression
+	! video/x-h264,profile=constrained-baseline,stream-format=byte-stream
+	! appsink name=video-{{.Name}} max-buffers=50 drop=true
+	{{- end }}
 	{{- else }}
-	! avdec_mpeg2video
+	! {{.Streams.VideoDecoder}}
 	! deinterlace
 	{{- if eq .VideoPipeline "lowpower" }}
 	{{- template "queue-max-time" 2_500_000_000 }}
@@ -271,32 +603,196 @@ var pipelineDescriptionTemplate = template.Must(template.New("").Parse(`
 	! videoscale add-borders=true method=nearest-neighbour
 	{{- template "queue-max-time" 2_500_000_000 }}
 	! video/x-raw,width=640,height=360
-	! x264enc bitrate=2500 vbv-buf-capacity=1000 speed-preset=ultrafast bframes=0 mb-tree=false key-int-max=60 rc-lookahead=30
-	{{- else }}
-	! x264enc bitrate=8000 vbv-buf-capacity=1000 speed-preset=ultrafast tune=zerolatency
 	{{- end }}
+	! tee name=raw
+	{{- range .VideoLayers }}
+
+	raw.
+	{{- template "queue-max-time" 2_500_000_000 }}
+	{{- if .Height }}
+	! videoscale add-borders=true
+	! video/x-raw,width={{.Width}},height={{.Height}}
+	{{- end }}
+	{{- if eq $.VideoPipeline "lowpower" }}
+	! x264enc name=encoder-{{.Name}} bitrate={{.Bitrate}} vbv-buf-capacity=1000 speed-preset=ultrafast bframes=0 mb-tree=false key-int-max=60 rc-lookahead=30
+	{{- else }}
+	! x264enc name=encoder-{{.Name}} bitrate={{.Bitrate}} vbv-buf-capacity=1000 speed-preset=ultrafast tune=zerolatency key-int-max=120
 	{{- end }}
 	! video/x-h264,profile=constrained-baseline,stream-format=byte-stream
-	! appsink name=video max-buffers=50 drop=true
+	! appsink name=video-{{.Name}} max-buffers=50 drop=true
+	{{- end }}
+	{{- end }}
+
+	video.
+	{{- template "queue-max-time" 2_500_000_000 }}
+	! appsink name=captions max-buffers=50 drop=true
+	{{- end }}
 
-	demux.
+	{{- define "audio" }}
+	{{ template "queue" 2_500_000_000 }}
+	! tsdemux name=demux program-number={{.ProgramID}} latency=500
+
+	demux.audio_0_{{ printf "%04x" .Streams.AudioPID }}
 	{{- template "queue-max-time" 2_500_000_000 }}
-	! a52dec
+	! {{.Streams.AudioDecoder}}
 	! audioconvert
 	! audioresample
 	! audio/x-raw,rate=48000,channels=2
-	! opusenc bitrate=128000
+	! opusenc name=audioencoder bitrate=128000 inband-fec={{.Streams.AudioFEC}} packet-loss-percentage={{.Streams.AudioPacketLoss}}
 	! appsink name=audio max-buffers=50 drop=true
+	{{- end }}
+
+	{{- define "transport-stream" }}
+	{{ template "queue" 2_500_000_000 }}
+	! appsink name=ts sync=false
+	{{- end }}
+
+	{{- define "stream-raw" }}
+	{{ template "queue" 2_500_000_000 }}
+	! tsparse name=parse
+
+	parse.program_{{.ProgramID}}
+	! appsink name=stream sync=false
+	{{- end }}
+
+	{{- define "stream-transcode" }}
+	{{ template "queue" 2_500_000_000 }}
+	! tsdemux name=demux program-number={{.ProgramID}} latency=500
+
+	mpegtsmux name=remux
+	! appsink name=stream sync=false
+
+	demux.{{ with .Streams.VideoPID }}video_0_{{ printf "%04x" . }}{{ end }}
+	{{- template "queue-max-time" 2_500_000_000 }}
+	! {{.Streams.VideoParser}}
+	{{- if not .Streams.VideoPassthrough }}
+	{{- if eq .VideoPipeline "vaapi" }}
+	! {{.Streams.VAAPIVideoDecoder}}
+	! vaapipostproc deinterlace-mode=auto
+	! vaapih264enc rate-control=cbr bitrate=6000
+	{{- else }}
+	! {{.Streams.VideoDecoder}}
+	! deinterlace
+	! x264enc bitrate=6000 speed-preset=ultrafast key-int-max=120
+	{{- end }}
+	! h264parse
+	{{- end }}
+	! remux.
+
+	{{- if .Streams.AudioDecoder }}
+
+	demux.audio_0_{{ printf "%04x" .Streams.AudioPID }}
+	{{- template "queue-max-time" 2_500_000_000 }}
+	! {{.Streams.AudioDecoder}}
+	! audioconvert
+	! audioresample
+	! avenc_aac bitrate=192000
+	! aacparse
+	! remux.
+	{{- end }}
+	{{- end }}
+
+	{{- define "scan" }}
+	dvbsrc adapter={{.Adapter}} frontend={{.Frontend}} delsys=atsc modulation={{.Modulation}} frequency={{.FrequencyHz}} tuning-timeout={{.TuningTimeout}}
+	! appsink name=ts sync=false
+	{{- end }}
+
+	{{- define "queue-max-time" }}
+	! {{ template "queue" . }}
+	{{- end }}
+
+	{{- define "queue" }}queue leaky=downstream max-size-time={{.}} max-size-buffers=0 max-size-bytes=0{{ end }}
 `))
 
-func (t *Tuner) destroyAnyRunningPipeline() error {
-	if t.pipeline == nil {
-		return nil
+// ErrEndOfStream is reported through the tuner's status when the stream for the
+// current channel ends unexpectedly.
+var ErrEndOfStream error = errors.New("end of stream")
+
+func (t *Tuner) createPipelineMessageHandler(p *gst.Pipeline) gst.MessageFunc {
+	return func(msg gst.Message) {
+		switch msg.Type {
+		case gst.MessageError:
+			t.log.Error(
+				"Transcode pipeline error",
+				"source", msg.Source, "branch", msg.Branch,
+				"error", msg.Err.Message, "debug", msg.Err.Debug,
+			)
+			// The pipeline can't be closed from its own message handler.
+			go t.stopFailedPipeline(p, msg.Branch, msg.Err)
+
+		case gst.MessageEOS:
+			t.log.Error("Transcode pipeline reached end of stream")
+			go t.stopFailedPipeline(p, "", ErrEndOfStream)
+
+		case gst.MessageWarning:
+			t.log.Warn(
+				"Transcode pipeline warning",
+				"source", msg.Source, "branch", msg.Branch,
+				"error", msg.Err.Message, "debug", msg.Err.Debug,
+			)
+
+		case gst.MessageStateChanged:
+			t.log.Debug(
+				"Transcode pipeline changed state",
+				"old", msg.OldState, "new", msg.NewState,
+			)
+
+		case gst.MessageElement:
+			if s, ok := parseSignal(msg.Structure, time.Now()); ok {
+				t.signal.Record(s)
+			}
+		}
 	}
-	err := t.pipeline.Close()
-	t.pipeline = nil
-	slog.Info("Destroyed transcode pipeline", "error", err)
-	return err
+}
+
+// stopFailedPipeline handles a failure of p, unless the tuner has already moved
+// on from p.
+//
+// A failure within the branch of a program that only viewers are watching ends
+// that program alone, as a failure within a stream branch ends that stream.
+// Any other failure destroys p and reports err through the tuner's status.
+func (t *Tuner) stopFailedPipeline(p *gst.Pipeline, branch string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.mux == nil || t.mux.pipeline != p {
+		return
+	}
+
+	if branch == branchNameTransportStream {
+		// Live video doesn't depend on the guide or the program maps, except to
+		// start programs that are still waiting on them.
+		t.stopTransportStream(t.mux)
+		return
+	}
+	if prog := t.mux.programForBranch(branch); prog != nil {
+		t.failProgram(prog, err)
+		return
+	}
+	if s := t.mux.streamForBranch(branch); s != nil {
+		t.removeStream(s, err)
+		return
+	}
+	t.failMultiplex(err)
+}
+
+// failProgram ends p after a failure. When p is the tuner's current program,
+// the whole multiplex ends with it and err is reported through the tuner's
+// status.
+func (t *Tuner) failProgram(p *program, err error) {
+	if p == t.current {
+		t.failMultiplex(err)
+		return
+	}
+	t.removeProgram(p)
+}
+
+// failMultiplex destroys the tuner's multiplex after a failure, and reports err
+// through the tuner's status.
+func (t *Tuner) failMultiplex(err error) {
+	t.destroyAnyRunningMultiplex()
+	t.status.Set(Status{Error: err})
+	t.publishTracks()
 }
 
 // fmtp is described by https://tools.ietf.org/html/rfc6184.
@@ -308,7 +804,8 @@ func (t *Tuner) destroyAnyRunningPipeline() error {
 // to support 1920x1080 video per
 // https://en.wikipedia.org/wiki/Advanced_Video_Coding#Levels.
 //
-// This needs to match up with the GStreamer pipeline definition.
+// This needs to match up with the GStreamer pipeline definition, and bounds the
+// video that the tuner passes through from the source.
 const videoCodecFMTP = "profile-level-id=42e028;level-asymmetry-allowed=1;packetization-mode=1"
 
 var (
@@ -323,25 +820,31 @@ var (
 	// AudioCodecCapability represents the RTP codec settings for the audio signal
 	// produced by the tuner.
 	AudioCodecCapability = webrtc.RTPCodecCapability{
-		MimeType:  webrtc.MimeTypeOpus,
-		ClockRate: 48_000,
-		Channels:  2,
+		MimeType:    webrtc.MimeTypeOpus,
+		ClockRate:   48_000,
+		Channels:    2,
+		SDPFmtpLine: "minptime=10;useinbandfec=1",
 	}
 )
 
-func (t *Tuner) createTrackPair() (video, audio *webrtc.TrackLocalStaticSample, err error) {
-	streamID := fmt.Sprintf("Tuner(%p)", t)
-	video, verr := webrtc.NewTrackLocalStaticSample(VideoCodecCapability, streamID, streamID)
-	audio, aerr := webrtc.NewTrackLocalStaticSample(AudioCodecCapability, streamID, streamID)
-	err = errors.Join(verr, aerr)
+// createTracks creates a video track that plays each of the provided video
+// sources, along with an audio track that plays the audio source. Clients
+// receive one video layer at a time, so the layers share a stream ID with the
+// audio.
+func (t *Tuner) createTracks(videoSources []*sampleSource, audioSource *sampleSource) (vts []*sampleTrack, at *sampleTrack) {
+	streamID := t.streamID()
+	vts = make([]*sampleTrack, len(videoSources))
+	for i, src := range videoSources {
+		vts[i] = newSampleTrack(VideoCodecCapability, streamID, streamID)
+		vts[i].setSource(src)
+	}
+	at = newSampleTrack(AudioCodecCapability, streamID, streamID)
+	at.setSource(audioSource)
 	return
 }
 
-func createTrackSink(track *webrtc.TrackLocalStaticSample) gst.SinkFunc {
-	return gst.SinkFunc(func(data []byte, duration time.Duration) {
-		track.WriteSample(media.Sample{
-			Data:     data,
-			Duration: duration,
-		})
-	})
+// streamID returns the stream ID of the tuner's tracks, and of the tracks of
+// every program that it streams.
+func (t *Tuner) streamID() string {
+	return fmt.Sprintf("Tuner(%p)", t)
 }
@@ -0,0 +1,168 @@
+package gst
+
+// #include "gst.h"
+import "C"
+import (
+	"errors"
+	"fmt"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// Branch represents a bin of GStreamer elements that can be linked to a tee
+// element of a running Pipeline, to process the tee's data alongside any other
+// branches of the same tee. Unlike the pipeline, a branch can be added and
+// removed without interrupting the flow of data through the rest of the
+// pipeline.
+type Branch struct {
+	pipeline          *Pipeline
+	name              string
+	gstBin            *C.GstElement
+	gstTee            *C.GstElement
+	gstTeePad         *C.GstPad
+	sinkHandlesByName map[string]cgo.Handle
+}
+
+// NewBranch creates a branch of p based on the syntax used in the
+// gst-launch-1.0 utility. The first element of the description must have an
+// unlinked sink pad, which [Branch.Link] links to a tee element of p.
+//
+// The name of the branch must be unique among the branches of p, and is
+// reported as the Branch of any [Message] posted by an element in the branch.
+func (p *Pipeline) NewBranch(name, description string) (*Branch, error) {
+	if p.gstPipeline == nil {
+		panic("pipeline not initialized")
+	}
+
+	descriptionCString := C.CString(description)
+	defer C.free(unsafe.Pointer(descriptionCString))
+	nameCString := C.CString(name)
+	defer C.free(unsafe.Pointer(nameCString))
+
+	var gerror *C.GError
+	gstBin := C.hypcast_parse_branch(descriptionCString, nameCString, &gerror)
+	if gerror != nil {
+		defer C.g_error_free(gerror)
+		if gstBin != nil {
+			C.gst_object_unref(C.gpointer(gstBin))
+		}
+		return nil, errors.New(C.GoString(gerror.message))
+	}
+
+	b := &Branch{
+		pipeline:          p,
+		name:              name,
+		gstBin:            gstBin,
+		sinkHandlesByName: make(map[string]cgo.Handle),
+	}
+	p.branches[b] = struct{}{}
+	return b, nil
+}
+
+// Name returns the name of the branch, as provided to [Pipeline.NewBranch].
+func (b *Branch) Name() string {
+	return b.name
+}
+
+// SetSink associates fn with a named appsink element in the branch, in the
+// same manner as [Pipeline.SetSink].
+//
+// SetSink must only be called before the branch is linked, and is otherwise
+// subject to the same restrictions as [Pipeline.SetSink].
+func (b *Branch) SetSink(name string, fn SinkFunc) {
+	connectSink(b.gstBin, b.sinkHandlesByName, name, fn)
+}
+
+// Link adds the branch to its pipeline, starts it in the same state as the
+// pipeline, and links it to a new source pad of the named tee element.
+//
+// Link may only be called once over the life of the branch.
+func (b *Branch) Link(teeName string) error {
+	if b.gstBin == nil {
+		panic("branch not initialized")
+	}
+	if b.gstTee != nil {
+		panic("called Link more than once")
+	}
+
+	gstTee := getGstElementByName(b.pipeline.gstPipeline, teeName)
+	if gstTee == nil {
+		return fmt.Errorf("unknown tee name %s", teeName)
+	}
+
+	gstTeePad := C.hypcast_link_branch(b.pipeline.gstPipeline, gstTee, b.gstBin)
+	if gstTeePad == nil {
+		C.gst_object_unref(C.gpointer(gstTee))
+		return fmt.Errorf("failed to link branch %s to %s", b.name, teeName)
+	}
+
+	b.gstTee, b.gstTeePad = gstTee, gstTeePad
+	return nil
+}
+
+// SetProperty sets a property of the named element in the branch, from the
+// representation of its value in the syntax used in the gst-launch-1.0
+// utility. It returns an error if the element has no such property, if the
+// value doesn't parse or falls out of the property's range, or if the element
+// keeps its old value because it can't change the property in its current
+// state.
+//
+// SetProperty may be called from any goroutine, but must not be called
+// concurrently with Close.
+func (b *Branch) SetProperty(element, property, value string) error {
+	if b.gstBin == nil {
+		panic("branch not initialized")
+	}
+	return setProperty(b.gstBin, element, property, value)
+}
+
+// ForceKeyUnit asks the named encoder element in the branch to produce a key
+// unit, such as an H.264 IDR picture, as soon as it can, along with any headers
+// that a decoder needs to start decoding from it.
+//
+// ForceKeyUnit may be called from any goroutine, but must not be called
+// concurrently with Close.
+func (b *Branch) ForceKeyUnit(element string) error {
+	if b.gstBin == nil {
+		panic("branch not initialized")
+	}
+
+	gstElement := getGstElementByName(b.gstBin, element)
+	if gstElement == nil {
+		return fmt.Errorf("unknown element name %s", element)
+	}
+	defer C.gst_object_unref(C.gpointer(gstElement))
+
+	if C.hypcast_force_key_unit(gstElement) == 0 {
+		return fmt.Errorf("%s did not accept key unit request", element)
+	}
+	return nil
+}
+
+// Close unlinks the branch from its tee if it is linked, stops it, and
+// releases any resources associated with it, without interrupting the rest of
+// the pipeline. It is invalid to call any other method of a branch after it
+// has been closed.
+func (b *Branch) Close() error {
+	if b.gstTeePad != nil {
+		C.hypcast_unlink_branch(b.pipeline.gstPipeline, b.gstTee, b.gstTeePad, b.gstBin)
+		C.gst_object_unref(C.gpointer(b.gstTeePad))
+		C.gst_object_unref(C.gpointer(b.gstTee))
+		b.gstTeePad, b.gstTee = nil, nil
+	}
+
+	// As with the pipeline, Close should tolerate multiple calls.
+
+	for name, handle := range b.sinkHandlesByName {
+		handle.Delete()
+		delete(b.sinkHandlesByName, name)
+	}
+
+	if b.gstBin != nil {
+		C.gst_object_unref(C.gpointer(b.gstBin))
+		b.gstBin = nil
+	}
+
+	delete(b.pipeline.branches, b)
+	return nil
+}
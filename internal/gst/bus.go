@@ -0,0 +1,265 @@
+package gst
+
+// #include "gst.h"
+import "C"
+import (
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+// busPollInterval bounds how long the bus watcher for a pipeline blocks while
+// waiting for new messages, and thus how long Close may wait for the watcher
+// to exit.
+const busPollInterval = 100 * time.Millisecond
+
+// MessageType identifies the kind of a Message posted to a pipeline's bus.
+type MessageType int
+
+const (
+	// MessageError indicates that an element of the pipeline encountered a
+	// fatal error and stopped processing data.
+	MessageError MessageType = iota + 1
+	// MessageWarning indicates that an element of the pipeline encountered a
+	// recoverable problem.
+	MessageWarning
+	// MessageEOS indicates that every sink in the pipeline has reached the end
+	// of its stream.
+	MessageEOS
+	// MessageStateChanged indicates that the pipeline itself changed state.
+	// State changes by individual elements of the pipeline are not reported.
+	MessageStateChanged
+	// MessageElement carries element-specific information, such as the
+	// frontend statistics that dvbsrc reports while it receives a signal.
+	MessageElement
+)
+
+// State represents the state of a GStreamer element.
+type State int
+
+// The following are the states of a GStreamer element.
+const (
+	StateVoidPending State = C.GST_STATE_VOID_PENDING
+	StateNull        State = C.GST_STATE_NULL
+	StateReady       State = C.GST_STATE_READY
+	StatePaused      State = C.GST_STATE_PAUSED
+	StatePlaying     State = C.GST_STATE_PLAYING
+)
+
+func (s State) String() string {
+	switch s {
+	case StateVoidPending:
+		return "VOID_PENDING"
+	case StateNull:
+		return "NULL"
+	case StateReady:
+		return "READY"
+	case StatePaused:
+		return "PAUSED"
+	case StatePlaying:
+		return "PLAYING"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// Message represents a message posted to the bus of a Pipeline.
+type Message struct {
+	Type MessageType
+	// Source is the name of the element that posted the message.
+	Source string
+	// Branch is the name of the Branch that contains the source element, or
+	// the empty string if the source is not part of a branch.
+	Branch string
+	// Err is set for messages of type MessageError and MessageWarning.
+	Err *Error
+	// OldState and NewState are set for messages of type MessageStateChanged.
+	OldState State
+	NewState State
+	// Structure is set for messages of type MessageElement.
+	Structure *Structure
+}
+
+// Structure represents the named set of fields carried by an element message.
+type Structure struct {
+	Name string
+	// Fields holds the values of the structure's fields by name. Boolean,
+	// integer, floating point, and string values are represented by the Go
+	// types bool, int, uint, int64, uint64, float64, and string. Fields of any
+	// other type are omitted.
+	Fields map[string]any
+}
+
+// Error represents an error or warning posted by an element of a pipeline.
+type Error struct {
+	// Source is the name of the element that posted the error.
+	Source string
+	// Message is a human-readable description of the error.
+	Message string
+	// Debug contains additional details for debugging purposes, typically
+	// including the location in the GStreamer source code that produced the
+	// error. It may be empty.
+	Debug string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Source, e.Message)
+}
+
+// MessageFunc is a type for functions that receive messages from the bus of a
+// Pipeline.
+type MessageFunc func(Message)
+
+// SetMessageHandler associates fn with the bus of the pipeline, causing it to
+// be called with each error, warning, end-of-stream, pipeline state change, and
+// element message that is posted after the pipeline starts.
+//
+// fn is called serially on a dedicated goroutine, and must not call
+// [Pipeline.Close] or block on any other goroutine that might do so.
+//
+// SetMessageHandler must only be called before the pipeline is first started,
+// and may only be called once over the life of the pipeline. It will panic if
+// fn is nil or if SetMessageHandler has already been called.
+func (p *Pipeline) SetMessageHandler(fn MessageFunc) {
+	if fn == nil {
+		panic("attempted to set nil message handler")
+	}
+	if p.messageHandler != nil {
+		panic("called SetMessageHandler more than once")
+	}
+	p.messageHandler = fn
+}
+
+func (p *Pipeline) startBusWatch() {
+	if p.messageHandler == nil || p.busDone != nil {
+		return
+	}
+
+	p.busDone = make(chan struct{})
+	bus := C.gst_element_get_bus(p.gstPipeline)
+	p.busWatcher.Go(func() {
+		defer C.gst_object_unref(C.gpointer(bus))
+		for {
+			select {
+			case <-p.busDone:
+				return
+			default:
+			}
+
+			gstMessage := C.hypcast_bus_pop(bus, C.GstClockTime(busPollInterval))
+			if gstMessage == nil {
+				continue
+			}
+			msg, ok := p.parseMessage(gstMessage)
+			C.gst_message_unref(gstMessage)
+			if ok {
+				p.messageHandler(msg)
+			}
+		}
+	})
+}
+
+func (p *Pipeline) stopBusWatch() {
+	if p.busDone == nil {
+		return
+	}
+	close(p.busDone)
+	p.busWatcher.Wait()
+	p.busDone = nil
+}
+
+func (p *Pipeline) parseMessage(gstMessage *C.GstMessage) (msg Message, ok bool) {
+	msg.Source = C.GoString(C.hypcast_message_src_name(gstMessage))
+	if branch := C.hypcast_message_branch_name(gstMessage); branch != nil {
+		msg.Branch = C.GoString(branch)
+		C.g_free(C.gpointer(unsafe.Pointer(branch)))
+	}
+
+	switch C.hypcast_message_type(gstMessage) {
+	case C.GST_MESSAGE_ERROR:
+		msg.Type = MessageError
+		msg.Err = parseErrorMessage(gstMessage, msg.Source)
+		return msg, true
+
+	case C.GST_MESSAGE_WARNING:
+		msg.Type = MessageWarning
+		msg.Err = parseErrorMessage(gstMessage, msg.Source)
+		return msg, true
+
+	case C.GST_MESSAGE_EOS:
+		msg.Type = MessageEOS
+		return msg, true
+
+	case C.GST_MESSAGE_STATE_CHANGED:
+		if C.hypcast_message_is_from(gstMessage, p.gstPipeline) == 0 {
+			return msg, false
+		}
+		var oldState, newState, pending C.GstState
+		C.gst_message_parse_state_changed(gstMessage, &oldState, &newState, &pending)
+		msg.Type = MessageStateChanged
+		msg.OldState, msg.NewState = State(oldState), State(newState)
+		return msg, true
+
+	case C.GST_MESSAGE_ELEMENT:
+		structure := C.gst_message_get_structure(gstMessage)
+		if structure == nil {
+			return msg, false
+		}
+		msg.Type = MessageElement
+		msg.Structure = parseStructure(structure)
+		return msg, true
+	}
+
+	return msg, false
+}
+
+// parseErrorMessage extracts the details of an error or warning message.
+func parseErrorMessage(gstMessage *C.GstMessage, source string) *Error {
+	var (
+		gerror *C.GError
+		debug  *C.gchar
+	)
+	if C.hypcast_message_type(gstMessage) == C.GST_MESSAGE_WARNING {
+		C.gst_message_parse_warning(gstMessage, &gerror, &debug)
+	} else {
+		C.gst_message_parse_error(gstMessage, &gerror, &debug)
+	}
+	defer C.g_error_free(gerror)
+	defer C.g_free(C.gpointer(unsafe.Pointer(debug)))
+
+	return &Error{
+		Source:  source,
+		Message: C.GoString(gerror.message),
+		Debug:   C.GoString(debug),
+	}
+}
+
+// parseStructure copies the name and supported fields of a structure.
+func parseStructure(structure *C.GstStructure) *Structure {
+	n := int(C.gst_structure_n_fields(structure))
+	s := &Structure{
+		Name:   C.GoString(C.gst_structure_get_name(structure)),
+		Fields: make(map[string]any, n),
+	}
+	for i := range n {
+		name := C.gst_structure_nth_field_name(structure, C.guint(i))
+		value := C.gst_structure_get_value(structure, name)
+		switch C.hypcast_value_kind(value) {
+		case C.HYPCAST_VALUE_BOOLEAN:
+			s.Fields[C.GoString(name)] = C.g_value_get_boolean(value) != 0
+		case C.HYPCAST_VALUE_INT:
+			s.Fields[C.GoString(name)] = int(C.g_value_get_int(value))
+		case C.HYPCAST_VALUE_UINT:
+			s.Fields[C.GoString(name)] = uint(C.g_value_get_uint(value))
+		case C.HYPCAST_VALUE_INT64:
+			s.Fields[C.GoString(name)] = int64(C.g_value_get_int64(value))
+		case C.HYPCAST_VALUE_UINT64:
+			s.Fields[C.GoString(name)] = uint64(C.g_value_get_uint64(value))
+		case C.HYPCAST_VALUE_DOUBLE:
+			s.Fields[C.GoString(name)] = float64(C.g_value_get_double(value))
+		case C.HYPCAST_VALUE_STRING:
+			s.Fields[C.GoString(name)] = C.GoString(C.g_value_get_string(value))
+		}
+	}
+	return s
+}
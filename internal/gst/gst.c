@@ -18,3 +18,221 @@ GstFlowReturn hypcast_sink_sample(GstElement *object, gpointer user_data) {
   // At this point, the Go side takes over the ownership of sample.
   return hypcastSinkSample(sample, sink_handle);
 }
+
+GstMessage *hypcast_bus_pop(GstBus *bus, GstClockTime timeout) {
+  return gst_bus_timed_pop_filtered(
+      bus, timeout,
+      GST_MESSAGE_ERROR | GST_MESSAGE_WARNING | GST_MESSAGE_EOS |
+          GST_MESSAGE_STATE_CHANGED | GST_MESSAGE_ELEMENT);
+}
+
+// The following wrap macros that cgo is unable to call directly.
+
+GstMessageType hypcast_message_type(GstMessage *message) {
+  return GST_MESSAGE_TYPE(message);
+}
+
+const gchar *hypcast_message_src_name(GstMessage *message) {
+  return GST_MESSAGE_SRC_NAME(message);
+}
+
+gboolean hypcast_message_is_from(GstMessage *message, GstElement *element) {
+  return GST_MESSAGE_SRC(message) == GST_OBJECT(element);
+}
+
+GstBufferFlags hypcast_buffer_flags(GstBuffer *buffer) {
+  return GST_BUFFER_FLAGS(buffer);
+}
+
+HypcastValueKind hypcast_value_kind(const GValue *value) {
+  switch (G_VALUE_TYPE(value)) {
+  case G_TYPE_BOOLEAN:
+    return HYPCAST_VALUE_BOOLEAN;
+  case G_TYPE_INT:
+    return HYPCAST_VALUE_INT;
+  case G_TYPE_UINT:
+    return HYPCAST_VALUE_UINT;
+  case G_TYPE_INT64:
+    return HYPCAST_VALUE_INT64;
+  case G_TYPE_UINT64:
+    return HYPCAST_VALUE_UINT64;
+  case G_TYPE_DOUBLE:
+    return HYPCAST_VALUE_DOUBLE;
+  case G_TYPE_STRING:
+    return HYPCAST_VALUE_STRING;
+  default:
+    return HYPCAST_VALUE_UNSUPPORTED;
+  }
+}
+
+// hypcast_branch_key marks the bins created by hypcast_parse_branch, so that
+// messages from elements within a branch can be attributed to it.
+static const gchar *hypcast_branch_key = "hypcast-branch";
+
+gchar *hypcast_message_branch_name(GstMessage *message) {
+  GstObject *object = GST_MESSAGE_SRC(message);
+  if (object == NULL) {
+    return NULL;
+  }
+
+  gst_object_ref(object);
+  while (object != NULL) {
+    if (g_object_get_data(G_OBJECT(object), hypcast_branch_key) != NULL) {
+      gchar *name = gst_object_get_name(object);
+      gst_object_unref(object);
+      return name;
+    }
+    GstObject *parent = gst_object_get_parent(object);
+    gst_object_unref(object);
+    object = parent;
+  }
+  return NULL;
+}
+
+GstElement *hypcast_parse_branch(const gchar *description, const gchar *name,
+                                 GError **error) {
+  GstElement *bin = gst_parse_bin_from_description(description, TRUE, error);
+  if (bin == NULL) {
+    return NULL;
+  }
+  gst_object_ref_sink(bin);
+  gst_object_set_name(GST_OBJECT(bin), name);
+  g_object_set_data(G_OBJECT(bin), hypcast_branch_key, GINT_TO_POINTER(1));
+  return bin;
+}
+
+GstPad *hypcast_link_branch(GstElement *pipeline, GstElement *tee,
+                            GstElement *bin) {
+  if (!gst_bin_add(GST_BIN(pipeline), bin)) {
+    return NULL;
+  }
+
+  // The branch must be running before the tee can push data to it, as a
+  // stopped branch would return a flushing result that stops the tee's
+  // upstream source.
+  gst_element_sync_state_with_parent(bin);
+
+  GstPad *tee_pad = gst_element_request_pad_simple(tee, "src_%u");
+  GstPad *sink_pad = gst_element_get_static_pad(bin, "sink");
+  if (tee_pad == NULL || sink_pad == NULL ||
+      gst_pad_link(tee_pad, sink_pad) != GST_PAD_LINK_OK) {
+    if (sink_pad != NULL) {
+      gst_object_unref(sink_pad);
+    }
+    if (tee_pad != NULL) {
+      gst_element_release_request_pad(tee, tee_pad);
+      gst_object_unref(tee_pad);
+    }
+    gst_element_set_state(bin, GST_STATE_NULL);
+    gst_bin_remove(GST_BIN(pipeline), bin);
+    return NULL;
+  }
+
+  gst_object_unref(sink_pad);
+  return tee_pad;
+}
+
+typedef struct {
+  GMutex lock;
+  GCond cond;
+  gboolean done;
+} HypcastUnlinkWait;
+
+static GstPadProbeReturn hypcast_unlink_idle(GstPad *pad, GstPadProbeInfo *info,
+                                             gpointer user_data) {
+  HypcastUnlinkWait *wait = user_data;
+
+  GstPad *peer = gst_pad_get_peer(pad);
+  if (peer != NULL) {
+    gst_pad_unlink(pad, peer);
+    gst_object_unref(peer);
+  }
+
+  g_mutex_lock(&wait->lock);
+  wait->done = TRUE;
+  g_cond_signal(&wait->cond);
+  g_mutex_unlock(&wait->lock);
+  return GST_PAD_PROBE_REMOVE;
+}
+
+void hypcast_unlink_branch(GstElement *pipeline, GstElement *tee,
+                           GstPad *tee_pad, GstElement *bin) {
+  // The tee pad can only be unlinked safely while no data is flowing through
+  // it. An idle probe runs as soon as that's true, possibly right away on the
+  // calling thread.
+  HypcastUnlinkWait wait;
+  g_mutex_init(&wait.lock);
+  g_cond_init(&wait.cond);
+  wait.done = FALSE;
+
+  gst_pad_add_probe(tee_pad, GST_PAD_PROBE_TYPE_IDLE, hypcast_unlink_idle,
+                    &wait, NULL);
+
+  g_mutex_lock(&wait.lock);
+  while (!wait.done) {
+    g_cond_wait(&wait.cond, &wait.lock);
+  }
+  g_mutex_unlock(&wait.lock);
+  g_mutex_clear(&wait.lock);
+  g_cond_clear(&wait.cond);
+
+  gst_element_release_request_pad(tee, tee_pad);
+  gst_element_set_state(bin, GST_STATE_NULL);
+  gst_bin_remove(GST_BIN(pipeline), bin);
+}
+
+gboolean hypcast_force_key_unit(GstElement *encoder) {
+  GstPad *src = gst_element_get_static_pad(encoder, "src");
+  if (src == NULL) {
+    return FALSE;
+  }
+
+  // This is the upstream event that gst_video_event_new_upstream_force_key_unit
+  // builds, without requiring the GStreamer video library. The encoder receives
+  // it as though a downstream element had sent it.
+  GstStructure *s = gst_structure_new(
+      "GstForceKeyUnit", "running-time", GST_TYPE_CLOCK_TIME,
+      GST_CLOCK_TIME_NONE, "all-headers", G_TYPE_BOOLEAN, TRUE, "count",
+      G_TYPE_UINT, 0, NULL);
+  GstEvent *event = gst_event_new_custom(GST_EVENT_CUSTOM_UPSTREAM, s);
+  gboolean sent = gst_pad_send_event(src, event);
+  gst_object_unref(src);
+  return sent;
+}
+
+HypcastPropertyResult hypcast_set_property(GstElement *element,
+                                           const gchar *name,
+                                           const gchar *value) {
+  GParamSpec *pspec =
+      g_object_class_find_property(G_OBJECT_GET_CLASS(element), name);
+  if (pspec == NULL || !(pspec->flags & G_PARAM_WRITABLE)) {
+    return HYPCAST_PROPERTY_UNKNOWN;
+  }
+
+  // gst_util_set_object_arg would quietly ignore a value that it can't
+  // deserialize, or that falls outside of the property's range.
+  GValue want = G_VALUE_INIT;
+  g_value_init(&want, G_PARAM_SPEC_VALUE_TYPE(pspec));
+  if (!gst_value_deserialize(&want, value) ||
+      g_param_value_validate(pspec, &want)) {
+    g_value_unset(&want);
+    return HYPCAST_PROPERTY_INVALID;
+  }
+  g_object_set_property(G_OBJECT(element), name, &want);
+
+  // An element that can't change the property in its current state only logs
+  // a warning, and keeps its old value.
+  HypcastPropertyResult result = HYPCAST_PROPERTY_SET;
+  if (pspec->flags & G_PARAM_READABLE) {
+    GValue got = G_VALUE_INIT;
+    g_value_init(&got, G_PARAM_SPEC_VALUE_TYPE(pspec));
+    g_object_get_property(G_OBJECT(element), name, &got);
+    gint order = gst_value_compare(&want, &got);
+    if (order != GST_VALUE_EQUAL && order != GST_VALUE_UNORDERED) {
+      result = HYPCAST_PROPERTY_NOT_APPLIED;
+    }
+    g_value_unset(&got);
+  }
+  g_value_unset(&want);
+  return result;
+}
@@ -11,7 +11,9 @@ import "C"
 import (
 	"errors"
 	"fmt"
+	"math"
 	"runtime/cgo"
+	"sync"
 	"time"
 	"unsafe"
 )
@@ -20,15 +22,45 @@ func init() {
 	C.gst_init(nil, nil)
 }
 
-// SinkFunc is a type for functions that receive data from the appsink elements
-// of a Pipeline.
-type SinkFunc func([]byte, time.Duration)
+// SinkFunc is a type for functions that receive samples from the appsink
+// elements of a Pipeline.
+type SinkFunc func(Sample)
+
+// Sample represents a buffer that an appsink element received, along with its
+// timing and flags.
+type Sample struct {
+	Data []byte
+
+	// PTS and DTS are the presentation and decode timestamps of the buffer,
+	// as running times of the pipeline, or ClockTimeNone if the buffer lacks
+	// them. Running times are comparable across every sink of a pipeline, no
+	// matter which branch delivers them.
+	PTS, DTS time.Duration
+	// Duration is the duration of the buffer, or 0 if it is unknown.
+	Duration time.Duration
+
+	// Discont marks the first buffer after a break in the stream, as after an
+	// upstream element dropped buffers.
+	Discont bool
+	// Keyframe marks a buffer that doesn't depend on earlier buffers to be
+	// decoded.
+	Keyframe bool
+}
+
+// ClockTimeNone stands in for a timestamp that a buffer lacks.
+const ClockTimeNone time.Duration = -1
 
 // Pipeline represents a GStreamer pipeline that can provide sample data to Go
 // programs through appsink elements.
 type Pipeline struct {
 	gstPipeline       *C.GstElement
 	sinkHandlesByName map[string]cgo.Handle
+
+	branches map[*Branch]struct{}
+
+	messageHandler MessageFunc
+	busDone        chan struct{}
+	busWatcher     sync.WaitGroup
 }
 
 // NewPipeline creates a GStreamer pipeline based on the syntax used in the
@@ -50,16 +82,24 @@ func NewPipeline(description string) (*Pipeline, error) {
 	return &Pipeline{
 		gstPipeline:       gstPipeline,
 		sinkHandlesByName: make(map[string]cgo.Handle),
+		branches:          make(map[*Branch]struct{}),
 	}, nil
 }
 
 // Start attempts to set the GStreamer pipeline to the PLAYING state, in which
 // all elements are processing data and sinks are receiving output.
+//
+// A nil error from Start does not guarantee that the pipeline will reach the
+// PLAYING state, as many elements change state asynchronously. Errors that
+// occur after Start returns are reported through the function provided to
+// [Pipeline.SetMessageHandler].
 func (p *Pipeline) Start() error {
 	if p.gstPipeline == nil {
 		panic("pipeline not initialized")
 	}
 
+	p.startBusWatch()
+
 	result := C.gst_element_set_state(p.gstPipeline, C.GST_STATE_PLAYING)
 	if result == C.GST_STATE_CHANGE_FAILURE {
 		return errors.New("failed to start pipeline")
@@ -82,10 +122,16 @@ func (p *Pipeline) Stop() error {
 }
 
 // Close stops this pipeline if it is started and releases any resources
-// associated with it. It is invalid to call any other method of a pipeline
-// after it has been closed.
+// associated with it, including any branches created with [Pipeline.NewBranch]
+// that were not already closed. It is invalid to call any other method of a
+// pipeline after it has been closed.
 func (p *Pipeline) Close() error {
 	p.Stop()
+	p.stopBusWatch()
+
+	for b := range p.branches {
+		b.Close()
+	}
 
 	// The behavior of multiple calls to Close isn't strictly defined, however it
 	// probably should not exhibit any form of double-free error, *especially* for
@@ -113,31 +159,70 @@ func (p *Pipeline) Close() error {
 // name does not correspond to the name of a defined appsink, if fn is nil, or
 // if SetSink has already been called once for the named appsink.
 func (p *Pipeline) SetSink(name string, fn SinkFunc) {
+	connectSink(p.gstPipeline, p.sinkHandlesByName, name, fn)
+}
+
+// SetProperty sets a property of the named element in the pipeline, in the
+// same manner as [Branch.SetProperty]. Setting a property after parsing the
+// pipeline keeps values that come from outside of Hypcast, like file paths,
+// from breaking the pipeline's syntax.
+func (p *Pipeline) SetProperty(element, property, value string) error {
+	if p.gstPipeline == nil {
+		panic("pipeline not initialized")
+	}
+	return setProperty(p.gstPipeline, element, property, value)
+}
+
+// setProperty implements SetProperty for both pipelines and branches.
+func setProperty(bin *C.GstElement, element, property, value string) error {
+	gstElement := getGstElementByName(bin, element)
+	if gstElement == nil {
+		return fmt.Errorf("unknown element name %s", element)
+	}
+	defer C.gst_object_unref(C.gpointer(gstElement))
+
+	propertyCString := C.CString(property)
+	defer C.free(unsafe.Pointer(propertyCString))
+	valueCString := C.CString(value)
+	defer C.free(unsafe.Pointer(valueCString))
+
+	switch C.hypcast_set_property(gstElement, propertyCString, valueCString) {
+	case C.HYPCAST_PROPERTY_UNKNOWN:
+		return fmt.Errorf("element %s has no writable property %s", element, property)
+	case C.HYPCAST_PROPERTY_INVALID:
+		return fmt.Errorf("invalid value %q for property %s of element %s", value, property, element)
+	case C.HYPCAST_PROPERTY_NOT_APPLIED:
+		return fmt.Errorf("element %s did not accept value %q for property %s", element, value, property)
+	}
+	return nil
+}
+
+// connectSink implements SetSink for both pipelines and branches, which differ
+// only in the bin that contains the appsink.
+func connectSink(bin *C.GstElement, handles map[string]cgo.Handle, name string, fn SinkFunc) {
 	if fn == nil {
 		panic("attempted to set nil sink function")
 	}
-	if p.sinkHandlesByName[name] > 0 {
+	if handles[name] > 0 {
 		panic("called SetSink more than once for the same appsink")
 	}
 
-	handle := cgo.NewHandle(fn)
-	p.sinkHandlesByName[name] = handle
-
-	element := p.getGstElementByName(name)
+	element := getGstElementByName(bin, name)
 	if element == nil {
 		panic(fmt.Errorf("unknown sink name %s", name))
 	}
 	defer C.gst_object_unref(C.gpointer(element))
 
+	handle := cgo.NewHandle(fn)
+	handles[name] = handle
 	C.hypcast_connect_sink(element, C.uintptr_t(handle))
 }
 
-func (p *Pipeline) getGstElementByName(name string) *C.GstElement {
+func getGstElementByName(bin *C.GstElement, name string) *C.GstElement {
 	nameCString := C.CString(name)
 	defer C.free(unsafe.Pointer(nameCString))
 
-	pipelineBin := (*C.GstBin)(unsafe.Pointer(p.gstPipeline))
-	return C.gst_bin_get_by_name(pipelineBin, nameCString)
+	return C.gst_bin_get_by_name((*C.GstBin)(unsafe.Pointer(bin)), nameCString)
 }
 
 // GStreamer calls hypcastSinkSample to pass data from the encoding pipeline
@@ -156,13 +241,44 @@ func hypcastSinkSample(sample *C.GstSample, sinkHandle uintptr) C.GstFlowReturn
 	data := make([]byte, size)
 	extracted := C.gst_buffer_extract(buffer, offset, C.gpointer(&data[0]), size)
 
-	data = data[:extracted]
-	duration := time.Duration(buffer.duration)
+	segment := C.gst_sample_get_segment(sample)
+	flags := C.hypcast_buffer_flags(buffer)
+	s := Sample{
+		Data:     data[:extracted],
+		PTS:      runningTime(segment, buffer.pts),
+		DTS:      runningTime(segment, buffer.dts),
+		Discont:  flags&C.GST_BUFFER_FLAG_DISCONT != 0,
+		Keyframe: flags&C.GST_BUFFER_FLAG_DELTA_UNIT == 0,
+	}
+	if buffer.duration != clockTimeNone {
+		s.Duration = time.Duration(buffer.duration)
+	}
 
 	C.gst_sample_unref(sample) // Invalidates sample and buffer
 
 	sinkFn := cgo.Handle(sinkHandle).Value().(SinkFunc)
-	sinkFn(data, duration)
+	sinkFn(s)
 
 	return C.GST_FLOW_OK
 }
+
+// clockTimeNone is the value of GST_CLOCK_TIME_NONE, which cgo can't use
+// directly.
+const clockTimeNone = C.GstClockTime(math.MaxUint64)
+
+// runningTime converts a timestamp within segment to the running time of the
+// pipeline, which GStreamer synchronizes every sink to.
+func runningTime(segment *C.GstSegment, timestamp C.GstClockTime) time.Duration {
+	if timestamp == clockTimeNone {
+		return ClockTimeNone
+	}
+	if segment == nil {
+		return time.Duration(timestamp)
+	}
+	rt := C.gst_segment_to_running_time(segment, C.GST_FORMAT_TIME, C.guint64(timestamp))
+	if rt == C.guint64(clockTimeNone) {
+		// The timestamp falls outside of the segment.
+		return ClockTimeNone
+	}
+	return time.Duration(rt)
+}
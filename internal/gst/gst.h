@@ -12,5 +12,40 @@ extern GstFlowReturn hypcastSinkSample(GstSample *, uintptr_t);
 
 void hypcast_connect_sink(GstElement *, uintptr_t);
 GstFlowReturn hypcast_sink_sample(GstElement *, gpointer);
+GstBufferFlags hypcast_buffer_flags(GstBuffer *);
+
+GstMessage *hypcast_bus_pop(GstBus *, GstClockTime);
+GstMessageType hypcast_message_type(GstMessage *);
+const gchar *hypcast_message_src_name(GstMessage *);
+gboolean hypcast_message_is_from(GstMessage *, GstElement *);
+gchar *hypcast_message_branch_name(GstMessage *);
+
+typedef enum {
+  HYPCAST_VALUE_UNSUPPORTED,
+  HYPCAST_VALUE_BOOLEAN,
+  HYPCAST_VALUE_INT,
+  HYPCAST_VALUE_UINT,
+  HYPCAST_VALUE_INT64,
+  HYPCAST_VALUE_UINT64,
+  HYPCAST_VALUE_DOUBLE,
+  HYPCAST_VALUE_STRING,
+} HypcastValueKind;
+
+HypcastValueKind hypcast_value_kind(const GValue *);
+
+GstElement *hypcast_parse_branch(const gchar *, const gchar *, GError **);
+GstPad *hypcast_link_branch(GstElement *, GstElement *, GstElement *);
+void hypcast_unlink_branch(GstElement *, GstElement *, GstPad *, GstElement *);
+gboolean hypcast_force_key_unit(GstElement *);
+
+typedef enum {
+  HYPCAST_PROPERTY_SET,
+  HYPCAST_PROPERTY_UNKNOWN,
+  HYPCAST_PROPERTY_INVALID,
+  HYPCAST_PROPERTY_NOT_APPLIED,
+} HypcastPropertyResult;
+
+HypcastPropertyResult hypcast_set_property(GstElement *, const gchar *,
+                                           const gchar *);
 
 #endif